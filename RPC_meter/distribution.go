@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// itemsRange is how many item IDs requests pick from, [0, itemsRange) / из скольких ID товаров выбирают запросы, [0, itemsRange)
+const itemsRange = 10000
+
+// Hottest items listed at the end of the test / Самые горячие товары в конце теста
+const hottestItems = 5
+
+// itemDistribution picks item IDs, nil is uniform / выбирает ID товаров, nil - равномерно
+type itemDistribution struct {
+	skew float64
+	// rand.Zipf is not safe for concurrent use / rand.Zipf не безопасен для конкурентного использования
+	mu   sync.Mutex
+	zipf *rand.Zipf
+}
+
+// parseDistribution builds the -distribution, skew is only used by zipf and must be above 1 /
+// строит -distribution, skew используется только zipf и должен быть больше 1
+func parseDistribution(name string, skew float64) (*itemDistribution, error) {
+	switch name {
+	case "uniform":
+		return nil, nil
+	case "zipf":
+		// rand.NewZipf returns nil for s <= 1 / rand.NewZipf возвращает nil при s <= 1
+		if skew <= 1 {
+			return nil, fmt.Errorf("zipf skew must be greater than 1, got %g", skew)
+		}
+		source := rand.New(rand.NewSource(time.Now().UnixNano()))
+		return &itemDistribution{skew: skew, zipf: rand.NewZipf(source, skew, 1, itemsRange-1)}, nil
+	default:
+		return nil, fmt.Errorf("unknown distribution %q, expected uniform or zipf", name)
+	}
+}
+
+// next picks an item, with zipf item 0 is the hottest / выбирает товар, при zipf самый горячий - товар 0
+func (d *itemDistribution) next() int64 {
+	if d == nil {
+		return rand.Int63n(itemsRange)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return int64(d.zipf.Uint64())
+}
+
+// expectedShare is the part of requests the n hottest items should get / доля запросов, которая должна прийтись на n самых горячих товаров
+func (d *itemDistribution) expectedShare(n int) float64 {
+	if d == nil {
+		return float64(n) / itemsRange
+	}
+
+	// rand.Zipf picks k with probability proportional to (1+k)^-skew / rand.Zipf выбирает k с вероятностью, пропорциональной (1+k)^-skew
+	var top, total float64
+	for k := 0; k < itemsRange; k++ {
+		weight := math.Pow(float64(1+k), -d.skew)
+		if k < n {
+			top += weight
+		}
+		total += weight
+	}
+	return top / total
+}
+
+func (d *itemDistribution) String() string {
+	if d == nil {
+		return "uniform"
+	}
+	return fmt.Sprintf("zipf (skew %g)", d.skew)
+}
+
+// SetDistribution sets how item IDs are picked, nil keeps them uniform / задает выбор ID товаров, nil оставляет его равномерным
+func (lt *LoadTester) SetDistribution(d *itemDistribution) {
+	lt.items = d
+}
+
+// recordItem counts a request for an item, IDs outside [0, itemsRange) are not tracked /
+// учитывает запрос к товару, ID вне [0, itemsRange) не отслеживаются
+func (s *Stats) recordItem(itemID int64) {
+	if itemID >= 0 && itemID < itemsRange {
+		atomic.AddInt64(&s.itemHits[itemID], 1)
+	}
+}
+
+// ItemHit is the request count of one item / количество запросов к одному товару
+type ItemHit struct {
+	ItemID   int64 `json:"itemId"`
+	Requests int64 `json:"requests"`
+}
+
+// HitSummary is the effective item distribution of a run / фактическое распределение запросов по товарам за прогон
+type HitSummary struct {
+	Distribution string    `json:"distribution"`
+	ItemsHit     int       `json:"itemsHit"`
+	Top1Pct      float64   `json:"top1Pct"`
+	Top10Pct     float64   `json:"top10Pct"`
+	Top100Pct    float64   `json:"top100Pct"`
+	Hottest      []ItemHit `json:"hottest"`
+}
+
+// hitSummary ranks the items by requests, nil if none were tracked / ранжирует товары по запросам, nil если ничего не отслежено
+func (lt *LoadTester) hitSummary() *HitSummary {
+	hits := make([]ItemHit, 0, itemsRange)
+	var total int64
+	for itemID := range lt.stats.itemHits {
+		if requests := atomic.LoadInt64(&lt.stats.itemHits[itemID]); requests > 0 {
+			hits = append(hits, ItemHit{ItemID: int64(itemID), Requests: requests})
+			total += requests
+		}
+	}
+	if total == 0 {
+		return nil
+	}
+	sort.Slice(hits, func(i, j int) bool { return hits[i].Requests > hits[j].Requests })
+
+	share := func(n int) float64 {
+		var top int64
+		for _, hit := range hits[:min(n, len(hits))] {
+			top += hit.Requests
+		}
+		return float64(top) / float64(total) * 100
+	}
+
+	return &HitSummary{
+		Distribution: lt.items.String(),
+		ItemsHit:     len(hits),
+		Top1Pct:      share(1),
+		Top10Pct:     share(10),
+		Top100Pct:    share(100),
+		Hottest:      hits[:min(hottestItems, len(hits))],
+	}
+}
+
+// printHitStats prints how requests spread over items / выводит, как запросы распределились по товарам
+func (lt *LoadTester) printHitStats() {
+	summary := lt.hitSummary()
+	if summary == nil {
+		return
+	}
+
+	fmt.Printf("\nItem hits (%s):\n", summary.Distribution)
+	fmt.Printf("- Items requested: %d of %d\n", summary.ItemsHit, itemsRange)
+	fmt.Printf("- Top 1 / 10 / 100 items: %.2f%% / %.2f%% / %.2f%% of requests\n", summary.Top1Pct, summary.Top10Pct, summary.Top100Pct)
+	fmt.Printf("- Hottest:")
+	for _, hit := range summary.Hottest {
+		fmt.Printf(" #%d (%d)", hit.ItemID, hit.Requests)
+	}
+	fmt.Printf("\n")
+}
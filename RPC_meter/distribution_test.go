@@ -0,0 +1,76 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestParseDistribution tests the distribution names and the zipf skew bound
+func TestParseDistribution(t *testing.T) {
+	uniform, err := parseDistribution("uniform", 0)
+	require.NoError(t, err)
+	assert.Nil(t, uniform)
+	assert.Equal(t, "uniform", uniform.String())
+
+	zipf, err := parseDistribution("zipf", 1.5)
+	require.NoError(t, err)
+	assert.Equal(t, "zipf (skew 1.5)", zipf.String())
+
+	for _, skew := range []float64{1, 0.5, -2} {
+		_, err := parseDistribution("zipf", skew)
+		assert.Error(t, err, "skew %g", skew)
+	}
+	_, err = parseDistribution("pareto", 1.5)
+	assert.Error(t, err)
+}
+
+// TestZipfHotItems tests that zipf sends the bulk of requests to a few items while uniform spreads them
+func TestZipfHotItems(t *testing.T) {
+	const requests = 20000
+
+	zipf, err := parseDistribution("zipf", 1.5)
+	require.NoError(t, err)
+	lt := NewLoadTester("http://localhost:0", 10)
+	lt.SetDistribution(zipf)
+	for i := 0; i < requests; i++ {
+		_, itemID := lt.generateRequest()
+		require.True(t, itemID >= 0 && itemID < itemsRange, "item %d out of range", itemID)
+		lt.stats.recordItem(itemID)
+	}
+
+	summary := lt.hitSummary()
+	require.NotNil(t, summary)
+	assert.Equal(t, "zipf (skew 1.5)", summary.Distribution)
+	assert.Equal(t, int64(0), summary.Hottest[0].ItemID, "item 0 is the hottest")
+	assert.InDelta(t, zipf.expectedShare(10)*100, summary.Top10Pct, 3)
+	assert.Greater(t, summary.Top10Pct, 50.0)
+
+	uniform := NewLoadTester("http://localhost:0", 10)
+	for i := 0; i < requests; i++ {
+		_, itemID := uniform.generateRequest()
+		uniform.stats.recordItem(itemID)
+	}
+	assert.Less(t, uniform.hitSummary().Top10Pct, 1.0)
+	assert.InDelta(t, 0.001, uniform.items.expectedShare(10), 1e-9)
+}
+
+// TestHitSummary tests ranking of tracked items and that IDs outside the range are ignored
+func TestHitSummary(t *testing.T) {
+	lt := NewLoadTester("http://localhost:0", 10)
+	assert.Nil(t, lt.hitSummary())
+
+	for itemID, requests := range map[int64]int{7: 5, 3: 3, 9: 2, -1: 4, itemsRange: 4} {
+		for i := 0; i < requests; i++ {
+			lt.stats.recordItem(itemID)
+		}
+	}
+
+	summary := lt.hitSummary()
+	require.NotNil(t, summary)
+	assert.Equal(t, 3, summary.ItemsHit)
+	assert.Equal(t, []ItemHit{{7, 5}, {3, 3}, {9, 2}}, summary.Hottest)
+	assert.InDelta(t, 50.0, summary.Top1Pct, 0.001)
+	assert.InDelta(t, 100.0, summary.Top10Pct, 0.001)
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// ResultSummary holds the final aggregate stats of a run / Итоговая статистика прогона
+type ResultSummary struct {
+	TestType      string  `json:"testType"`
+	URL           string  `json:"url"`
+	Users         int64   `json:"users"`
+	Ramp          string  `json:"ramp,omitempty"`
+	DurationSec   float64 `json:"durationSec"`
+	TotalRequests int64   `json:"totalRequests"`
+	AchievedRPS   float64 `json:"achievedRps"`
+	// Response distribution / Распределение ответов
+	Successful  int64 `json:"successful"`
+	Conflicts   int64 `json:"conflicts"`
+	Errors500   int64 `json:"errors500"`
+	Timeouts    int64 `json:"timeouts"`
+	OtherErrors int64 `json:"otherErrors"`
+	// Latency in milliseconds / Латентность в миллисекундах
+	LatencyAvgMs float64 `json:"latencyAvgMs"`
+	LatencyMinMs float64 `json:"latencyMinMs"`
+	LatencyMaxMs float64 `json:"latencyMaxMs"`
+	LatencyP50Ms float64 `json:"latencyP50Ms"`
+	LatencyP90Ms float64 `json:"latencyP90Ms"`
+	LatencyP99Ms float64 `json:"latencyP99Ms"`
+	// Chain steps, zero in checkout-only mode / Этапы цепочки, ноль в режиме только checkout
+	CheckoutRequests  int64 `json:"checkoutRequests"`
+	CheckoutSuccesses int64 `json:"checkoutSuccesses"`
+	PurchaseRequests  int64 `json:"purchaseRequests"`
+	PurchaseSuccesses int64 `json:"purchaseSuccesses"`
+	// Per operation of -mix / По операциям -mix
+	Operations []OperationSummary `json:"operations,omitempty"`
+	// Per response class / По классам ответов
+	LatencyByStatus []StatusLatencySummary `json:"latencyByStatus,omitempty"`
+	// Effective item distribution / Фактическое распределение по товарам
+	ItemHits *HitSummary `json:"itemHits,omitempty"`
+}
+
+// TestResults is the -output file: summary plus per-second points / файл -output: итоги и посекундные точки
+type TestResults struct {
+	Summary ResultSummary `json:"summary"`
+	Points  []DataPoint   `json:"points"`
+}
+
+// csvHeader names the -csv columns, one row per DataPoint / названия колонок -csv, строка на DataPoint
+var csvHeader = []string{
+	"timestamp", "rps", "achieved_rps", "target_rps", "latency_ms", "error_rate",
+	"success", "errors500", "timeouts", "checkout_reqs", "purchase_reqs", "checkout_succ", "purchase_succ",
+}
+
+// SetOutputs sets the result files, the whole run is then kept for them / задает файлы результатов, тогда для них хранится весь прогон
+func (lt *LoadTester) SetOutputs(jsonPath, csvPath string) {
+	lt.outputJSON = jsonPath
+	lt.outputCSV = csvPath
+	if jsonPath != "" || csvPath != "" {
+		lt.metricsHistory.KeepAll()
+	}
+}
+
+// summary collects the final aggregate stats / собирает итоговую статистику
+func (lt *LoadTester) summary(testChain bool) ResultSummary {
+	elapsed := time.Since(lt.stats.startTime).Seconds()
+	total := atomic.LoadInt64(&lt.stats.totalRequests)
+
+	result := ResultSummary{
+		TestType:          "checkout",
+		URL:               lt.baseURL,
+		Users:             lt.maxUsers,
+		DurationSec:       elapsed,
+		TotalRequests:     total,
+		Successful:        atomic.LoadInt64(&lt.stats.successfulRequests),
+		Conflicts:         atomic.LoadInt64(&lt.stats.conflictErrors),
+		Errors500:         atomic.LoadInt64(&lt.stats.internalErrors),
+		Timeouts:          atomic.LoadInt64(&lt.stats.timeouts),
+		OtherErrors:       atomic.LoadInt64(&lt.stats.otherErrors),
+		LatencyP50Ms:      lt.stats.latencies.percentile(0.50),
+		LatencyP90Ms:      lt.stats.latencies.percentile(0.90),
+		LatencyP99Ms:      lt.stats.latencies.percentile(0.99),
+		CheckoutRequests:  atomic.LoadInt64(&lt.stats.checkoutRequests),
+		CheckoutSuccesses: atomic.LoadInt64(&lt.stats.checkoutSuccesses),
+		PurchaseRequests:  atomic.LoadInt64(&lt.stats.purchaseRequests),
+		PurchaseSuccesses: atomic.LoadInt64(&lt.stats.purchaseSuccesses),
+		Operations:        lt.operationSummaries(),
+		LatencyByStatus:   lt.stats.statusSummaries(),
+		ItemHits:          lt.hitSummary(),
+	}
+	if lt.replay != nil {
+		result.TestType = "replay"
+	} else if lt.mix != nil {
+		result.TestType = "mix"
+	} else if testChain {
+		result.TestType = "chain"
+	}
+	if lt.ramp != nil {
+		result.Ramp = lt.ramp.String()
+	}
+	if elapsed > 0 {
+		result.AchievedRPS = float64(total) / elapsed
+	}
+	if total > 0 {
+		result.LatencyAvgMs = float64(atomic.LoadInt64(&lt.stats.totalLatency)) / float64(total) / 1000
+		result.LatencyMinMs = float64(atomic.LoadInt64(&lt.stats.minLatency)) / 1000
+		result.LatencyMaxMs = float64(atomic.LoadInt64(&lt.stats.maxLatency)) / 1000
+	}
+	return result
+}
+
+// writeResults writes the configured result files and reports where they went / пишет настроенные файлы результатов и сообщает, куда
+func (lt *LoadTester) writeResults(testChain bool) {
+	points := lt.metricsHistory.AllPoints()
+
+	if lt.outputJSON != "" {
+		results := TestResults{Summary: lt.summary(testChain), Points: points}
+		if err := writeResultsJSON(lt.outputJSON, results); err != nil {
+			fmt.Printf("❌ Failed to write %s: %v\n", lt.outputJSON, err)
+		} else {
+			fmt.Printf("💾 Results written to %s\n", lt.outputJSON)
+		}
+	}
+
+	if lt.outputCSV != "" {
+		if err := writeResultsCSV(lt.outputCSV, points); err != nil {
+			fmt.Printf("❌ Failed to write %s: %v\n", lt.outputCSV, err)
+		} else {
+			fmt.Printf("💾 Per-second points written to %s\n", lt.outputCSV)
+		}
+	}
+}
+
+// writeResultsJSON writes results as indented JSON / пишет результаты в виде JSON с отступами
+func writeResultsJSON(path string, results TestResults) error {
+	return writeFileSynced(path, func(f *os.File) error {
+		encoder := json.NewEncoder(f)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(results)
+	})
+}
+
+// writeResultsCSV writes one row per point / пишет по строке на точку
+func writeResultsCSV(path string, points []DataPoint) error {
+	return writeFileSynced(path, func(f *os.File) error {
+		w := csv.NewWriter(f)
+		w.Write(csvHeader)
+		for _, p := range points {
+			w.Write([]string{
+				p.Timestamp.Format(time.RFC3339Nano),
+				formatFloat(p.RPS),
+				formatFloat(p.AchievedRPS),
+				strconv.FormatInt(p.TargetRPS, 10),
+				formatFloat(p.Latency),
+				formatFloat(p.ErrorRate),
+				strconv.FormatInt(p.Success, 10),
+				strconv.FormatInt(p.Errors500, 10),
+				strconv.FormatInt(p.Timeouts, 10),
+				strconv.FormatInt(p.CheckoutReqs, 10),
+				strconv.FormatInt(p.PurchaseReqs, 10),
+				strconv.FormatInt(p.CheckoutSucc, 10),
+				strconv.FormatInt(p.PurchaseSucc, 10),
+			})
+		}
+		w.Flush()
+		return w.Error()
+	})
+}
+
+// writeFileSynced creates path, lets write fill it and syncs it to disk before closing /
+// создает path, заполняет его через write и сбрасывает на диск до закрытия
+func writeFileSynced(path string, write func(*os.File) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// formatFloat keeps CSV numbers short and exact enough for diffs / держит числа CSV короткими и достаточно точными для сравнения
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', 3, 64)
+}
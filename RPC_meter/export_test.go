@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestLatencyPercentiles tests that percentiles land within the bucket error
+func TestLatencyPercentiles(t *testing.T) {
+	var h latencyHistogram
+	assert.Zero(t, h.percentile(0.99))
+
+	// 1..1000 ms, one sample each
+	for ms := int64(1); ms <= 1000; ms++ {
+		h.record(ms * 1000)
+	}
+
+	assert.InEpsilon(t, 500, h.percentile(0.50), 0.1)
+	assert.InEpsilon(t, 900, h.percentile(0.90), 0.1)
+	assert.InEpsilon(t, 990, h.percentile(0.99), 0.1)
+
+	// Out of range latencies go to the edge buckets instead of panicking
+	h.record(0)
+	h.record(1 << 62)
+}
+
+// TestWriteResults tests that the JSON and CSV files hold the summary and every point
+func TestWriteResults(t *testing.T) {
+	dir := t.TempDir()
+	lt := NewLoadTester("http://localhost:0", 10)
+	lt.SetOutputs(filepath.Join(dir, "results.json"), filepath.Join(dir, "results.csv"))
+	lt.stats.totalRequests = 3
+	lt.stats.successfulRequests = 3
+	lt.stats.latencies.record(2000)
+
+	// More points than the dashboard window keeps
+	start := time.Now()
+	for i := 0; i < 310; i++ {
+		lt.metricsHistory.AddPoint(DataPoint{Timestamp: start.Add(time.Duration(i) * time.Second), Success: int64(i)})
+	}
+	require.Len(t, lt.metricsHistory.GetPoints(), 300)
+
+	lt.writeResults(false)
+
+	data, err := os.ReadFile(filepath.Join(dir, "results.json"))
+	require.NoError(t, err)
+	var results TestResults
+	require.NoError(t, json.Unmarshal(data, &results))
+	assert.Equal(t, "checkout", results.Summary.TestType)
+	assert.Equal(t, int64(3), results.Summary.TotalRequests)
+	assert.Positive(t, results.Summary.LatencyP99Ms)
+	assert.Len(t, results.Points, 310)
+
+	f, err := os.Open(filepath.Join(dir, "results.csv"))
+	require.NoError(t, err)
+	defer f.Close()
+	rows, err := csv.NewReader(f).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, rows, 311)
+	assert.Equal(t, csvHeader, rows[0])
+	assert.Equal(t, "309", rows[310][6])
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// loadOp is one kind of storefront request / один вид запроса витрины
+type loadOp int
+
+// Operations of -mix / Операции -mix
+const (
+	opCheckout loadOp = iota // checkout only / только checkout
+	opChain                  // checkout->purchase
+	opStatus                 // GET /item read / чтение GET /item
+	opCount
+)
+
+// opNames are the -mix names, indexed by loadOp / имена для -mix, по индексу loadOp
+var opNames = [opCount]string{"checkout", "chain", "status"}
+
+func (op loadOp) String() string { return opNames[op] }
+
+// parseOp looks an operation up by name / ищет операцию по имени
+func parseOp(name string) (loadOp, bool) {
+	for i, known := range opNames {
+		if name == known {
+			return loadOp(i), true
+		}
+	}
+	return 0, false
+}
+
+// opStats counts the requests of one operation, atomic only / считает запросы одной операции, только атомарно
+type opStats struct {
+	requests     int64
+	successes    int64
+	totalLatency int64 // in microseconds / в микросекундах
+}
+
+// operationMix picks operations in proportion to their weights / выбирает операции пропорционально их весам
+type operationMix struct {
+	weights [opCount]int
+	total   int
+}
+
+// parseMix parses "op:weight,..." such as "checkout:70,chain:20,status:10"; weights are relative and need not sum to 100 /
+// разбирает "операция:вес,..." вида "checkout:70,chain:20,status:10"; веса относительные, сумма не обязана быть 100
+func parseMix(s string) (*operationMix, error) {
+	mix := &operationMix{}
+	seen := [opCount]bool{}
+
+	for _, part := range strings.Split(s, ",") {
+		name, weightStr, ok := strings.Cut(strings.TrimSpace(part), ":")
+		if !ok {
+			return nil, fmt.Errorf("operation %q: expected op:weight", part)
+		}
+
+		op, known := parseOp(name)
+		if !known {
+			return nil, fmt.Errorf("unknown operation %q, expected one of %s", name, strings.Join(opNames[:], ", "))
+		}
+		if seen[op] {
+			return nil, fmt.Errorf("operation %q listed twice", name)
+		}
+		seen[op] = true
+
+		weight, err := strconv.Atoi(weightStr)
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf("operation %q: weight %q must be a non-negative integer", name, weightStr)
+		}
+		mix.weights[op] = weight
+		mix.total += weight
+	}
+
+	if mix.total == 0 {
+		return nil, fmt.Errorf("all weights are zero")
+	}
+	return mix, nil
+}
+
+// pick maps n in [0, total) to its operation / сопоставляет n из [0, total) с его операцией
+func (m *operationMix) pick(n int) loadOp {
+	for op, weight := range m.weights {
+		if n < weight {
+			return loadOp(op)
+		}
+		n -= weight
+	}
+	return opCheckout
+}
+
+// includes reports whether op is ever picked / сообщает, выбирается ли op вообще
+func (m *operationMix) includes(op loadOp) bool {
+	return m.weights[op] > 0
+}
+
+// String formats the mix as op:percent of the total / форматирует смесь как операция:процент от суммы
+func (m *operationMix) String() string {
+	parts := make([]string, 0, opCount)
+	for op, weight := range m.weights {
+		if weight > 0 {
+			parts = append(parts, fmt.Sprintf("%s:%.0f%%", loadOp(op), float64(weight)/float64(m.total)*100))
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// SetMix replaces the checkout-only or chain mode with a weighted mix, nil keeps the mode / заменяет режим только checkout или цепочки взвешенной смесью, nil оставляет режим
+func (lt *LoadTester) SetMix(mix *operationMix) {
+	lt.mix = mix
+}
+
+// nextOp picks the operation of the next request / выбирает операцию следующего запроса
+func (lt *LoadTester) nextOp(testChain bool) loadOp {
+	switch {
+	case lt.mix != nil:
+		return lt.mix.pick(rand.Intn(lt.mix.total))
+	case testChain:
+		return opChain
+	default:
+		return opCheckout
+	}
+}
+
+// runOp performs one operation and records it in the per-operation stats / выполняет одну операцию и учитывает ее в статистике операций
+func (lt *LoadTester) runOp(op loadOp, userID, itemID int64) {
+	stats := &lt.stats.ops[op]
+	lt.stats.recordItem(itemID)
+	start := time.Now()
+
+	var ok bool
+	switch op {
+	case opChain:
+		ok = lt.makeChainedRequest(userID, itemID)
+	case opStatus:
+		ok = lt.makeStatusRequest(itemID)
+	default:
+		ok = lt.makeRequest(userID, itemID)
+	}
+
+	atomic.AddInt64(&stats.requests, 1)
+	atomic.AddInt64(&stats.totalLatency, time.Since(start).Microseconds())
+	if ok {
+		atomic.AddInt64(&stats.successes, 1)
+	}
+}
+
+// statusURL is the /item URL for an item / URL /item для товара
+func (lt *LoadTester) statusURL(itemID int64) string {
+	return fmt.Sprintf("%s/item?item_id=%d", lt.baseURL, itemID)
+}
+
+// makeStatusRequest reads an item status like a storefront page and reports whether it succeeded /
+// читает статус товара, как страница витрины, и сообщает, успешен ли запрос
+func (lt *LoadTester) makeStatusRequest(itemID int64) bool {
+	start := time.Now()
+
+	// Reads are GETs, the pooled requests are POSTs / Чтения - это GET, запросы из пула - POST
+	req, err := http.NewRequest(http.MethodGet, lt.statusURL(itemID), nil)
+	if err != nil {
+		atomic.AddInt64(&lt.stats.otherErrors, 1)
+		atomic.AddInt64(&lt.stats.totalRequests, 1)
+		return false
+	}
+	req.Header.Set("User-Agent", "LoadTester/2.0")
+
+	resp, err := lt.httpClient.Do(req)
+	if err != nil {
+		lt.stats.recordClientError(err, time.Since(start).Microseconds())
+		atomic.AddInt64(&lt.stats.totalRequests, 1)
+		return false
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	latency := time.Since(start).Microseconds()
+	lt.stats.recordLatency(latency)
+	atomic.AddInt64(&lt.stats.totalRequests, 1)
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		lt.stats.recordStatus(status200, latency)
+		atomic.AddInt64(&lt.stats.successfulRequests, 1)
+		return true
+	case http.StatusInternalServerError:
+		lt.stats.recordStatus(status500, latency)
+		atomic.AddInt64(&lt.stats.internalErrors, 1)
+	default:
+		atomic.AddInt64(&lt.stats.otherErrors, 1)
+	}
+	return false
+}
+
+// OperationSummary holds the final stats of one mix operation / итоговая статистика одной операции смеси
+type OperationSummary struct {
+	Operation    string  `json:"operation"`
+	Requests     int64   `json:"requests"`
+	Successes    int64   `json:"successes"`
+	LatencyAvgMs float64 `json:"latencyAvgMs"`
+}
+
+// operations is the mix in use, the recorded one while replaying, nil for a single operation /
+// используемая смесь, при повторе - записанная, nil для одной операции
+func (lt *LoadTester) operations() *operationMix {
+	if lt.replay != nil {
+		return lt.replay.mix
+	}
+	return lt.mix
+}
+
+// operationSummaries collects per-operation stats of the mix, nil without one / собирает статистику операций смеси, nil без нее
+func (lt *LoadTester) operationSummaries() []OperationSummary {
+	mix := lt.operations()
+	if mix == nil {
+		return nil
+	}
+
+	summaries := make([]OperationSummary, 0, opCount)
+	for op := range lt.stats.ops {
+		if !mix.includes(loadOp(op)) {
+			continue
+		}
+		stats := &lt.stats.ops[op]
+		summary := OperationSummary{
+			Operation: loadOp(op).String(),
+			Requests:  atomic.LoadInt64(&stats.requests),
+			Successes: atomic.LoadInt64(&stats.successes),
+		}
+		if summary.Requests > 0 {
+			summary.LatencyAvgMs = float64(atomic.LoadInt64(&stats.totalLatency)) / float64(summary.Requests) / 1000
+		}
+		summaries = append(summaries, summary)
+	}
+	return summaries
+}
+
+// printOperationStats prints the per-operation breakdown of a mix / выводит разбивку по операциям смеси
+func (lt *LoadTester) printOperationStats() {
+	summaries := lt.operationSummaries()
+	if summaries == nil {
+		return
+	}
+
+	fmt.Printf("\nOperation mix (%s):\n", lt.operations())
+	for _, summary := range summaries {
+		successRate := float64(0)
+		if summary.Requests > 0 {
+			successRate = float64(summary.Successes) / float64(summary.Requests) * 100
+		}
+		fmt.Printf("- %s: %d requests, %d successful (%.2f%%), avg latency %.2f ms\n",
+			summary.Operation, summary.Requests, summary.Successes, successRate, summary.LatencyAvgMs)
+	}
+}
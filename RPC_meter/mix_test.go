@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestParseMix tests mix parsing and its validation errors
+func TestParseMix(t *testing.T) {
+	mix, err := parseMix("checkout:70, chain:20,status:10")
+	require.NoError(t, err)
+	assert.Equal(t, [opCount]int{70, 20, 10}, mix.weights)
+	assert.Equal(t, "checkout:70%,chain:20%,status:10%", mix.String())
+
+	// Weights are relative, omitted operations are never picked
+	mix, err = parseMix("chain:1,status:3")
+	require.NoError(t, err)
+	assert.False(t, mix.includes(opCheckout))
+	assert.Equal(t, "chain:25%,status:75%", mix.String())
+
+	for _, bad := range []string{"", "checkout", "buy:10", "checkout:-1", "checkout:x", "checkout:1,checkout:2", "checkout:0,chain:0"} {
+		_, err := parseMix(bad)
+		assert.Error(t, err, "mix %q", bad)
+	}
+}
+
+// TestOperationMixPick tests that every operation gets exactly its share of [0, total)
+func TestOperationMixPick(t *testing.T) {
+	mix, err := parseMix("checkout:70,chain:20,status:10")
+	require.NoError(t, err)
+
+	var picked [opCount]int
+	for n := 0; n < mix.total; n++ {
+		picked[mix.pick(n)]++
+	}
+	assert.Equal(t, mix.weights, picked)
+}
+
+// TestRunOpMix tests that each operation hits its endpoint and is counted separately
+func TestRunOpMix(t *testing.T) {
+	var paths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.Method+" "+r.URL.Path)
+		switch r.URL.Path {
+		case "/checkout":
+			w.Write([]byte("550e8400-e29b-41d4-a716-446655440000"))
+		case "/purchase":
+			w.WriteHeader(http.StatusConflict)
+		case "/item":
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer srv.Close()
+
+	lt := NewLoadTester(srv.URL, 10)
+	mix, err := parseMix("checkout:1,chain:1,status:1")
+	require.NoError(t, err)
+	lt.SetMix(mix)
+
+	lt.runOp(opCheckout, 1, 2)
+	lt.runOp(opChain, 1, 3)
+	lt.runOp(opStatus, 1, 4)
+
+	assert.Equal(t, []string{"POST /checkout", "POST /checkout", "POST /purchase", "GET /item"}, paths)
+	assert.Equal(t, int64(3), lt.stats.totalRequests)
+	assert.Equal(t, int64(2), lt.stats.successfulRequests)
+	assert.Equal(t, int64(1), lt.stats.conflictErrors)
+
+	summaries := lt.operationSummaries()
+	require.Len(t, summaries, 3)
+	for _, summary := range summaries {
+		assert.Equal(t, int64(1), summary.Requests, summary.Operation)
+	}
+	assert.Equal(t, int64(1), summaries[opCheckout].Successes)
+	assert.Equal(t, int64(0), summaries[opChain].Successes, "the purchase was refused")
+	assert.Equal(t, int64(1), summaries[opStatus].Successes)
+	assert.Equal(t, "mix", lt.summary(true).TestType)
+}
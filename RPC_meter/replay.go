@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// traceEntry is one line of a -replay file / одна строка файла -replay
+type traceEntry struct {
+	Timestamp time.Time `json:"ts"`
+	UserID    int64     `json:"user_id"`
+	ItemID    int64     `json:"item_id"`
+	Op        string    `json:"op"` // checkout, chain or status, empty is checkout / checkout, chain или status, пустая - checkout
+}
+
+// trace is a recorded request sequence with offsets from its first request /
+// записанная последовательность запросов со смещениями от первого запроса
+type trace struct {
+	offsets []time.Duration
+	ops     []loadOp
+	users   []int64
+	items   []int64
+	// Recorded operation shares, reported like a -mix / Доли операций в записи, выводятся как -mix
+	mix *operationMix
+}
+
+// readTrace parses a JSON lines trace, timestamps must not go back /
+// разбирает трассу в формате JSON lines, время не должно идти назад
+func readTrace(r io.Reader) (*trace, error) {
+	tr := &trace{mix: &operationMix{}}
+	var first, prev time.Time
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var entry traceEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		op := opCheckout
+		if entry.Op != "" {
+			var ok bool
+			if op, ok = parseOp(entry.Op); !ok {
+				return nil, fmt.Errorf("line %d: unknown operation %q", line, entry.Op)
+			}
+		}
+
+		if first.IsZero() {
+			first = entry.Timestamp
+		} else if entry.Timestamp.Before(prev) {
+			return nil, fmt.Errorf("line %d: timestamp %v goes back from %v", line, entry.Timestamp, prev)
+		}
+		prev = entry.Timestamp
+
+		tr.offsets = append(tr.offsets, entry.Timestamp.Sub(first))
+		tr.ops = append(tr.ops, op)
+		tr.users = append(tr.users, entry.UserID)
+		tr.items = append(tr.items, entry.ItemID)
+		tr.mix.weights[op]++
+		tr.mix.total++
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	// Inter-arrival timing needs at least one gap / Для интервалов между запросами нужен хотя бы один промежуток
+	if len(tr.offsets) < 2 {
+		return nil, fmt.Errorf("trace has %d requests, at least 2 are needed", len(tr.offsets))
+	}
+	// A zero span gives a zero period and the replay loop would never advance / Нулевая длительность дает нулевой период, и цикл воспроизведения не продвигается
+	if tr.offsets[len(tr.offsets)-1] <= 0 {
+		return nil, fmt.Errorf("trace spans no time, all %d requests share one timestamp", len(tr.offsets))
+	}
+	return tr, nil
+}
+
+// loadTrace reads a trace file / читает файл трассы
+func loadTrace(path string) (*trace, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return readTrace(file)
+}
+
+// period is how long one pass lasts before the trace loops: its span plus the mean gap /
+// сколько длится один проход до повтора трассы: ее охват плюс средний промежуток
+func (tr *trace) period() time.Duration {
+	span := tr.offsets[len(tr.offsets)-1]
+	return span + span/time.Duration(len(tr.offsets)-1)
+}
+
+// rate is the mean recorded RPS / средний записанный RPS
+func (tr *trace) rate() float64 {
+	if tr.period() <= 0 {
+		return 0
+	}
+	return float64(len(tr.offsets)) / tr.period().Seconds()
+}
+
+// SetReplay replaces random requests with a recorded trace played at speed times its pace, nil keeps random requests /
+// заменяет случайные запросы записанной трассой, проигрываемой в speed раз быстрее, nil оставляет случайные запросы
+func (lt *LoadTester) SetReplay(tr *trace, speed float64) {
+	lt.replay = tr
+	lt.replaySpeed = speed
+}
+
+// runReplay issues the trace requests at their recorded offsets, looping until ctx ends /
+// отправляет запросы трассы в записанные моменты, повторяя ее, пока не завершится ctx
+func (lt *LoadTester) runReplay(ctx context.Context) {
+	tr := lt.replay
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	start := time.Now()
+	for pass := 0; ; pass++ {
+		for i, offset := range tr.offsets {
+			due := start.Add(time.Duration(float64(time.Duration(pass)*tr.period()+offset) / lt.replaySpeed))
+			if wait := time.Until(due); wait > 0 {
+				timer.Reset(wait)
+				select {
+				case <-ctx.Done():
+					return
+				case <-timer.C:
+				}
+			} else if ctx.Err() != nil {
+				return
+			}
+
+			// Paused requests are dropped, the timing goes on / Запросы на паузе пропускаются, отсчет времени продолжается
+			if lt.IsPaused() {
+				continue
+			}
+			go lt.runOp(tr.ops[i], tr.users[i], tr.items[i])
+		}
+	}
+}
+
+// dryRunReplay prints the trace timing and its first requests / выводит темп трассы и ее первые запросы
+func (lt *LoadTester) dryRunReplay(w io.Writer) {
+	tr := lt.replay
+	fmt.Fprintf(w, "Replay of %d requests (%s), one pass every %v at speed %g, %.1f RPS\n",
+		len(tr.offsets), tr.mix, time.Duration(float64(tr.period())/lt.replaySpeed), lt.replaySpeed, tr.rate()*lt.replaySpeed)
+
+	fmt.Fprintf(w, "First requests:\n")
+	for i := 0; i < min(dryRunSamples, len(tr.offsets)); i++ {
+		at := time.Duration(float64(tr.offsets[i]) / lt.replaySpeed)
+		switch tr.ops[i] {
+		case opStatus:
+			fmt.Fprintf(w, "- +%v GET %s\n", at, lt.statusURL(tr.items[i]))
+		case opChain:
+			fmt.Fprintf(w, "- +%v POST %s\n", at, lt.checkoutURL(tr.users[i], tr.items[i]))
+			fmt.Fprintf(w, "  POST %s\n", lt.purchaseURL("<code>"))
+		default:
+			fmt.Fprintf(w, "- +%v POST %s\n", at, lt.checkoutURL(tr.users[i], tr.items[i]))
+		}
+	}
+}
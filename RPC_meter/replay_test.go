@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testTrace = `{"ts":"2025-06-01T12:00:00.000Z","user_id":1,"item_id":10,"op":"checkout"}
+{"ts":"2025-06-01T12:00:00.100Z","user_id":2,"item_id":20,"op":"chain"}
+
+{"ts":"2025-06-01T12:00:00.200Z","user_id":3,"item_id":30}
+`
+
+// TestReadTrace tests trace parsing, its timing and validation errors
+func TestReadTrace(t *testing.T) {
+	tr, err := readTrace(strings.NewReader(testTrace))
+	require.NoError(t, err)
+	assert.Equal(t, []time.Duration{0, 100 * time.Millisecond, 200 * time.Millisecond}, tr.offsets)
+	assert.Equal(t, []loadOp{opCheckout, opChain, opCheckout}, tr.ops, "op defaults to checkout")
+	assert.Equal(t, []int64{1, 2, 3}, tr.users)
+	assert.Equal(t, []int64{10, 20, 30}, tr.items)
+	assert.Equal(t, "checkout:67%,chain:33%", tr.mix.String())
+	assert.Equal(t, 300*time.Millisecond, tr.period(), "span plus the mean gap")
+	assert.InDelta(t, 10.0, tr.rate(), 0.001)
+
+	for name, bad := range map[string]string{
+		"not json":       "checkout",
+		"unknown op":     `{"ts":"2025-06-01T12:00:00Z","op":"buy"}` + "\n" + `{"ts":"2025-06-01T12:00:01Z"}`,
+		"time goes back": `{"ts":"2025-06-01T12:00:01Z"}` + "\n" + `{"ts":"2025-06-01T12:00:00Z"}`,
+		"single request": `{"ts":"2025-06-01T12:00:00Z"}`,
+		"zero span":      `{"ts":"2025-06-01T12:00:00Z"}` + "\n" + `{"ts":"2025-06-01T12:00:00Z"}`,
+		"empty":          "",
+	} {
+		_, err := readTrace(strings.NewReader(bad))
+		assert.Error(t, err, name)
+	}
+}
+
+// TestRunReplay tests that the trace is replayed in order with its timing and looped
+func TestRunReplay(t *testing.T) {
+	var mu sync.Mutex
+	var items []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/checkout" {
+			mu.Lock()
+			items = append(items, r.URL.Query().Get("user_id")+":"+r.URL.Query().Get("item_id"))
+			mu.Unlock()
+		}
+		w.WriteHeader(http.StatusConflict)
+	}))
+	defer srv.Close()
+
+	tr, err := readTrace(strings.NewReader(testTrace))
+	require.NoError(t, err)
+	lt := NewLoadTester(srv.URL, 10)
+	lt.SetReplay(tr, 1)
+
+	// Requests are due at 0, 100ms, 200ms, then the loop at 300ms
+	ctx, cancel := context.WithTimeout(context.Background(), 350*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	lt.runReplay(ctx)
+	assert.GreaterOrEqual(t, time.Since(start), 350*time.Millisecond)
+
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(items) == 4
+	}, time.Second, time.Millisecond)
+	mu.Lock()
+	assert.Equal(t, []string{"1:10", "2:20", "3:30", "1:10"}, items)
+	mu.Unlock()
+}
+
+// TestDryRunReplay tests that a dry run shows the trace scaled by speed without sending requests
+func TestDryRunReplay(t *testing.T) {
+	srv, hits := newCountingServer(t)
+	tr, err := readTrace(strings.NewReader(testTrace))
+	require.NoError(t, err)
+	lt := NewLoadTester(srv.URL, 10)
+	lt.SetReplay(tr, 2)
+
+	var out strings.Builder
+	lt.DryRun(&out, 1, 1, true)
+
+	assert.Zero(t, atomic.LoadInt64(hits), "a dry run must not send traffic")
+	assert.Contains(t, out.String(), "Replay of 3 requests (checkout:67%,chain:33%), one pass every 150ms at speed 2, 20.0 RPS")
+	assert.Contains(t, out.String(), "- +50ms POST "+srv.URL+"/checkout?user_id=2&item_id=20")
+	assert.Contains(t, out.String(), "- +100ms POST "+srv.URL+"/checkout?user_id=3&item_id=30")
+	assert.Equal(t, 1, strings.Count(out.String(), "/purchase?code=<code>"))
+}
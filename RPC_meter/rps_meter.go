@@ -6,11 +6,14 @@ import (
 	"flag"
 	"fmt"
 	"io"
+	"math"
 	"math/rand"
 	"net"
 	"net/http"
+	"os"
 	"regexp"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -37,6 +40,115 @@ type Stats struct {
 	purchaseSuccesses int64
 	checkoutErrors    int64
 	purchaseErrors    int64
+	// Latency distribution for percentiles / Распределение латентности для перцентилей
+	latencies latencyHistogram
+	// Per operation of -mix / По операциям -mix
+	ops [opCount]opStats
+	// Per response class, a chain counts under the response that ended it / По классам ответов, цепочка учитывается по ответу, которым завершилась
+	byStatus [statusClassCount]statusLatency
+	// Requests per item ID / Запросы по ID товара
+	itemHits [itemsRange]int64
+}
+
+// recordLatency adds one request latency in microseconds to the totals, histogram and min/max / добавляет латентность запроса в микросекундах в сумму, гистограмму и мин/макс
+func (s *Stats) recordLatency(latency int64) {
+	atomic.AddInt64(&s.totalLatency, latency)
+	s.latencies.record(latency)
+
+	// Update min/max latency / Обновляем мин/макс латентность
+	for {
+		current := atomic.LoadInt64(&s.maxLatency)
+		if latency <= current || atomic.CompareAndSwapInt64(&s.maxLatency, current, latency) {
+			break
+		}
+	}
+
+	for {
+		current := atomic.LoadInt64(&s.minLatency)
+		if latency >= current || atomic.CompareAndSwapInt64(&s.minLatency, current, latency) {
+			break
+		}
+	}
+}
+
+// reset zeroes every counter in place and restarts the clock at now, workers may keep recording meanwhile /
+// обнуляет все счетчики на месте и перезапускает отсчет с now, воркеры могут продолжать запись в это время
+func (s *Stats) reset(now time.Time) {
+	for _, counter := range []*int64{
+		&s.checkoutRequests, &s.purchaseRequests, &s.checkoutSuccesses, &s.purchaseSuccesses, &s.checkoutErrors, &s.purchaseErrors,
+		&s.totalRequests, &s.internalErrors, &s.successfulRequests, &s.conflictErrors, &s.otherErrors, &s.timeouts,
+		&s.totalLatency, &s.maxLatency,
+	} {
+		atomic.StoreInt64(counter, 0)
+	}
+	atomic.StoreInt64(&s.minLatency, int64(^uint64(0)>>1))
+
+	for i := range s.latencies.counts {
+		atomic.StoreInt64(&s.latencies.counts[i], 0)
+	}
+	for i := range s.ops {
+		atomic.StoreInt64(&s.ops[i].requests, 0)
+		atomic.StoreInt64(&s.ops[i].successes, 0)
+		atomic.StoreInt64(&s.ops[i].totalLatency, 0)
+	}
+	for i := range s.itemHits {
+		atomic.StoreInt64(&s.itemHits[i], 0)
+	}
+	for i := range s.byStatus {
+		atomic.StoreInt64(&s.byStatus[i].requests, 0)
+		atomic.StoreInt64(&s.byStatus[i].totalLatency, 0)
+		for j := range s.byStatus[i].latencies.counts {
+			atomic.StoreInt64(&s.byStatus[i].latencies.counts[j], 0)
+		}
+	}
+
+	s.startTime = now
+}
+
+// Latency histogram layout: bucket i holds [growth^i, growth^(i+1)) microseconds, ~5% error up to ~100s /
+// Устройство гистограммы: корзина i хранит [growth^i, growth^(i+1)) микросекунд, погрешность ~5% до ~100 с
+const (
+	latencyBucketGrowth = 1.1
+	latencyBuckets      = 200
+)
+
+// latencyHistogram counts latencies in exponential buckets, safe for concurrent use /
+// считает латентности в экспоненциальных корзинах, безопасна для конкурентного использования
+type latencyHistogram struct {
+	counts [latencyBuckets]int64
+}
+
+// record adds one latency in microseconds / добавляет одну латентность в микросекундах
+func (h *latencyHistogram) record(latencyMicros int64) {
+	bucket := 0
+	if latencyMicros > 1 {
+		bucket = min(int(math.Log(float64(latencyMicros))/math.Log(latencyBucketGrowth)), latencyBuckets-1)
+	}
+	atomic.AddInt64(&h.counts[bucket], 1)
+}
+
+// percentile returns the upper bound of the bucket holding quantile q (0..1) in milliseconds, 0 if empty /
+// возвращает верхнюю границу корзины с квантилем q (0..1) в миллисекундах, 0 если данных нет
+func (h *latencyHistogram) percentile(q float64) float64 {
+	var counts [latencyBuckets]int64
+	var total int64
+	for i := range h.counts {
+		counts[i] = atomic.LoadInt64(&h.counts[i])
+		total += counts[i]
+	}
+	if total == 0 {
+		return 0
+	}
+
+	rank := int64(math.Ceil(q * float64(total)))
+	var seen int64
+	for i, count := range counts {
+		seen += count
+		if seen >= rank {
+			return math.Pow(latencyBucketGrowth, float64(i+1)) / 1000
+		}
+	}
+	return math.Pow(latencyBucketGrowth, latencyBuckets) / 1000
 }
 
 // DataPoint represents chart data point / Структура для точки данных на графике
@@ -47,17 +159,27 @@ type DataPoint struct {
 	ErrorRate float64   `json:"errorRate"`
 	Success   int64     `json:"success"`
 	Errors500 int64     `json:"errors500"`
+	Timeouts  int64     `json:"timeouts"`
 	// Additional fields for chain testing / Дополнительные поля для цепочки
 	CheckoutReqs int64 `json:"checkoutReqs"`
 	PurchaseReqs int64 `json:"purchaseReqs"`
 	CheckoutSucc int64 `json:"checkoutSucc"`
 	PurchaseSucc int64 `json:"purchaseSucc"`
+	// Load shape / Форма нагрузки
+	TargetRPS   int64   `json:"targetRps"`        // scheduled RPS at this moment / запланированный RPS в этот момент
+	AchievedRPS float64 `json:"achievedRps"`      // RPS over the last interval / RPS за последний интервал
+	Warmup      bool    `json:"warmup,omitempty"` // collected before the stats reset / собрана до сброса статистики
+	// Average latency in ms per response class / Средняя латентность в мс по классам ответов
+	LatencyByStatus map[string]float64 `json:"latencyByStatus,omitempty"`
 }
 
 // MetricsHistory stores historical data / Структура для хранения исторических данных
 type MetricsHistory struct {
 	mu     sync.RWMutex
 	points []DataPoint
+	// Whole run kept for export, nil unless enabled / Весь прогон для экспорта, nil если не включено
+	all     []DataPoint
+	keepAll bool
 }
 
 // AddPoint adds new data point to history / Добавляет новую точку данных в историю
@@ -66,6 +188,9 @@ func (mh *MetricsHistory) AddPoint(point DataPoint) {
 	defer mh.mu.Unlock()
 
 	mh.points = append(mh.points, point)
+	if mh.keepAll {
+		mh.all = append(mh.all, point)
+	}
 
 	// Keep only last 300 points (5 minutes at 1 second interval) / Храним только последние 300 точек (5 минут при интервале в 1 секунду)
 	if len(mh.points) > 300 {
@@ -84,6 +209,28 @@ func (mh *MetricsHistory) GetPoints() []DataPoint {
 	return result
 }
 
+// KeepAll keeps every point for AllPoints, beyond the dashboard window / хранит все точки для AllPoints, сверх окна дашборда
+func (mh *MetricsHistory) KeepAll() {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+	mh.keepAll = true
+}
+
+// AllPoints returns every point of the run if KeepAll was called, otherwise the dashboard window /
+// возвращает все точки прогона, если был вызван KeepAll, иначе окно дашборда
+func (mh *MetricsHistory) AllPoints() []DataPoint {
+	mh.mu.RLock()
+	keepAll := mh.keepAll
+	result := make([]DataPoint, len(mh.all))
+	copy(result, mh.all)
+	mh.mu.RUnlock()
+
+	if !keepAll {
+		return mh.GetPoints()
+	}
+	return result
+}
+
 // LoadTester main structure for load testing / Основная структура для нагрузочного тестирования
 type LoadTester struct {
 	baseURL    string
@@ -98,6 +245,167 @@ type LoadTester struct {
 	// New fields for charts / Новые поля для графиков
 	metricsHistory *MetricsHistory
 	webServer      *http.Server
+
+	// Load control from dashboard / Управление нагрузкой из дашборда
+	paused   int32         // Atomic flag: workers skip sending while set / Атомарный флаг: пока установлен, воркеры не отправляют запросы
+	stopCh   chan struct{} // Closed on stop / Закрывается при остановке
+	stopOnce sync.Once
+
+	// Load shape: nil ramp keeps the constant -rps / Форма нагрузки: без ramp держим постоянный -rps
+	ramp      rampSchedule
+	targetRPS int64 // current total target, atomic / текущая общая цель, атомарная переменная
+
+	// Warmup excluded from stats, warming is an atomic flag / Прогрев, исключенный из статистики, warming - атомарный флаг
+	warmup  time.Duration
+	warming int32
+
+	// Item ID picking: nil is uniform / Выбор ID товаров: nil - равномерно
+	items *itemDistribution
+
+	// Request kinds: nil mix keeps checkout only or -chain / Виды запросов: без mix - только checkout или -chain
+	mix *operationMix
+
+	// Recorded requests replacing the workers, nil generates random ones / Записанные запросы вместо воркеров, nil - генерируем случайные
+	replay      *trace
+	replaySpeed float64
+
+	// Previous sample for the per-interval RPS / Предыдущий замер для RPS за интервал
+	lastTotal   int64
+	lastCollect time.Time
+
+	// Result files written when the test ends, empty skips / Файлы результатов после теста, пустой путь - пропускаем
+	outputJSON string
+	outputCSV  string
+}
+
+// rampPollInterval is how often the target is recomputed and idle workers look again /
+// как часто пересчитывается цель и простаивающие воркеры проверяют ее снова
+const rampPollInterval = 100 * time.Millisecond
+
+// rampPhase moves the target RPS linearly to Target over Duration / линейно ведет целевой RPS к Target за Duration
+type rampPhase struct {
+	Target   int
+	Duration time.Duration
+}
+
+// rampSchedule is a sequence of phases starting from 0 RPS / последовательность фаз, начиная с 0 RPS
+type rampSchedule []rampPhase
+
+// parseRamp parses "rps:duration,..." such as "0:30s,50000:10s" / разбирает "rps:длительность,..." вида "0:30s,50000:10s"
+func parseRamp(s string) (rampSchedule, error) {
+	var schedule rampSchedule
+	for _, part := range strings.Split(s, ",") {
+		target, duration, ok := strings.Cut(strings.TrimSpace(part), ":")
+		if !ok {
+			return nil, fmt.Errorf("phase %q: expected rps:duration", part)
+		}
+
+		rps, err := strconv.Atoi(target)
+		if err != nil || rps < 0 {
+			return nil, fmt.Errorf("phase %q: RPS must be a non-negative integer", part)
+		}
+
+		d, err := parseDuration(duration)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("phase %q: duration must be positive", part)
+		}
+
+		schedule = append(schedule, rampPhase{Target: rps, Duration: d})
+	}
+	return schedule, nil
+}
+
+// targetAt returns the target RPS after elapsed, holding the last target once all phases are over /
+// возвращает целевой RPS спустя elapsed, после всех фаз держит последнюю цель
+func (r rampSchedule) targetAt(elapsed time.Duration) int {
+	from := 0
+	for _, phase := range r {
+		if elapsed < phase.Duration {
+			progress := float64(elapsed) / float64(phase.Duration)
+			return from + int(float64(phase.Target-from)*progress)
+		}
+		elapsed -= phase.Duration
+		from = phase.Target
+	}
+	return from
+}
+
+// peak returns the highest target of the schedule / возвращает наибольшую цель расписания
+func (r rampSchedule) peak() int {
+	peak := 0
+	for _, phase := range r {
+		peak = max(peak, phase.Target)
+	}
+	return peak
+}
+
+// total returns the length of all phases / возвращает длину всех фаз
+func (r rampSchedule) total() time.Duration {
+	var total time.Duration
+	for _, phase := range r {
+		total += phase.Duration
+	}
+	return total
+}
+
+// SetRamp replaces the constant RPS with a ramp schedule / заменяет постоянный RPS расписанием разгона
+func (lt *LoadTester) SetRamp(schedule rampSchedule) {
+	lt.ramp = schedule
+}
+
+// workerRate returns the share of worker i out of n in the current target, spreading the remainder /
+// возвращает долю воркера i из n в текущей цели, распределяя остаток
+func (lt *LoadTester) workerRate(i, n int) func() int {
+	return func() int {
+		target := int(atomic.LoadInt64(&lt.targetRPS))
+		rate := target / n
+		if i < target%n {
+			rate++
+		}
+		return rate
+	}
+}
+
+// runRamp moves the target along the schedule until ctx ends / ведет цель по расписанию, пока не завершится ctx
+func (lt *LoadTester) runRamp(ctx context.Context, start time.Time) {
+	ticker := time.NewTicker(rampPollInterval)
+	defer ticker.Stop()
+
+	for {
+		atomic.StoreInt64(&lt.targetRPS, int64(lt.ramp.targetAt(time.Since(start))))
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Load tester states reported to dashboard / Состояния нагрузочного тестера для дашборда
+const (
+	stateRunning = "running"
+	stateWarmup  = "warmup"
+	statePaused  = "paused"
+	stateStopped = "stopped"
+)
+
+// SetWarmup runs the load for d before the test duration without recording it / сначала дает нагрузку в течение d, не учитывая ее, а затем длительность теста
+func (lt *LoadTester) SetWarmup(d time.Duration) {
+	lt.warmup = d
+}
+
+// IsWarmingUp reports whether the warmup is still running / Сообщает, идет ли еще прогрев
+func (lt *LoadTester) IsWarmingUp() bool {
+	return atomic.LoadInt32(&lt.warming) == 1
+}
+
+// endWarmup drops everything recorded so far and starts counting the steady state /
+// отбрасывает все учтенное до сих пор и начинает учет установившегося режима
+func (lt *LoadTester) endWarmup() {
+	now := time.Now()
+	lt.stats.reset(now)
+	lt.lastTotal, lt.lastCollect = 0, now
+	atomic.StoreInt32(&lt.warming, 0)
 }
 
 // NewLoadTester creates new load tester instance / Создает новый экземпляр нагрузочного тестера
@@ -138,6 +446,7 @@ func NewLoadTester(baseURL string, maxUsers int) *LoadTester {
 
 		// Initialize new fields / Инициализация новых полей
 		metricsHistory: &MetricsHistory{},
+		stopCh:         make(chan struct{}),
 	}
 
 	// Initialize request pool / Инициализация пула запросов
@@ -146,6 +455,8 @@ func NewLoadTester(baseURL string, maxUsers int) *LoadTester {
 			req, _ := http.NewRequest("POST", "", nil)
 			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 			req.Header.Set("User-Agent", "LoadTester/2.0")
+			// JSON checkout response, purchase ignores it / JSON ответ checkout, purchase его игнорирует
+			req.Header.Set("Accept", "application/json")
 			return req
 		},
 	}
@@ -161,6 +472,8 @@ func (lt *LoadTester) StartWebDashboard(port int) {
 	mux.HandleFunc("/", lt.handleDashboard)
 	// API for metrics data / API для получения данных
 	mux.HandleFunc("/api/metrics", lt.handleMetricsAPI)
+	// API for pause/resume/stop / API для паузы/возобновления/остановки
+	mux.HandleFunc("/api/control", lt.handleControlAPI)
 
 	lt.webServer = &http.Server{
 		Addr:    fmt.Sprintf(":%d", port),
@@ -214,6 +527,23 @@ func (lt *LoadTester) handleDashboard(w http.ResponseWriter, r *http.Request) {
             animation: pulse 2s infinite;
         }
         .status-running { background-color: #10b981; }
+        .status-warmup { background-color: #3b82f6; }
+        .status-paused { background-color: #f59e0b; animation: none; }
+        .status-stopped { background-color: #ef4444; animation: none; }
+        .controls { margin-top: 10px; }
+        .controls button {
+            padding: 6px 16px;
+            margin: 0 4px;
+            border: none;
+            border-radius: 6px;
+            color: white;
+            cursor: pointer;
+            font-size: 0.9em;
+        }
+        .controls button:disabled { opacity: 0.4; cursor: default; }
+        #pauseBtn { background: #f59e0b; }
+        #resumeBtn { background: #10b981; }
+        #stopBtn { background: #ef4444; }
         @keyframes pulse {
             0% { opacity: 1; }
             50% { opacity: 0.5; }
@@ -234,14 +564,23 @@ func (lt *LoadTester) handleDashboard(w http.ResponseWriter, r *http.Request) {
     <div class="container">
         <h1>🚀 RPS Meter - Real-Time Monitoring</h1>
         <div class="test-info">
-            <span class="status-indicator status-running"></span>
-            <strong>Test Active</strong> | Updates every second
+            <span class="status-indicator status-running" id="statusIndicator"></span>
+            <strong id="statusText">Test Active</strong> | Updates every second
+            <div class="controls">
+                <button id="pauseBtn" onclick="sendControl('pause')">⏸ Pause</button>
+                <button id="resumeBtn" onclick="sendControl('resume')">▶ Resume</button>
+                <button id="stopBtn" onclick="sendControl('stop')">⏹ Stop</button>
+            </div>
         </div>
         <div class="stats">
             <div class="stat-card">
                 <div class="stat-value" id="currentRPS">0</div>
                 <div class="stat-label">Current RPS</div>
             </div>
+            <div class="stat-card">
+                <div class="stat-value" id="targetRPS">0</div>
+                <div class="stat-label">Target RPS</div>
+            </div>
             <div class="stat-card">
                 <div class="stat-value" id="avgLatency">0ms</div>
                 <div class="stat-label">Average Latency</div>
@@ -250,6 +589,10 @@ func (lt *LoadTester) handleDashboard(w http.ResponseWriter, r *http.Request) {
                 <div class="stat-value" id="errorRate">0%</div>
                 <div class="stat-label">Error Rate</div>
             </div>
+            <div class="stat-card">
+                <div class="stat-value" id="timeouts">0</div>
+                <div class="stat-label">Timeouts</div>
+            </div>
             <div class="stat-card">
                 <div class="stat-value" id="totalRequests">0</div>
                 <div class="stat-label">Total Requests</div>
@@ -325,15 +668,31 @@ func (lt *LoadTester) handleDashboard(w http.ResponseWriter, r *http.Request) {
         const rpsChart = new Chart(document.getElementById('rpsChart'), {
             ...chartConfig,
             data: {
-                datasets: [{
-                    label: 'RPS',
-                    data: [],
-                    borderColor: 'rgb(37, 99, 235)',
-                    backgroundColor: 'rgba(37, 99, 235, 0.1)',
-                    fill: true
-                }]
+                datasets: [
+                    {
+                        label: 'Achieved RPS',
+                        data: [],
+                        borderColor: 'rgb(37, 99, 235)',
+                        backgroundColor: 'rgba(37, 99, 235, 0.1)',
+                        fill: true
+                    },
+                    {
+                        label: 'Target RPS',
+                        data: [],
+                        borderColor: 'rgb(245, 158, 11)',
+                        borderDash: [6, 4],
+                        fill: false
+                    }
+                ]
             }
         });
+        // Per-status lines start hidden, click the legend to show them
+        const statusLatencyLines = [
+            { status: '200', color: 'rgb(34, 197, 94)' },
+            { status: '409', color: 'rgb(245, 158, 11)' },
+            { status: '500', color: 'rgb(239, 68, 68)' },
+            { status: 'timeout', color: 'rgb(107, 114, 128)' }
+        ];
         const latencyChart = new Chart(document.getElementById('latencyChart'), {
             ...chartConfig,
             data: {
@@ -343,7 +702,13 @@ func (lt *LoadTester) handleDashboard(w http.ResponseWriter, r *http.Request) {
                     borderColor: 'rgb(16, 185, 129)',
                     backgroundColor: 'rgba(16, 185, 129, 0.1)',
                     fill: true
-                }]
+                }, ...statusLatencyLines.map(line => ({
+                    label: line.status + ' latency (ms)',
+                    data: [],
+                    borderColor: line.color,
+                    fill: false,
+                    hidden: true
+                }))]
             }
         });
         const statusChart = new Chart(document.getElementById('statusChart'), {
@@ -364,6 +729,13 @@ func (lt *LoadTester) handleDashboard(w http.ResponseWriter, r *http.Request) {
                         backgroundColor: 'rgba(239, 68, 68, 0.1)',
                         fill: false
                     },
+                    {
+                        label: '⏱️ Timeouts',
+                        data: [],
+                        borderColor: 'rgb(107, 114, 128)',
+                        backgroundColor: 'rgba(107, 114, 128, 0.1)',
+                        fill: false
+                    },
                 ]
             }
         });
@@ -418,21 +790,33 @@ func (lt *LoadTester) handleDashboard(w http.ResponseWriter, r *http.Request) {
                     document.getElementById('chainChartContainer').style.display = 'block';
                     document.querySelector('.charts').style.gridTemplateColumns = '1fr 1fr';
                 }
-                document.getElementById('currentRPS').textContent = Math.round(latest.rps);
+                document.getElementById('currentRPS').textContent = Math.round(latest.achievedRps);
+                document.getElementById('targetRPS').textContent = latest.targetRps;
                 document.getElementById('avgLatency').textContent = Math.round(latest.latency) + 'ms';
                 document.getElementById('errorRate').textContent = Math.round(latest.errorRate) + '%';
+                document.getElementById('timeouts').textContent = latest.timeouts.toLocaleString();
                 const totalReqs = latest.success + latest.errors500;
                 document.getElementById('totalRequests').textContent = totalReqs.toLocaleString();
                 const successRate = totalReqs > 0 ? (latest.success / totalReqs * 100) : 0;
                 document.getElementById('successRate').textContent = Math.round(successRate) + '%';
                 rpsChart.data.datasets[0].data = data.map(point => ({
                     x: new Date(point.timestamp),
-                    y: point.rps
+                    y: point.achievedRps
+                }));
+                rpsChart.data.datasets[1].data = data.map(point => ({
+                    x: new Date(point.timestamp),
+                    y: point.targetRps
                 }));
                 latencyChart.data.datasets[0].data = data.map(point => ({
                     x: new Date(point.timestamp),
                     y: point.latency
                 }));
+                statusLatencyLines.forEach((line, i) => {
+                    latencyChart.data.datasets[i + 1].data = data.map(point => ({
+                        x: new Date(point.timestamp),
+                        y: point.latencyByStatus?.[line.status] ?? null
+                    }));
+                });
                 statusChart.data.datasets[0].data = data.map(point => ({
                     x: new Date(point.timestamp),
                     y: point.success
@@ -441,6 +825,10 @@ func (lt *LoadTester) handleDashboard(w http.ResponseWriter, r *http.Request) {
                     x: new Date(point.timestamp),
                     y: point.errors500
                 }));
+                statusChart.data.datasets[2].data = data.map(point => ({
+                    x: new Date(point.timestamp),
+                    y: point.timeouts
+                }));
                 if (isChainTest) {
                     chainChart.data.datasets[0].data = data.map(point => ({
                         x: new Date(point.timestamp),
@@ -467,9 +855,40 @@ func (lt *LoadTester) handleDashboard(w http.ResponseWriter, r *http.Request) {
                 console.error('Error fetching data:', error);
             }
         }
+        const stateLabels = { running: 'Test Active', warmup: 'Warming Up (not counted)', paused: 'Test Paused', stopped: 'Test Stopped' };
+        function renderState(state) {
+            document.getElementById('statusIndicator').className = 'status-indicator status-' + state;
+            document.getElementById('statusText').textContent = stateLabels[state] || state;
+            document.getElementById('pauseBtn').disabled = state !== 'running' && state !== 'warmup';
+            document.getElementById('resumeBtn').disabled = state !== 'paused';
+            document.getElementById('stopBtn').disabled = state === 'stopped';
+        }
+        async function sendControl(action) {
+            if (action === 'stop' && !confirm('Stop the load test?')) return;
+            try {
+                const response = await fetch('/api/control', {
+                    method: 'POST',
+                    headers: { 'Content-Type': 'application/x-www-form-urlencoded' },
+                    body: 'action=' + action
+                });
+                renderState((await response.json()).state);
+            } catch (error) {
+                console.error('Error sending control action:', error);
+            }
+        }
+        async function updateState() {
+            try {
+                const response = await fetch('/api/control');
+                renderState((await response.json()).state);
+            } catch (error) {
+                console.error('Error fetching state:', error);
+            }
+        }
         adjustChartContainers();
         updateCharts();
+        updateState();
         setInterval(updateCharts, 1000);
+        setInterval(updateState, 1000);
         window.addEventListener('resize', adjustChartContainers);
     </script>
 </body>
@@ -486,6 +905,71 @@ func (lt *LoadTester) handleMetricsAPI(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(points)
 }
 
+// Pause stops workers from sending new requests / Останавливает отправку новых запросов воркерами
+func (lt *LoadTester) Pause() {
+	atomic.StoreInt32(&lt.paused, 1)
+}
+
+// Resume lets workers send requests again / Разрешает воркерам снова отправлять запросы
+func (lt *LoadTester) Resume() {
+	atomic.StoreInt32(&lt.paused, 0)
+}
+
+// Stop finishes the test early, keeping the dashboard running / Досрочно завершает тест, дашборд продолжает работать
+func (lt *LoadTester) Stop() {
+	lt.stopOnce.Do(func() { close(lt.stopCh) })
+}
+
+// IsPaused reports whether load generation is paused / Сообщает, приостановлена ли генерация нагрузки
+func (lt *LoadTester) IsPaused() bool {
+	return atomic.LoadInt32(&lt.paused) == 1
+}
+
+// State returns current load tester state / Возвращает текущее состояние нагрузочного тестера
+func (lt *LoadTester) State() string {
+	select {
+	case <-lt.stopCh:
+		return stateStopped
+	default:
+	}
+	if lt.IsPaused() {
+		return statePaused
+	}
+	if lt.IsWarmingUp() {
+		return stateWarmup
+	}
+	return stateRunning
+}
+
+// handleControlAPI applies pause/resume/stop actions and reports state / Применяет действия pause/resume/stop и возвращает состояние
+func (lt *LoadTester) handleControlAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if r.Method == http.MethodPost {
+		switch action := r.FormValue("action"); action {
+		case "pause":
+			lt.Pause()
+			fmt.Printf("⏸️  Load paused from dashboard\n")
+		case "resume":
+			lt.Resume()
+			fmt.Printf("▶️  Load resumed from dashboard\n")
+		case "stop":
+			lt.Stop()
+			fmt.Printf("⏹️  Load stopped from dashboard\n")
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("unknown action %q", action)})
+			return
+		}
+	} else if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{"state": lt.State()})
+}
+
 // collectMetrics gathers and stores current metrics / Метод сбора метрик
 func (lt *LoadTester) collectMetrics() {
 	elapsed := time.Since(lt.stats.startTime).Seconds()
@@ -515,18 +999,37 @@ func (lt *LoadTester) collectMetrics() {
 		avgLatency = float64(totalLatency) / float64(total) / 1000
 	}
 
+	// The cumulative average lags behind a ramp, so also measure the last interval /
+	// Накопленное среднее отстает от разгона, поэтому меряем и последний интервал
+	now := time.Now()
+	since := lt.lastCollect
+	if since.IsZero() {
+		since = lt.stats.startTime
+	}
+	achievedRPS := float64(0)
+	if interval := now.Sub(since).Seconds(); interval > 0 {
+		achievedRPS = float64(total-lt.lastTotal) / interval
+	}
+	lt.lastTotal, lt.lastCollect = total, now
+
 	// Add point to history / Добавляем точку в историю
 	point := DataPoint{
-		Timestamp:    time.Now(),
+		Timestamp:    now,
 		RPS:          currentRPS,
 		Latency:      avgLatency,
 		ErrorRate:    errorRate,
 		Success:      successful,
 		Errors500:    errors500,
+		Timeouts:     atomic.LoadInt64(&lt.stats.timeouts),
 		CheckoutReqs: checkoutReqs,
 		PurchaseReqs: purchaseReqs,
 		CheckoutSucc: checkoutSucc,
 		PurchaseSucc: purchaseSucc,
+		TargetRPS:    atomic.LoadInt64(&lt.targetRPS),
+		AchievedRPS:  achievedRPS,
+		Warmup:       lt.IsWarmingUp(),
+
+		LatencyByStatus: lt.stats.statusAverages(),
 	}
 
 	lt.metricsHistory.AddPoint(point)
@@ -536,19 +1039,29 @@ func (lt *LoadTester) collectMetrics() {
 func (lt *LoadTester) generateRequest() (int64, int64) {
 	// IMPORTANT: Using logic from old working code / ВАЖНО: Используем логику из старого рабочего кода
 	// If maxUsers = 0 or very large, use 1_000_000 as in old code / Если maxUsers = 0 или очень большое, используем 1_000_000 как в старом коде
+	// The server accepts user IDs from 1 / Сервер принимает ID пользователей начиная с 1
 	var userID int64
 	if lt.maxUsers <= 0 || lt.maxUsers > 1_000_000 {
-		userID = rand.Int63n(1_000_000) // as in old code / как в старом коде
+		userID = rand.Int63n(1_000_000) + 1 // as in old code / как в старом коде
 	} else {
-		userID = rand.Int63n(lt.maxUsers) // from 0 to maxUsers-1 / от 0 до maxUsers-1
+		userID = rand.Int63n(lt.maxUsers) + 1 // from 1 to maxUsers / от 1 до maxUsers
 	}
 
-	itemID := rand.Int63n(10000) // as in old code / как в старом коде
-	return userID, itemID
+	return userID, lt.items.next()
+}
+
+// checkoutURL is the /checkout URL for a user and item / URL /checkout для пользователя и товара
+func (lt *LoadTester) checkoutURL(userID, itemID int64) string {
+	return fmt.Sprintf("%s/checkout?user_id=%d&item_id=%d", lt.baseURL, userID, itemID)
+}
+
+// purchaseURL is the /purchase URL for a checkout code / URL /purchase для кода checkout
+func (lt *LoadTester) purchaseURL(code string) string {
+	return fmt.Sprintf("%s/purchase?code=%s", lt.baseURL, code)
 }
 
-// makeRequest performs single checkout request / Старый метод для тестирования только checkout
-func (lt *LoadTester) makeRequest(userID, itemID int64) {
+// makeRequest performs single checkout request and reports whether it succeeded / Старый метод для тестирования только checkout, сообщает, успешен ли запрос
+func (lt *LoadTester) makeRequest(userID, itemID int64) bool {
 	start := time.Now()
 
 	// Get request from pool / Получаем запрос из пула
@@ -556,13 +1069,13 @@ func (lt *LoadTester) makeRequest(userID, itemID int64) {
 	defer lt.requestPool.Put(req)
 
 	// Update URL / Обновляем URL
-	req.URL, _ = req.URL.Parse(fmt.Sprintf("%s/checkout?user_id=%d&item_id=%d", lt.baseURL, userID, itemID))
+	req.URL, _ = req.URL.Parse(lt.checkoutURL(userID, itemID))
 
 	resp, err := lt.httpClient.Do(req)
 	if err != nil {
-		atomic.AddInt64(&lt.stats.otherErrors, 1)
+		lt.stats.recordClientError(err, time.Since(start).Microseconds())
 		atomic.AddInt64(&lt.stats.totalRequests, 1)
-		return
+		return false
 	}
 
 	// Read and close response body as fast as possible / Читаем и закрываем тело ответа максимально быстро
@@ -571,108 +1084,122 @@ func (lt *LoadTester) makeRequest(userID, itemID int64) {
 
 	// Calculate latency / Вычисляем латентность
 	latency := time.Since(start).Microseconds()
-	atomic.AddInt64(&lt.stats.totalLatency, latency)
-
-	// Update min/max latency / Обновляем мин/макс латентность
-	for {
-		current := atomic.LoadInt64(&lt.stats.maxLatency)
-		if latency <= current || atomic.CompareAndSwapInt64(&lt.stats.maxLatency, current, latency) {
-			break
-		}
-	}
-
-	for {
-		current := atomic.LoadInt64(&lt.stats.minLatency)
-		if latency >= current || atomic.CompareAndSwapInt64(&lt.stats.minLatency, current, latency) {
-			break
-		}
-	}
+	lt.stats.recordLatency(latency)
 
 	atomic.AddInt64(&lt.stats.totalRequests, 1)
 
 	switch resp.StatusCode {
-	case http.StatusOK:
+	case http.StatusOK, http.StatusCreated:
+		lt.stats.recordStatus(status200, latency)
 		atomic.AddInt64(&lt.stats.successfulRequests, 1)
+		return true
 	case http.StatusInternalServerError:
+		lt.stats.recordStatus(status500, latency)
 		atomic.AddInt64(&lt.stats.internalErrors, 1)
-	case http.StatusConflict:
+	case http.StatusConflict, http.StatusGone, http.StatusTooManyRequests:
+		// Taken lot, sold out or user limit / Занятый лот, распродано или лимит пользователя
+		lt.stats.recordStatus(status409, latency)
 		atomic.AddInt64(&lt.stats.conflictErrors, 1)
 	default:
 		atomic.AddInt64(&lt.stats.otherErrors, 1)
 	}
+	return false
+}
+
+// checkoutResponse is the JSON /checkout response / JSON ответ /checkout
+type checkoutResponse struct {
+	Code      string    `json:"code"`
+	ExpiresAt time.Time `json:"expires_at"`
+	ItemID    int64     `json:"item_id"`
+}
+
+// checkoutSucceeded accepts both 200 and 201 (CHECKOUT_CREATED_STATUS) / принимает и 200, и 201 (CHECKOUT_CREATED_STATUS)
+func checkoutSucceeded(status int) bool {
+	return status == http.StatusOK || status == http.StatusCreated
+}
+
+// extractCode reads the code from a JSON checkout response, falling back to plain text for older servers /
+// читает код из JSON ответа checkout, для старых серверов - из простого текста
+func (lt *LoadTester) extractCode(body []byte) string {
+	var resp checkoutResponse
+	if err := json.Unmarshal(body, &resp); err == nil && resp.Code != "" {
+		return resp.Code
+	}
+
+	code := strings.TrimSpace(string(body))
+	// If code is empty after trimming, try to find UUID with regex / Если после очистки код пустой, пробуем найти UUID регуляркой
+	if code == "" || !strings.Contains(code, "-") {
+		code = lt.codeRegex.FindString(string(body))
+	}
+	return code
 }
 
-// makeChainedRequest performs checkout->purchase chain / Новый метод для тестирования цепочки checkout -> purchase
-func (lt *LoadTester) makeChainedRequest(userID, itemID int64) {
+// makeChainedRequest performs checkout->purchase chain and reports whether the purchase succeeded / Новый метод для тестирования цепочки checkout -> purchase, сообщает, успешна ли покупка
+func (lt *LoadTester) makeChainedRequest(userID, itemID int64) bool {
 	start := time.Now()
 
 	// Step 1: make checkout / Этап 1: делаем checkout
 	checkoutReq := lt.requestPool.Get().(*http.Request)
 	defer lt.requestPool.Put(checkoutReq)
 
-	checkoutReq.URL, _ = checkoutReq.URL.Parse(fmt.Sprintf("%s/checkout?user_id=%d&item_id=%d", lt.baseURL, userID, itemID))
+	checkoutReq.URL, _ = checkoutReq.URL.Parse(lt.checkoutURL(userID, itemID))
 
 	atomic.AddInt64(&lt.stats.checkoutRequests, 1)
 
 	checkoutResp, err := lt.httpClient.Do(checkoutReq)
 	if err != nil {
+		lt.stats.recordClientError(err, time.Since(start).Microseconds())
 		atomic.AddInt64(&lt.stats.checkoutErrors, 1)
-		atomic.AddInt64(&lt.stats.otherErrors, 1)
 		atomic.AddInt64(&lt.stats.totalRequests, 1)
-		return
+		return false
 	}
 
 	// Read checkout response body / Читаем тело ответа checkout
 	checkoutBody, err := io.ReadAll(checkoutResp.Body)
 	checkoutResp.Body.Close()
 
-	if err != nil || checkoutResp.StatusCode != http.StatusOK {
+	if err != nil || !checkoutSucceeded(checkoutResp.StatusCode) {
 		atomic.AddInt64(&lt.stats.checkoutErrors, 1)
 		atomic.AddInt64(&lt.stats.totalRequests, 1)
 
 		switch checkoutResp.StatusCode {
 		case http.StatusInternalServerError:
+			lt.stats.recordStatus(status500, time.Since(start).Microseconds())
 			atomic.AddInt64(&lt.stats.internalErrors, 1)
-		case http.StatusConflict:
+		case http.StatusConflict, http.StatusGone, http.StatusTooManyRequests:
+			lt.stats.recordStatus(status409, time.Since(start).Microseconds())
 			atomic.AddInt64(&lt.stats.conflictErrors, 1)
 		default:
 			atomic.AddInt64(&lt.stats.otherErrors, 1)
 		}
-		return
+		return false
 	}
 
 	atomic.AddInt64(&lt.stats.checkoutSuccesses, 1)
 
 	// Extract code from checkout response / Извлекаем код из ответа checkout
-	// IMPORTANT: First trim whitespace, as in debug version / ВАЖНО: Сначала очищаем от пробелов, как в отладочной версии
-	code := strings.TrimSpace(string(checkoutBody))
-
-	// If code is empty after trimming, try to find UUID with regex / Если после очистки код пустой, пробуем найти UUID регуляркой
-	if code == "" || !strings.Contains(code, "-") {
-		code = lt.codeRegex.FindString(string(checkoutBody))
-	}
-
+	code := lt.extractCode(checkoutBody)
 	if code == "" {
 		atomic.AddInt64(&lt.stats.checkoutErrors, 1)
 		atomic.AddInt64(&lt.stats.otherErrors, 1)
 		atomic.AddInt64(&lt.stats.totalRequests, 1)
-		return
+		return false
 	}
 
 	// Step 2: make purchase / Этап 2: делаем purchase
 	purchaseReq := lt.requestPool.Get().(*http.Request)
 	defer lt.requestPool.Put(purchaseReq)
 
-	purchaseReq.URL, _ = purchaseReq.URL.Parse(fmt.Sprintf("%s/purchase?code=%s", lt.baseURL, code))
+	purchaseReq.URL, _ = purchaseReq.URL.Parse(lt.purchaseURL(code))
 
 	atomic.AddInt64(&lt.stats.purchaseRequests, 1)
 
 	purchaseResp, err := lt.httpClient.Do(purchaseReq)
 	if err != nil {
+		lt.stats.recordClientError(err, time.Since(start).Microseconds())
 		atomic.AddInt64(&lt.stats.purchaseErrors, 1)
-		atomic.AddInt64(&lt.stats.otherErrors, 1)
 		atomic.AddInt64(&lt.stats.totalRequests, 1)
-		return
+		return false
 	}
 
 	// Read and close purchase response body / Читаем и закрываем тело ответа purchase
@@ -681,45 +1208,39 @@ func (lt *LoadTester) makeChainedRequest(userID, itemID int64) {
 
 	// Calculate total chain latency / Вычисляем общую латентность цепочки
 	latency := time.Since(start).Microseconds()
-	atomic.AddInt64(&lt.stats.totalLatency, latency)
-
-	// Update min/max latency / Обновляем мин/макс латентность
-	for {
-		current := atomic.LoadInt64(&lt.stats.maxLatency)
-		if latency <= current || atomic.CompareAndSwapInt64(&lt.stats.maxLatency, current, latency) {
-			break
-		}
-	}
-
-	for {
-		current := atomic.LoadInt64(&lt.stats.minLatency)
-		if latency >= current || atomic.CompareAndSwapInt64(&lt.stats.minLatency, current, latency) {
-			break
-		}
-	}
+	lt.stats.recordLatency(latency)
 
 	atomic.AddInt64(&lt.stats.totalRequests, 1)
 
 	// Process purchase result / Обрабатываем результат purchase
 	switch purchaseResp.StatusCode {
 	case http.StatusOK:
+		lt.stats.recordStatus(status200, latency)
 		atomic.AddInt64(&lt.stats.purchaseSuccesses, 1)
 		atomic.AddInt64(&lt.stats.successfulRequests, 1)
+		return true
 	case http.StatusInternalServerError:
+		lt.stats.recordStatus(status500, latency)
 		atomic.AddInt64(&lt.stats.purchaseErrors, 1)
 		atomic.AddInt64(&lt.stats.internalErrors, 1)
-	case http.StatusConflict:
+	case http.StatusConflict, http.StatusNotFound, http.StatusGone, http.StatusTooManyRequests:
+		// Servers name the refusal reason, all of them are the expected conflicts of a sale / Серверы называют причину отказа, все они - ожидаемые конфликты распродажи
+		lt.stats.recordStatus(status409, latency)
 		atomic.AddInt64(&lt.stats.purchaseErrors, 1)
 		atomic.AddInt64(&lt.stats.conflictErrors, 1)
 	default:
 		atomic.AddInt64(&lt.stats.purchaseErrors, 1)
 		atomic.AddInt64(&lt.stats.otherErrors, 1)
 	}
+	return false
 }
 
-// worker performs load testing with support for different test types / Улучшенный воркер с поддержкой разных типов тестов
-func (lt *LoadTester) worker(ctx context.Context, requestsPerSecond int, wg *sync.WaitGroup, testChain bool) {
-	defer wg.Done()
+// workerPace returns the tick interval and batch size for a per-worker rate, polling while the rate is zero /
+// возвращает интервал тиков и размер пакета для доли воркера, при нулевой доле только опрашивает
+func workerPace(requestsPerSecond int) (time.Duration, int) {
+	if requestsPerSecond <= 0 {
+		return rampPollInterval, 0
+	}
 
 	// Calculate interval between requests / Вычисляем интервал между запросами
 	interval := time.Second / time.Duration(requestsPerSecond)
@@ -732,7 +1253,31 @@ func (lt *LoadTester) worker(ctx context.Context, requestsPerSecond int, wg *syn
 			batchSize = 100
 		}
 	}
+	return interval, batchSize
+}
+
+// workerCount sizes the worker pool for rps: 10 RPS per worker within 10..1000 when requested is 0, and never more workers than rps, so each gets at least 1 RPS /
+// рассчитывает пул воркеров для rps: 10 RPS на воркера в пределах 10..1000, если requested равен 0, и не больше воркеров, чем rps, чтобы каждому досталось хотя бы 1 RPS
+func workerCount(rps, requested int) int {
+	numWorkers := requested
+	if numWorkers <= 0 {
+		numWorkers = min(max(rps/10, 10), 1000) // 10 RPS per worker by default / 10 RPS на воркера по умолчанию
+	}
+	return max(min(numWorkers, rps), 1)
+}
 
+// constantRate is a worker rate that never changes / доля воркера, которая не меняется
+func constantRate(requestsPerSecond int) func() int {
+	return func() int { return requestsPerSecond }
+}
+
+// worker performs load testing with support for different test types, following rate as it changes /
+// Улучшенный воркер с поддержкой разных типов тестов, следует за rate при его изменении
+func (lt *LoadTester) worker(ctx context.Context, rate func() int, wg *sync.WaitGroup, testChain bool) {
+	defer wg.Done()
+
+	current := rate()
+	interval, batchSize := workerPace(current)
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
@@ -741,14 +1286,22 @@ func (lt *LoadTester) worker(ctx context.Context, requestsPerSecond int, wg *syn
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
+			// Retune the ticker when the target moved / Перенастраиваем тикер, если цель сдвинулась
+			if next := rate(); next != current {
+				current = next
+				interval, batchSize = workerPace(current)
+				ticker.Reset(interval)
+			}
+
+			// Skip tick while paused from dashboard / Пропускаем тик, пока нагрузка на паузе
+			if lt.IsPaused() {
+				continue
+			}
+
 			// Send batch of requests / Отправляем пакет запросов
 			for i := 0; i < batchSize; i++ {
 				userID, itemID := lt.generateRequest()
-				if testChain {
-					go lt.makeChainedRequest(userID, itemID)
-				} else {
-					go lt.makeRequest(userID, itemID)
-				}
+				go lt.runOp(lt.nextOp(testChain), userID, itemID)
 			}
 		}
 	}
@@ -757,7 +1310,11 @@ func (lt *LoadTester) worker(ctx context.Context, requestsPerSecond int, wg *syn
 // RunLoadTest starts the main load testing process / Запускает основной процесс нагрузочного тестирования
 func (lt *LoadTester) RunLoadTest(rps int, duration time.Duration, numWorkers int, testChain bool) {
 	testType := "checkout"
-	if testChain {
+	if lt.replay != nil {
+		testType = "replay " + lt.replay.mix.String()
+	} else if lt.mix != nil {
+		testType = "mix " + lt.mix.String()
+	} else if testChain {
 		testType = "checkout->purchase chain"
 	}
 
@@ -773,11 +1330,22 @@ func (lt *LoadTester) RunLoadTest(rps int, duration time.Duration, numWorkers in
 	runtime.GOMAXPROCS(runtime.NumCPU())
 
 	fmt.Printf("Starting high-performance load testing (%s):\n", testType)
-	fmt.Printf("- Target RPS: %d\n", rps)
+	if lt.replay != nil {
+		fmt.Printf("- Replay: %d requests at speed %g, %.1f RPS on average\n", len(lt.replay.offsets), lt.replaySpeed, lt.replay.rate()*lt.replaySpeed)
+	} else if lt.ramp != nil {
+		fmt.Printf("- Ramp: %s (peak %d RPS)\n", lt.ramp, lt.ramp.peak())
+	} else {
+		fmt.Printf("- Target RPS: %d\n", rps)
+	}
 	fmt.Printf("- Duration: %v\n", duration)
-	fmt.Printf("- Number of workers: %d\n", numWorkers)
-	fmt.Printf("- Number of users: %d\n", lt.maxUsers)
-	fmt.Printf("- RPS per worker: %.1f\n", float64(rps)/float64(numWorkers))
+	if lt.warmup > 0 {
+		fmt.Printf("- Warmup: %v, not included in statistics\n", lt.warmup)
+	}
+	if lt.replay == nil {
+		fmt.Printf("- Number of workers: %d\n", numWorkers)
+		fmt.Printf("- Number of users: %d\n", lt.maxUsers)
+		fmt.Printf("- RPS per worker: %.1f\n", float64(rps)/float64(numWorkers))
+	}
 	fmt.Printf("- CPU cores: %d\n", runtime.NumCPU())
 	fmt.Printf("- URL: %s\n", lt.baseURL)
 	fmt.Printf("- Web dashboard: http://localhost:9090\n\n")
@@ -788,16 +1356,44 @@ func (lt *LoadTester) RunLoadTest(rps int, duration time.Duration, numWorkers in
 		minLatency: int64(^uint64(0) >> 1),
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), duration)
+	// The warmup comes on top of the measured duration / Прогрев добавляется к измеряемой длительности
+	ctx, cancel := context.WithTimeout(context.Background(), lt.warmup+duration)
 	defer cancel()
+	if lt.warmup > 0 {
+		atomic.StoreInt32(&lt.warming, 1)
+	}
+
+	// Stop from dashboard ends the test early / Остановка из дашборда досрочно завершает тест
+	go func() {
+		select {
+		case <-lt.stopCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	// Constant target unless a ramp drives it / Постоянная цель, если ею не управляет разгон
+	atomic.StoreInt64(&lt.targetRPS, int64(rps))
+	if lt.ramp != nil {
+		go lt.runRamp(ctx, lt.stats.startTime)
+	}
 
 	var wg sync.WaitGroup
-	requestsPerWorker := rps / numWorkers
 
-	// Start workers / Запускаем воркеры
-	for i := 0; i < numWorkers; i++ {
+	if lt.replay != nil {
+		// The trace sets the pace, the mean rate is only shown as the target / Темп задает трасса, средний RPS только показывается как цель
+		atomic.StoreInt64(&lt.targetRPS, int64(lt.replay.rate()*lt.replaySpeed))
 		wg.Add(1)
-		go lt.worker(ctx, requestsPerWorker, &wg, testChain)
+		go func() {
+			defer wg.Done()
+			lt.runReplay(ctx)
+		}()
+	} else {
+		// Start workers / Запускаем воркеры
+		for i := 0; i < numWorkers; i++ {
+			wg.Add(1)
+			go lt.worker(ctx, lt.workerRate(i, numWorkers), &wg, testChain)
+		}
 	}
 
 	// Statistics in separate goroutine / Статистика в отдельной горутине
@@ -807,6 +1403,9 @@ func (lt *LoadTester) RunLoadTest(rps int, duration time.Duration, numWorkers in
 	time.Sleep(1 * time.Second) // Give time to finish last requests / Даем время завершить последние запросы
 	lt.printFinalStats(testChain)
 
+	// Written before blocking below, the process is usually killed there / Пишем до блокировки ниже, там процесс обычно убивают
+	lt.writeResults(testChain)
+
 	fmt.Printf("\n🌐 Web dashboard continues running at http://localhost:9090\n")
 	fmt.Printf("Press Ctrl+C to exit the program\n")
 
@@ -814,15 +1413,89 @@ func (lt *LoadTester) RunLoadTest(rps int, duration time.Duration, numWorkers in
 	select {}
 }
 
+// Sample requests printed by -dry-run / Примеры запросов, которые печатает -dry-run
+const dryRunSamples = 5
+
+// DryRun prints the worker pacing and sample requests of a test without sending traffic, the ramp is shown at its peak rps /
+// печатает темп воркеров и примеры запросов теста без отправки трафика, разгон показывается на пике rps
+func (lt *LoadTester) DryRun(w io.Writer, rps, numWorkers int, testChain bool) {
+	if lt.replay != nil {
+		lt.dryRunReplay(w)
+		return
+	}
+
+	atomic.StoreInt64(&lt.targetRPS, int64(rps))
+
+	// The remainder goes to the first workers, so equal rates are contiguous / Остаток достается первым воркерам, поэтому равные доли идут подряд
+	fmt.Fprintf(w, "Worker pacing at %d RPS over %d workers:\n", rps, numWorkers)
+	idle := 0
+	for first := 0; first < numWorkers; {
+		rate := lt.workerRate(first, numWorkers)()
+		last := first
+		for last+1 < numWorkers && lt.workerRate(last+1, numWorkers)() == rate {
+			last++
+		}
+
+		interval, batchSize := workerPace(rate)
+		if rate == 0 {
+			idle += last - first + 1
+			fmt.Fprintf(w, "- workers %d-%d: idle, polling every %v\n", first, last, interval)
+		} else {
+			fmt.Fprintf(w, "- workers %d-%d: %d RPS each, tick every %v, batch %d\n", first, last, rate, interval, batchSize)
+		}
+		first = last + 1
+	}
+	if idle > 0 {
+		fmt.Fprintf(w, "⚠️  %d of %d workers get no requests: -rps is below -workers\n", idle, numWorkers)
+	}
+
+	if lt.mix != nil {
+		fmt.Fprintf(w, "Operation mix: %s\n", lt.mix)
+	}
+	fmt.Fprintf(w, "Item distribution: %s, top 10 of %d items expected to get %.2f%% of requests\n",
+		lt.items, itemsRange, lt.items.expectedShare(10)*100)
+	fmt.Fprintf(w, "Sample requests:\n")
+	for i := 0; i < dryRunSamples; i++ {
+		userID, itemID := lt.generateRequest()
+		switch lt.nextOp(testChain) {
+		case opStatus:
+			fmt.Fprintf(w, "- GET %s\n", lt.statusURL(itemID))
+		case opChain:
+			fmt.Fprintf(w, "- POST %s\n", lt.checkoutURL(userID, itemID))
+			fmt.Fprintf(w, "  POST %s\n", lt.purchaseURL("<code>"))
+		default:
+			fmt.Fprintf(w, "- POST %s\n", lt.checkoutURL(userID, itemID))
+		}
+	}
+}
+
+// String formats the schedule the way -ramp takes it / форматирует расписание так, как его принимает -ramp
+func (r rampSchedule) String() string {
+	parts := make([]string, len(r))
+	for i, phase := range r {
+		parts[i] = fmt.Sprintf("%d:%v", phase.Target, phase.Duration)
+	}
+	return strings.Join(parts, ",")
+}
+
 // printStatsLoop prints statistics periodically / Выводит статистику периодически
 func (lt *LoadTester) printStatsLoop(ctx context.Context, testChain bool) {
 	ticker := time.NewTicker(1 * time.Second)
 	defer ticker.Stop()
 
+	// Reset here, this goroutine is the one reading startTime during the test / Сбрасываем здесь, эта горутина читает startTime во время теста
+	var warmupDone <-chan time.Time
+	if lt.IsWarmingUp() {
+		warmupDone = time.After(lt.warmup)
+	}
+
 	for {
 		select {
 		case <-ctx.Done():
 			return
+		case <-warmupDone:
+			lt.endWarmup()
+			fmt.Printf("🔥 Warmup finished after %v, statistics reset\n", lt.warmup)
 		case <-ticker.C:
 			lt.collectMetrics()             // First collect metrics for charts / Сначала собираем метрики для графиков
 			lt.printCurrentStats(testChain) // Then print to console / Потом выводим в консоль
@@ -838,6 +1511,7 @@ func (lt *LoadTester) printCurrentStats(testChain bool) {
 	conflicts := atomic.LoadInt64(&lt.stats.conflictErrors)
 	successful := atomic.LoadInt64(&lt.stats.successfulRequests) + atomic.LoadInt64(&lt.stats.conflictErrors)
 	otherErrors := atomic.LoadInt64(&lt.stats.otherErrors)
+	timeouts := atomic.LoadInt64(&lt.stats.timeouts)
 	totalLatency := atomic.LoadInt64(&lt.stats.totalLatency)
 
 	currentRPS := float64(total) / elapsed
@@ -859,11 +1533,11 @@ func (lt *LoadTester) printCurrentStats(testChain bool) {
 		checkoutSucc := atomic.LoadInt64(&lt.stats.checkoutSuccesses)
 		purchaseSucc := atomic.LoadInt64(&lt.stats.purchaseSuccesses)
 
-		fmt.Printf("[%.1fs] RPS: %.0f | Total: %d | Checkout: %d->%d | Purchase: %d->%d | 500: %d (%.1f%%) | 409: %d (%.1f%%) | Avg Latency: %.2fms\n",
-			elapsed, currentRPS, total, checkoutReqs, checkoutSucc, purchaseReqs, purchaseSucc, errors500, errorRate, conflicts, conflictRate, avgLatency)
+		fmt.Printf("[%.1fs] RPS: %.0f | Total: %d | Checkout: %d->%d | Purchase: %d->%d | 500: %d (%.1f%%) | 409: %d (%.1f%%) | Timeouts: %d | Avg Latency: %.2fms\n",
+			elapsed, currentRPS, total, checkoutReqs, checkoutSucc, purchaseReqs, purchaseSucc, errors500, errorRate, conflicts, conflictRate, timeouts, avgLatency)
 	} else {
-		fmt.Printf("[%.1fs] RPS: %.0f | Total: %d | 200: %d | 500: %d (%.1f%%) | 409: %d (%.1f%%) | Other: %d (%.1f%%) | Avg Latency: %.2fms\n",
-			elapsed, currentRPS, total, successful, errors500, errorRate, conflicts, conflictRate, otherErrors, otherErrorsRate, avgLatency)
+		fmt.Printf("[%.1fs] RPS: %.0f | Total: %d | 200: %d | 500: %d (%.1f%%) | 409: %d (%.1f%%) | Timeouts: %d | Other: %d (%.1f%%) | Avg Latency: %.2fms\n",
+			elapsed, currentRPS, total, successful, errors500, errorRate, conflicts, conflictRate, timeouts, otherErrors, otherErrorsRate, avgLatency)
 	}
 }
 
@@ -875,6 +1549,7 @@ func (lt *LoadTester) printFinalStats(testChain bool) {
 	conflicts := atomic.LoadInt64(&lt.stats.conflictErrors)
 	successful := atomic.LoadInt64(&lt.stats.successfulRequests)
 	otherErrors := atomic.LoadInt64(&lt.stats.otherErrors)
+	timeouts := atomic.LoadInt64(&lt.stats.timeouts)
 	totalLatency := atomic.LoadInt64(&lt.stats.totalLatency)
 	maxLatency := atomic.LoadInt64(&lt.stats.maxLatency)
 	minLatency := atomic.LoadInt64(&lt.stats.minLatency)
@@ -894,13 +1569,22 @@ func (lt *LoadTester) printFinalStats(testChain bool) {
 	}
 
 	testTypeStr := "CHECKOUT"
-	if testChain {
+	if lt.replay != nil {
+		testTypeStr = "REPLAY"
+	} else if lt.mix != nil {
+		testTypeStr = "MIX"
+	} else if testChain {
 		testTypeStr = "CHECKOUT->PURCHASE CHAIN"
 	}
 
 	fmt.Printf("\n%s\n", strings.Repeat("=", 80))
 	fmt.Printf("FINAL LOAD TESTING STATISTICS %s\n", testTypeStr)
 	fmt.Printf("%s\n", strings.Repeat("=", 80))
+	if lt.IsWarmingUp() {
+		fmt.Printf("⚠️  Stopped during warmup, statistics include the cold start\n")
+	} else if lt.warmup > 0 {
+		fmt.Printf("Warmup of %v excluded\n", lt.warmup)
+	}
 	fmt.Printf("Total testing time: %.2f seconds\n", elapsed)
 	fmt.Printf("Total requests: %d\n", total)
 	fmt.Printf("Achieved RPS: %.0f\n", avgRPS)
@@ -909,6 +1593,8 @@ func (lt *LoadTester) printFinalStats(testChain bool) {
 	fmt.Printf("- Average latency: %.2f ms\n", avgLatency)
 	fmt.Printf("- Minimum latency: %.2f ms\n", float64(minLatency)/1000)
 	fmt.Printf("- Maximum latency: %.2f ms\n", float64(maxLatency)/1000)
+	fmt.Printf("- Latency p50 / p90 / p99: %.2f / %.2f / %.2f ms\n",
+		lt.stats.latencies.percentile(0.50), lt.stats.latencies.percentile(0.90), lt.stats.latencies.percentile(0.99))
 
 	if testChain {
 		checkoutReqs := atomic.LoadInt64(&lt.stats.checkoutRequests)
@@ -927,11 +1613,16 @@ func (lt *LoadTester) printFinalStats(testChain bool) {
 		fmt.Printf("- Purchase errors: %d (%.2f%%)\n", purchaseErrors, float64(purchaseErrors)/float64(purchaseReqs)*100)
 	}
 
+	lt.printOperationStats()
+	lt.printStatusStats()
+	lt.printHitStats()
+
 	fmt.Printf("\nFinal response distribution:\n")
 	fmt.Printf("- 200 OK: %d (%.2f%%)\n", successful, successRate)
 	fmt.Printf("- 500 Internal Server Error: %d (%.2f%%)\n", errors500, errorRate)
 	fmt.Printf("- 409 Conflict: %d (%.2f%%)\n", conflicts, conflictRate)
-	fmt.Printf("- Other errors/timeouts: %d (%.2f%%)\n", otherErrors, float64(otherErrors)/float64(total)*100)
+	fmt.Printf("- Timeouts: %d (%.2f%%)\n", timeouts, float64(timeouts)/float64(total)*100)
+	fmt.Printf("- Other errors (refused, reset, ...): %d (%.2f%%)\n", otherErrors, float64(otherErrors)/float64(total)*100)
 	fmt.Printf("%s\n", strings.Repeat("=", 80))
 }
 
@@ -949,7 +1640,7 @@ func (lt *LoadTester) TestSingleRequest(testChain bool) bool {
 // testCheckoutOnly tests single checkout request / Тестирует одиночный checkout запрос
 func (lt *LoadTester) testCheckoutOnly() bool {
 	userID, itemID := lt.generateRequest()
-	url := fmt.Sprintf("%s/checkout?user_id=%d&item_id=%d", lt.baseURL, userID, itemID)
+	url := lt.checkoutURL(userID, itemID)
 
 	req, err := http.NewRequest("POST", url, nil)
 	if err != nil {
@@ -983,7 +1674,7 @@ func (lt *LoadTester) testChainedRequest() bool {
 	userID, itemID := lt.generateRequest()
 
 	// Test checkout / Тест checkout
-	checkoutURL := fmt.Sprintf("%s/checkout?user_id=%d&item_id=%d", lt.baseURL, userID, itemID)
+	checkoutURL := lt.checkoutURL(userID, itemID)
 	fmt.Printf("🔍 Checkout URL: %s\n", checkoutURL)
 
 	checkoutReq, err := http.NewRequest("POST", checkoutURL, nil)
@@ -991,6 +1682,7 @@ func (lt *LoadTester) testChainedRequest() bool {
 		fmt.Printf("❌ Checkout request creation error: %v\n", err)
 		return false
 	}
+	checkoutReq.Header.Set("Accept", "application/json")
 
 	checkoutResp, err := lt.httpClient.Do(checkoutReq)
 	if err != nil {
@@ -1003,8 +1695,8 @@ func (lt *LoadTester) testChainedRequest() bool {
 
 	fmt.Printf("✅ Checkout status: %d %s\n", checkoutResp.StatusCode, http.StatusText(checkoutResp.StatusCode))
 
-	if checkoutResp.StatusCode != http.StatusOK {
-		fmt.Printf("⚠️  Checkout didn't return 200, testing checkout only...\n")
+	if !checkoutSucceeded(checkoutResp.StatusCode) {
+		fmt.Printf("⚠️  Checkout didn't return 200/201, testing checkout only...\n")
 		fmt.Printf("📄 Server response: %s\n\n", string(checkoutBody))
 		return true
 	}
@@ -1013,16 +1705,7 @@ func (lt *LoadTester) testChainedRequest() bool {
 	fmt.Printf("📄 Raw checkout response: [%s]\n", string(checkoutBody))
 	fmt.Printf("📏 Response length: %d bytes\n", len(checkoutBody))
 
-	// Trim whitespace / Очищаем от пробелов
-	code := strings.TrimSpace(string(checkoutBody))
-	fmt.Printf("🔤 Cleaned code: [%s]\n", code)
-
-	// If no dashes, try to find UUID with regex / Если нет дефисов, пробуем найти UUID регуляркой
-	if !strings.Contains(code, "-") {
-		fmt.Printf("⚠️  Code doesn't contain dashes, searching for UUID with regex...\n")
-		code = lt.codeRegex.FindString(string(checkoutBody))
-	}
-
+	code := lt.extractCode(checkoutBody)
 	if code == "" {
 		fmt.Printf("❌ Failed to extract code from checkout response\n")
 		return false
@@ -1031,7 +1714,7 @@ func (lt *LoadTester) testChainedRequest() bool {
 	fmt.Printf("✅ Got code: %s\n", code)
 
 	// Test purchase / Тест purchase
-	purchaseURL := fmt.Sprintf("%s/purchase?code=%s", lt.baseURL, code)
+	purchaseURL := lt.purchaseURL(code)
 	fmt.Printf("🔍 Purchase URL: %s\n", purchaseURL)
 
 	purchaseReq, err := http.NewRequest("POST", purchaseURL, nil)
@@ -1082,9 +1765,19 @@ func printUsage() {
 	fmt.Printf("  -rps int        Target RPS (requests per second) (default: 1000)\n")
 	fmt.Printf("  -users int      Number of users (default: 100)\n")
 	fmt.Printf("  -duration string Test duration (e.g.: 30s, 1m, 2h) (default: 60s)\n")
+	fmt.Printf("  -warmup string  Load before -duration that is not counted in statistics (e.g.: 10s) (default: none)\n")
 	fmt.Printf("  -url string     Server URL (default: http://localhost:8080)\n")
 	fmt.Printf("  -chain bool     Test checkout->purchase chain (default: false)\n")
-	fmt.Printf("  -workers int    Number of workers (default: automatic)\n")
+	fmt.Printf("  -workers int    Number of workers, at most -rps (default: automatic)\n")
+	fmt.Printf("  -output string  Write final stats and per-second points to a JSON file\n")
+	fmt.Printf("  -csv string     Write per-second points to a CSV file\n")
+	fmt.Printf("  -ramp string    Ramp phases rps:duration, linear from 0 (e.g.: 0:30s,50000:10s), overrides -rps\n")
+	fmt.Printf("  -mix string     Weighted operation mix op:weight, ops checkout, chain, status (e.g.: checkout:70,chain:20,status:10), overrides -chain\n")
+	fmt.Printf("  -replay string  Replay a JSON lines trace of ts, user_id, item_id, op with its recorded timing, overrides -rps, -users, -chain and -mix\n")
+	fmt.Printf("  -speed float    Replay speed factor, 2 plays the trace twice as fast (default: 1)\n")
+	fmt.Printf("  -distribution string Item ID distribution: uniform or zipf, where low item IDs are hot (default: uniform)\n")
+	fmt.Printf("  -skew float     Zipf skew, above 1; higher sends more traffic to the hottest items (default: 1.1)\n")
+	fmt.Printf("  -dry-run        Print worker pacing and sample requests, then exit without sending traffic\n")
 	fmt.Printf("  -help           Show this help\n\n")
 	fmt.Printf("Web Dashboard:\n")
 	fmt.Printf("  Automatically starts at http://localhost:9090\n")
@@ -1096,6 +1789,18 @@ func printUsage() {
 	fmt.Printf("  %s -rps=5000 -duration=2m -chain=true\n\n", "rps_meter")
 	fmt.Printf("  # Test with limited number of users\n")
 	fmt.Printf("  %s -rps=100 -users=100 -duration=30s\n\n", "rps_meter")
+	fmt.Printf("  # Quiet 30s, then spike to 50000 RPS in 10s and hold it\n")
+	fmt.Printf("  %s -ramp=0:30s,50000:10s -duration=1m\n\n", "rps_meter")
+	fmt.Printf("  # Storefront traffic: mostly checkouts, some purchases and item reads\n")
+	fmt.Printf("  %s -rps=2000 -mix=checkout:70,chain:20,status:10\n\n", "rps_meter")
+	fmt.Printf("  # Steady-state numbers: 10s of unrecorded load, then 1m measured\n")
+	fmt.Printf("  %s -rps=5000 -warmup=10s -duration=1m\n\n", "rps_meter")
+	fmt.Printf("  # Contention on a few hot items\n")
+	fmt.Printf("  %s -rps=5000 -distribution=zipf -skew=1.5 -chain=true\n\n", "rps_meter")
+	fmt.Printf("  # Reproduce an incident from a recorded trace at triple speed\n")
+	fmt.Printf("  %s -replay=trace.jsonl -speed=3 -duration=5m\n\n", "rps_meter")
+	fmt.Printf("  # Check pacing of 25 RPS over 10 workers without sending anything\n")
+	fmt.Printf("  %s -rps=25 -workers=10 -dry-run\n\n", "rps_meter")
 }
 
 func main() {
@@ -1104,9 +1809,19 @@ func main() {
 		rps      = flag.Int("rps", 1000, "Target RPS (requests per second)")
 		users    = flag.Int("users", 100, "Number of users")
 		duration = flag.String("duration", "60s", "Test duration (e.g.: 30s, 1m, 2h)")
+		warmup   = flag.String("warmup", "", "Load before -duration that is not counted in statistics (e.g.: 10s)")
 		baseURL  = flag.String("url", "http://localhost:8080", "Server URL")
 		chain    = flag.Bool("chain", false, "Test checkout->purchase chain")
 		workers  = flag.Int("workers", 0, "Number of workers (0 = automatic)")
+		ramp     = flag.String("ramp", "", "Ramp phases rps:duration, linear from 0 (e.g.: 0:30s,50000:10s), overrides -rps")
+		output   = flag.String("output", "", "Write final stats and per-second points to a JSON file")
+		csvPath  = flag.String("csv", "", "Write per-second points to a CSV file")
+		mix      = flag.String("mix", "", "Weighted operation mix op:weight, ops checkout, chain, status (e.g.: checkout:70,chain:20,status:10), overrides -chain")
+		replay   = flag.String("replay", "", "Replay a JSON lines trace of ts, user_id, item_id, op with its recorded timing, overrides -rps, -users, -chain and -mix")
+		speed    = flag.Float64("speed", 1, "Replay speed factor, 2 plays the trace twice as fast")
+		distName = flag.String("distribution", "uniform", "Item ID distribution: uniform or zipf, where low item IDs are hot")
+		skew     = flag.Float64("skew", 1.1, "Zipf skew, above 1; higher sends more traffic to the hottest items")
+		dryRun   = flag.Bool("dry-run", false, "Print worker pacing and sample requests, then exit without sending traffic")
 		help     = flag.Bool("help", false, "Show help")
 	)
 
@@ -1118,6 +1833,61 @@ func main() {
 		return
 	}
 
+	// A ramp replaces the constant RPS, workers are sized for its peak / Разгон заменяет постоянный RPS, воркеры рассчитываются на его пик
+	var schedule rampSchedule
+	if *ramp != "" {
+		var err error
+		schedule, err = parseRamp(*ramp)
+		if err != nil {
+			fmt.Printf("❌ Ramp parsing error '%s': %v\n", *ramp, err)
+			fmt.Printf("Valid example: 0:30s,50000:10s\n")
+			return
+		}
+		*rps = max(schedule.peak(), 1)
+	}
+
+	// A mix replaces -chain, chain stats are kept when it buys / Смесь заменяет -chain, статистика цепочки ведется, если смесь покупает
+	var operations *operationMix
+	if *mix != "" {
+		var err error
+		operations, err = parseMix(*mix)
+		if err != nil {
+			fmt.Printf("❌ Mix parsing error '%s': %v\n", *mix, err)
+			fmt.Printf("Valid example: checkout:70,chain:20,status:10\n")
+			return
+		}
+		*chain = operations.includes(opChain)
+	}
+
+	// A replay replaces generated requests, the trace decides the chain / Повтор заменяет генерацию запросов, цепочку определяет трасса
+	var recorded *trace
+	if *replay != "" {
+		if schedule != nil || operations != nil {
+			fmt.Printf("❌ Error: -replay takes its timing and operations from the trace, drop -ramp and -mix\n")
+			return
+		}
+		if *speed <= 0 {
+			fmt.Printf("❌ Error: Replay speed must be greater than 0\n")
+			return
+		}
+		var err error
+		recorded, err = loadTrace(*replay)
+		if err != nil {
+			fmt.Printf("❌ Replay loading error '%s': %v\n", *replay, err)
+			fmt.Printf("Valid line: {\"ts\":\"2025-06-01T12:00:00.250Z\",\"user_id\":42,\"item_id\":7,\"op\":\"chain\"}\n")
+			return
+		}
+		*chain = recorded.mix.includes(opChain)
+	}
+
+	// Hot items for realistic contention / Горячие товары для реалистичной конкуренции
+	items, err := parseDistribution(*distName, *skew)
+	if err != nil {
+		fmt.Printf("❌ Distribution error: %v\n", err)
+		fmt.Printf("Valid examples: -distribution=uniform, -distribution=zipf -skew=1.2\n")
+		return
+	}
+
 	// Parameter validation / Валидация параметров
 	if *rps <= 0 {
 		fmt.Printf("❌ Error: RPS must be greater than 0\n")
@@ -1137,17 +1907,27 @@ func main() {
 		return
 	}
 
-	// Automatic worker count calculation / Автоматический расчет количества воркеров
-	numWorkers := *workers
-	if numWorkers == 0 {
-		numWorkers = *rps / 10 // 10 RPS per worker by default / 10 RPS на воркера по умолчанию
-		if numWorkers < 10 {
-			numWorkers = 10
+	// Warmup parsing, empty or 0 disables it / Парсинг прогрева, пустое значение или 0 его отключает
+	var warmupDuration time.Duration
+	if *warmup != "" {
+		warmupDuration, err = parseDuration(*warmup)
+		if err == nil && warmupDuration < 0 {
+			err = fmt.Errorf("must not be negative")
 		}
-		if numWorkers > 1000 {
-			numWorkers = 1000
+		if err != nil {
+			fmt.Printf("❌ Warmup parsing error '%s': %v\n", *warmup, err)
+			fmt.Printf("Valid examples: 10s, 1m\n")
+			return
 		}
 	}
+	if schedule != nil && schedule.total() > testDuration {
+		fmt.Printf("⚠️  Warning: ramp lasts %v, longer than the %v test; later phases are cut off\n", schedule.total(), testDuration)
+	}
+
+	numWorkers := workerCount(*rps, *workers)
+	if *workers > numWorkers && recorded == nil {
+		fmt.Printf("⚠️  Warning: %d workers for %d RPS would leave some idle, using %d workers\n", *workers, *rps, numWorkers)
+	}
 
 	// Sanity check parameters / Проверка разумности параметров
 	if *rps > 100000 {
@@ -1158,22 +1938,52 @@ func main() {
 	fmt.Printf("🚀 RPS Meter - Load Testing\n")
 	fmt.Printf("%s\n", strings.Repeat("=", 50))
 	fmt.Printf("Test configuration:\n")
-	fmt.Printf("- Target RPS: %d\n", *rps)
-	fmt.Printf("- Users: %d\n", *users)
+	if recorded != nil {
+		fmt.Printf("- Replay: %s (%d requests, speed %g)\n", *replay, len(recorded.offsets), *speed)
+	} else if schedule != nil {
+		fmt.Printf("- Ramp: %s\n", schedule)
+	} else {
+		fmt.Printf("- Target RPS: %d\n", *rps)
+	}
+	if recorded == nil {
+		fmt.Printf("- Users: %d\n", *users)
+		fmt.Printf("- Items: %s\n", items)
+	}
 	fmt.Printf("- Duration: %v\n", testDuration)
+	if warmupDuration > 0 {
+		fmt.Printf("- Warmup: %v\n", warmupDuration)
+	}
 	fmt.Printf("- URL: %s\n", *baseURL)
 	fmt.Printf("- Test type: ")
-	if *chain {
+	if recorded != nil {
+		fmt.Printf("replay %s\n", recorded.mix)
+	} else if operations != nil {
+		fmt.Printf("mix %s\n", operations)
+	} else if *chain {
 		fmt.Printf("checkout->purchase chain\n")
 	} else {
 		fmt.Printf("checkout only\n")
 	}
-	fmt.Printf("- Workers: %d\n", numWorkers)
+	if recorded == nil {
+		fmt.Printf("- Workers: %d\n", numWorkers)
+	}
 	fmt.Printf("- Web dashboard: http://localhost:9090\n")
 	fmt.Printf("%s\n\n", strings.Repeat("=", 50))
 
 	// Create tester / Создание тестера
 	tester := NewLoadTester(*baseURL, *users)
+	tester.SetRamp(schedule)
+	tester.SetMix(operations)
+	tester.SetWarmup(warmupDuration)
+	tester.SetReplay(recorded, *speed)
+	tester.SetDistribution(items)
+	tester.SetOutputs(*output, *csvPath)
+
+	// Neither workers nor the dashboard start / Не запускаются ни воркеры, ни дашборд
+	if *dryRun {
+		tester.DryRun(os.Stdout, *rps, numWorkers, *chain)
+		return
+	}
 
 	// Run test / Запуск теста
 	tester.RunLoadTest(*rps, testDuration, numWorkers, *chain)
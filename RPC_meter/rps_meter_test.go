@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newCountingServer returns a target server counting received requests
+func newCountingServer(t *testing.T) (*httptest.Server, *int64) {
+	var hits int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&hits, 1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &hits
+}
+
+// TestWorkerPauseResume tests that paused workers stop issuing requests and resume afterwards
+func TestWorkerPauseResume(t *testing.T) {
+	srv, hits := newCountingServer(t)
+	lt := NewLoadTester(srv.URL, 10)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go lt.worker(ctx, constantRate(500), &wg, false)
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	require.Eventually(t, func() bool { return atomic.LoadInt64(hits) > 0 }, time.Second, time.Millisecond)
+
+	lt.Pause()
+	assert.Equal(t, statePaused, lt.State())
+
+	// Let in-flight requests land, then no new ones should arrive
+	time.Sleep(50 * time.Millisecond)
+	paused := atomic.LoadInt64(hits)
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, paused, atomic.LoadInt64(hits), "paused worker must not send requests")
+
+	lt.Resume()
+	assert.Equal(t, stateRunning, lt.State())
+	require.Eventually(t, func() bool { return atomic.LoadInt64(hits) > paused }, time.Second, time.Millisecond)
+}
+
+// TestControlAPI tests the dashboard control endpoint
+func TestControlAPI(t *testing.T) {
+	lt := NewLoadTester("http://localhost:0", 10)
+
+	control := func(method, action string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/control", strings.NewReader("action="+action))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		lt.handleControlAPI(rec, req)
+		return rec
+	}
+
+	rec := control(http.MethodPost, "pause")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), statePaused)
+	assert.True(t, lt.IsPaused())
+
+	control(http.MethodPost, "resume")
+	assert.False(t, lt.IsPaused())
+
+	rec = control(http.MethodPost, "explode")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	control(http.MethodPost, "stop")
+	control(http.MethodPost, "stop") // stopping twice is safe
+	rec = control(http.MethodGet, "")
+	assert.Contains(t, rec.Body.String(), stateStopped)
+}
+
+// TestExtractCode tests code extraction from JSON and legacy plain-text checkout responses
+func TestExtractCode(t *testing.T) {
+	lt := NewLoadTester("http://localhost", 10)
+	const code = "550e8400-e29b-41d4-a716-446655440000"
+
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"json", `{"code":"` + code + `","expires_at":"2025-01-01T00:00:00Z","item_id":42}` + "\n", code},
+		{"json token", `{"code":"opaque.token","expires_at":"2025-01-01T00:00:00Z","item_id":42}`, "opaque.token"},
+		{"plain text", code, code},
+		{"plain text with whitespace", " " + code + "\n", code},
+		{"garbage", "oops", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, lt.extractCode([]byte(tt.body)))
+		})
+	}
+}
+
+// TestParseRamp tests the -ramp phase syntax
+func TestParseRamp(t *testing.T) {
+	schedule, err := parseRamp("0:30s, 50000:10s,100:5")
+	require.NoError(t, err)
+	assert.Equal(t, rampSchedule{
+		{Target: 0, Duration: 30 * time.Second},
+		{Target: 50000, Duration: 10 * time.Second},
+		{Target: 100, Duration: 5 * time.Second},
+	}, schedule)
+	assert.Equal(t, 50000, schedule.peak())
+	assert.Equal(t, 45*time.Second, schedule.total())
+
+	for _, bad := range []string{"", "1000", "abc:10s", "-5:10s", "100:0s", "100:soon"} {
+		_, err := parseRamp(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+// TestRampTargetAt tests linear interpolation between phases and holding the last target
+func TestRampTargetAt(t *testing.T) {
+	schedule := rampSchedule{
+		{Target: 1000, Duration: 10 * time.Second},
+		{Target: 1000, Duration: 5 * time.Second},
+		{Target: 0, Duration: 10 * time.Second},
+	}
+
+	tests := []struct {
+		elapsed time.Duration
+		want    int
+	}{
+		{0, 0},
+		{5 * time.Second, 500},
+		{10 * time.Second, 1000},
+		{12 * time.Second, 1000},
+		{20 * time.Second, 500},
+		{time.Minute, 0},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, schedule.targetAt(tt.elapsed), tt.elapsed.String())
+	}
+}
+
+// TestWorkerRate tests that the target is split across workers without losing the remainder
+func TestWorkerRate(t *testing.T) {
+	lt := NewLoadTester("http://localhost:0", 10)
+	atomic.StoreInt64(&lt.targetRPS, 7)
+
+	total := 0
+	for i := 0; i < 3; i++ {
+		total += lt.workerRate(i, 3)()
+	}
+	assert.Equal(t, 7, total)
+}
+
+// TestWorkerFollowsRate tests that an idle worker starts sending once its rate rises above zero
+func TestWorkerFollowsRate(t *testing.T) {
+	srv, hits := newCountingServer(t)
+	lt := NewLoadTester(srv.URL, 10)
+
+	var rate int64
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go lt.worker(ctx, func() int { return int(atomic.LoadInt64(&rate)) }, &wg, false)
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	time.Sleep(2 * rampPollInterval)
+	assert.Zero(t, atomic.LoadInt64(hits), "worker at zero rate must stay idle")
+
+	atomic.StoreInt64(&rate, 500)
+	require.Eventually(t, func() bool { return atomic.LoadInt64(hits) > 0 }, time.Second, time.Millisecond)
+}
+
+// TestDryRun tests that a dry run reports pacing and sample URLs without sending requests
+func TestDryRun(t *testing.T) {
+	srv, hits := newCountingServer(t)
+	lt := NewLoadTester(srv.URL, 10)
+
+	var out strings.Builder
+	lt.DryRun(&out, 5, 10, true)
+
+	assert.Zero(t, atomic.LoadInt64(hits), "a dry run must not send traffic")
+	assert.Contains(t, out.String(), "workers 0-4: 1 RPS each, tick every 1s, batch 1")
+	assert.Contains(t, out.String(), "workers 5-9: idle")
+	assert.Contains(t, out.String(), "5 of 10 workers get no requests")
+	assert.Equal(t, dryRunSamples, strings.Count(out.String(), srv.URL+"/checkout?user_id="))
+	assert.Equal(t, dryRunSamples, strings.Count(out.String(), srv.URL+"/purchase?code=<code>"))
+
+	out.Reset()
+	lt.DryRun(&out, 5000, 2, false)
+	assert.Contains(t, out.String(), "workers 0-1: 2500 RPS each, tick every 400µs, batch 2")
+	assert.NotContains(t, out.String(), "idle")
+	assert.NotContains(t, out.String(), "/purchase")
+}
+
+// TestWorkerCount tests that the pool never has more workers than requests per second
+func TestWorkerCount(t *testing.T) {
+	tests := []struct {
+		rps, requested, want int
+	}{
+		{1000, 0, 100},
+		{50, 0, 10},
+		{5, 0, 5},
+		{1_000_000, 0, 1000},
+		{1, 100, 1},
+		{5, 10, 5},
+		{1000, 10, 10},
+		{0, 10, 1},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, workerCount(tt.rps, tt.requested), "rps %d, workers %d", tt.rps, tt.requested)
+	}
+}
+
+// TestWorkerPaceLowRPS tests that -rps=1 -workers=100 neither panics nor leaves the worker unpaced
+func TestWorkerPaceLowRPS(t *testing.T) {
+	lt := NewLoadTester("http://localhost:0", 10)
+	atomic.StoreInt64(&lt.targetRPS, 1)
+
+	numWorkers := workerCount(1, 100)
+	require.Equal(t, 1, numWorkers)
+	interval, batchSize := workerPace(lt.workerRate(0, numWorkers)())
+	assert.Equal(t, time.Second, interval)
+	assert.Equal(t, 1, batchSize)
+
+	// Without the clamp the extra workers only poll, they never divide by zero
+	assert.NotPanics(t, func() {
+		for i := 0; i < 100; i++ {
+			interval, batchSize := workerPace(lt.workerRate(i, 100)())
+			assert.Positive(t, interval)
+			if i > 0 {
+				assert.Zero(t, batchSize)
+			}
+		}
+	})
+}
+
+// TestWarmupResetsStats tests that requests sent during the warmup are dropped from the stats once it ends
+func TestWarmupResetsStats(t *testing.T) {
+	srv, _ := newCountingServer(t)
+	lt := NewLoadTester(srv.URL, 10)
+	lt.SetWarmup(50 * time.Millisecond)
+	atomic.StoreInt32(&lt.warming, 1)
+	assert.Equal(t, stateWarmup, lt.State())
+
+	for i := 0; i < 3; i++ {
+		lt.runOp(opCheckout, 1, 2)
+	}
+	require.Equal(t, int64(3), lt.stats.totalRequests)
+	warmupStart := lt.stats.startTime
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		lt.printStatsLoop(ctx, false)
+		close(done)
+	}()
+	require.Eventually(t, func() bool { return !lt.IsWarmingUp() }, time.Second, time.Millisecond)
+	cancel()
+	<-done
+
+	assert.Equal(t, stateRunning, lt.State())
+	assert.Zero(t, lt.stats.totalRequests)
+	assert.Zero(t, lt.stats.successfulRequests)
+	assert.Zero(t, lt.stats.totalLatency)
+	assert.Zero(t, lt.stats.ops[opCheckout].requests)
+	assert.Zero(t, lt.stats.latencies.percentile(0.99))
+	assert.Nil(t, lt.stats.statusSummaries())
+	assert.True(t, lt.stats.startTime.After(warmupStart), "the clock restarts after the warmup")
+
+	// Steady state requests are recorded as usual
+	lt.runOp(opCheckout, 1, 2)
+	assert.Equal(t, int64(1), lt.stats.totalRequests)
+	assert.Equal(t, lt.stats.maxLatency, lt.stats.minLatency)
+}
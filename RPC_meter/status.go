@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync/atomic"
+)
+
+// statusClass groups responses whose latency is tracked apart / группа ответов, латентность которой учитывается отдельно
+type statusClass int
+
+// Tracked response classes / Отслеживаемые классы ответов
+const (
+	status200     statusClass = iota // 200 and 201 / 200 и 201
+	status409                        // 409, 410, 429 and purchase 404: refusals of a sale / отказы распродажи
+	status500                        // internal server error / внутренняя ошибка сервера
+	statusTimeout                    // client or network timeout / таймаут клиента или сети
+	statusClassCount
+)
+
+// statusNames label the classes in output, indexed by statusClass / подписи классов в выводе, по индексу statusClass
+var statusNames = [statusClassCount]string{"200", "409", "500", "timeout"}
+
+func (c statusClass) String() string { return statusNames[c] }
+
+// statusLatency accumulates the latency of one class, atomic only / накапливает латентность одного класса, только атомарно
+type statusLatency struct {
+	requests     int64
+	totalLatency int64 // in microseconds / в микросекундах
+	latencies    latencyHistogram
+}
+
+// recordStatus adds the latency of a request that ended with class / добавляет латентность запроса, завершившегося классом class
+func (s *Stats) recordStatus(class statusClass, latency int64) {
+	stats := &s.byStatus[class]
+	atomic.AddInt64(&stats.requests, 1)
+	atomic.AddInt64(&stats.totalLatency, latency)
+	stats.latencies.record(latency)
+}
+
+// isTimeout reports whether a client error is the request running out of time / сообщает, является ли ошибка клиента истечением времени запроса
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// recordClientError counts a request that got no response, timeouts apart from refused or reset connections /
+// учитывает запрос без ответа, таймауты отдельно от отказов и разрывов соединения
+func (s *Stats) recordClientError(err error, latency int64) {
+	if isTimeout(err) {
+		atomic.AddInt64(&s.timeouts, 1)
+		s.recordStatus(statusTimeout, latency)
+	} else {
+		atomic.AddInt64(&s.otherErrors, 1)
+	}
+}
+
+// StatusLatencySummary holds the latency of one response class / латентность одного класса ответов
+type StatusLatencySummary struct {
+	Status       string  `json:"status"`
+	Requests     int64   `json:"requests"`
+	LatencyAvgMs float64 `json:"latencyAvgMs"`
+	LatencyP99Ms float64 `json:"latencyP99Ms"`
+}
+
+// statusSummaries collects the classes that saw requests / собирает классы, на которые пришлись запросы
+func (s *Stats) statusSummaries() []StatusLatencySummary {
+	var summaries []StatusLatencySummary
+	for class := range s.byStatus {
+		stats := &s.byStatus[class]
+		requests := atomic.LoadInt64(&stats.requests)
+		if requests == 0 {
+			continue
+		}
+		summaries = append(summaries, StatusLatencySummary{
+			Status:       statusClass(class).String(),
+			Requests:     requests,
+			LatencyAvgMs: float64(atomic.LoadInt64(&stats.totalLatency)) / float64(requests) / 1000,
+			LatencyP99Ms: stats.latencies.percentile(0.99),
+		})
+	}
+	return summaries
+}
+
+// statusAverages maps each class that saw requests to its average latency in milliseconds, nil if none /
+// сопоставляет каждому классу с запросами его среднюю латентность в миллисекундах, nil если таких нет
+func (s *Stats) statusAverages() map[string]float64 {
+	var averages map[string]float64
+	for _, summary := range s.statusSummaries() {
+		if averages == nil {
+			averages = make(map[string]float64, statusClassCount)
+		}
+		averages[summary.Status] = summary.LatencyAvgMs
+	}
+	return averages
+}
+
+// printStatusStats prints the per-class latency breakdown / выводит разбивку латентности по классам ответов
+func (lt *LoadTester) printStatusStats() {
+	summaries := lt.stats.statusSummaries()
+	if summaries == nil {
+		return
+	}
+
+	fmt.Printf("\nLatency by status:\n")
+	for _, summary := range summaries {
+		fmt.Printf("- %s: %d requests, avg %.2f ms, p99 %.2f ms\n",
+			summary.Status, summary.Requests, summary.LatencyAvgMs, summary.LatencyP99Ms)
+	}
+}
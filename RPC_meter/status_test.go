@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestStatusLatencyBreakdown tests that slow successes and fast conflicts are reported apart
+func TestStatusLatencyBreakdown(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("item_id") {
+		case "1":
+			time.Sleep(20 * time.Millisecond)
+			w.WriteHeader(http.StatusOK)
+		case "2":
+			w.WriteHeader(http.StatusConflict)
+		case "3":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+
+	lt := NewLoadTester(srv.URL, 10)
+	lt.makeRequest(1, 1)
+	lt.makeRequest(1, 2)
+	lt.makeRequest(1, 2)
+	lt.makeRequest(1, 3)
+	lt.makeRequest(1, 4)
+
+	summaries := lt.stats.statusSummaries()
+	require.Len(t, summaries, 3, "the 400 and the unused timeout class are left out")
+	assert.Equal(t, "200", summaries[0].Status)
+	assert.Equal(t, int64(1), summaries[0].Requests)
+	assert.Equal(t, "409", summaries[1].Status)
+	assert.Equal(t, int64(2), summaries[1].Requests)
+	assert.Equal(t, "500", summaries[2].Status)
+	assert.Equal(t, int64(1), summaries[2].Requests)
+
+	assert.GreaterOrEqual(t, summaries[0].LatencyAvgMs, 20.0)
+	assert.GreaterOrEqual(t, summaries[0].LatencyP99Ms, 20.0)
+	assert.Less(t, summaries[1].LatencyAvgMs, summaries[0].LatencyAvgMs, "conflicts skip the slow path")
+
+	averages := lt.stats.statusAverages()
+	assert.Equal(t, summaries[1].LatencyAvgMs, averages["409"])
+	assert.NotContains(t, averages, "timeout")
+}
+
+// TestStatusLatencyTimeout tests that requests cut by the client timeout get their own class
+func TestStatusLatencyTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+	}))
+	defer srv.Close()
+
+	lt := NewLoadTester(srv.URL, 10)
+	lt.httpClient.Timeout = 10 * time.Millisecond
+	lt.makeRequest(1, 1)
+
+	summaries := lt.stats.statusSummaries()
+	require.Len(t, summaries, 1)
+	assert.Equal(t, "timeout", summaries[0].Status)
+	assert.Equal(t, int64(1), summaries[0].Requests)
+	assert.Zero(t, lt.stats.totalLatency, "timeouts stay out of the overall latency")
+	assert.Equal(t, int64(1), lt.stats.timeouts)
+	assert.Zero(t, lt.stats.otherErrors)
+}
+
+// TestClientErrorClassification tests that timeouts and refused connections are counted apart in every request kind
+func TestClientErrorClassification(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+	}))
+	defer slow.Close()
+	refused := httptest.NewServer(http.NotFoundHandler())
+	refused.Close()
+
+	for _, op := range []loadOp{opCheckout, opChain, opStatus} {
+		lt := NewLoadTester(slow.URL, 10)
+		lt.httpClient.Timeout = 10 * time.Millisecond
+		lt.runOp(op, 1, 1)
+		assert.Equal(t, int64(1), lt.stats.timeouts, "%s timeout", op)
+		assert.Zero(t, lt.stats.otherErrors, "%s timeout", op)
+
+		lt = NewLoadTester(refused.URL, 10)
+		lt.runOp(op, 1, 1)
+		assert.Zero(t, lt.stats.timeouts, "%s refused", op)
+		assert.Equal(t, int64(1), lt.stats.otherErrors, "%s refused", op)
+	}
+
+	// The purchase step of a chain times out after a successful checkout
+	chain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/purchase" {
+			time.Sleep(100 * time.Millisecond)
+			return
+		}
+		w.Write([]byte("550e8400-e29b-41d4-a716-446655440000"))
+	}))
+	defer chain.Close()
+	lt := NewLoadTester(chain.URL, 10)
+	lt.httpClient.Timeout = 50 * time.Millisecond
+	lt.makeChainedRequest(1, 1)
+	assert.Equal(t, int64(1), lt.stats.timeouts)
+	assert.Equal(t, int64(1), lt.stats.purchaseErrors)
+}
+
+// TestIsTimeout tests which client errors count as timeouts
+func TestIsTimeout(t *testing.T) {
+	assert.True(t, isTimeout(context.DeadlineExceeded))
+	assert.True(t, isTimeout(fmt.Errorf("checkout: %w", context.DeadlineExceeded)))
+	assert.False(t, isTimeout(context.Canceled))
+	assert.False(t, isTimeout(fmt.Errorf("connection refused")))
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// adminTokenHeader carries the admin token / заголовок с админским токеном
+const adminTokenHeader = "X-Admin-Token"
+
+// registerAdminRoutes mounts admin endpoints when a token is configured / подключает админские эндпоинты, если задан токен
+func (s *ServerInstance) registerAdminRoutes(mux *http.ServeMux) {
+	if s.config.AdminToken == "" {
+		log.Println("🔒 ADMIN_TOKEN is not set, admin endpoints are disabled")
+		return
+	}
+
+	mux.HandleFunc("/admin/pool-history", s.audited(s.adminOnly(s.poolHistoryHandler)))
+	mux.HandleFunc("/admin/write-amplification", s.audited(s.adminOnly(s.writeAmplificationHandler)))
+	mux.HandleFunc("/admin/purchase-cutoff", s.audited(s.adminOnly(s.purchaseCutoffHandler)))
+	mux.HandleFunc("/admin/cancel-user", s.audited(s.adminOnly(s.cancelUserHandler)))
+}
+
+// adminOnly rejects requests without a valid admin token / отклоняет запросы без корректного админского токена
+func (s *ServerInstance) adminOnly(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.validAdminToken(r.Header.Get(adminTokenHeader)) {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("Unauthorized"))
+			return
+		}
+		next(w, r)
+	}
+}
+
+// validAdminToken compares in constant time / сравнивает за постоянное время
+func (s *ServerInstance) validAdminToken(token string) bool {
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.config.AdminToken)) == 1
+}
+
+// poolHistoryHandler returns DB connection pool saturation history / возвращает историю насыщения пула соединений БД
+func (s *ServerInstance) poolHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte("Method not allowed"))
+		return
+	}
+
+	if s.server == nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("Database is not initialized"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"connection": s.server.GetConnectionInfo(),
+		"samples":    s.server.PoolHistory(),
+	})
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+// Page sizes of /available / Размеры страницы /available
+const (
+	defaultAvailableLimit = 100
+	maxAvailableLimit     = 500 // larger limits are clamped / большие limit обрезаются
+)
+
+// availableItem is one lot in the /available response / один лот в ответе /available
+type availableItem struct {
+	ItemID   int64  `json:"item_id"`
+	Name     string `json:"name,omitempty"`
+	ImageURL string `json:"image_url,omitempty"`
+}
+
+// availableResponse is the /available response body / тело ответа /available
+type availableResponse struct {
+	Items      []availableItem `json:"items"`
+	NextOffset *int            `json:"next_offset"` // null once the scan reached the last lot / null, когда сканирование дошло до последнего лота
+}
+
+// availableHandler pages through available lots for the storefront, read-only like /item / постранично отдает доступные лоты для витрины, только чтение как /item
+func (s *ServerInstance) availableHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	offset, ok := queryInt(r, "offset", 0)
+	if !ok || offset < 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	limit, ok := queryInt(r, "limit", defaultAvailableLimit)
+	if !ok || limit <= 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	limit = min(limit, maxAvailableLimit)
+
+	itemIDs := s.cache.ListAvailable(offset, limit)
+	response := availableResponse{Items: make([]availableItem, 0, len(itemIDs))}
+	for _, itemID := range itemIDs {
+		name, imageURL, _, err := s.cache.GetLotInfo(itemID)
+		if err != nil {
+			continue
+		}
+		response.Items = append(response.Items, availableItem{ItemID: itemID, Name: name, ImageURL: imageURL})
+	}
+
+	// A full page may have more lots after it / После полной страницы могут быть еще лоты
+	if len(itemIDs) == limit {
+		next := int(itemIDs[len(itemIDs)-1]) + 1
+		response.NextOffset = &next
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// queryInt parses an optional int query parameter, returning def when it is absent / разбирает необязательный int параметр запроса, возвращает def при его отсутствии
+func queryInt(r *http.Request, name string, def int) (int, bool) {
+	raw := r.URL.Query().Get(name)
+	if raw == "" {
+		return def, true
+	}
+	value, err := strconv.Atoi(raw)
+	return value, err == nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+)
+
+// poolShedRetryAfter is the Retry-After, in seconds, of requests shed on a saturated pool / Retry-After в секундах для запросов, сброшенных при насыщенном пуле
+const poolShedRetryAfter = 1
+
+// poolStatsSource reports database pool usage / сообщает об использовании пула соединений БД
+type poolStatsSource interface {
+	Stats() sql.DBStats
+}
+
+// poolSaturated reports whether the share of pool connections in use reached POOL_SATURATION_THRESHOLD; an unlimited pool is never saturated /
+// сообщает, достигла ли доля занятых соединений пула POOL_SATURATION_THRESHOLD; неограниченный пул никогда не насыщен
+func (s *ServerInstance) poolSaturated() bool {
+	if s.config.PoolSaturationThreshold <= 0 || s.dbPool == nil {
+		return false
+	}
+	stats := s.dbPool.Stats()
+	if stats.MaxOpenConnections <= 0 {
+		return false
+	}
+	return float64(stats.InUse) >= s.config.PoolSaturationThreshold*float64(stats.MaxOpenConnections)
+}
+
+// withPoolBackpressure answers 503 with Retry-After instead of queueing on a saturated pool; the check is one Stats call, cheap enough per request /
+// отвечает 503 с Retry-After вместо ожидания в очереди насыщенного пула; проверка - один вызов Stats, достаточно дешево для каждого запроса
+func (s *ServerInstance) withPoolBackpressure(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.poolSaturated() {
+			s.metrics.poolShed.Add(1)
+			w.Header().Set("Retry-After", strconv.Itoa(poolShedRetryAfter))
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"contest_notcoin/megacache"
+	"contest_notcoin/token"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxBulkItems bounds the items of one bulk request / ограничивает число элементов одного пакетного запроса
+const maxBulkItems = 100
+
+// bulkCheckoutResult is the outcome for one lot of /checkout/bulk / исход для одного лота /checkout/bulk
+type bulkCheckoutResult struct {
+	ItemID    int64      `json:"item_id"`
+	Status    int        `json:"status"`
+	Code      string     `json:"code,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Error     string     `json:"error,omitempty"`
+}
+
+// bulkPurchaseResult is the outcome for one code of /purchase/bulk / исход для одного кода /purchase/bulk
+type bulkPurchaseResult struct {
+	Code   string `json:"code"`
+	Status int    `json:"status"`
+	ItemID int64  `json:"item_id"`
+	Error  string `json:"error,omitempty"`
+}
+
+// bulkResponse is the body of every bulk endpoint / тело ответа всех пакетных эндпоинтов
+type bulkResponse[T any] struct {
+	Results []T `json:"results"`
+}
+
+// bulkStatus folds per-item statuses: 200 if all succeeded, 207 (or 200 when disabled) if some did, otherwise the shared failure status or 409 /
+// сводит статусы элементов: 200, если все успешны, 207 (или 200, если выключено), если успешны некоторые, иначе общий статус отказа или 409
+func bulkStatus(statuses []int, multiStatus bool) int {
+	succeeded := 0
+	for _, status := range statuses {
+		if itemSucceeded(status) {
+			succeeded++
+		}
+	}
+
+	switch {
+	case succeeded == len(statuses):
+		return http.StatusOK
+	case succeeded > 0 && multiStatus:
+		return http.StatusMultiStatus
+	case succeeded > 0:
+		return http.StatusOK
+	}
+
+	for _, status := range statuses[1:] {
+		if status != statuses[0] {
+			return http.StatusConflict
+		}
+	}
+	return statuses[0]
+}
+
+// writeBulkResponse writes per-item results with the folded status / пишет результаты элементов со сводным статусом
+func writeBulkResponse[T any](w http.ResponseWriter, status int, results []T) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(bulkResponse[T]{Results: results})
+}
+
+// splitBulkParam splits a comma-separated list, false if it is empty, too long or has duplicates /
+// разбивает список через запятую, false, если он пустой, слишком длинный или с повторами
+func splitBulkParam(value string) ([]string, bool) {
+	if value == "" {
+		return nil, false
+	}
+
+	parts := strings.Split(value, ",")
+	if len(parts) > maxBulkItems {
+		return nil, false
+	}
+
+	seen := make(map[string]bool, len(parts))
+	for i, part := range parts {
+		part = strings.TrimSpace(part)
+		if part == "" || seen[part] {
+			return nil, false
+		}
+		seen[part] = true
+		parts[i] = part
+	}
+	return parts, true
+}
+
+// itemSucceeded reports a bulk item outcome that kept its lot, 202 is a purchase written later /
+// сообщает об исходе элемента, сохранившем лот, 202 - покупка, записываемая позже
+func itemSucceeded(status int) bool {
+	return status == http.StatusOK || status == http.StatusAccepted
+}
+
+// errorText describes a failed item, falling back to the status text / описывает неуспешный элемент, по умолчанию текстом статуса
+func errorText(status int, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return http.StatusText(status)
+}
+
+// bulkCheckoutHandler reserves several lots independently: each lot succeeds or fails on its own /
+// резервирует несколько лотов независимо: каждый лот успешен или нет сам по себе
+func (s *ServerInstance) bulkCheckoutHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.isAcceptingRequests() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	params, err := parseRequestParams(r, s.config.BodyContentTypePolicy)
+	if err != nil {
+		w.WriteHeader(paramsErrorStatus(err))
+		return
+	}
+
+	userID, err := parseUserID(params.Get("user_id"), s.config.MaxUserID)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	values, ok := splitBulkParam(params.Get("item_ids"))
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	itemIDs := make([]int64, len(values))
+	for i, value := range values {
+		itemIDs[i], err = strconv.ParseInt(value, 10, 64)
+		if err != nil || itemIDs[i] < 0 || itemIDs[i] >= int64(s.config.LotsCount) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Items share the DB batches, so they are saved in parallel / Элементы попадают в общие пакеты БД, поэтому сохраняются параллельно
+	results := make([]bulkCheckoutResult, len(itemIDs))
+	statuses := make([]int, len(itemIDs))
+	var wg sync.WaitGroup
+	for i, itemID := range itemIDs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			// Each item spends a token, so bulk can't bypass the limit / Каждый лот тратит токен, поэтому пакет не обходит лимит
+			var checkout megacache.Checkout
+			var err error
+			status := http.StatusTooManyRequests
+			if s.allowCheckout(userID) {
+				checkout, status, err = s.reserveItem(r, userID, itemID)
+			}
+			results[i] = bulkCheckoutResult{ItemID: itemID, Status: status}
+			statuses[i] = status
+			if status != http.StatusOK {
+				results[i].Error = errorText(status, err)
+				return
+			}
+			results[i].Code = s.reservationCode(checkout)
+			results[i].ExpiresAt = &checkout.ExpiresAt
+		}()
+	}
+	wg.Wait()
+
+	writeBulkResponse(w, bulkStatus(statuses, s.config.BulkMultiStatus), results)
+}
+
+// bulkPurchaseHandler buys several reservations independently / покупает несколько резервов независимо
+func (s *ServerInstance) bulkPurchaseHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.isAcceptingRequests() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	params, err := parseRequestParams(r, s.config.BodyContentTypePolicy)
+	if err != nil {
+		w.WriteHeader(paramsErrorStatus(err))
+		return
+	}
+
+	codes, ok := splitBulkParam(params.Get("codes"))
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	results := make([]bulkPurchaseResult, len(codes))
+	statuses := make([]int, len(codes))
+	var wg sync.WaitGroup
+	for i, codeStr := range codes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = bulkPurchaseResult{Code: codeStr}
+
+			// A bad code fails only its own item / Некорректный код проваливает только свой элемент
+			var status int
+			code, err := s.parseReservationCode(codeStr)
+			switch {
+			case errors.Is(err, token.ErrExpired):
+				s.metrics.conflicts.Add(1)
+				status = http.StatusConflict
+			case err != nil:
+				status = http.StatusBadRequest
+			default:
+				var checkout megacache.Checkout
+				checkout, status, err = s.purchaseReserved(r.Context(), code)
+				results[i].ItemID = checkout.LotIndex
+			}
+
+			results[i].Status = status
+			statuses[i] = status
+			if !itemSucceeded(status) {
+				results[i].Error = errorText(status, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	writeBulkResponse(w, bulkStatus(statuses, s.config.BulkMultiStatus), results)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"contest_notcoin/db"
+	"contest_notcoin/megacache"
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+// buyHandler reserves and buys a lot in one round trip / резервирует и покупает лот за один запрос
+func (s *ServerInstance) buyHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.isAcceptingRequests() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	params, err := parseRequestParams(r, s.config.BodyContentTypePolicy)
+	if err != nil {
+		w.WriteHeader(paramsErrorStatus(err))
+		return
+	}
+
+	userID, err := parseUserID(params.Get("user_id"), s.config.MaxUserID)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// A buy takes the lot like a checkout, so it spends the same token / Покупка забирает лот как checkout, поэтому тратит тот же токен
+	if !s.allowCheckout(userID) {
+		s.writeRateLimited(w)
+		return
+	}
+
+	itemID, err := strconv.ParseInt(params.Get("item_id"), 10, 64)
+	if err != nil || itemID < 0 || itemID >= int64(s.config.LotsCount) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	checkout, status, err := s.buyItem(r.Context(), userID, itemID)
+	if err != nil {
+		w.WriteHeader(status)
+		return
+	}
+
+	writeResponse(w, r, status, s.purchaseBody(checkout.Code.String(), checkout))
+}
+
+// buyItem sells a lot in the cache, saves the purchase to the DB and confirms it, status is the HTTP outcome /
+// продает лот в кеше, сохраняет покупку в БД и подтверждает ее, status - HTTP исход
+func (s *ServerInstance) buyItem(ctx context.Context, userID, itemID int64) (megacache.Checkout, int, error) {
+	logger := requestLogger(ctx).With("user_id", userID, "item_id", itemID)
+
+	// Stage 1: Available straight to sold in cache / из доступного сразу в проданный в кеше
+	checkout, err := s.cache.BuyNow(userID, itemID)
+	if err != nil {
+		logger.Debug("buy refused", "error", err)
+		status := checkoutRefusedStatus(err)
+		if status == http.StatusConflict {
+			s.metrics.conflicts.Add(1)
+		}
+		return megacache.Checkout{}, status, err
+	}
+	logger = logger.With("code", checkout.Code)
+
+	// Stage 2: Purchase in database, no checkout row is written / покупка в БД, строка checkout не пишется
+	err = s.batchPurchase.Purchase(s.saleID, itemID, userID)
+	deferred := errors.Is(err, db.ErrPurchaseDeferred)
+	if err != nil && !deferred {
+		logger.Warn("rollback buy: db update failed", "error", err)
+		s.cache.AbortBuyNow(checkout.Code)
+		s.cache.DeleteCheckout(checkout.Code)
+		switch {
+		case errors.Is(err, db.ErrItemNotAvailable):
+			// DB already sold the lot, the cache was behind / БД уже продала лот, кеш отстал
+			s.cache.MarkSold(itemID)
+			s.metrics.conflicts.Add(1)
+			return megacache.Checkout{}, http.StatusConflict, err
+		case errors.Is(err, db.ErrUpdaterClosed), errors.Is(err, context.Canceled), errors.Is(err, db.ErrConnection):
+			return megacache.Checkout{}, http.StatusServiceUnavailable, err
+		default:
+			s.metrics.internalErrors.Add(1)
+			return megacache.Checkout{}, http.StatusInternalServerError, err
+		}
+	}
+
+	// Stage 3: Confirm in cache / подтверждение в кеше
+	if err := s.cache.ConfirmPurchase(checkout.Code); err != nil {
+		logger.Warn("buy saved but not confirmed in cache", "error", err)
+	}
+	s.writes.recordPurchase()
+	s.metrics.purchases.Add(1)
+
+	if deferred {
+		logger.Warn("buy deferred", "error", err)
+		return checkout, http.StatusAccepted, nil
+	}
+	logger.Debug("buy")
+	return checkout, http.StatusOK, nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"contest_notcoin/megacache"
+	"contest_notcoin/token"
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// cancelDeleteTimeout bounds the best-effort DB cleanup of a cancelled reservation / ограничивает удаление отмененного резерва из БД
+const cancelDeleteTimeout = 200 * time.Millisecond
+
+// reservationDeleter removes a persisted reservation / удаляет сохраненный резерв
+type reservationDeleter interface {
+	DeleteReservation(ctx context.Context, code uuid.UUID) error
+	BatchDeleteReservations(ctx context.Context, codes []uuid.UUID) error
+}
+
+// cancelHandler releases a reserved lot before its TTL runs out / освобождает зарезервированный лот до истечения TTL
+func (s *ServerInstance) cancelHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.isAcceptingRequests() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	params, err := parseRequestParams(r, s.config.BodyContentTypePolicy)
+	if err != nil {
+		w.WriteHeader(paramsErrorStatus(err))
+		return
+	}
+
+	code, err := s.parseReservationCode(params.Get("code"))
+	if err != nil {
+		// An expired token means the reservation is gone anyway / Истекший токен значит, что резерва уже нет
+		if errors.Is(err, token.ErrExpired) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	switch err := s.cache.CancelCheckout(code); {
+	case errors.Is(err, megacache.ErrReservationCompleted):
+		w.WriteHeader(http.StatusConflict)
+		return
+	case errors.Is(err, megacache.ErrReservationNotFound):
+		// Confirmed purchases are dropped from the cache / Подтвержденные покупки удаляются из кеша
+		if s.isPurchasedCode(r.Context(), code) {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	s.cache.DeleteCheckout(code)
+	s.deleteReservation(r.Context(), code)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// isPurchasedCode reports whether a code missing from the cache belongs to this sale and its lot is sold / сообщает, относится ли отсутствующий в кеше код к этой распродаже и продан ли его лот
+func (s *ServerInstance) isPurchasedCode(ctx context.Context, code uuid.UUID) bool {
+	if s.reservations == nil {
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, staleSaleLookupTimeout)
+	defer cancel()
+
+	record, err := s.reservations.GetReservationByCode(ctx, code)
+	if err != nil {
+		log.Printf("⚠️  Cancelled reservation lookup failed: %v", err)
+		return false
+	}
+	if record == nil || record.SaleID != s.saleID {
+		return false
+	}
+
+	status, err := s.cache.GetLotStatus(record.ItemID)
+	return err == nil && status == megacache.StatusSold
+}
+
+// deleteReservation drops the persisted row, the cache is already authoritative so failures are only logged /
+// удаляет сохраненную строку, кеш уже актуален, поэтому ошибки только логируются
+func (s *ServerInstance) deleteReservation(ctx context.Context, code uuid.UUID) {
+	// Nothing is written in none mode / В режиме none ничего не записывается
+	if s.cancels == nil || s.config.CheckoutPersistMode == persistModeNone {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, cancelDeleteTimeout)
+	defer cancel()
+
+	if err := s.cancels.DeleteReservation(ctx, code); err != nil {
+		log.Printf("⚠️  Failed to delete cancelled reservation %s: %v", code, err)
+	}
+}
+
+// deleteReservations drops released reservations from the DB in one statement, best effort like deleteReservation /
+// удаляет освобожденные резервы из БД одним запросом, без гарантий, как deleteReservation
+func (s *ServerInstance) deleteReservations(ctx context.Context, codes []uuid.UUID) {
+	if s.cancels == nil || s.config.CheckoutPersistMode == persistModeNone || len(codes) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, cancelDeleteTimeout)
+	defer cancel()
+
+	if err := s.cancels.BatchDeleteReservations(ctx, codes); err != nil {
+		log.Printf("⚠️  Failed to delete %d cancelled reservations: %v", len(codes), err)
+	}
+}
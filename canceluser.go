@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// cancelUserResponse is the body of /admin/cancel-user / тело ответа /admin/cancel-user
+type cancelUserResponse struct {
+	UserID   int64 `json:"user_id"`
+	Released int   `json:"released"` // active reservations released / освобожденных активных резервов
+}
+
+// cancelUserHandler releases every lot a flagged user holds, purchases stay; persisted rows are deleted too, so a restart doesn't give the holds back /
+// освобождает все лоты, которые держит помеченный пользователь, покупки остаются; сохраненные строки тоже удаляются, чтобы рестарт не вернул резервы
+func (s *ServerInstance) cancelUserHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte("Method not allowed"))
+		return
+	}
+
+	// Taken from the query string so the audit log records it / Берем из строки запроса, чтобы значение попало в аудит
+	userID, err := parseUserID(r.URL.Query().Get("user_id"), s.config.MaxUserID)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("user_id must be between 1 and MAX_USER_ID"))
+		return
+	}
+
+	// Same cleanup as /cancel, per released code / Та же очистка, что и в /cancel, для каждого освобожденного кода
+	codes := s.cache.CancelUserCheckouts(userID)
+	for _, code := range codes {
+		s.cache.DeleteCheckout(code)
+	}
+	s.deleteReservations(r.Context(), codes)
+
+	response := cancelUserResponse{UserID: userID, Released: len(codes)}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"contest_notcoin/megacache"
+	"contest_notcoin/token"
+	"errors"
+	"net/http"
+)
+
+// checkoutInfoHandler looks up a reservation by the code from /checkout, it is what the Location header points at.
+// Served from memory, read-only, so it keeps answering during shutdown /
+// ищет резерв по коду из /checkout, на него указывает заголовок Location. Отдается из памяти, только чтение, поэтому работает и во время остановки
+func (s *ServerInstance) checkoutInfoHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	raw := r.URL.Query().Get("code")
+	code, err := s.parseReservationCode(raw)
+	if err != nil {
+		// An expired token means the reservation is gone anyway / Истекший токен значит, что резерва уже нет
+		if errors.Is(err, token.ErrExpired) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	checkout, exists := s.cache.GetCheckoutInfo(code)
+	if !exists || checkout.Status == megacache.CheckoutStatusCancelled {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// Same answer as /renew for a reservation that can no longer be held / Тот же ответ, что у /renew, для резерва, который уже нельзя держать
+	if checkout.Status != megacache.CheckoutStatusActive {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+	if _, ok := s.cache.TimeRemaining(code); !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// The code is echoed back as sent, a token stays valid until the expiry it carries / Код возвращается как прислан, токен действителен до своего срока
+	writeResponse(w, r, http.StatusOK, checkoutResponse{Code: raw, ExpiresAt: checkout.ExpiresAt, ItemID: checkout.LotIndex})
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"contest_notcoin/db"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Checkout persistence modes / Режимы сохранения checkout
+const (
+	persistModeSync       = "sync"       // every checkout waits for its batch insert / каждый checkout ждет своей пакетной вставки
+	persistModeBackground = "background" // cache snapshots are bulk-persisted in the background / снимки кеша сохраняются пачками в фоне
+	persistModeNone       = "none"       // checkouts stay in the cache, only purchases are persisted / checkout остаются в кеше, сохраняются только покупки
+)
+
+// Policies for lots marked sold under a live reservation / Политики для лотов, помеченных проданными при живом резерве
+const (
+	claimedLotReject  = "reject"  // the reservation holder loses the lot / держатель резерва теряет лот
+	claimedLotProtect = "protect" // reserved lots are not marked sold until released / зарезервированные лоты не помечаются проданными до освобождения
+)
+
+// AppConfig holds service settings read from the environment / хранит настройки сервиса, читаемые из окружения
+type AppConfig struct {
+	// Listener / Слушатель
+	ListenAddr string // address of the sale API / адрес API распродажи
+	EnableH2C  bool   // serve cleartext HTTP/2 next to HTTP/1.1 / отдавать HTTP/2 без шифрования рядом с HTTP/1.1
+
+	// Sale size / Размер распродажи
+	LotsCount    int // lots per sale, also the item_id bound and the DB sale size / лотов в распродаже, также граница item_id и размер распродажи в БД
+	LimitPerUser int // purchases allowed per user / покупок на пользователя
+
+	// Reservation lifetime / Время жизни резерва
+	CheckoutTTL          time.Duration // how long a checkout holds a lot / сколько checkout держит лот
+	MaxCheckouts         int           // reservations kept in memory before an out-of-band cleanup, 0 disables / резервов в памяти до внеплановой очистки, 0 - выключено
+	CleanupInterval      time.Duration // expired reservations cleanup period, 0 derives it from CheckoutTTL / период очистки истекших резервов, 0 - вычисляется из CheckoutTTL
+	CheckoutEndsWithSale bool          // cap reservation expiry at the next restart / ограничивать срок резерва следующим перезапуском
+	ClaimedLotPolicy     string        // reject or protect reserved lots the DB reports sold / reject или protect для зарезервированных лотов, проданных по данным БД
+
+	// User IDs / ID пользователей
+	MaxUserID int64 // largest accepted user_id, smaller IDs down to 1 are valid / наибольший допустимый user_id, допустимы ID от 1
+
+	// Per-user checkout rate limit, off by default / Лимит частоты checkout на пользователя, по умолчанию выключен
+	CheckoutRateLimit int // checkouts per second per user_id, 0 disables / checkout в секунду на user_id, 0 - выключено
+	CheckoutRateBurst int // checkouts a user may fire at once / сколько checkout пользователь может сделать разом
+
+	// Endgame soft cap, off by default / Мягкий лимит в конце распродажи, по умолчанию выключен
+	SoftCapThreshold  int           // engages below this many unsold lots, 0 disables / включается, когда непроданных лотов меньше, 0 - выключено
+	SoftCapMaxDelay   time.Duration // max random checkout delay / макс. случайная задержка checkout
+	SoftCapRejectRate float64       // checkout rejection probability / вероятность отказа в checkout
+
+	// Sale lifecycle / Жизненный цикл распродажи
+	RestartInterval time.Duration // new sale and instance every interval / новая распродажа и экземпляр каждый интервал
+
+	// Checkout write path / Путь записи checkout
+	CheckoutPersistMode     string        // sync, background or none / sync, background или none
+	CheckoutPersistInterval time.Duration // background snapshot interval / интервал фоновых снимков
+	CheckoutPersistBatch    int           // rows per bulk insert in background mode / строк на одну вставку в фоновом режиме
+	MaxClientWait           time.Duration // upper bound for X-Max-Wait-Ms / верхняя граница для X-Max-Wait-Ms
+	StatementWarmupConns    int           // pooled connections to prepare statements on at startup / соединения пула для прогрева выражений при старте
+	MinIdleConns            int           // idle connections kept warm between bursts, 0 disables / простаивающие соединения, которые держатся теплыми между всплесками, 0 - выключено
+	QueryCacheSize          int           // generated batch queries kept per repository / сгенерированных запросов пачек на репозиторий
+	PoolSaturationThreshold float64       // share of pool connections in use that sheds writes with 503, 0 disables / доля занятых соединений пула, при которой запись сбрасывается с 503, 0 - выключено
+	ReplicaDSN              string        // read replica for query-only endpoints, empty reads from the primary / реплика для эндпоинтов только на чтение, пустое значение - читаем из основной БД
+	DisableReplicas         bool          // ignore ReplicaDSN, e.g. while the replica lags / игнорировать ReplicaDSN, например, пока реплика отстает
+
+	// Request parsing / Разбор запросов
+	BodyContentTypePolicy string // ignore or reject bodies without a supported Content-Type / игнорировать или отклонять тела без поддерживаемого Content-Type
+
+	// Checkout responses / Ответы на checkout
+	CheckoutCreatedStatus bool // answer 201 Created instead of 200 OK / отвечать 201 Created вместо 200 OK
+
+	// Bulk responses / Ответы пакетных эндпоинтов
+	BulkMultiStatus bool // answer partial success with 207 Multi-Status instead of 200 / отвечать на частичный успех 207 Multi-Status вместо 200
+
+	// Purchase responses / Ответы на покупку
+	StaleSaleStatus      int // status for codes from an ended sale / статус для кодов из завершенной распродажи
+	IdempotencyCacheSize int // finished purchases remembered for replaying retries / завершенных покупок, запомненных для повторов
+
+	// Batch writers, tuned per database / Пакетная запись, настраивается под БД
+	CheckoutBatchSize     int           // checkouts per batch insert in sync mode / checkout на пакетную вставку в режиме sync
+	CheckoutFlushInterval time.Duration // flush of a partial checkout batch / сброс неполного пакета checkout
+	PurchaseBatchSize     int           // purchases per batch update / покупок на пакетное обновление
+	PurchaseFlushInterval time.Duration // flush of a partial purchase batch / сброс неполного пакета покупок
+
+	// Purchase writes / Запись покупок
+	PurchaseRetryAttempts int // tries per purchase batch before it is dead-lettered / попыток на пачку покупок до очереди недоставленных
+
+	// Recovery / Восстановление
+	RecoveryCleanupExpired bool // delete expired checkout rows on startup / удалять истекшие строки checkout при запуске
+
+	// Reservation tokens / Токены резервирования
+	TokenSecret string // HMAC secret, empty returns raw UUIDs / секрет HMAC, пустое значение - отдаем UUID
+
+	// Admin endpoints / Админские эндпоинты
+	AdminToken string // empty disables /admin routes / пустое значение отключает маршруты /admin
+	AuditLog   string // admin audit file, empty writes to stdout / файл аудита админских действий, пустое значение - пишем в stdout
+	PprofAddr  string // separate listen address for /debug/pprof, empty disables it / отдельный адрес для /debug/pprof, пустое значение отключает его
+}
+
+// DefaultAppConfig returns settings matching the original hardcoded behaviour / возвращает настройки, совпадающие с исходным поведением
+func DefaultAppConfig() *AppConfig {
+	return &AppConfig{
+		ListenAddr:              defaultListenAddr,
+		LotsCount:               10000,
+		LimitPerUser:            10,
+		CheckoutTTL:             3 * time.Second,
+		ClaimedLotPolicy:        claimedLotReject,
+		MaxUserID:               math.MaxInt32,
+		CheckoutRateBurst:       10,
+		RestartInterval:         time.Hour,
+		CheckoutPersistMode:     persistModeSync,
+		CheckoutPersistInterval: 100 * time.Millisecond,
+		CheckoutPersistBatch:    1000,
+		MaxClientWait:           5 * time.Second,
+		StatementWarmupConns:    50, // every idle connection the pool keeps / все простаивающие соединения пула
+		QueryCacheSize:          128,
+		BodyContentTypePolicy:   bodyPolicyIgnore,
+		StaleSaleStatus:         http.StatusGone,
+		CheckoutBatchSize:       100,
+		CheckoutFlushInterval:   50 * time.Millisecond,
+		PurchaseBatchSize:       10,
+		PurchaseFlushInterval:   10 * time.Millisecond,
+		PurchaseRetryAttempts:   db.DefaultPurchaseRetryAttempts,
+		IdempotencyCacheSize:    defaultPurchaseOutcomes,
+		BulkMultiStatus:         true,
+		RecoveryCleanupExpired:  true,
+	}
+}
+
+// loadAppConfig reads service settings from environment variables / читает настройки сервиса из переменных окружения
+func loadAppConfig() *AppConfig {
+	config := DefaultAppConfig()
+
+	config.ListenAddr = envString("LISTEN_ADDR", config.ListenAddr)
+	if _, _, err := net.SplitHostPort(config.ListenAddr); err != nil {
+		log.Printf("⚠️  Invalid LISTEN_ADDR %q, using %q", config.ListenAddr, defaultListenAddr)
+		config.ListenAddr = defaultListenAddr
+	}
+	config.EnableH2C = envBool("ENABLE_H2C", config.EnableH2C)
+
+	config.LotsCount = envInt("LOTS_COUNT", config.LotsCount)
+	config.LimitPerUser = envInt("LIMIT_PER_USER", config.LimitPerUser)
+	config.CheckoutTTL = envDuration("CHECKOUT_TTL", config.CheckoutTTL)
+	config.MaxCheckouts = envInt("MAX_CHECKOUTS", config.MaxCheckouts)
+	config.CleanupInterval = envDuration("CLEANUP_INTERVAL", config.CleanupInterval)
+	config.CheckoutEndsWithSale = envBool("CHECKOUT_ENDS_WITH_SALE", config.CheckoutEndsWithSale)
+	switch policy := envString("CLAIMED_LOT_POLICY", config.ClaimedLotPolicy); policy {
+	case claimedLotReject, claimedLotProtect:
+		config.ClaimedLotPolicy = policy
+	default:
+		log.Printf("⚠️  Unknown CLAIMED_LOT_POLICY %q, using %q", policy, config.ClaimedLotPolicy)
+	}
+	config.RestartInterval = envDuration("RESTART_INTERVAL", config.RestartInterval)
+
+	config.MaxUserID = envInt64("MAX_USER_ID", config.MaxUserID)
+
+	config.CheckoutRateLimit = envInt("CHECKOUT_RATE_LIMIT", config.CheckoutRateLimit)
+	config.CheckoutRateBurst = envInt("CHECKOUT_RATE_BURST", config.CheckoutRateBurst)
+
+	config.SoftCapThreshold = envInt("SOFT_CAP_THRESHOLD", config.SoftCapThreshold)
+	config.SoftCapMaxDelay = envDuration("SOFT_CAP_MAX_DELAY", config.SoftCapMaxDelay)
+	config.SoftCapRejectRate = envFloat("SOFT_CAP_REJECT_RATE", config.SoftCapRejectRate)
+
+	switch mode := envString("CHECKOUT_PERSIST_MODE", config.CheckoutPersistMode); mode {
+	case persistModeSync, persistModeBackground, persistModeNone:
+		config.CheckoutPersistMode = mode
+	default:
+		log.Printf("⚠️  Unknown CHECKOUT_PERSIST_MODE %q, using %q", mode, config.CheckoutPersistMode)
+	}
+
+	config.CheckoutPersistInterval = envDuration("CHECKOUT_PERSIST_INTERVAL", config.CheckoutPersistInterval)
+	config.CheckoutPersistBatch = envInt("CHECKOUT_PERSIST_BATCH", config.CheckoutPersistBatch)
+	config.MaxClientWait = envDuration("MAX_CLIENT_WAIT", config.MaxClientWait)
+	config.StatementWarmupConns = envInt("STATEMENT_WARMUP_CONNS", config.StatementWarmupConns)
+	config.MinIdleConns = envInt("MIN_IDLE_CONNS", config.MinIdleConns)
+	config.QueryCacheSize = envInt("QUERY_CACHE_SIZE", config.QueryCacheSize)
+	config.PoolSaturationThreshold = envFloat("POOL_SATURATION_THRESHOLD", config.PoolSaturationThreshold)
+	config.ReplicaDSN = envString("DB_REPLICA_DSN", config.ReplicaDSN)
+	config.DisableReplicas = envBool("DB_DISABLE_REPLICAS", config.DisableReplicas)
+	switch policy := envString("BODY_CONTENT_TYPE_POLICY", config.BodyContentTypePolicy); policy {
+	case bodyPolicyIgnore, bodyPolicyReject:
+		config.BodyContentTypePolicy = policy
+	default:
+		log.Printf("⚠️  Unknown BODY_CONTENT_TYPE_POLICY %q, using %q", policy, config.BodyContentTypePolicy)
+	}
+
+	config.CheckoutCreatedStatus = envBool("CHECKOUT_CREATED_STATUS", config.CheckoutCreatedStatus)
+	config.BulkMultiStatus = envBool("BULK_MULTI_STATUS", config.BulkMultiStatus)
+	if status := envInt("STALE_SALE_STATUS", config.StaleSaleStatus); status >= 400 && status < 600 {
+		config.StaleSaleStatus = status
+	} else {
+		log.Printf("⚠️  STALE_SALE_STATUS must be a 4xx or 5xx code, using %d", config.StaleSaleStatus)
+	}
+
+	config.IdempotencyCacheSize = envInt("IDEMPOTENCY_CACHE_SIZE", config.IdempotencyCacheSize)
+
+	config.CheckoutBatchSize = envInt("CHECKOUT_BATCH_SIZE", config.CheckoutBatchSize)
+	config.CheckoutFlushInterval = envMillis("CHECKOUT_FLUSH_MS", config.CheckoutFlushInterval)
+	config.PurchaseBatchSize = envInt("PURCHASE_BATCH_SIZE", config.PurchaseBatchSize)
+	config.PurchaseFlushInterval = envMillis("PURCHASE_FLUSH_MS", config.PurchaseFlushInterval)
+	config.PurchaseRetryAttempts = envInt("PURCHASE_RETRY_ATTEMPTS", config.PurchaseRetryAttempts)
+
+	config.RecoveryCleanupExpired = envBool("RECOVERY_CLEANUP_EXPIRED", config.RecoveryCleanupExpired)
+
+	config.TokenSecret = envString("RESERVATION_TOKEN_SECRET", config.TokenSecret)
+	config.AdminToken = envString("ADMIN_TOKEN", config.AdminToken)
+	config.AuditLog = envString("AUDIT_LOG", config.AuditLog)
+
+	// Profiles must never be reachable on the public port / Профили никогда не должны быть доступны на публичном порту
+	_, listenPort, _ := net.SplitHostPort(config.ListenAddr)
+	config.PprofAddr = envString("PPROF_ADDR", config.PprofAddr)
+	if _, port, err := net.SplitHostPort(config.PprofAddr); config.PprofAddr != "" && (err != nil || port == listenPort) {
+		log.Printf("⚠️  Invalid PPROF_ADDR %q, pprof is disabled", config.PprofAddr)
+		config.PprofAddr = ""
+	}
+
+	return config
+}
+
+// envString returns env value or default / возвращает значение переменной окружения или значение по умолчанию
+func envString(key, def string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return def
+}
+
+// envInt returns positive integer env value or default / возвращает положительное целое из окружения или значение по умолчанию
+func envInt(key string, def int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed <= 0 {
+		log.Printf("⚠️  Invalid %s=%q, using %d", key, value, def)
+		return def
+	}
+	return parsed
+}
+
+// envInt64 returns positive 64-bit integer env value or default / возвращает положительное 64-битное целое из окружения или значение по умолчанию
+func envInt64(key string, def int64) int64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+
+	parsed, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || parsed <= 0 {
+		log.Printf("⚠️  Invalid %s=%q, using %d", key, value, def)
+		return def
+	}
+	return parsed
+}
+
+// envDuration returns positive duration env value or default / возвращает положительную длительность из окружения или значение по умолчанию
+func envDuration(key string, def time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+
+	parsed, err := time.ParseDuration(value)
+	if err != nil || parsed <= 0 {
+		log.Printf("⚠️  Invalid %s=%q, using %v", key, value, def)
+		return def
+	}
+	return parsed
+}
+
+// envMillis returns a positive whole number of milliseconds from env or default / возвращает положительное целое число миллисекунд из окружения или значение по умолчанию
+func envMillis(key string, def time.Duration) time.Duration {
+	return time.Duration(envInt(key, int(def/time.Millisecond))) * time.Millisecond
+}
+
+// envFloat returns a [0, 1] fraction env value or default / возвращает долю [0, 1] из окружения или значение по умолчанию
+func envFloat(key string, def float64) float64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil || parsed < 0 || parsed > 1 {
+		log.Printf("⚠️  Invalid %s=%q, using %v", key, value, def)
+		return def
+	}
+	return parsed
+}
+
+// envBool returns boolean env value or default / возвращает логическое значение из окружения или значение по умолчанию
+func envBool(key string, def bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Printf("⚠️  Invalid %s=%q, using %v", key, value, def)
+		return def
+	}
+	return parsed
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// purchaseCutoffResponse is the body of /admin/purchase-cutoff / тело ответа /admin/purchase-cutoff
+type purchaseCutoffResponse struct {
+	Cutoff *time.Time `json:"cutoff"` // null when no cutoff is set / null, если отсечка не задана
+}
+
+// purchaseCutoffHandler reads, sets or lifts the cutoff before which reservations can't be purchased /
+// читает, задает или снимает отсечку, до которой созданные резервы нельзя купить
+func (s *ServerInstance) purchaseCutoffHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		// Taken from the query string so the audit log records it / Берем из строки запроса, чтобы значение попало в аудит
+		cutoff := time.Now()
+		if before := r.URL.Query().Get("before"); before != "" {
+			parsed, err := time.Parse(time.RFC3339Nano, before)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte("before must be an RFC 3339 timestamp"))
+				return
+			}
+			cutoff = parsed
+		}
+		s.cache.SetPurchaseCutoff(cutoff)
+	case http.MethodDelete:
+		s.cache.SetPurchaseCutoff(time.Time{})
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte("Method not allowed"))
+		return
+	}
+
+	var response purchaseCutoffResponse
+	if cutoff := s.cache.PurchaseCutoff(); !cutoff.IsZero() {
+		response.Cutoff = &cutoff
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
@@ -5,15 +5,22 @@ package db
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"log"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/google/uuid"
-	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
 )
 
+// ErrDuplicateCode код checkout уже есть в БД: коллизия UUID или повторная вставка того же резерва.
+// Ошибка записи с таким кодом относится и к категории ErrConstraint
+var ErrDuplicateCode = errors.New("duplicate checkout code")
+
 // CheckoutRepository инкапсулирует все методы работы с checkouts
 type CheckoutRepository struct {
 	server              *Server // Ссылка на сервер для переподключений
@@ -21,7 +28,7 @@ type CheckoutRepository struct {
 	insertStmt          *sql.Stmt
 	updatePurchaseStmt  *sql.Stmt
 	batchInsertStmt     *sql.Stmt
-	multiRowInsertCache map[int]string // Кеш для многострочных запросов
+	multiRowInsertCache *queryLRU // Кеш для многострочных запросов
 }
 
 // NewCheckoutRepository создает новый репозиторий с подготовленными выражениями
@@ -35,8 +42,8 @@ func NewCheckoutRepository(server *Server) (*CheckoutRepository, error) {
 
 	// Подготавливаем базовые выражения
 	insertStmt, err := db.PrepareContext(ctx, `
-		INSERT INTO checkouts (user_id, item_id, code, created_at, expires_at)
-		VALUES ($1, $2, $3, $4, $5)
+		INSERT INTO checkouts (sale_id, user_id, item_id, code, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
 		RETURNING id`)
 	if err != nil {
 		return nil, fmt.Errorf("prepare insert: %w", err)
@@ -49,8 +56,9 @@ func NewCheckoutRepository(server *Server) (*CheckoutRepository, error) {
 	}
 
 	batchInsertStmt, err := db.PrepareContext(ctx, `
-		INSERT INTO checkouts (user_id, item_id, code, created_at, expires_at)
-		VALUES ($1, $2, $3, $4, $5)`)
+		INSERT INTO checkouts (sale_id, user_id, item_id, code, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (code) DO NOTHING`)
 	if err != nil {
 		return nil, fmt.Errorf("prepare batch insert: %w", err)
 	}
@@ -61,10 +69,15 @@ func NewCheckoutRepository(server *Server) (*CheckoutRepository, error) {
 		insertStmt:          insertStmt,
 		updatePurchaseStmt:  updateStmt,
 		batchInsertStmt:     batchInsertStmt,
-		multiRowInsertCache: make(map[int]string),
+		multiRowInsertCache: newQueryLRU(server.config.QueryCacheSize),
 	}, nil
 }
 
+// Warmup подготавливает выражения репозитория на соединениях пула
+func (r *CheckoutRepository) Warmup(ctx context.Context) (int, error) {
+	return r.server.WarmupStatements(ctx, r.insertStmt, r.updatePurchaseStmt, r.batchInsertStmt)
+}
+
 // Close освобождает ресурсы
 func (r *CheckoutRepository) Close() error {
 	var errs []error
@@ -89,55 +102,76 @@ func (r *CheckoutRepository) Close() error {
 func (r *CheckoutRepository) InsertOne(ctx context.Context, record CheckoutRecord) (int64, error) {
 	var id int64
 	err := r.insertStmt.QueryRowContext(ctx,
+		record.SaleID,
 		record.UserID,
 		record.ItemID,
 		record.Code,
 		record.CreatedAt,
 		record.ExpiresAt,
 	).Scan(&id)
-	return id, err
+	return id, classify(err)
 }
 
-// BatchInsert пакетная вставка в транзакции
-func (r *CheckoutRepository) BatchInsert(ctx context.Context, records []CheckoutRecord) error {
+// duplicateCodeError ошибка записи, чей код уже занят
+func duplicateCodeError(code uuid.UUID) error {
+	return &classifiedError{category: ErrConstraint, err: fmt.Errorf("%w %s", ErrDuplicateCode, code)}
+}
+
+// BatchInsert пакетная вставка в транзакции.
+// Записи с уже занятым кодом пропускаются: results[i] для них ErrDuplicateCode, для вставленных nil.
+// err - ошибка всей пачки, тогда не вставлено ничего
+func (r *CheckoutRepository) BatchInsert(ctx context.Context, records []CheckoutRecord) (results []error, err error) {
 	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
-		return err
+		return nil, classify(err)
 	}
 	defer tx.Rollback()
 
 	stmt := tx.StmtContext(ctx, r.batchInsertStmt)
 	defer stmt.Close()
 
-	for _, record := range records {
-		if _, err := stmt.ExecContext(ctx,
+	results = make([]error, len(records))
+	for i, record := range records {
+		res, err := stmt.ExecContext(ctx,
+			record.SaleID,
 			record.UserID,
 			record.ItemID,
 			record.Code,
 			record.CreatedAt,
 			record.ExpiresAt,
-		); err != nil {
-			return err
+		)
+		if err != nil {
+			return nil, classify(err)
+		}
+
+		// ON CONFLICT DO NOTHING: ноль строк значит, что код уже есть
+		inserted, err := res.RowsAffected()
+		if err != nil {
+			return nil, classify(err)
+		}
+		if inserted == 0 {
+			results[i] = duplicateCodeError(record.Code)
 		}
 	}
 
-	return tx.Commit()
+	if err := tx.Commit(); err != nil {
+		return nil, classify(err)
+	}
+	return results, nil
 }
 
-// MultiRowInsert многострочный INSERT (VALUES (..), (..), ...)
-func (r *CheckoutRepository) MultiRowInsert(ctx context.Context, records []CheckoutRecord) error {
-	// Используем кешированный запрос если есть
-	query, ok := r.multiRowInsertCache[len(records)]
-	if !ok {
-		// Генерируем запрос с нужным количеством плейсхолдеров
-		query = generateMultiRowQuery(len(records))
-		r.multiRowInsertCache[len(records)] = query
-	}
+// MultiRowInsert многострочный INSERT (VALUES (..), (..), ...).
+// Записи с уже занятым кодом, в том числе повторы внутри пачки, пропускаются:
+// results[i] для них ErrDuplicateCode, для вставленных nil. err - ошибка всей пачки
+func (r *CheckoutRepository) MultiRowInsert(ctx context.Context, records []CheckoutRecord) (results []error, err error) {
+	// Используем кешированный запрос, при промахе генерируем с нужным количеством плейсхолдеров
+	query := r.multiRowInsertCache.Get(len(records), generateMultiRowQuery)
 
 	// Подготавливаем значения
-	values := make([]interface{}, 0, len(records)*5)
+	values := make([]interface{}, 0, len(records)*6)
 	for _, record := range records {
 		values = append(values,
+			record.SaleID,
 			record.UserID,
 			record.ItemID,
 			record.Code,
@@ -147,33 +181,118 @@ func (r *CheckoutRepository) MultiRowInsert(ctx context.Context, records []Check
 	}
 
 	// Используем метод сервера с автоматическим переподключением
-	_, err := r.server.ExecContext(ctx, query, values...)
-	return err
+	rows, err := r.server.QueryContext(ctx, query, values...)
+	if err != nil {
+		return nil, classify(err)
+	}
+	defer rows.Close()
+
+	// RETURNING отдает только вставленные коды
+	inserted := make(map[uuid.UUID]bool, len(records))
+	for rows.Next() {
+		var code uuid.UUID
+		if err := rows.Scan(&code); err != nil {
+			return nil, classify(err)
+		}
+		inserted[code] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, classify(err)
+	}
+
+	// Код принадлежит первой записи пачки с ним, остальные получают ошибку
+	results = make([]error, len(records))
+	for i, record := range records {
+		if inserted[record.Code] {
+			inserted[record.Code] = false
+			continue
+		}
+		results[i] = duplicateCodeError(record.Code)
+	}
+	return results, nil
+}
+
+// checkoutColumns колонки checkouts в порядке вставки
+var checkoutColumns = []string{"sale_id", "user_id", "item_id", "code", "created_at", "expires_at"}
+
+// CopyInsert вставляет записи через COPY протокол pgx.
+// На больших пачках быстрее многострочного VALUES и не упирается в лимит 65535 параметров
+// (многострочный VALUES упирается в него на ~10922 строках по 6 колонок).
+// Соединение pgx берется из общего пула database/sql через conn.Raw, отдельный pgxpool не нужен:
+// COPY остается в том же лимите соединений и метриках пула, что и остальные запросы
+func (r *CheckoutRepository) CopyInsert(ctx context.Context, records []CheckoutRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	db := r.server.DB()
+	if db == nil {
+		return fmt.Errorf("database connection is nil")
+	}
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("acquire connection: %w", classify(err))
+	}
+	defer conn.Close()
+
+	return conn.Raw(func(driverConn any) error {
+		pgxConn := driverConn.(*stdlib.Conn).Conn()
+
+		rows := pgx.CopyFromSlice(len(records), func(i int) ([]any, error) {
+			record := records[i]
+			return []any{
+				record.SaleID,
+				record.UserID,
+				record.ItemID,
+				[16]byte(record.Code),
+				record.CreatedAt,
+				record.ExpiresAt,
+			}, nil
+		})
+
+		copied, err := pgxConn.CopyFrom(ctx, pgx.Identifier{"checkouts"}, checkoutColumns, rows)
+		if err != nil {
+			return fmt.Errorf("copy checkouts: %w", classify(err))
+		}
+		if copied != int64(len(records)) {
+			return fmt.Errorf("expected %d checkouts copied, got %d", len(records), copied)
+		}
+		return nil
+	})
 }
 
 // UpdatePurchase обновляет время покупки по коду
 func (r *CheckoutRepository) UpdatePurchase(ctx context.Context, code uuid.UUID, purchaseTime time.Time) error {
 	_, err := r.updatePurchaseStmt.ExecContext(ctx, purchaseTime, code)
-	return err
+	return classify(err)
+}
+
+// UpdateExpiresAt переносит срок резерва после продления, тем же выражением, что и UpdatePurchase
+func (r *CheckoutRepository) UpdateExpiresAt(ctx context.Context, code uuid.UUID, expiresAt time.Time) error {
+	_, err := r.updatePurchaseStmt.ExecContext(ctx, expiresAt, code)
+	return classify(err)
 }
 
 func generateMultiRowQuery(count int) string {
 	var sb strings.Builder
-	sb.WriteString(`INSERT INTO checkouts (user_id, item_id, code, created_at, expires_at) VALUES `)
+	sb.WriteString(`INSERT INTO checkouts (sale_id, user_id, item_id, code, created_at, expires_at) VALUES `)
 
 	placeholders := make([]string, count)
 	for i := 0; i < count; i++ {
-		placeholders[i] = fmt.Sprintf("($%d,$%d,$%d,$%d,$%d)",
-			i*5+1, i*5+2, i*5+3, i*5+4, i*5+5)
+		placeholders[i] = fmt.Sprintf("($%d,$%d,$%d,$%d,$%d,$%d)",
+			i*6+1, i*6+2, i*6+3, i*6+4, i*6+5, i*6+6)
 	}
 
 	sb.WriteString(strings.Join(placeholders, ","))
+	sb.WriteString(` ON CONFLICT (code) DO NOTHING RETURNING code`)
 	return sb.String()
 }
 
 // CheckoutRecord представляет запись о checkout
 type CheckoutRecord struct {
 	ID        int64     `json:"id" db:"id"`
+	SaleID    int64     `json:"sale_id" db:"sale_id"`
 	UserID    int64     `json:"user_id" db:"user_id"`
 	ItemID    int64     `json:"item_id" db:"item_id"`
 	Code      uuid.UUID `json:"code" db:"code"`
@@ -187,19 +306,33 @@ type pendingRecord struct {
 	result chan error
 }
 
+// DefaultCopyThreshold размер пачки, начиная с которого BatchInserter переходит на COPY.
+// На маленьких пачках многострочный VALUES быстрее: кешированный запрос уходит одним
+// обращением, а COPY тратит лишние обращения на захват соединения и начало потока.
+// Точка пересечения зависит от железа и сети, ее показывает BenchmarkCheckoutInsert.
+// 500 - оценка, замер пересечения еще не записан: после прогона бенчмарка на целевой БД
+// укажите здесь первый размер, где CopyInsert дает больше rows/s, вместе с железом и сетью
+const DefaultCopyThreshold = 500
+
+// flushTimeout ограничивает одну пакетную вставку, в том числе финальную при закрытии
+const flushTimeout = 5 * time.Second
+
 // BatchInserter накапливает записи и выполняет пакетную вставку
 // Исправленная версия без дедлоков
 type BatchInserter struct {
-	repo      *CheckoutRepository
-	batchSize int
-	timeout   time.Duration
-	buffer    []pendingRecord
-	timer     *time.Timer
-	mu        sync.Mutex
-	ctx       context.Context
-	cancel    context.CancelFunc
-	done      chan struct{}
-	flushCh   chan struct{} // Канал для принудительного флеша
+	repo          *CheckoutRepository
+	batchSize     int
+	copyThreshold int // Пачки больше порога вставляются через COPY
+	timeout       time.Duration
+	buffer        []pendingRecord
+	timer         *time.Timer
+	mu            sync.Mutex
+	ctx           context.Context
+	cancel        context.CancelFunc
+	done          chan struct{}
+	flushCh       chan struct{}   // Канал для принудительного флеша
+	syncFlushCh   chan chan error // Канал синхронного флеша, воркер отвечает результатом вставки
+	abandoned     sync.WaitGroup  // Удаления строк, вставленных после ухода ожидающего
 }
 
 // NewBatchInserter создает новый батчер
@@ -207,14 +340,16 @@ func NewBatchInserter(repo *CheckoutRepository, batchSize int, timeout time.Dura
 	ctx, cancel := context.WithCancel(context.Background())
 
 	bi := &BatchInserter{
-		repo:      repo,
-		batchSize: batchSize,
-		timeout:   timeout,
-		buffer:    make([]pendingRecord, 0, batchSize),
-		ctx:       ctx,
-		cancel:    cancel,
-		done:      make(chan struct{}),
-		flushCh:   make(chan struct{}, 1), // Буферизованный канал
+		repo:          repo,
+		batchSize:     batchSize,
+		copyThreshold: DefaultCopyThreshold,
+		timeout:       timeout,
+		buffer:        make([]pendingRecord, 0, batchSize),
+		ctx:           ctx,
+		cancel:        cancel,
+		done:          make(chan struct{}),
+		flushCh:       make(chan struct{}, 1), // Буферизованный канал
+		syncFlushCh:   make(chan chan error),
 	}
 
 	// Запускаем воркер для обработки флешей
@@ -223,6 +358,35 @@ func NewBatchInserter(repo *CheckoutRepository, batchSize int, timeout time.Dura
 	return bi
 }
 
+// SetCopyThreshold задает размер пачки, начиная с которого используется COPY.
+// Вызывать до первого Add
+func (bi *BatchInserter) SetCopyThreshold(threshold int) {
+	bi.mu.Lock()
+	bi.copyThreshold = threshold
+	bi.mu.Unlock()
+}
+
+// insert вставляет пачку через COPY, если она больше порога, иначе многострочным VALUES.
+// COPY не умеет пропускать строки: если в пачке занятый код, она целиком повторяется через BatchInsert
+func (bi *BatchInserter) insert(ctx context.Context, records []CheckoutRecord) (results []error, err error) {
+	bi.mu.Lock()
+	threshold := bi.copyThreshold
+	bi.mu.Unlock()
+
+	if len(records) <= threshold {
+		return bi.repo.MultiRowInsert(ctx, records)
+	}
+
+	err = bi.repo.CopyInsert(ctx, records)
+	if isUniqueViolation(err) {
+		return bi.repo.BatchInsert(ctx, records)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return make([]error, len(records)), nil
+}
+
 // worker обрабатывает флеши в отдельной горутине
 func (bi *BatchInserter) worker() {
 	defer close(bi.done)
@@ -231,6 +395,8 @@ func (bi *BatchInserter) worker() {
 		select {
 		case <-bi.flushCh:
 			bi.performFlush()
+		case done := <-bi.syncFlushCh:
+			done <- bi.performFlush()
 		case <-bi.ctx.Done():
 			// Финальный флеш перед завершением
 			bi.performFlush()
@@ -241,6 +407,12 @@ func (bi *BatchInserter) worker() {
 
 // Add добавляет запись в буфер и ждет результата вставки
 func (bi *BatchInserter) Add(record CheckoutRecord) error {
+	return bi.AddContext(context.Background(), record)
+}
+
+// AddContext добавляет запись в буфер и ждет результата вставки не дольше ctx.
+// Если ctx истек, запись не вставляется: вызывающий уже считает резерв неудавшимся
+func (bi *BatchInserter) AddContext(ctx context.Context, record CheckoutRecord) error {
 	// Создаем канал для получения результата
 	resultChan := make(chan error, 1)
 
@@ -287,13 +459,47 @@ func (bi *BatchInserter) Add(record CheckoutRecord) error {
 	case err := <-resultChan:
 		// fmt.Println("qwe", err)
 		return err
+	case <-ctx.Done():
+		bi.abandon(record.Code, resultChan)
+		return ctx.Err()
 	case <-bi.ctx.Done():
+		bi.abandon(record.Code, resultChan)
 		return bi.ctx.Err()
 	}
 
 	// return <-resultChan
 }
 
+// abandon убирает запись ушедшего ожидающего из буфера. Если пачка с ней уже вставляется,
+// дожидается результата в фоне и удаляет вставленную строку: иначе в БД остался бы резерв,
+// который кеш уже освободил, и восстановление после рестарта вернуло бы его
+func (bi *BatchInserter) abandon(code uuid.UUID, result chan error) {
+	bi.mu.Lock()
+	for i, pr := range bi.buffer {
+		if pr.result == result {
+			bi.buffer = append(bi.buffer[:i], bi.buffer[i+1:]...)
+			bi.mu.Unlock()
+			return
+		}
+	}
+	bi.abandoned.Add(1)
+	bi.mu.Unlock()
+
+	go func() {
+		defer bi.abandoned.Done()
+
+		if err := <-result; err != nil {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+		defer cancel()
+		if err := bi.repo.DeleteReservation(ctx, code); err != nil {
+			log.Printf("❌ Failed to delete abandoned checkout %s: %v", code, err)
+		}
+	}()
+}
+
 // stopTimer безопасно останавливает таймер
 func (bi *BatchInserter) stopTimer() {
 	bi.mu.Lock()
@@ -304,13 +510,13 @@ func (bi *BatchInserter) stopTimer() {
 	bi.mu.Unlock()
 }
 
-// performFlush выполняет фактический флеш
-func (bi *BatchInserter) performFlush() {
+// performFlush выполняет фактический флеш и возвращает результат вставки
+func (bi *BatchInserter) performFlush() error {
 	bi.mu.Lock()
 
 	if len(bi.buffer) == 0 {
 		bi.mu.Unlock()
-		return
+		return nil
 	}
 
 	// Останавливаем таймер
@@ -335,45 +541,44 @@ func (bi *BatchInserter) performFlush() {
 	}
 
 	// Выполняем вставку
-	err := bi.repo.MultiRowInsert(bi.ctx, records)
-	// fmt.Println("err := bi.repo.MultiRowInsert(bi.ctx, records)", err)
-
-	// Отправляем результат всем ожидающим
-	for _, pr := range pendingRecords {
-		select {
-		case pr.result <- err:
-		case <-bi.ctx.Done():
-			return
+	// Не bi.ctx: Close отменяет его до финального флеша, и вставка записей,
+	// оставшихся в буфере к закрытию, сразу бы упала
+	ctx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+	results, err := bi.insert(ctx, records)
+	cancel()
+
+	// Отправляем каждому ожидающему его результат, при ошибке пачки - ее. Каналы буферизованы
+	// на один результат, поэтому отправка не блокируется, даже если ожидающий уже ушел
+	for i, pr := range pendingRecords {
+		if err != nil {
+			pr.result <- err
+		} else {
+			pr.result <- results[i]
 		}
 	}
+	return err
 }
 
-// Flush принудительно выполняет вставку всех накопленных записей
+// Flush принудительно выполняет вставку всех накопленных записей и ждет ее завершения
 func (bi *BatchInserter) Flush() error {
-	// Отправляем сигнал флеша
-	select {
-	case bi.flushCh <- struct{}{}:
-	case <-bi.ctx.Done():
-		return bi.ctx.Err()
-	}
-
-	// Ждем небольшой период для завершения флеша
-	// В реальном коде можно добавить синхронизацию через дополнительный канал
-	time.Sleep(10 * time.Millisecond)
-
-	return nil
+	return bi.FlushAndWait()
 }
 
-// FlushAndWait выполняет флеш и ждет его завершения
+// FlushAndWait выполняет флеш и ждет его завершения.
+// Воркер сам выполняет флеш и отвечает в канал этого вызова, поэтому в таблицу
+// ничего лишнего не пишется. Возвращает ошибку вставки пачки или nil, если буфер был пуст;
+// пропущенные занятые коды ошибкой пачки не считаются, их получают только их Add
 func (bi *BatchInserter) FlushAndWait() error {
-	// Создаем фиктивную запись для синхронизации
-	dummyRecord := CheckoutRecord{
-		UserID:    -1, // Специальный маркер
-		CreatedAt: time.Now(),
+	done := make(chan error, 1)
+
+	select {
+	case bi.syncFlushCh <- done:
+	case <-bi.ctx.Done():
+		return bi.ctx.Err()
 	}
 
-	// Добавляем фиктивную запись, которая вызовет флеш
-	return bi.Add(dummyRecord)
+	// Воркер принял запрос и обязательно ответит
+	return <-done
 }
 
 // Close завершает работу батчера
@@ -384,8 +589,9 @@ func (bi *BatchInserter) Close() error {
 	// Отменяем контекст для завершения воркера
 	bi.cancel()
 
-	// Ждем завершения воркера
+	// Ждем завершения воркера и удалений брошенных записей
 	<-bi.done
+	bi.abandoned.Wait()
 
 	return nil
 }
@@ -398,17 +604,17 @@ func (bi *BatchInserter) Stats() (buffered int, isActive bool) {
 	return len(bi.buffer), bi.timer != nil
 }
 
-// GetActiveReservations возвращает все активные резервации для восстановления кеша
-func (r *CheckoutRepository) GetActiveReservations(ctx context.Context) ([]CheckoutRecord, error) {
+// GetActiveReservations возвращает активные резервации распродажи для восстановления кеша
+func (r *CheckoutRepository) GetActiveReservations(ctx context.Context, saleID int64) ([]CheckoutRecord, error) {
 	query := `
-		SELECT id, user_id, item_id, code, created_at, expires_at
+		SELECT id, sale_id, user_id, item_id, code, created_at, expires_at
 		FROM checkouts 
-		WHERE expires_at > NOW()
+		WHERE sale_id = $1 AND expires_at > NOW()
 		ORDER BY created_at`
 
-	rows, err := r.db.QueryContext(ctx, query)
+	rows, err := r.db.QueryContext(ctx, query, saleID)
 	if err != nil {
-		return nil, fmt.Errorf("query active reservations: %w", err)
+		return nil, fmt.Errorf("query active reservations: %w", classify(err))
 	}
 	defer rows.Close()
 
@@ -417,6 +623,7 @@ func (r *CheckoutRepository) GetActiveReservations(ctx context.Context) ([]Check
 		var reservation CheckoutRecord
 		err := rows.Scan(
 			&reservation.ID,
+			&reservation.SaleID,
 			&reservation.UserID,
 			&reservation.ItemID,
 			&reservation.Code,
@@ -436,45 +643,48 @@ func (r *CheckoutRepository) GetActiveReservations(ctx context.Context) ([]Check
 	return reservations, nil
 }
 
-// // CleanupExpiredReservations удаляет истекшие резервации из БД
-// func (r *CheckoutRepository) CleanupExpiredReservations(ctx context.Context) (int64, error) {
-// 	query := `DELETE FROM checkouts WHERE expires_at <= NOW()`
+// CleanupExpiredReservations удаляет истекшие резервации распродажи из БД, возвращает число удаленных строк.
+// Строки прошлых распродаж не трогаются: по ним isStaleSaleCode распознает их коды.
+// Условие покрывается индексом idx_checkouts_sale_expires
+func (r *CheckoutRepository) CleanupExpiredReservations(ctx context.Context, saleID int64) (int64, error) {
+	query := `DELETE FROM checkouts WHERE sale_id = $1 AND expires_at <= NOW()`
 
-// 	result, err := r.db.ExecContext(ctx, query)
-// 	if err != nil {
-// 		return 0, fmt.Errorf("cleanup expired reservations: %w", err)
-// 	}
+	result, err := r.db.ExecContext(ctx, query, saleID)
+	if err != nil {
+		return 0, fmt.Errorf("cleanup expired reservations: %w", classify(err))
+	}
 
-// 	affected, err := result.RowsAffected()
-// 	if err != nil {
-// 		return 0, fmt.Errorf("get rows affected: %w", err)
-// 	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("get rows affected: %w", err)
+	}
 
-// 	return affected, nil
-// }
+	return affected, nil
+}
 
-// // DeleteReservation удаляет конкретную резервацию
-// func (r *CheckoutRepository) DeleteReservation(ctx context.Context, code uuid.UUID) error {
-// 	query := `DELETE FROM checkouts WHERE code = $1`
+// DeleteReservation удаляет конкретную резервацию, отсутствие строки не ошибка
+func (r *CheckoutRepository) DeleteReservation(ctx context.Context, code uuid.UUID) error {
+	query := `DELETE FROM checkouts WHERE code = $1`
 
-// 	_, err := r.db.ExecContext(ctx, query, code)
-// 	if err != nil {
-// 		return fmt.Errorf("delete reservation: %w", err)
-// 	}
+	_, err := r.db.ExecContext(ctx, query, code)
+	if err != nil {
+		return fmt.Errorf("delete reservation: %w", classify(err))
+	}
 
-// 	return nil
-// }
+	return nil
+}
 
 // GetReservationByCode получает резервацию по коду
 func (r *CheckoutRepository) GetReservationByCode(ctx context.Context, code uuid.UUID) (*CheckoutRecord, error) {
 	query := `
-		SELECT id, user_id, item_id, code, created_at, expires_at
+		SELECT id, sale_id, user_id, item_id, code, created_at, expires_at
 		FROM checkouts 
 		WHERE code = $1`
 
 	var reservation CheckoutRecord
 	err := r.db.QueryRowContext(ctx, query, code).Scan(
 		&reservation.ID,
+		&reservation.SaleID,
 		&reservation.UserID,
 		&reservation.ItemID,
 		&reservation.Code,
@@ -483,10 +693,10 @@ func (r *CheckoutRepository) GetReservationByCode(ctx context.Context, code uuid
 	)
 
 	if err != nil {
-		if err == sql.ErrNoRows {
+		if isNotFoundError(err) {
 			return nil, nil // Резервация не найдена
 		}
-		return nil, fmt.Errorf("get reservation by code: %w", err)
+		return nil, fmt.Errorf("get reservation by code: %w", classify(err))
 	}
 
 	return &reservation, nil
@@ -512,7 +722,7 @@ func (r *CheckoutRepository) BatchDeleteReservations(ctx context.Context, codes
 
 	_, err := r.db.ExecContext(ctx, query, args...)
 	if err != nil {
-		return fmt.Errorf("batch delete reservations: %w", err)
+		return fmt.Errorf("batch delete reservations: %w", classify(err))
 	}
 
 	return nil
@@ -0,0 +1,414 @@
+package db
+
+import (
+	"contest_notcoin/leaktest"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestCheckoutRecords builds count records of one sale
+func newTestCheckoutRecords(saleID int64, count int) []CheckoutRecord {
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	records := make([]CheckoutRecord, count)
+	for i := range records {
+		records[i] = CheckoutRecord{
+			SaleID:    saleID,
+			UserID:    int64(i + 1),
+			ItemID:    int64(i),
+			Code:      uuid.New(),
+			CreatedAt: now,
+			ExpiresAt: now.Add(time.Minute),
+		}
+	}
+	return records
+}
+
+// cleanupTestSale removes checkouts of a test sale
+func cleanupTestSale(tb testing.TB, s *Server, saleID int64) {
+	tb.Cleanup(func() {
+		s.ExecContext(context.Background(), "DELETE FROM checkouts WHERE sale_id = $1", saleID)
+	})
+}
+
+// TestCopyInsert tests that COPY inserts rows readable like any other checkout
+func TestCopyInsert(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+
+	repo, err := NewCheckoutRepository(s)
+	require.NoError(t, err)
+	defer repo.Close()
+
+	saleID := time.Now().UnixNano() % 1_000_000_000
+	cleanupTestSale(t, s, saleID)
+
+	assert.NoError(t, repo.CopyInsert(ctx, nil))
+
+	records := newTestCheckoutRecords(saleID, 3)
+	require.NoError(t, repo.CopyInsert(ctx, records))
+
+	for _, record := range records {
+		stored, err := repo.GetReservationByCode(ctx, record.Code)
+		require.NoError(t, err)
+		require.NotNil(t, stored)
+		assert.Equal(t, record.SaleID, stored.SaleID)
+		assert.Equal(t, record.UserID, stored.UserID)
+		assert.Equal(t, record.ItemID, stored.ItemID)
+		assert.True(t, record.ExpiresAt.Equal(stored.ExpiresAt))
+	}
+
+	// Duplicate codes fail the whole copy
+	assert.Error(t, repo.CopyInsert(ctx, records[:1]))
+}
+
+// TestBatchInserterCloseFlushesBuffer tests that records left buffered after the client gave up are inserted on Close
+func TestBatchInserterCloseFlushesBuffer(t *testing.T) {
+	s := newTestServer(t)
+
+	repo, err := NewCheckoutRepository(s)
+	require.NoError(t, err)
+	defer repo.Close()
+
+	saleID := time.Now().UnixNano() % 1_000_000_000
+	cleanupTestSale(t, s, saleID)
+
+	// The worker must be gone once Close returns
+	defer leaktest.Check(t)()
+
+	// Neither the batch size nor the timer would flush this record before Close
+	inserter := NewBatchInserter(repo, 100, time.Hour)
+	record := newTestCheckoutRecords(saleID, 1)[0]
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, inserter.AddContext(ctx, record), context.Canceled)
+
+	require.NoError(t, inserter.Close())
+
+	stored, err := repo.GetReservationByCode(context.Background(), record.Code)
+	require.NoError(t, err)
+	require.NotNil(t, stored, "buffered record must be inserted by the final flush")
+	assert.Equal(t, record.ItemID, stored.ItemID)
+}
+
+// TestBatchInserterCopyThreshold tests that batches on both sides of the threshold are inserted
+func TestBatchInserterCopyThreshold(t *testing.T) {
+	s := newTestServer(t)
+
+	repo, err := NewCheckoutRepository(s)
+	require.NoError(t, err)
+	defer repo.Close()
+
+	saleID := time.Now().UnixNano() % 1_000_000_000
+	cleanupTestSale(t, s, saleID)
+
+	inserter := NewBatchInserter(repo, 100, time.Hour)
+	defer inserter.Close()
+	inserter.SetCopyThreshold(3)
+
+	ctx := context.Background()
+	for _, size := range []int{2, 5} {
+		records := newTestCheckoutRecords(saleID, size)
+		results, err := inserter.insert(ctx, records)
+		require.NoError(t, err, "batch of %d", size)
+		assert.Equal(t, make([]error, size), results, "batch of %d", size)
+
+		for _, record := range records {
+			stored, err := repo.GetReservationByCode(ctx, record.Code)
+			require.NoError(t, err)
+			assert.NotNil(t, stored, "batch of %d", size)
+		}
+	}
+}
+
+// TestInsertDuplicateCode tests that only the record with a taken code fails and the rest of the batch is inserted
+func TestInsertDuplicateCode(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+
+	repo, err := NewCheckoutRepository(s)
+	require.NoError(t, err)
+	defer repo.Close()
+
+	saleID := time.Now().UnixNano() % 1_000_000_000
+	cleanupTestSale(t, s, saleID)
+
+	existing := newTestCheckoutRecords(saleID, 1)[0]
+	require.NoError(t, repo.CopyInsert(ctx, []CheckoutRecord{existing}))
+
+	// A taken code, a fresh record and a repeat of the fresh code in the same batch
+	duplicateBatch := func() []CheckoutRecord {
+		records := newTestCheckoutRecords(saleID, 3)
+		records[0].Code = existing.Code
+		records[0].UserID = existing.UserID + 100
+		records[2].Code = records[1].Code
+		return records
+	}
+
+	inserter := NewBatchInserter(repo, 100, time.Hour)
+	defer inserter.Close()
+	inserter.SetCopyThreshold(2)
+
+	methods := []struct {
+		name   string
+		insert func(context.Context, []CheckoutRecord) ([]error, error)
+	}{
+		{"MultiRowInsert", repo.MultiRowInsert},
+		{"BatchInsert", repo.BatchInsert},
+		{"CopyFallback", inserter.insert},
+	}
+
+	for _, method := range methods {
+		records := duplicateBatch()
+		results, err := method.insert(ctx, records)
+		require.NoError(t, err, method.name)
+		require.Len(t, results, len(records), method.name)
+
+		assert.ErrorIs(t, results[0], ErrDuplicateCode, method.name)
+		assert.ErrorIs(t, results[0], ErrConstraint, method.name)
+		assert.NoError(t, results[1], method.name)
+		assert.ErrorIs(t, results[2], ErrDuplicateCode, method.name)
+
+		stored, err := repo.GetReservationByCode(ctx, records[1].Code)
+		require.NoError(t, err)
+		require.NotNil(t, stored, method.name)
+		assert.Equal(t, records[1].UserID, stored.UserID, "the first record with the code wins")
+
+		stored, err = repo.GetReservationByCode(ctx, existing.Code)
+		require.NoError(t, err)
+		assert.Equal(t, existing.UserID, stored.UserID, "the existing row must be kept")
+	}
+
+	// Through Add only the colliding caller gets the error
+	records := newTestCheckoutRecords(saleID, 2)
+	records[0].Code = existing.Code
+	inserter.SetCopyThreshold(DefaultCopyThreshold)
+
+	errs := make(chan error, len(records))
+	for _, record := range records {
+		go func(record CheckoutRecord) { errs <- inserter.Add(record) }(record)
+	}
+	require.Eventually(t, func() bool {
+		buffered, _ := inserter.Stats()
+		return buffered == len(records)
+	}, time.Second, time.Millisecond)
+	require.NoError(t, inserter.FlushAndWait())
+
+	var duplicates int
+	for range records {
+		if err := <-errs; err != nil {
+			assert.ErrorIs(t, err, ErrDuplicateCode)
+			duplicates++
+		}
+	}
+	assert.Equal(t, 1, duplicates)
+}
+
+// TestBatchInserterFlushAndWait tests that FlushAndWait inserts buffered records and writes no marker rows
+func TestBatchInserterFlushAndWait(t *testing.T) {
+	s := newTestServer(t)
+
+	repo, err := NewCheckoutRepository(s)
+	require.NoError(t, err)
+	defer repo.Close()
+
+	saleID := time.Now().UnixNano() % 1_000_000_000
+	cleanupTestSale(t, s, saleID)
+
+	inserter := NewBatchInserter(repo, 100, time.Hour)
+	defer inserter.Close()
+
+	// Nothing buffered yet
+	require.NoError(t, inserter.FlushAndWait())
+
+	// Waiting callers keep their records in the buffer until the flush
+	records := newTestCheckoutRecords(saleID, 3)
+	errs := make(chan error, len(records))
+	for _, record := range records {
+		go func(record CheckoutRecord) { errs <- inserter.Add(record) }(record)
+	}
+	require.Eventually(t, func() bool {
+		buffered, _ := inserter.Stats()
+		return buffered == len(records)
+	}, time.Second, time.Millisecond)
+
+	require.NoError(t, inserter.FlushAndWait())
+	buffered, _ := inserter.Stats()
+	assert.Zero(t, buffered)
+
+	for range records {
+		assert.NoError(t, <-errs)
+	}
+	for _, record := range records {
+		stored, err := repo.GetReservationByCode(context.Background(), record.Code)
+		require.NoError(t, err)
+		assert.NotNil(t, stored, "flushed record must be stored once FlushAndWait returns")
+	}
+
+	var markers int
+	require.NoError(t, s.DB().QueryRowContext(context.Background(),
+		`SELECT COUNT(*) FROM checkouts WHERE user_id = -1`).Scan(&markers))
+	assert.Zero(t, markers)
+}
+
+// TestBatchInserterDropsAbandonedRecords tests that a caller whose context expired leaves no row behind
+func TestBatchInserterDropsAbandonedRecords(t *testing.T) {
+	s := newTestServer(t)
+
+	repo, err := NewCheckoutRepository(s)
+	require.NoError(t, err)
+	defer repo.Close()
+
+	saleID := time.Now().UnixNano() % 1_000_000_000
+	cleanupTestSale(t, s, saleID)
+
+	inserter := NewBatchInserter(repo, 100, time.Hour)
+	defer inserter.Close()
+
+	records := newTestCheckoutRecords(saleID, 2)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, inserter.AddContext(ctx, records[0]), context.Canceled)
+
+	buffered, _ := inserter.Stats()
+	assert.Zero(t, buffered, "the abandoned record must leave the buffer")
+
+	// A later flush writes only the records still awaited
+	done := make(chan error, 1)
+	go func() { done <- inserter.Add(records[1]) }()
+	require.Eventually(t, func() bool {
+		buffered, _ := inserter.Stats()
+		return buffered == 1
+	}, time.Second, time.Millisecond)
+	require.NoError(t, inserter.FlushAndWait())
+	require.NoError(t, <-done)
+
+	stored, err := repo.GetReservationByCode(context.Background(), records[0].Code)
+	require.NoError(t, err)
+	assert.Nil(t, stored, "the abandoned record must not be inserted")
+
+	stored, err = repo.GetReservationByCode(context.Background(), records[1].Code)
+	require.NoError(t, err)
+	assert.NotNil(t, stored)
+}
+
+// TestDeleteReservation tests that a deleted reservation is no longer found and repeated deletes succeed
+func TestDeleteReservation(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+
+	repo, err := NewCheckoutRepository(s)
+	require.NoError(t, err)
+	defer repo.Close()
+
+	saleID := time.Now().UnixNano() % 1_000_000_000
+	cleanupTestSale(t, s, saleID)
+
+	record := newTestCheckoutRecords(saleID, 1)[0]
+	require.NoError(t, repo.CopyInsert(ctx, []CheckoutRecord{record}))
+
+	require.NoError(t, repo.DeleteReservation(ctx, record.Code))
+	stored, err := repo.GetReservationByCode(ctx, record.Code)
+	require.NoError(t, err)
+	assert.Nil(t, stored)
+
+	assert.NoError(t, repo.DeleteReservation(ctx, record.Code))
+}
+
+// BenchmarkCheckoutInsert compares multi-row VALUES with COPY for growing batch sizes
+func BenchmarkCheckoutInsert(b *testing.B) {
+	s := newTestServer(b)
+	ctx := context.Background()
+
+	repo, err := NewCheckoutRepository(s)
+	require.NoError(b, err)
+	defer repo.Close()
+
+	methods := []struct {
+		name   string
+		insert func(context.Context, []CheckoutRecord) error
+	}{
+		{"MultiRowInsert", func(ctx context.Context, records []CheckoutRecord) error {
+			_, err := repo.MultiRowInsert(ctx, records)
+			return err
+		}},
+		{"CopyInsert", repo.CopyInsert},
+	}
+
+	// Sizes bracket DefaultCopyThreshold so one run shows the crossover, 10000 is close to the VALUES ceiling of 65535/6 rows
+	for _, size := range []int{10, 100, 250, 500, 750, 1000, 10000} {
+		for _, method := range methods {
+			b.Run(fmt.Sprintf("%s/%d", method.name, size), func(b *testing.B) {
+				saleID := time.Now().UnixNano() % 1_000_000_000
+				cleanupTestSale(b, s, saleID)
+
+				for i := 0; i < b.N; i++ {
+					b.StopTimer()
+					records := newTestCheckoutRecords(saleID, size)
+					b.StartTimer()
+
+					if err := method.insert(ctx, records); err != nil {
+						b.Fatal(err)
+					}
+				}
+				b.ReportMetric(float64(size*b.N)/b.Elapsed().Seconds(), "rows/s")
+			})
+		}
+	}
+}
+
+// TestCleanupExpiredReservations tests that expired rows of the sale are deleted, while fresh ones and other sales' rows are kept
+func TestCleanupExpiredReservations(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+
+	repo, err := NewCheckoutRepository(s)
+	require.NoError(t, err)
+	defer repo.Close()
+
+	saleID := time.Now().UnixNano() % 1_000_000_000
+	cleanupTestSale(t, s, saleID)
+
+	endedSaleID := saleID + 1
+	cleanupTestSale(t, s, endedSaleID)
+
+	records := newTestCheckoutRecords(saleID, 4)
+	for i := range records[:2] {
+		records[i].ExpiresAt = records[i].CreatedAt.Add(-time.Minute)
+	}
+	require.NoError(t, repo.CopyInsert(ctx, records))
+
+	// The ended sale's rows are all expired but must survive for stale-code detection
+	previous := newTestCheckoutRecords(endedSaleID, 1)
+	previous[0].ExpiresAt = previous[0].CreatedAt.Add(-time.Minute)
+	require.NoError(t, repo.CopyInsert(ctx, previous))
+
+	cleaned, err := repo.CleanupExpiredReservations(ctx, saleID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), cleaned)
+
+	stored, err := repo.GetReservationByCode(ctx, previous[0].Code)
+	require.NoError(t, err)
+	assert.NotNil(t, stored, "previous sale's row must be kept")
+
+	for i, record := range records {
+		stored, err := repo.GetReservationByCode(ctx, record.Code)
+		require.NoError(t, err)
+		if i < 2 {
+			assert.Nil(t, stored, "expired row %d must be deleted", i)
+		} else {
+			assert.NotNil(t, stored, "fresh row %d must be kept", i)
+		}
+	}
+
+	// Fresh rows are still recovered
+	active, err := repo.GetActiveReservations(ctx, saleID)
+	require.NoError(t, err)
+	assert.Len(t, active, 2)
+}
@@ -5,6 +5,7 @@ package db
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"strings"
@@ -14,7 +15,11 @@ import (
 	_ "github.com/jackc/pgx/v5/stdlib"
 )
 
-var server *Server
+// server глобальный экземпляр для устаревших InitGlobalServer/GetGlobalServer
+var (
+	server   *Server
+	serverMu sync.Mutex
+)
 
 // Config конфигурация подключения к БД
 type Config struct {
@@ -31,19 +36,43 @@ type Config struct {
 	ConnMaxLifetime time.Duration // Максимальное время жизни соединения
 	ConnMaxIdleTime time.Duration // Максимальное время простоя соединения
 
+	// Поддержание минимума простаивающих соединений
+	MinIdleConns     int           // Сколько соединений держать теплыми (не больше MaxIdleConns, 0 - выключено)
+	IdleKeepInterval time.Duration // Как часто проверять минимум
+
+	// Прогрев подготовленных выражений
+	StatementWarmupConns int // Сколько соединений пула прогреть (не больше MaxIdleConns, 0 - выключено)
+
+	// Кеш сгенерированных запросов на пачку
+	QueryCacheSize int // Сколько разных размеров пачек держать в кеше каждого репозитория
+
+	// Реплика для запросов только на чтение
+	ReplicaDSN      string // Строка подключения к реплике (пусто - все запросы идут в основную БД)
+	DisableReplicas bool   // Не подключаться к реплике, даже если ReplicaDSN задан
+
 	// Настройки переподключения
 	RetryAttempts       int
 	RetryDelay          time.Duration
 	HealthCheckInterval time.Duration
 
+	// Настройки истории пула соединений
+	PoolSampleInterval  time.Duration // Интервал снимков пула (0 - выключено)
+	PoolSampleRetention int           // Сколько снимков хранить
+
 	// Настройки схемы
 	AutoCreateSchema bool // Автоматически создавать схему при подключении
+
+	// Настройки распродажи
+	LotsCount        int           // Количество лотов в распродаже
+	ItemNameTemplate string        // Шаблон названия лота, {item} и {sale} заменяются на ID
+	ImageURLTemplate string        // Шаблон URL картинки, {item} и {sale} заменяются на ID
+	SaleInterval     time.Duration // Длительность распродажи, новая создается в начале каждого интервала
 }
 
 // DefaultConfig возвращает конфигурацию по умолчанию для высокого RPS
 func DefaultConfig() *Config {
 	return &Config{
-		Host:     "postgres", // localhost postgres
+		Host:     "localhost", // в docker-compose задается DB_HOST=postgres
 		Port:     5432,
 		User:     "postgres",
 		Password: "password123",
@@ -56,32 +85,54 @@ func DefaultConfig() *Config {
 		ConnMaxLifetime: 30 * time.Minute, // Обновляем соединения каждые 30 минут
 		ConnMaxIdleTime: 5 * time.Minute,  // Закрываем простаивающие через 5 минут
 
+		// Минимум простаивающих соединений выключен, пул ведет себя как раньше
+		MinIdleConns:     0,
+		IdleKeepInterval: 10 * time.Second,
+
+		// Прогреваем все соединения, которые пул держит открытыми
+		StatementWarmupConns: 50,
+
+		// Покрывает все размеры пачек BatchInserter (1..100), но кеш все равно ограничен
+		QueryCacheSize: 128,
+
 		// Переподключение
 		RetryAttempts:       5,
 		RetryDelay:          time.Second,
 		HealthCheckInterval: 10 * time.Second,
 
+		// История пула: 5 минут при интервале в 1 секунду
+		PoolSampleInterval:  time.Second,
+		PoolSampleRetention: 300,
+
 		// Схема
 		AutoCreateSchema: true, // По умолчанию создаем схему автоматически
+
+		// Распродажа
+		LotsCount:        10000,
+		ItemNameTemplate: "Flash Item #{item} (Sale {sale})",
+		ImageURLTemplate: "https://picsum.photos/200/200?random={sale}_{item}",
+		SaleInterval:     time.Hour,
 	}
 }
 
 // Server представляет сервер базы данных с пулом соединений
 type Server struct {
-	db     *sql.DB
-	config *Config
-	mu     sync.RWMutex
-	ctx    context.Context
-	cancel context.CancelFunc
+	db      *sql.DB
+	replica *sql.DB // Пул реплики для чтения, nil - читаем из основной БД
+	config  *Config
+	mu      sync.RWMutex
+	ctx     context.Context
+	cancel  context.CancelFunc
 
 	// Метрики
 	connectionAttempts int64
 	connectionFailures int64
 	lastError          error
 	lastConnectTime    time.Time
-}
 
-var serverOnce sync.Once
+	// История насыщения пула
+	poolHistory *PoolStatsHistory
+}
 
 // Connect создает подключение к PostgreSQL с оптимизациями для высокого RPS
 func Connect(config *Config) (*Server, error) {
@@ -117,24 +168,55 @@ func Connect(config *Config) (*Server, error) {
 		}
 	}
 
+	// Реплика необязательна: без нее чтения идут в основную БД
+	if err := s.connectReplica(); err != nil {
+		log.Printf("⚠️  Replica unavailable, reads go to the primary: %v", err)
+	}
+
 	// Запускаем мониторинг здоровья соединения
 	go s.healthMonitor()
 
+	// Запускаем поддержание минимума простаивающих соединений
+	if s.config.MinIdleConns > 0 && s.config.IdleKeepInterval > 0 {
+		go s.idleKeeper()
+	}
+
+	// Запускаем сбор истории пула соединений
+	if s.config.PoolSampleInterval > 0 {
+		s.poolHistory = NewPoolStatsHistory(s.config.PoolSampleRetention)
+		go s.poolSampler()
+	}
+
 	return s, nil
 }
 
 // GetGlobalServer возвращает глобальный экземпляр сервера (singleton)
+//
+// Deprecated: создавайте сервер через Connect и передавайте его явно.
 func GetGlobalServer() *Server {
+	serverMu.Lock()
+	defer serverMu.Unlock()
 	return server
 }
 
-// InitGlobalServer инициализирует глобальный сервер
+// InitGlobalServer инициализирует глобальный сервер.
+// Неудачная попытка не запоминается, следующий вызов подключается заново.
+//
+// Deprecated: создавайте сервер через Connect и передавайте его явно.
 func InitGlobalServer(config *Config) error {
-	var err error
-	serverOnce.Do(func() {
-		server, err = Connect(config)
-	})
-	return err
+	serverMu.Lock()
+	defer serverMu.Unlock()
+
+	if server != nil {
+		return nil
+	}
+
+	s, err := Connect(config)
+	if err != nil {
+		return err
+	}
+	server = s
+	return nil
 }
 
 // connect выполняет подключение к базе данных
@@ -200,6 +282,39 @@ func (s *Server) connect() error {
 	return nil
 }
 
+// connectReplica открывает пул реплики, если она задана и не выключена
+func (s *Server) connectReplica() error {
+	if s.config.ReplicaDSN == "" || s.config.DisableReplicas {
+		return nil
+	}
+
+	replica, err := sql.Open("pgx", s.config.ReplicaDSN)
+	if err != nil {
+		return fmt.Errorf("failed to open replica: %w", err)
+	}
+
+	// Тот же размер пула, что и у основной БД
+	replica.SetMaxOpenConns(s.config.MaxOpenConns)
+	replica.SetMaxIdleConns(s.config.MaxIdleConns)
+	replica.SetConnMaxLifetime(s.config.ConnMaxLifetime)
+	replica.SetConnMaxIdleTime(s.config.ConnMaxIdleTime)
+
+	ctx, cancel := context.WithTimeout(s.ctx, 10*time.Second)
+	defer cancel()
+
+	if err := replica.PingContext(ctx); err != nil {
+		replica.Close()
+		return fmt.Errorf("failed to ping replica: %w", err)
+	}
+
+	s.mu.Lock()
+	s.replica = replica
+	s.mu.Unlock()
+
+	log.Println("📶 Connected to PostgreSQL read replica")
+	return nil
+}
+
 // createSchema создает все необходимые таблицы, индексы и функции
 func (s *Server) createSchema() error {
 	log.Println("📃 Creating database schema...")
@@ -237,7 +352,8 @@ func (s *Server) getSchemaSQLCommands() []string {
 		// Создание таблицы checkouts
 		`CREATE TABLE IF NOT EXISTS checkouts (
 			id BIGSERIAL PRIMARY KEY,
-			user_id INTEGER NOT NULL,
+			sale_id INTEGER NOT NULL DEFAULT 0,
+			user_id BIGINT NOT NULL,
 			item_id INTEGER NOT NULL,
 			code UUID UNIQUE NOT NULL,
 			created_at TIMESTAMP NOT NULL DEFAULT NOW(),
@@ -247,24 +363,47 @@ func (s *Server) getSchemaSQLCommands() []string {
 		// Индекс для таблицы checkouts
 		`CREATE INDEX IF NOT EXISTS idx_checkouts_expires_at ON checkouts(expires_at)`,
 
+		// Миграция: checkouts привязываются к распродаже (старые строки получают sale_id = 0)
+		`ALTER TABLE checkouts ADD COLUMN IF NOT EXISTS sale_id INTEGER NOT NULL DEFAULT 0`,
+
+		// Индекс для восстановления резервов текущей распродажи
+		`CREATE INDEX IF NOT EXISTS idx_checkouts_sale_expires ON checkouts(sale_id, expires_at)`,
+
 		// Создание таблицы sale_items
-		`CREATE TABLE sale_items (
+		`CREATE TABLE IF NOT EXISTS sale_items (
 			id BIGSERIAL PRIMARY KEY,
 			sale_id INTEGER NOT NULL,           		-- ID распродажи (например, hour of day)
 			sale_start_hour TIMESTAMP NOT NULL, 		-- Час начала распродажи
-			item_id INTEGER NOT NULL,           		-- ID лота от 0 до 9999 (10000 лотов)
+			item_id INTEGER NOT NULL,           		-- ID лота от 0 до LotsCount-1
 			item_name VARCHAR(255) NOT NULL,    		-- Название товара
 			image_url VARCHAR(500) NOT NULL,    		-- URL картинки
 			purchased BOOLEAN NOT NULL DEFAULT FALSE, 	-- Флаг, куплен ли лот
-			purchased_by INTEGER NULL,          		-- ID пользователя, кто купил
+			purchased_by BIGINT NULL,           		-- ID пользователя, кто купил
 			purchased_at TIMESTAMP NULL         		-- Время покупки
 		);`,
 
 		// Уникальный индекс для sale_items
 		`CREATE UNIQUE INDEX IF NOT EXISTS idx_sale_items_sale_item ON sale_items(sale_id, item_id)`,
 
+		// Индекс для истории покупок пользователя, непроданные лоты в него не попадают
+		`CREATE INDEX IF NOT EXISTS idx_sale_items_purchased_by ON sale_items(purchased_by, purchased_at DESC) WHERE purchased_by IS NOT NULL`,
+
+		// Миграция: user_id в Go int64, в INTEGER не помещаются ID больше 2^31-1.
+		// Для уже расширенных колонок это no-op без перезаписи таблицы
+		`ALTER TABLE checkouts ALTER COLUMN user_id TYPE BIGINT`,
+		`ALTER TABLE sale_items ALTER COLUMN purchased_by TYPE BIGINT`,
+
+		// Старые версии create_new_sale конфликтовали бы с новой по умолчанию
+		`DROP FUNCTION IF EXISTS create_new_sale()`,
+		`DROP FUNCTION IF EXISTS create_new_sale(INTEGER, TEXT, TEXT)`,
+
 		// Функция create_new_sale
-		`CREATE OR REPLACE FUNCTION create_new_sale() RETURNS INTEGER AS $$
+		`CREATE OR REPLACE FUNCTION create_new_sale(
+			items_count INTEGER DEFAULT 10000,
+			name_template TEXT DEFAULT 'Flash Item #{item} (Sale {sale})',
+			image_template TEXT DEFAULT 'https://picsum.photos/200/200?random={sale}_{item}',
+			sale_interval INTERVAL DEFAULT '1 hour'
+		) RETURNS INTEGER AS $$
 		DECLARE
 			max_sale_hour TIMESTAMP;
 			max_sale_id INTEGER;
@@ -273,8 +412,12 @@ func (s *Server) getSchemaSQLCommands() []string {
 			current_hour TIMESTAMP;
 			items_generated INTEGER;
 		BEGIN
-			-- Получаем текущий час (округленный)
-			current_hour := date_trunc('hour', NOW());
+			IF items_count IS NULL OR items_count <= 0 THEN
+				RAISE EXCEPTION 'items_count must be positive, got %', items_count;
+			END IF;
+
+			-- Получаем начало текущего интервала (при интервале в час - текущий час)
+			current_hour := date_bin(sale_interval, NOW(), TIMESTAMPTZ '2001-01-01 00:00:00+00');
 			
 			-- Находим максимальную запись в таблице
 			SELECT sale_start_hour, sale_id 
@@ -306,7 +449,7 @@ func (s *Server) getSchemaSQLCommands() []string {
 			ELSE
 				-- Последняя распродажа в будущем - создаем следующую по порядку
 				new_sale_id := max_sale_id + 1;
-				new_sale_hour := max_sale_hour + INTERVAL '1 hour';
+				new_sale_hour := max_sale_hour + sale_interval;
 				RAISE NOTICE 'Creating next sequential sale % for hour %', new_sale_id, new_sale_hour;
 			END IF;
 			
@@ -316,7 +459,7 @@ func (s *Server) getSchemaSQLCommands() []string {
 				RETURN new_sale_id;
 			END IF;
 			
-			-- Создаем 10,000 лотов для новой распродажи
+			-- Создаем items_count лотов для новой распродажи
 			INSERT INTO sale_items (
 				sale_id,
 				sale_start_hour,
@@ -331,22 +474,22 @@ func (s *Server) getSchemaSQLCommands() []string {
 				new_sale_id,
 				new_sale_hour,
 				item_counter,
-				'Flash Item #' || item_counter || ' (Sale ' || new_sale_id || ')',
-				'https://picsum.photos/200/200?random=' || new_sale_id || '_' || item_counter,
+				replace(replace(name_template, '{item}', item_counter::text), '{sale}', new_sale_id::text),
+				replace(replace(image_template, '{item}', item_counter::text), '{sale}', new_sale_id::text),
 				false,  
 				NULL,
 				NULL
-			FROM generate_series(0, 9999) AS item_counter;
+			FROM generate_series(0, items_count - 1) AS item_counter;
 			
 			-- Проверяем количество созданных записей
 			GET DIAGNOSTICS items_generated = ROW_COUNT;
 			
-			IF items_generated = 10000 THEN
+			IF items_generated = items_count THEN
 				RAISE NOTICE 'Successfully created sale % with % items for hour %', 
 					new_sale_id, items_generated, new_sale_hour;
 			ELSE
-				RAISE WARNING 'Expected 10000 items but created % for sale %', 
-					items_generated, new_sale_id;
+				RAISE WARNING 'Expected % items but created % for sale %', 
+					items_count, items_generated, new_sale_id;
 			END IF;
 			
 			RETURN new_sale_id;
@@ -360,29 +503,8 @@ func (s *Server) getSchemaSQLCommands() []string {
 	}
 }
 
-// isAlreadyExistsError проверяет, является ли ошибка связанной с уже существующим объектом
-func isAlreadyExistsError(err error) bool {
-	if err == nil {
-		return false
-	}
-
-	errStr := strings.ToLower(err.Error())
-	existsErrors := []string{
-		"already exists",
-		"duplicate key",
-		"relation already exists",
-		"function already exists",
-		"index already exists",
-	}
-
-	for _, existsErr := range existsErrors {
-		if strings.Contains(errStr, existsErr) {
-			return true
-		}
-	}
-
-	return false
-}
+// ErrLotsCountMismatch возвращается, если в распродаже в БД другое количество лотов, чем в конфиге
+var ErrLotsCountMismatch = errors.New("sale lots count does not match configured LotsCount")
 
 // CreateInitialSale создает первую распродажу если таблица пустая
 func (s *Server) CreateInitialSale() (saleID int64, err error) {
@@ -392,15 +514,34 @@ func (s *Server) CreateInitialSale() (saleID int64, err error) {
 	log.Println("🔍 Checking if initial sale creation is needed...")
 
 	// Используем QueryRowContext так как функция возвращает одно значение
-	err = s.db.QueryRowContext(ctx, "SELECT create_new_sale()").Scan(&saleID)
+	err = s.db.QueryRowContext(ctx, "SELECT create_new_sale($1, $2, $3, make_interval(secs => $4))",
+		s.config.LotsCount, s.config.ItemNameTemplate, s.config.ImageURLTemplate, s.config.SaleInterval.Seconds()).Scan(&saleID)
 	if err != nil {
 		return 0, fmt.Errorf("❌ Failed to create initial sale: %w", err)
 	}
 
+	// Распродажа текущего окна могла быть создана с другим LotsCount, тогда кеш и БД разойдутся
+	if err := s.checkLotsCount(ctx, saleID); err != nil {
+		return 0, err
+	}
+
 	log.Printf("✅ Initial sale created successfully with saleID: %d", saleID)
 	return saleID, nil
 }
 
+// checkLotsCount сверяет количество лотов распродажи в БД с config.LotsCount
+func (s *Server) checkLotsCount(ctx context.Context, saleID int64) error {
+	var count int
+	err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM sale_items WHERE sale_id = $1", saleID).Scan(&count)
+	if err != nil {
+		return fmt.Errorf("❌ Failed to count lots of sale %d: %w", saleID, classify(err))
+	}
+	if count != s.config.LotsCount {
+		return fmt.Errorf("%w: sale %d has %d lots, LotsCount is %d", ErrLotsCountMismatch, saleID, count, s.config.LotsCount)
+	}
+	return nil
+}
+
 // reconnect выполняет переподключение с retry логикой
 func (s *Server) reconnect() error {
 	for attempt := 1; attempt <= s.config.RetryAttempts; attempt++ {
@@ -488,17 +629,24 @@ func (s *Server) IsHealthy() bool {
 }
 
 // GetConnectionInfo возвращает информацию о соединении
+// Stats берется до блокировки: повторный RLock мог бы зависнуть за ожидающим reconnect
 func (s *Server) GetConnectionInfo() map[string]interface{} {
+	stats := s.Stats()
+
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	stats := s.Stats()
+	// error сериализуется в JSON как {}, поэтому отдаем текст
+	lastError := ""
+	if s.lastError != nil {
+		lastError = s.lastError.Error()
+	}
 
 	return map[string]interface{}{
 		"connection_attempts": s.connectionAttempts,
 		"connection_failures": s.connectionFailures,
 		"last_connect_time":   s.lastConnectTime,
-		"last_error":          s.lastError,
+		"last_error":          lastError,
 		"open_connections":    stats.OpenConnections,
 		"in_use":              stats.InUse,
 		"idle":                stats.Idle,
@@ -516,6 +664,10 @@ func (s *Server) Close() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	if s.replica != nil {
+		s.replica.Close()
+	}
+
 	if s.db != nil {
 		return s.db.Close()
 	}
@@ -574,48 +726,26 @@ func (s *Server) QueryContext(ctx context.Context, query string, args ...interfa
 	return rows, err
 }
 
-// isConnectionError проверяет, является ли ошибка проблемой соединения
-func isConnectionError(err error) bool {
-	if err == nil {
-		return false
-	}
-
-	errStr := err.Error()
-	connectionErrors := []string{
-		"connection refused",
-		"connection reset",
-		"broken pipe",
-		"no such host",
-		"network is unreachable",
-		"connection timed out",
-		"driver: bad connection",
-		"EOF",
-	}
+// QueryReplicaContext выполняет запрос только на чтение на реплике, при ошибке повторяет его на основной БД.
+// Реплика может отставать: запросы, которым нужна согласованность (восстановление кеша), идут через QueryContext
+func (s *Server) QueryReplicaContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	s.mu.RLock()
+	replica := s.replica
+	s.mu.RUnlock()
 
-	for _, connErr := range connectionErrors {
-		if contains(errStr, connErr) {
-			return true
+	if replica != nil {
+		rows, err := replica.QueryContext(ctx, query, args...)
+		if err == nil {
+			return rows, nil
 		}
-	}
-
-	return false
-}
-
-func contains(s, substr string) bool {
-	return len(s) >= len(substr) && (s == substr ||
-		(len(s) > len(substr) &&
-			(s[:len(substr)] == substr ||
-				s[len(s)-len(substr):] == substr ||
-				indexOf(s, substr) >= 0)))
-}
-
-func indexOf(s, substr string) int {
-	for i := 0; i <= len(s)-len(substr); i++ {
-		if s[i:i+len(substr)] == substr {
-			return i
+		// Отмененный запрос на основной БД тоже не выполнится
+		if ctx.Err() != nil {
+			return nil, err
 		}
+		log.Printf("⚠️  Replica query failed, falling back to primary: %v", err)
 	}
-	return -1
+
+	return s.QueryContext(ctx, query, args...)
 }
 
 // Пример использования с автоматическим созданием схемы
@@ -638,13 +768,12 @@ func indexOf(s, substr string) int {
 // 		AutoCreateSchema:    true, // Автоматически создаем схему
 // 	}
 
-// 	// Инициализация глобального сервера (автоматически создаст схему)
-// 	if err := InitGlobalServer(config); err != nil {
+// 	// Подключение (автоматически создаст схему)
+// 	server, err := Connect(config)
+// 	if err != nil {
 // 		log.Fatal("Failed to initialize database:", err)
 // 	}
-
-// 	// Получаем сервер
-// 	server := GetGlobalServer()
+// 	defer server.Close()
 
 // 	// Создаем первую распродажу если нужно
 // 	if err := server.CreateInitialSale(); err != nil {
@@ -0,0 +1,193 @@
+package db
+
+import (
+	"contest_notcoin/leaktest"
+	"context"
+	"database/sql"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestIndependentServers tests that two Servers in one process don't share state
+func TestIndependentServers(t *testing.T) {
+	first := newTestServer(t)
+	second := newTestServer(t)
+	require.NotSame(t, first.DB(), second.DB(), "each Server must own its pool")
+
+	firstSale, err := first.CreateInitialSale()
+	require.NoError(t, err)
+
+	firstRepo, err := NewCheckoutRepository(first)
+	require.NoError(t, err)
+	defer firstRepo.Close()
+
+	// Closing one server must leave the other usable
+	require.NoError(t, second.Close())
+	assert.False(t, second.IsHealthy())
+
+	_, err = firstRepo.GetActiveReservations(context.Background(), firstSale)
+	assert.NoError(t, err)
+	assert.True(t, first.IsHealthy())
+	assert.Nil(t, GetGlobalServer(), "Connect must not touch the deprecated global")
+}
+
+// TestServerCloseStopsBackgroundWork tests that Close stops the health, idle and pool sampling loops
+func TestServerCloseStopsBackgroundWork(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	// No database connection: the loops only need the context and config
+	config := DefaultConfig()
+	config.HealthCheckInterval = time.Millisecond
+	config.IdleKeepInterval = time.Millisecond
+	config.PoolSampleInterval = time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Server{
+		config:      config,
+		ctx:         ctx,
+		cancel:      cancel,
+		poolHistory: NewPoolStatsHistory(config.PoolSampleRetention),
+	}
+	go s.healthMonitor()
+	go s.idleKeeper()
+	go s.poolSampler()
+
+	time.Sleep(5 * time.Millisecond)
+	require.NoError(t, s.Close())
+}
+
+// TestConnectCloseReleasesGoroutines tests that a connected Server leaves nothing running after Close
+func TestConnectCloseReleasesGoroutines(t *testing.T) {
+	host := os.Getenv("TEST_DB_HOST")
+	if host == "" {
+		t.Skip("TEST_DB_HOST is not set, skipping integration test")
+	}
+	defer leaktest.Check(t)()
+
+	config := DefaultConfig()
+	config.Host = host
+	config.MinIdleConns = 5
+	s, err := Connect(config)
+	require.NoError(t, err)
+	require.NoError(t, s.Close())
+}
+
+// TestMinIdleConnsCapped tests that the idle minimum never exceeds what the pool keeps
+func TestMinIdleConnsCapped(t *testing.T) {
+	s := &Server{config: &Config{MinIdleConns: 80, MaxIdleConns: 50}}
+	assert.Equal(t, 50, s.minIdleConns())
+
+	s.config.MinIdleConns = 5
+	assert.Equal(t, 5, s.minIdleConns())
+}
+
+// TestIdleKeeperMaintainsMinimum tests that idle connections closed by the pool are reopened
+func TestIdleKeeperMaintainsMinimum(t *testing.T) {
+	host := os.Getenv("TEST_DB_HOST")
+	if host == "" {
+		t.Skip("TEST_DB_HOST is not set, skipping integration test")
+	}
+
+	config := DefaultConfig()
+	config.Host = host
+	config.PoolSampleInterval = 0
+	config.AutoCreateSchema = false
+	// The pool drops idle connections almost immediately, only the keeper brings them back
+	config.ConnMaxIdleTime = 100 * time.Millisecond
+	config.MinIdleConns = 5
+	config.IdleKeepInterval = 50 * time.Millisecond
+
+	s, err := Connect(config)
+	require.NoError(t, err)
+	defer s.Close()
+
+	// Let the pool's cleaner (runs at least once a second) close idle connections
+	time.Sleep(2 * time.Second)
+
+	assert.Eventually(t, func() bool {
+		return s.Stats().Idle >= config.MinIdleConns
+	}, time.Second, 10*time.Millisecond)
+	assert.Positive(t, s.Stats().MaxIdleTimeClosed, "pool must have closed idle connections during the idle period")
+}
+
+// TestCreateSchemaIdempotent tests that every schema command is a clean no-op on an existing schema
+func TestCreateSchemaIdempotent(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+
+	// newTestServer already created the schema; no command may rely on isAlreadyExistsError
+	for i, cmd := range s.getSchemaSQLCommands() {
+		_, err := s.DB().ExecContext(ctx, cmd)
+		assert.NoError(t, err, "schema command %d", i+1)
+	}
+	assert.NoError(t, s.createSchema())
+}
+
+// TestConnectReplicaDisabled tests that no replica pool is opened without a DSN or with replicas disabled
+func TestConnectReplicaDisabled(t *testing.T) {
+	for _, config := range []*Config{
+		{},
+		{ReplicaDSN: "host=127.0.0.1 port=1", DisableReplicas: true},
+	} {
+		s := &Server{config: config, ctx: context.Background()}
+		require.NoError(t, s.connectReplica())
+		assert.Nil(t, s.replica)
+	}
+}
+
+// TestQueryReplicaFallback tests that a failing replica falls back to the primary
+func TestQueryReplicaFallback(t *testing.T) {
+	s := newTestServer(t)
+
+	// Nothing listens on port 1, every replica query fails to connect
+	replica, err := sql.Open("pgx", "host=127.0.0.1 port=1 connect_timeout=1")
+	require.NoError(t, err)
+	s.mu.Lock()
+	s.replica = replica
+	s.mu.Unlock()
+
+	rows, err := s.QueryReplicaContext(context.Background(), "SELECT 1")
+	require.NoError(t, err)
+	defer rows.Close()
+
+	require.True(t, rows.Next())
+	var one int
+	require.NoError(t, rows.Scan(&one))
+	assert.Equal(t, 1, one)
+}
+
+// TestQueryReplicaCancelled tests that a cancelled query is not retried on the primary
+func TestQueryReplicaCancelled(t *testing.T) {
+	replica, err := sql.Open("pgx", "host=127.0.0.1 port=1 connect_timeout=1")
+	require.NoError(t, err)
+	defer replica.Close()
+
+	// No primary at all: reaching it would report a nil connection instead
+	s := &Server{config: DefaultConfig(), replica: replica}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = s.QueryReplicaContext(ctx, "SELECT 1")
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+// TestCreateInitialSaleLotsCountMismatch tests that reusing the current sale with a different LotsCount fails fast
+func TestCreateInitialSaleLotsCountMismatch(t *testing.T) {
+	s := newTestServer(t)
+
+	saleID, err := s.CreateInitialSale()
+	require.NoError(t, err)
+
+	// The current window's sale already exists, so only the check sees the new count
+	s.config.LotsCount++
+	_, err = s.CreateInitialSale()
+	assert.ErrorIs(t, err, ErrLotsCountMismatch)
+
+	s.config.LotsCount--
+	again, err := s.CreateInitialSale()
+	require.NoError(t, err)
+	assert.Equal(t, saleID, again)
+}
@@ -0,0 +1,108 @@
+package db
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// sslModes допустимые значения sslmode у libpq и pgx
+var sslModes = map[string]bool{
+	"disable": true, "allow": true, "prefer": true,
+	"require": true, "verify-ca": true, "verify-full": true,
+}
+
+// ConfigFromEnv возвращает DefaultConfig с параметрами подключения и пула из окружения.
+// Некорректные значения логируются и заменяются значениями по умолчанию
+func ConfigFromEnv() *Config {
+	config := DefaultConfig()
+
+	config.Host = envString("DB_HOST", config.Host)
+	config.Port = envPort("DB_PORT", config.Port)
+	config.User = envString("DB_USER", config.User)
+	config.Password = envString("DB_PASSWORD", config.Password)
+	config.Database = envString("DB_NAME", config.Database)
+
+	if mode := envString("DB_SSLMODE", config.SSLMode); sslModes[mode] {
+		config.SSLMode = mode
+	} else {
+		log.Printf("⚠️  Invalid DB_SSLMODE=%q, using %s", mode, config.SSLMode)
+	}
+
+	config.MaxOpenConns = envInt("DB_MAX_OPEN_CONNS", config.MaxOpenConns)
+	config.MaxIdleConns = envInt("DB_MAX_IDLE_CONNS", config.MaxIdleConns)
+	config.ConnMaxLifetime = envDuration("DB_CONN_MAX_LIFETIME", config.ConnMaxLifetime)
+	config.ConnMaxIdleTime = envDuration("DB_CONN_MAX_IDLE_TIME", config.ConnMaxIdleTime)
+
+	// Простаивающих соединений не может быть больше открытых
+	if config.MaxIdleConns > config.MaxOpenConns {
+		log.Printf("⚠️  DB_MAX_IDLE_CONNS=%d exceeds DB_MAX_OPEN_CONNS=%d, using %d",
+			config.MaxIdleConns, config.MaxOpenConns, config.MaxOpenConns)
+		config.MaxIdleConns = config.MaxOpenConns
+	}
+
+	return config
+}
+
+// String описывает подключение и пул без пароля, безопасно для логов
+func (c *Config) String() string {
+	password := ""
+	if c.Password != "" {
+		password = "***"
+	}
+	return fmt.Sprintf(
+		"host=%s port=%d user=%s password=%s dbname=%s sslmode=%s max_open_conns=%d max_idle_conns=%d conn_max_lifetime=%v conn_max_idle_time=%v",
+		c.Host, c.Port, c.User, password, c.Database, c.SSLMode,
+		c.MaxOpenConns, c.MaxIdleConns, c.ConnMaxLifetime, c.ConnMaxIdleTime,
+	)
+}
+
+// envString возвращает значение из окружения или значение по умолчанию
+func envString(key, def string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return def
+}
+
+// envInt возвращает положительное целое из окружения или значение по умолчанию
+func envInt(key string, def int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed <= 0 {
+		log.Printf("⚠️  Invalid %s=%q, using %d", key, value, def)
+		return def
+	}
+	return parsed
+}
+
+// envPort возвращает TCP порт из окружения или значение по умолчанию
+func envPort(key string, def int) int {
+	port := envInt(key, def)
+	if port > 65535 {
+		log.Printf("⚠️  Invalid %s=%d, using %d", key, port, def)
+		return def
+	}
+	return port
+}
+
+// envDuration возвращает положительную длительность из окружения или значение по умолчанию
+func envDuration(key string, def time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+
+	parsed, err := time.ParseDuration(value)
+	if err != nil || parsed <= 0 {
+		log.Printf("⚠️  Invalid %s=%q, using %v", key, value, def)
+		return def
+	}
+	return parsed
+}
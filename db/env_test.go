@@ -0,0 +1,66 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestConfigFromEnv tests that DB_* variables override the defaults
+func TestConfigFromEnv(t *testing.T) {
+	t.Setenv("DB_HOST", "db.internal")
+	t.Setenv("DB_PORT", "6432")
+	t.Setenv("DB_USER", "sale")
+	t.Setenv("DB_PASSWORD", "s3cret")
+	t.Setenv("DB_NAME", "flash")
+	t.Setenv("DB_SSLMODE", "require")
+	t.Setenv("DB_MAX_OPEN_CONNS", "40")
+	t.Setenv("DB_MAX_IDLE_CONNS", "10")
+	t.Setenv("DB_CONN_MAX_LIFETIME", "1m")
+	t.Setenv("DB_CONN_MAX_IDLE_TIME", "20s")
+
+	config := ConfigFromEnv()
+	assert.Equal(t, "db.internal", config.Host)
+	assert.Equal(t, 6432, config.Port)
+	assert.Equal(t, "sale", config.User)
+	assert.Equal(t, "s3cret", config.Password)
+	assert.Equal(t, "flash", config.Database)
+	assert.Equal(t, "require", config.SSLMode)
+	assert.Equal(t, 40, config.MaxOpenConns)
+	assert.Equal(t, 10, config.MaxIdleConns)
+	assert.Equal(t, time.Minute, config.ConnMaxLifetime)
+	assert.Equal(t, 20*time.Second, config.ConnMaxIdleTime)
+}
+
+// TestConfigFromEnvInvalid tests that invalid values fall back to the defaults
+func TestConfigFromEnvInvalid(t *testing.T) {
+	t.Setenv("DB_PORT", "postgres")
+	t.Setenv("DB_SSLMODE", "sometimes")
+	t.Setenv("DB_MAX_OPEN_CONNS", "-1")
+	t.Setenv("DB_CONN_MAX_LIFETIME", "forever")
+
+	defaults := DefaultConfig()
+	config := ConfigFromEnv()
+	assert.Equal(t, defaults.Port, config.Port)
+	assert.Equal(t, defaults.SSLMode, config.SSLMode)
+	assert.Equal(t, defaults.MaxOpenConns, config.MaxOpenConns)
+	assert.Equal(t, defaults.ConnMaxLifetime, config.ConnMaxLifetime)
+
+	t.Setenv("DB_PORT", "70000")
+	assert.Equal(t, defaults.Port, ConfigFromEnv().Port)
+
+	// Idle connections are capped by open ones
+	t.Setenv("DB_MAX_OPEN_CONNS", "5")
+	assert.Equal(t, 5, ConfigFromEnv().MaxIdleConns)
+}
+
+// TestConfigStringRedactsPassword tests that the logged config never contains the password
+func TestConfigStringRedactsPassword(t *testing.T) {
+	config := DefaultConfig()
+	config.Password = "s3cret"
+
+	assert.NotContains(t, config.String(), "s3cret")
+	assert.Contains(t, config.String(), "password=***")
+	assert.Contains(t, config.String(), "user=postgres")
+}
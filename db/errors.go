@@ -0,0 +1,160 @@
+// errors.go
+
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Категории ошибок БД, проверяются через errors.Is
+var (
+	ErrConnection = errors.New("database connection error")
+	ErrConstraint = errors.New("database constraint violation")
+	ErrNotFound   = errors.New("database record not found")
+)
+
+// SQLSTATE коды ошибок "объект уже существует"
+const (
+	sqlStateDuplicateTable    = "42P07" // Таблица или индекс
+	sqlStateDuplicateObject   = "42710"
+	sqlStateDuplicateFunction = "42723"
+	sqlStateDuplicateSchema   = "42P06"
+	sqlStateDuplicateColumn   = "42701"
+)
+
+// SQLSTATE классы и коды для классификации
+const (
+	sqlStateClassConnection  = "08"    // connection_exception
+	sqlStateClassConstraint  = "23"    // integrity_constraint_violation
+	sqlStateUniqueViolation  = "23505" // unique_violation
+	sqlStateAdminShutdown    = "57P01" // Сервер остановлен администратором
+	sqlStateCrashShutdown    = "57P02"
+	sqlStateCannotConnectNow = "57P03" // Сервер запускается или восстанавливается
+)
+
+// classifiedError ошибка с категорией: сообщение исходное, errors.Is/As видят и категорию, и исходную ошибку
+type classifiedError struct {
+	category error
+	err      error
+}
+
+func (e *classifiedError) Error() string   { return e.err.Error() }
+func (e *classifiedError) Unwrap() []error { return []error{e.category, e.err} }
+
+// classify добавляет к ошибке категорию ErrConnection, ErrConstraint или ErrNotFound, если ее удалось определить
+func classify(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var classified *classifiedError
+	if errors.As(err, &classified) {
+		return err
+	}
+
+	var category error
+	switch {
+	case isNotFoundError(err):
+		category = ErrNotFound
+	case isConstraintError(err):
+		category = ErrConstraint
+	case isConnectionError(err):
+		category = ErrConnection
+	default:
+		return err
+	}
+
+	return &classifiedError{category: category, err: err}
+}
+
+// isNotFoundError проверяет, что запрос не вернул строк
+func isNotFoundError(err error) bool {
+	return errors.Is(err, sql.ErrNoRows) || errors.Is(err, pgx.ErrNoRows)
+}
+
+// isConstraintError проверяет нарушение ограничения целостности (unique, foreign key, check, not null)
+func isConstraintError(err error) bool {
+	return hasSQLStateClass(err, sqlStateClassConstraint)
+}
+
+// isUniqueViolation проверяет нарушение уникальности
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == sqlStateUniqueViolation
+}
+
+// isConnectionError проверяет, является ли ошибка проблемой соединения
+func isConnectionError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	// Таймаут или отмена запроса - не проблема соединения, хотя DeadlineExceeded реализует net.Error
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, net.ErrClosed) || errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+
+	var connectErr *pgconn.ConnectError
+	if errors.As(err, &connectErr) {
+		return true
+	}
+
+	// Сетевые ошибки: отказ в соединении, недоступный хост, DNS
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case sqlStateAdminShutdown, sqlStateCrashShutdown, sqlStateCannotConnectNow:
+			return true
+		}
+		return hasSQLStateClass(pgErr, sqlStateClassConnection)
+	}
+
+	return false
+}
+
+// isAlreadyExistsError проверяет, является ли ошибка связанной с уже существующим объектом
+func isAlreadyExistsError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+
+	switch pgErr.Code {
+	case sqlStateDuplicateTable, sqlStateDuplicateObject, sqlStateDuplicateFunction,
+		sqlStateDuplicateSchema, sqlStateDuplicateColumn:
+		return true
+	}
+
+	return false
+}
+
+// hasSQLStateClass проверяет класс SQLSTATE (первые два символа кода) ошибки PostgreSQL
+func hasSQLStateClass(err error, class string) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && strings.HasPrefix(pgErr.Code, class)
+}
@@ -0,0 +1,96 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestIsAlreadyExistsError tests that only "already exists" SQLSTATE codes are ignored
+func TestIsAlreadyExistsError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"duplicate table", &pgconn.PgError{Code: "42P07"}, true},
+		{"duplicate function wrapped", fmt.Errorf("exec: %w", &pgconn.PgError{Code: "42723"}), true},
+		{"unique violation", &pgconn.PgError{Code: "23505", Message: "duplicate key value violates unique constraint"}, false},
+		{"syntax error", &pgconn.PgError{Code: "42601"}, false},
+		{"plain error mentioning already exists", errors.New("relation already exists"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, isAlreadyExistsError(tt.err))
+		})
+	}
+}
+
+// TestClassify tests the category assigned to representative driver, network and PostgreSQL errors
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want error // nil means unclassified
+	}{
+		{"unique violation", &pgconn.PgError{Code: "23505", Message: "duplicate key value violates unique constraint"}, ErrConstraint},
+		{"foreign key violation wrapped", fmt.Errorf("insert: %w", &pgconn.PgError{Code: "23503"}), ErrConstraint},
+		{"not null violation", &pgconn.PgError{Code: "23502"}, ErrConstraint},
+		{"connection failure", &pgconn.PgError{Code: "08006"}, ErrConnection},
+		{"admin shutdown", &pgconn.PgError{Code: "57P01"}, ErrConnection},
+		{"cannot connect now", &pgconn.PgError{Code: "57P03"}, ErrConnection},
+		{"bad connection", driver.ErrBadConn, ErrConnection},
+		{"unexpected EOF", fmt.Errorf("read: %w", io.ErrUnexpectedEOF), ErrConnection},
+		{"connection refused", &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}, ErrConnection},
+		{"connection reset", fmt.Errorf("write: %w", syscall.ECONNRESET), ErrConnection},
+		{"unknown host", &net.DNSError{Err: "no such host", Name: "db"}, ErrConnection},
+		{"sql no rows", sql.ErrNoRows, ErrNotFound},
+		{"pgx no rows", pgx.ErrNoRows, ErrNotFound},
+		{"syntax error", &pgconn.PgError{Code: "42601"}, nil},
+		{"deadline exceeded", context.DeadlineExceeded, nil},
+		{"canceled", fmt.Errorf("query: %w", context.Canceled), nil},
+		{"plain error mentioning connection refused", errors.New("connection refused"), nil},
+	}
+
+	categories := []error{ErrConnection, ErrConstraint, ErrNotFound}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			classified := classify(fmt.Errorf("query: %w", tt.err))
+			for _, category := range categories {
+				assert.Equal(t, category == tt.want, errors.Is(classified, category), "category %v", category)
+			}
+
+			// The original error stays inspectable and the message unchanged
+			assert.ErrorIs(t, classified, tt.err)
+			assert.Equal(t, "query: "+tt.err.Error(), classified.Error())
+		})
+	}
+
+	assert.NoError(t, classify(nil))
+}
+
+// TestClassifyKeepsPgError tests that callers can still reach the PostgreSQL error through a category
+func TestClassifyKeepsPgError(t *testing.T) {
+	err := fmt.Errorf("execute batch purchase: %w", classify(&pgconn.PgError{Code: "23505", ConstraintName: "sale_items_pkey"}))
+	assert.ErrorIs(t, err, ErrConstraint)
+
+	var pgErr *pgconn.PgError
+	if assert.ErrorAs(t, err, &pgErr) {
+		assert.Equal(t, "sale_items_pkey", pgErr.ConstraintName)
+	}
+
+	// Classifying twice doesn't nest categories
+	assert.Same(t, err, classify(err))
+}
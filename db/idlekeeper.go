@@ -0,0 +1,77 @@
+// idlekeeper.go
+
+package db
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+)
+
+// idleKeeper периодически поддерживает не меньше MinIdleConns простаивающих соединений.
+// database/sql сам минимум не держит: после паузы между распродажами соединения
+// закрываются по ConnMaxIdleTime и первый всплеск запросов платит за переподключение
+func (s *Server) idleKeeper() {
+	ticker := time.NewTicker(s.config.IdleKeepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.keepIdle(); err != nil {
+				log.Printf("⚠️  Idle connections keeper failed: %v", err)
+			}
+		}
+	}
+}
+
+// minIdleConns возвращает минимум простаивающих соединений, который пул реально удержит
+func (s *Server) minIdleConns() int {
+	if s.config.MinIdleConns > s.config.MaxIdleConns {
+		return s.config.MaxIdleConns
+	}
+	return s.config.MinIdleConns
+}
+
+// keepIdle добирает простаивающие соединения до минимума
+func (s *Server) keepIdle() error {
+	db := s.DB()
+	if db == nil {
+		return nil
+	}
+
+	target := s.minIdleConns()
+	stats := db.Stats()
+	// Под нагрузкой соединения и так теплые, занятые вернутся в простой сами
+	if stats.Idle >= target || stats.InUse > 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(s.ctx, s.config.IdleKeepInterval)
+	defer cancel()
+
+	// Держим соединения одновременно, иначе пул вернет одно и то же.
+	// После возврата в пул все они снова простаивают
+	conns := make([]*sql.Conn, 0, target)
+	defer func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+	for len(conns) < target {
+		conn, err := db.Conn(ctx)
+		if err != nil {
+			return err
+		}
+		if err := conn.PingContext(ctx); err != nil {
+			conn.Close()
+			return err
+		}
+		conns = append(conns, conn)
+	}
+
+	return nil
+}
@@ -0,0 +1,164 @@
+// persister.go
+
+package db
+
+import (
+	"contest_notcoin/megacache"
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// CheckoutSource источник активных резервирований (обычно кеш)
+type CheckoutSource interface {
+	GetActiveCheckouts() []megacache.Checkout
+}
+
+// checkoutWriter пишет пачку checkout записей в БД, пропуская занятые коды
+type checkoutWriter interface {
+	MultiRowInsert(ctx context.Context, records []CheckoutRecord) ([]error, error)
+}
+
+// CheckoutPersister периодически сохраняет новые активные резервирования из кеша пачками.
+// Обработчики не ждут БД: источником правды во время распродажи остается кеш.
+type CheckoutPersister struct {
+	writer    checkoutWriter
+	source    CheckoutSource
+	converter *CacheDataConverter
+	saleID    int64
+	interval  time.Duration
+	batchSize int
+
+	mu        sync.Mutex
+	persisted map[uuid.UUID]struct{} // Коды, уже записанные в БД
+	written   atomic.Int64           // Всего записано строк за время жизни
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewCheckoutPersister создает и запускает фоновое сохранение резервирований
+func NewCheckoutPersister(repo *CheckoutRepository, source CheckoutSource, saleID int64, interval time.Duration, batchSize int) *CheckoutPersister {
+	return newCheckoutPersister(repo, source, saleID, interval, batchSize)
+}
+
+func newCheckoutPersister(writer checkoutWriter, source CheckoutSource, saleID int64, interval time.Duration, batchSize int) *CheckoutPersister {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	p := &CheckoutPersister{
+		writer:    writer,
+		source:    source,
+		converter: &CacheDataConverter{},
+		saleID:    saleID,
+		interval:  interval,
+		batchSize: batchSize,
+		persisted: make(map[uuid.UUID]struct{}),
+		ctx:       ctx,
+		cancel:    cancel,
+		done:      make(chan struct{}),
+	}
+
+	go p.worker()
+
+	return p
+}
+
+// worker сохраняет снимки по таймеру
+func (p *CheckoutPersister) worker() {
+	defer close(p.done)
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := p.PersistOnce(p.ctx); err != nil {
+				log.Printf("❌ Background checkout persist failed: %v", err)
+			}
+		case <-p.ctx.Done():
+			return
+		}
+	}
+}
+
+// PersistOnce снимает снимок кеша и записывает резервирования, которых еще нет в БД
+func (p *CheckoutPersister) PersistOnce(ctx context.Context) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	snapshot := p.source.GetActiveCheckouts()
+
+	// Разница снимка: только новые коды
+	var fresh []megacache.Checkout
+	alive := make(map[uuid.UUID]struct{}, len(snapshot))
+	for _, checkout := range snapshot {
+		alive[checkout.Code] = struct{}{}
+		if _, ok := p.persisted[checkout.Code]; !ok {
+			fresh = append(fresh, checkout)
+		}
+	}
+
+	// Забываем коды, которые ушли из кеша, чтобы множество не росло
+	for code := range p.persisted {
+		if _, ok := alive[code]; !ok {
+			delete(p.persisted, code)
+		}
+	}
+
+	records := p.converter.ConvertCacheToCheckoutRecords(p.saleID, fresh)
+
+	written := 0
+	for start := 0; start < len(records); start += p.batchSize {
+		end := start + p.batchSize
+		if end > len(records) {
+			end = len(records)
+		}
+
+		results, err := p.writer.MultiRowInsert(ctx, records[start:end])
+		if err != nil {
+			// Незаписанные коды попадут в следующий снимок
+			return written, fmt.Errorf("persist checkouts batch: %w", err)
+		}
+
+		// Занятый код уже в БД (обычно запись прошлой пачки, ответ на которую потерялся),
+		// повторять его бессмысленно
+		duplicates := 0
+		for i, record := range records[start:end] {
+			p.persisted[record.Code] = struct{}{}
+			if results[i] != nil {
+				duplicates++
+			}
+		}
+		if duplicates > 0 {
+			log.Printf("⚠️ Skipped %d checkouts with codes already in DB", duplicates)
+		}
+		written += end - start - duplicates
+		p.written.Add(int64(end - start - duplicates))
+	}
+
+	return written, nil
+}
+
+// Written возвращает общее количество записанных в БД резервирований
+func (p *CheckoutPersister) Written() int64 {
+	return p.written.Load()
+}
+
+// Close останавливает фоновую задачу и выполняет финальное сохранение
+func (p *CheckoutPersister) Close() error {
+	p.cancel()
+	<-p.done
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := p.PersistOnce(ctx)
+	return err
+}
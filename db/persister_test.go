@@ -0,0 +1,131 @@
+package db
+
+import (
+	"contest_notcoin/leaktest"
+	"contest_notcoin/megacache"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeCheckoutWriter records inserted batches
+type fakeCheckoutWriter struct {
+	batches [][]CheckoutRecord
+	taken   map[uuid.UUID]bool // Codes reported as already in the DB
+	err     error
+}
+
+func (w *fakeCheckoutWriter) MultiRowInsert(ctx context.Context, records []CheckoutRecord) ([]error, error) {
+	if w.err != nil {
+		return nil, w.err
+	}
+	w.batches = append(w.batches, append([]CheckoutRecord(nil), records...))
+
+	results := make([]error, len(records))
+	for i, record := range records {
+		if w.taken[record.Code] {
+			results[i] = duplicateCodeError(record.Code)
+		}
+	}
+	return results, nil
+}
+
+// fakeCheckoutSource returns a fixed snapshot
+type fakeCheckoutSource struct {
+	checkouts []megacache.Checkout
+}
+
+func (s *fakeCheckoutSource) GetActiveCheckouts() []megacache.Checkout {
+	return s.checkouts
+}
+
+func newTestCheckout(userID, itemID int64) megacache.Checkout {
+	now := time.Now()
+	return megacache.Checkout{
+		Code:      uuid.New(),
+		UserID:    userID,
+		LotIndex:  itemID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(time.Minute),
+		Status:    megacache.CheckoutStatusActive,
+	}
+}
+
+// TestCheckoutPersisterSnapshotDiff tests that only new reservations are written
+func TestCheckoutPersisterSnapshotDiff(t *testing.T) {
+	defer leaktest.Check(t)()
+	writer := &fakeCheckoutWriter{}
+	source := &fakeCheckoutSource{}
+
+	// Long interval so only explicit PersistOnce calls run
+	p := newCheckoutPersister(writer, source, 1, time.Hour, 2)
+	defer p.Close()
+
+	source.checkouts = []megacache.Checkout{
+		newTestCheckout(1, 0),
+		newTestCheckout(2, 1),
+		newTestCheckout(3, 2),
+	}
+
+	written, err := p.PersistOnce(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 3, written)
+	assert.Len(t, writer.batches, 2, "3 records with batch size 2 should take 2 inserts")
+
+	// Same snapshot plus one new reservation: only the new one is written
+	source.checkouts = append(source.checkouts, newTestCheckout(4, 3))
+	written, err = p.PersistOnce(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, written)
+	assert.Equal(t, int64(3), writer.batches[2][0].ItemID)
+	assert.Equal(t, int64(1), writer.batches[2][0].SaleID)
+
+	// Reservations that left the cache are forgotten
+	source.checkouts = source.checkouts[3:]
+	_, err = p.PersistOnce(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, p.persisted, 1)
+	assert.Equal(t, int64(4), p.Written())
+}
+
+// TestCheckoutPersisterRetriesFailedBatch tests that a failed batch is retried on the next snapshot
+func TestCheckoutPersisterRetriesFailedBatch(t *testing.T) {
+	writer := &fakeCheckoutWriter{err: errors.New("db down")}
+	source := &fakeCheckoutSource{checkouts: []megacache.Checkout{newTestCheckout(1, 0)}}
+
+	p := newCheckoutPersister(writer, source, 1, time.Hour, 10)
+	defer p.Close()
+
+	_, err := p.PersistOnce(context.Background())
+	assert.Error(t, err)
+
+	writer.err = nil
+	written, err := p.PersistOnce(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, written)
+}
+
+// TestCheckoutPersisterSkipsDuplicateCodes tests that a code already in the DB is not counted or retried
+func TestCheckoutPersisterSkipsDuplicateCodes(t *testing.T) {
+	taken := newTestCheckout(1, 0)
+	writer := &fakeCheckoutWriter{taken: map[uuid.UUID]bool{taken.Code: true}}
+	source := &fakeCheckoutSource{checkouts: []megacache.Checkout{taken, newTestCheckout(2, 1)}}
+
+	p := newCheckoutPersister(writer, source, 1, time.Hour, 10)
+	defer p.Close()
+
+	written, err := p.PersistOnce(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, written)
+	assert.Equal(t, int64(1), p.Written())
+
+	written, err = p.PersistOnce(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 0, written)
+	assert.Len(t, writer.batches, 1, "the duplicate should not be retried")
+}
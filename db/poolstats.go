@@ -0,0 +1,96 @@
+// poolstats.go
+
+package db
+
+import (
+	"sync"
+	"time"
+)
+
+// PoolStatsSample снимок состояния пула соединений
+type PoolStatsSample struct {
+	Timestamp    time.Time     `json:"timestamp"`
+	OpenConns    int           `json:"open_connections"`
+	InUse        int           `json:"in_use"`
+	Idle         int           `json:"idle"`
+	WaitCount    int64         `json:"wait_count"`
+	WaitDuration time.Duration `json:"wait_duration"`
+}
+
+// PoolStatsHistory кольцевой буфер снимков пула фиксированного размера
+type PoolStatsHistory struct {
+	mu      sync.RWMutex
+	samples []PoolStatsSample
+	next    int  // Позиция следующей записи
+	full    bool // Буфер уже перезаписывается по кругу
+}
+
+// NewPoolStatsHistory создает буфер на size снимков
+func NewPoolStatsHistory(size int) *PoolStatsHistory {
+	if size <= 0 {
+		size = 1
+	}
+	return &PoolStatsHistory{
+		samples: make([]PoolStatsSample, size),
+	}
+}
+
+// Add добавляет снимок, вытесняя самый старый при переполнении
+func (h *PoolStatsHistory) Add(sample PoolStatsSample) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.samples[h.next] = sample
+	h.next = (h.next + 1) % len(h.samples)
+	if h.next == 0 {
+		h.full = true
+	}
+}
+
+// Samples возвращает копию снимков в хронологическом порядке
+func (h *PoolStatsHistory) Samples() []PoolStatsSample {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if !h.full {
+		result := make([]PoolStatsSample, h.next)
+		copy(result, h.samples[:h.next])
+		return result
+	}
+
+	result := make([]PoolStatsSample, 0, len(h.samples))
+	result = append(result, h.samples[h.next:]...)
+	result = append(result, h.samples[:h.next]...)
+	return result
+}
+
+// poolSampler периодически записывает состояние пула в историю
+func (s *Server) poolSampler() {
+	ticker := time.NewTicker(s.config.PoolSampleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case now := <-ticker.C:
+			stats := s.Stats()
+			s.poolHistory.Add(PoolStatsSample{
+				Timestamp:    now,
+				OpenConns:    stats.OpenConnections,
+				InUse:        stats.InUse,
+				Idle:         stats.Idle,
+				WaitCount:    stats.WaitCount,
+				WaitDuration: stats.WaitDuration,
+			})
+		}
+	}
+}
+
+// PoolHistory возвращает историю насыщения пула соединений
+func (s *Server) PoolHistory() []PoolStatsSample {
+	if s.poolHistory == nil {
+		return nil
+	}
+	return s.poolHistory.Samples()
+}
@@ -0,0 +1,56 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestPoolStatsHistoryBounded tests that the ring buffer keeps only the newest samples in order
+func TestPoolStatsHistoryBounded(t *testing.T) {
+	history := NewPoolStatsHistory(3)
+	assert.Empty(t, history.Samples())
+
+	for i := 1; i <= 5; i++ {
+		history.Add(PoolStatsSample{InUse: i})
+	}
+
+	samples := history.Samples()
+	require.Len(t, samples, 3)
+	assert.Equal(t, 3, samples[0].InUse)
+	assert.Equal(t, 4, samples[1].InUse)
+	assert.Equal(t, 5, samples[2].InUse)
+}
+
+// TestPoolSamplerRecordsSamples tests that the sampler records samples up to the retention size
+func TestPoolSamplerRecordsSamples(t *testing.T) {
+	config := DefaultConfig()
+	config.PoolSampleInterval = time.Millisecond
+	config.PoolSampleRetention = 5
+
+	// No database connection: Stats() returns zero values, which is enough for sampling
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Server{
+		config:      config,
+		ctx:         ctx,
+		cancel:      cancel,
+		poolHistory: NewPoolStatsHistory(config.PoolSampleRetention),
+	}
+	go s.poolSampler()
+	defer cancel()
+
+	require.Eventually(t, func() bool {
+		return len(s.PoolHistory()) == config.PoolSampleRetention
+	}, time.Second, time.Millisecond)
+
+	// Keep sampling past the retention size and verify the history stays bounded
+	time.Sleep(20 * time.Millisecond)
+	samples := s.PoolHistory()
+	assert.Len(t, samples, config.PoolSampleRetention)
+	for i := 1; i < len(samples); i++ {
+		assert.False(t, samples[i].Timestamp.Before(samples[i-1].Timestamp))
+	}
+}
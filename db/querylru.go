@@ -0,0 +1,63 @@
+// querylru.go
+
+package db
+
+import (
+	"container/list"
+	"sync"
+)
+
+// queryLRU кеш сгенерированных запросов по размеру пачки с вытеснением давно не использованных
+type queryLRU struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List            // Начало - недавно использованные
+	entries map[int]*list.Element // Размер пачки -> элемент order
+}
+
+// queryLRUEntry запись кеша запросов
+type queryLRUEntry struct {
+	count int
+	query string
+}
+
+// newQueryLRU создает кеш на size запросов
+func newQueryLRU(size int) *queryLRU {
+	if size <= 0 {
+		size = 1
+	}
+	return &queryLRU{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[int]*list.Element, size),
+	}
+}
+
+// Get возвращает запрос для count, генерируя его через build при промахе
+func (c *queryLRU) Get(count int, build func(int) string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[count]; ok {
+		c.order.MoveToFront(elem)
+		return elem.Value.(*queryLRUEntry).query
+	}
+
+	query := build(count)
+	c.entries[count] = c.order.PushFront(&queryLRUEntry{count: count, query: query})
+
+	// Вытесняем самый давно использованный запрос
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*queryLRUEntry).count)
+	}
+	return query
+}
+
+// Len возвращает количество запросов в кеше
+func (c *queryLRU) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
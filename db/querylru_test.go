@@ -0,0 +1,62 @@
+package db
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestQueryLRUBounded tests that many distinct batch sizes never grow the query cache past its size
+func TestQueryLRUBounded(t *testing.T) {
+	repo := &SaleItemsRepository{queryCache: newQueryLRU(8)}
+
+	for count := 1; count <= 1000; count++ {
+		assert.Equal(t, generateBatchPurchaseQuery(count), repo.getOrCreateBatchPurchaseQuery(count))
+		assert.LessOrEqual(t, repo.queryCache.Len(), 8)
+	}
+	assert.Equal(t, 8, repo.queryCache.Len())
+}
+
+// TestQueryLRUEvictsLeastRecentlyUsed tests that hits keep a query cached while cold ones are evicted
+func TestQueryLRUEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := newQueryLRU(2)
+	builds := 0
+	build := func(count int) string {
+		builds++
+		return fmt.Sprintf("query %d", count)
+	}
+
+	cache.Get(1, build)
+	cache.Get(2, build)
+	cache.Get(1, build) // 1 is now the most recently used
+	cache.Get(3, build) // evicts 2
+	assert.Equal(t, 3, builds)
+
+	assert.Equal(t, "query 1", cache.Get(1, build))
+	assert.Equal(t, 3, builds, "1 must still be cached")
+
+	assert.Equal(t, "query 2", cache.Get(2, build))
+	assert.Equal(t, 4, builds, "2 must have been evicted")
+}
+
+// TestQueryLRUConcurrent tests concurrent lookups under the race detector
+func TestQueryLRUConcurrent(t *testing.T) {
+	cache := newQueryLRU(4)
+
+	var wg sync.WaitGroup
+	for worker := 0; worker < 8; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				count := (worker + i) % 10
+				assert.Equal(t, generateMultiRowQuery(count+1), cache.Get(count+1, generateMultiRowQuery))
+			}
+		}(worker)
+	}
+	wg.Wait()
+
+	assert.Equal(t, 4, cache.Len())
+}
@@ -6,9 +6,12 @@ import (
 	"contest_notcoin/megacache"
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"log"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	_ "github.com/jackc/pgx/v5/stdlib"
@@ -19,8 +22,7 @@ type SaleItemsRepository struct {
 	server           *Server
 	db               *sql.DB
 	purchaseItemStmt *sql.Stmt
-	queryCache       map[string]string // Кеш для многострочных запросов
-	cacheMutex       sync.RWMutex      // Мьютекс для защиты кеша
+	queryCache       *queryLRU // Кеш для многострочных запросов
 }
 
 // NewSaleItemsRepository создает новый репозиторий с подготовленными выражениями
@@ -45,10 +47,15 @@ func NewSaleItemsRepository(server *Server) (*SaleItemsRepository, error) {
 		server:           server,
 		db:               db,
 		purchaseItemStmt: purchaseItemStmt,
-		queryCache:       make(map[string]string),
+		queryCache:       newQueryLRU(server.config.QueryCacheSize),
 	}, nil
 }
 
+// Warmup подготавливает выражения репозитория на соединениях пула
+func (r *SaleItemsRepository) Warmup(ctx context.Context) (int, error) {
+	return r.server.WarmupStatements(ctx, r.purchaseItemStmt)
+}
+
 // Close освобождает ресурсы
 func (r *SaleItemsRepository) Close() error {
 	var errs []error
@@ -69,7 +76,7 @@ func (r *SaleItemsRepository) Close() error {
 func (r *SaleItemsRepository) PurchaseItem(ctx context.Context, saleID, itemID, userID int64) error {
 	result, err := r.purchaseItemStmt.ExecContext(ctx, userID, time.Now(), saleID, itemID)
 	if err != nil {
-		return fmt.Errorf("execute purchase query: %w", err)
+		return fmt.Errorf("execute purchase query: %w", classify(err))
 	}
 
 	affected, err := result.RowsAffected()
@@ -78,7 +85,7 @@ func (r *SaleItemsRepository) PurchaseItem(ctx context.Context, saleID, itemID,
 	}
 
 	if affected == 0 {
-		return fmt.Errorf("item not available for purchase: sale_id=%d, item_id=%d", saleID, itemID)
+		return fmt.Errorf("%w: sale_id=%d, item_id=%d", ErrItemNotAvailable, saleID, itemID)
 	}
 
 	return nil
@@ -86,8 +93,23 @@ func (r *SaleItemsRepository) PurchaseItem(ctx context.Context, saleID, itemID,
 
 // BatchPurchaseItem многострочная покупка лотов
 func (r *SaleItemsRepository) BatchPurchaseItem(ctx context.Context, purchases []ItemPurchase) error {
+	purchased, err := r.purchaseBatch(ctx, purchases)
+	if err != nil {
+		return err
+	}
+
+	if len(purchased) != len(purchases) {
+		return fmt.Errorf("%w: expected %d purchases, but %d items were updated",
+			ErrItemNotAvailable, len(purchases), len(purchased))
+	}
+
+	return nil
+}
+
+// purchaseBatch выполняет многострочную покупку и возвращает лоты, которые реально были куплены
+func (r *SaleItemsRepository) purchaseBatch(ctx context.Context, purchases []ItemPurchase) (map[purchaseKey]bool, error) {
 	if len(purchases) == 0 {
-		return nil
+		return nil, nil
 	}
 
 	// Генерируем запрос для множественного обновления
@@ -102,50 +124,32 @@ func (r *SaleItemsRepository) BatchPurchaseItem(ctx context.Context, purchases [
 		values = append(values, purchase.UserID, purchase.SaleID, purchase.ItemID)
 	}
 
-	// Выполняем запрос
-	result, err := r.server.ExecContext(ctx, query, values...)
+	// Выполняем запрос, RETURNING отдает только обновленные лоты
+	rows, err := r.server.QueryContext(ctx, query, values...)
 	if err != nil {
-		return fmt.Errorf("execute batch purchase: %w", err)
+		return nil, fmt.Errorf("execute batch purchase: %w", classify(err))
 	}
+	defer rows.Close()
 
-	// Проверяем количество обновленных строк
-	affected, err := result.RowsAffected()
-	if err != nil {
-		return fmt.Errorf("get rows affected: %w", err)
+	purchased := make(map[purchaseKey]bool, len(purchases))
+	for rows.Next() {
+		var key purchaseKey
+		if err := rows.Scan(&key.saleID, &key.itemID); err != nil {
+			return nil, fmt.Errorf("scan purchased item: %w", classify(err))
+		}
+		purchased[key] = true
 	}
 
-	if affected != int64(len(purchases)) {
-		return fmt.Errorf("expected %d purchases, but %d items were updated", len(purchases), affected)
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", classify(err))
 	}
 
-	return nil
+	return purchased, nil
 }
 
 // getOrCreateBatchPurchaseQuery thread-safe получение или создание кешированного запроса покупки
 func (r *SaleItemsRepository) getOrCreateBatchPurchaseQuery(count int) string {
-	cacheKey := fmt.Sprintf("batch_purchase_%d", count)
-
-	// Сначала пытаемся прочитать из кеша
-	r.cacheMutex.RLock()
-	if query, exists := r.queryCache[cacheKey]; exists {
-		r.cacheMutex.RUnlock()
-		return query
-	}
-	r.cacheMutex.RUnlock()
-
-	// Если нет в кеше, генерируем и сохраняем
-	r.cacheMutex.Lock()
-	defer r.cacheMutex.Unlock()
-
-	// Проверяем еще раз, возможно кто-то уже добавил пока мы ждали Lock
-	if query, exists := r.queryCache[cacheKey]; exists {
-		return query
-	}
-
-	// Генерируем новый запрос
-	query := generateBatchPurchaseQuery(count)
-	r.queryCache[cacheKey] = query
-	return query
+	return r.queryCache.Get(count, generateBatchPurchaseQuery)
 }
 
 // generateBatchPurchaseQuery генерирует запрос для множественной покупки
@@ -160,7 +164,7 @@ func generateBatchPurchaseQuery(count int) string {
 	valueParts := make([]string, count)
 	for i := 0; i < count; i++ {
 		// Параметры начинаются с $2 (т.к. $1 - время)
-		valueParts[i] = fmt.Sprintf("($%d::integer, $%d::integer, $%d::integer)",
+		valueParts[i] = fmt.Sprintf("($%d::bigint, $%d::integer, $%d::integer)",
 			i*3+2, i*3+3, i*3+4)
 	}
 
@@ -168,11 +172,50 @@ func generateBatchPurchaseQuery(count int) string {
 	query += `) AS updates(user_id, sale_id, item_id) 
 		WHERE sale_items.sale_id = updates.sale_id 
 		AND sale_items.item_id = updates.item_id 
-		AND sale_items.purchased = false`
+		AND sale_items.purchased = false
+		RETURNING sale_items.sale_id, sale_items.item_id`
 
 	return query
 }
 
+// ErrItemNotAvailable лот уже куплен или отсутствует в распродаже
+var ErrItemNotAvailable = errors.New("item not available for purchase")
+
+// ErrUpdaterClosed покупка пришла после Close и не была принята
+var ErrUpdaterClosed = errors.New("batch purchase updater is closed")
+
+// ErrPurchaseDeferred пачка не записалась после всех попыток, покупка ушла в очередь
+// недоставленных и будет записана в фоне. Лот остается за покупателем; если запись так и
+// не удастся, покупку получит обработчик SetLostHandler
+var ErrPurchaseDeferred = errors.New("purchase deferred to the dead-letter queue")
+
+// Значения по умолчанию для повторов пачки покупок
+const (
+	DefaultPurchaseRetryAttempts = 3
+	DefaultPurchaseRetryBackoff  = 20 * time.Millisecond
+)
+
+// deadLetterSize ограничивает очередь недоставленных покупок, при переполнении покупка завершается ошибкой
+const deadLetterSize = 1000
+
+// maxDeadLetterBackoff верхняя граница паузы между повторами очереди недоставленных
+const maxDeadLetterBackoff = 5 * time.Second
+
+// DefaultDeadLetterDrainTimeout сколько Close дописывает очередь недоставленных, прежде чем
+// оставшиеся покупки получат по последней попытке
+const DefaultDeadLetterDrainTimeout = 10 * time.Second
+
+// purchaseKey идентифицирует лот в пакетной покупке
+type purchaseKey struct {
+	saleID int64
+	itemID int64
+}
+
+// batchPurchaser выполняет пакетную покупку и сообщает, какие лоты реально куплены
+type batchPurchaser interface {
+	purchaseBatch(ctx context.Context, purchases []ItemPurchase) (map[purchaseKey]bool, error)
+}
+
 // ItemPurchase представляет информацию о покупке лота
 type ItemPurchase struct {
 	SaleID int64
@@ -189,20 +232,56 @@ type SaleItem struct {
 	ItemName      string     `json:"item_name" db:"item_name"`
 	ImageURL      string     `json:"image_url" db:"image_url"`
 	Purchased     bool       `json:"purchased" db:"purchased"`
-	PurchasedBy   *int       `json:"purchased_by" db:"purchased_by"`
+	PurchasedBy   *int64     `json:"purchased_by" db:"purchased_by"`
 	PurchasedAt   *time.Time `json:"purchased_at" db:"purchased_at"`
 }
 
 // BatchPurchaseUpdater накапливает покупки и выполняет пакетное обновление
 type BatchPurchaseUpdater struct {
-	repo      *SaleItemsRepository
+	repo      batchPurchaser
 	batchSize int
 	timeout   time.Duration
 	buffer    []pendingPurchase
 	timer     *time.Timer
 	mu        sync.Mutex
+	closed    bool           // после Close новые покупки не принимаются (под mu)
+	inFlight  sync.WaitGroup // пачки, выполняемые в фоне
 	ctx       context.Context
 	cancel    context.CancelFunc
+
+	// Повторы пачки до отправки в очередь недоставленных и срок ее дописывания в Close (под mu)
+	retryAttempts int
+	retryBackoff  time.Duration
+	drainTimeout  time.Duration
+	onLost        func(purchase ItemPurchase, sold bool)
+
+	// Очередь недоставленных покупок и ее фоновый обработчик
+	deadLetters    chan ItemPurchase
+	stopping       chan struct{} // закрывается в Close по истечении drainTimeout: каждая оставшаяся покупка получает одну попытку
+	deadLetterDone chan struct{}
+	stats          purchaseUpdaterCounters
+
+	// Лоты с покупками из очереди: время ухода покупки из очереди, нулевое, пока она в ней
+	deferredMu sync.Mutex
+	deferred   map[purchaseKey]time.Time
+}
+
+// purchaseUpdaterCounters счетчики повторов и очереди недоставленных
+type purchaseUpdaterCounters struct {
+	retries      atomic.Int64
+	depth        atomic.Int64
+	deadLettered atomic.Int64
+	recovered    atomic.Int64
+	lost         atomic.Int64
+}
+
+// PurchaseUpdaterStats снимок счетчиков BatchPurchaseUpdater
+type PurchaseUpdaterStats struct {
+	Retries         int64 `json:"retries"`           // Повторные попытки пачек
+	DeadLetterDepth int64 `json:"dead_letter_depth"` // Покупки, ожидающие записи в фоне
+	DeadLettered    int64 `json:"dead_lettered"`     // Всего отправлено в очередь недоставленных
+	Recovered       int64 `json:"recovered"`         // Записаны из очереди
+	Lost            int64 `json:"lost"`              // Не записаны: лот продан в БД или очередь закрыта
 }
 
 // pendingPurchase представляет покупку ожидающую выполнения
@@ -213,22 +292,100 @@ type pendingPurchase struct {
 
 // NewBatchPurchaseUpdater создает новый батчер для покупок
 func NewBatchPurchaseUpdater(repo *SaleItemsRepository, batchSize int, timeout time.Duration) *BatchPurchaseUpdater {
+	return newBatchPurchaseUpdater(repo, batchSize, timeout)
+}
+
+func newBatchPurchaseUpdater(repo batchPurchaser, batchSize int, timeout time.Duration) *BatchPurchaseUpdater {
 	ctx, cancel := context.WithCancel(context.Background())
 
-	return &BatchPurchaseUpdater{
-		repo:      repo,
-		batchSize: batchSize,
-		timeout:   timeout,
-		buffer:    make([]pendingPurchase, 0, batchSize),
-		ctx:       ctx,
-		cancel:    cancel,
+	bpu := &BatchPurchaseUpdater{
+		repo:           repo,
+		batchSize:      batchSize,
+		timeout:        timeout,
+		buffer:         make([]pendingPurchase, 0, batchSize),
+		ctx:            ctx,
+		cancel:         cancel,
+		retryAttempts:  DefaultPurchaseRetryAttempts,
+		retryBackoff:   DefaultPurchaseRetryBackoff,
+		drainTimeout:   DefaultDeadLetterDrainTimeout,
+		deadLetters:    make(chan ItemPurchase, deadLetterSize),
+		stopping:       make(chan struct{}),
+		deadLetterDone: make(chan struct{}),
+		deferred:       make(map[purchaseKey]time.Time),
+	}
+
+	go bpu.deadLetterWorker()
+
+	return bpu
+}
+
+// SetRetry задает число попыток пачки и начальную паузу между ними, пауза удваивается.
+// attempts меньше 1 означает одну попытку
+func (bpu *BatchPurchaseUpdater) SetRetry(attempts int, backoff time.Duration) {
+	bpu.mu.Lock()
+	bpu.retryAttempts = max(attempts, 1)
+	bpu.retryBackoff = backoff
+	bpu.mu.Unlock()
+}
+
+// SetDrainTimeout задает, сколько Close дописывает очередь недоставленных
+func (bpu *BatchPurchaseUpdater) SetDrainTimeout(timeout time.Duration) {
+	bpu.mu.Lock()
+	bpu.drainTimeout = timeout
+	bpu.mu.Unlock()
+}
+
+// SetLostHandler задает обработчик покупок из очереди, которые так и не записались:
+// ответ покупателю уже ушел, поэтому исправить можно только кеш. sold означает, что лот
+// продан в БД другому, иначе он в БД свободен. Вызывать до первой Purchase
+func (bpu *BatchPurchaseUpdater) SetLostHandler(handler func(purchase ItemPurchase, sold bool)) {
+	bpu.mu.Lock()
+	bpu.onLost = handler
+	bpu.mu.Unlock()
+}
+
+// DeferredItems возвращает лоты распродажи, чьи покупки стоят в очереди недоставленных
+// или покинули ее не раньше since: БД, прочитанная после since, могла их еще не увидеть.
+// Покупки, покинувшие очередь раньше since, забываются
+func (bpu *BatchPurchaseUpdater) DeferredItems(saleID int64, since time.Time) map[int64]bool {
+	bpu.deferredMu.Lock()
+	defer bpu.deferredMu.Unlock()
+
+	items := make(map[int64]bool)
+	for key, left := range bpu.deferred {
+		if !left.IsZero() && left.Before(since) {
+			delete(bpu.deferred, key)
+			continue
+		}
+		if key.saleID == saleID {
+			items[key.itemID] = true
+		}
+	}
+	return items
+}
+
+// Stats возвращает счетчики повторов и глубину очереди недоставленных
+func (bpu *BatchPurchaseUpdater) Stats() PurchaseUpdaterStats {
+	return PurchaseUpdaterStats{
+		Retries:         bpu.stats.retries.Load(),
+		DeadLetterDepth: bpu.stats.depth.Load(),
+		DeadLettered:    bpu.stats.deadLettered.Load(),
+		Recovered:       bpu.stats.recovered.Load(),
+		Lost:            bpu.stats.lost.Load(),
 	}
 }
 
-// Purchase добавляет покупку в буфер и ждет результата
+// Purchase добавляет покупку в буфер и ждет результата.
+// ErrItemNotAvailable означает, что лот уже куплен в БД, ErrPurchaseDeferred - покупка
+// будет записана в фоне, остальные ошибки - сбой БД
 func (bpu *BatchPurchaseUpdater) Purchase(saleID, itemID, userID int64) error {
 	bpu.mu.Lock()
 
+	if bpu.closed {
+		bpu.mu.Unlock()
+		return ErrUpdaterClosed
+	}
+
 	// Создаем канал для получения результата
 	resultChan := make(chan error, 1)
 
@@ -260,13 +417,9 @@ func (bpu *BatchPurchaseUpdater) Purchase(saleID, itemID, userID int64) error {
 		bpu.mu.Unlock()
 	}
 
-	// Ждем результата
-	select {
-	case err := <-resultChan:
-		return err
-	case <-bpu.ctx.Done():
-		return bpu.ctx.Err()
-	}
+	// Ждем результата. Принятая покупка всегда получает ответ: Close выполняет
+	// остаток буфера и дожидается фоновых пачек, прежде чем отменить контекст
+	return <-resultChan
 }
 
 // flushLocked выполняет обновление (должен вызываться под мьютексом)
@@ -289,25 +442,168 @@ func (bpu *BatchPurchaseUpdater) flushLocked() {
 	bpu.buffer = bpu.buffer[:0]
 
 	// Выполняем обновление в отдельной горутине
+	bpu.inFlight.Add(1)
 	go func() {
-		// Извлекаем покупки
-		purchases := make([]ItemPurchase, len(pendingPurchases))
-		for i, pp := range pendingPurchases {
-			purchases[i] = pp.purchase
+		defer bpu.inFlight.Done()
+		bpu.executeBatch(pendingPurchases)
+	}()
+}
+
+// executeBatch выполняет пакетную покупку и отправляет каждому ожидающему его собственный результат
+func (bpu *BatchPurchaseUpdater) executeBatch(pending []pendingPurchase) error {
+	// Извлекаем покупки
+	purchases := make([]ItemPurchase, len(pending))
+	for i, pp := range pending {
+		purchases[i] = pp.purchase
+	}
+
+	purchased, err := bpu.purchaseWithRetry(purchases)
+
+	// Пачка так и не записалась: покупки уходят в очередь недоставленных, пока в ней есть место
+	deferred := err != nil && bpu.deadLetter(purchases)
+
+	for _, pp := range pending {
+		result := err
+		if deferred {
+			result = fmt.Errorf("%w: %w", ErrPurchaseDeferred, err)
+		} else if err == nil && !purchased[purchaseKey{saleID: pp.purchase.SaleID, itemID: pp.purchase.ItemID}] {
+			// Остальные покупки пачки прошли, не найденный лот уже куплен
+			result = fmt.Errorf("%w: sale_id=%d, item_id=%d", ErrItemNotAvailable, pp.purchase.SaleID, pp.purchase.ItemID)
+		}
+
+		// Канал буферизован на один результат, отправка не блокируется
+		pp.result <- result
+	}
+
+	return err
+}
+
+// purchaseWithRetry выполняет пачку, повторяя ее при сбое БД с удваивающейся паузой
+func (bpu *BatchPurchaseUpdater) purchaseWithRetry(purchases []ItemPurchase) (map[purchaseKey]bool, error) {
+	bpu.mu.Lock()
+	attempts, backoff := bpu.retryAttempts, bpu.retryBackoff
+	bpu.mu.Unlock()
+
+	for attempt := 1; ; attempt++ {
+		purchased, err := bpu.repo.purchaseBatch(bpu.ctx, purchases)
+		// Отмена контекста не лечится повтором
+		if err == nil || attempt >= attempts || errors.Is(err, context.Canceled) {
+			return purchased, err
+		}
+
+		bpu.stats.retries.Add(1)
+		select {
+		case <-bpu.ctx.Done():
+			return nil, err
+		case <-time.After(backoff):
 		}
+		backoff *= 2
+	}
+}
+
+// deadLetter ставит покупки в очередь недоставленных целиком или не ставит ни одной
+func (bpu *BatchPurchaseUpdater) deadLetter(purchases []ItemPurchase) bool {
+	// Места должно хватить на всю пачку, иначе часть ее ответов разошлась бы с остальными.
+	// Производители пачек работают параллельно, поэтому место резервируется счетчиком
+	if bpu.stats.depth.Add(int64(len(purchases))) > deadLetterSize {
+		bpu.stats.depth.Add(-int64(len(purchases)))
+		return false
+	}
+
+	bpu.deferredMu.Lock()
+	for _, purchase := range purchases {
+		bpu.deferred[purchaseKey{saleID: purchase.SaleID, itemID: purchase.ItemID}] = time.Time{}
+	}
+	bpu.deferredMu.Unlock()
+
+	for _, purchase := range purchases {
+		bpu.deadLetters <- purchase
+	}
+	bpu.stats.deadLettered.Add(int64(len(purchases)))
+	log.Printf("⚠️  %d purchases moved to the dead-letter queue", len(purchases))
+	return true
+}
 
-		// Выполняем пакетную покупку
-		err := bpu.repo.BatchPurchaseItem(bpu.ctx, purchases)
+// deadLetterWorker записывает покупки из очереди недоставленных пачками до batchSize
+func (bpu *BatchPurchaseUpdater) deadLetterWorker() {
+	defer close(bpu.deadLetterDone)
 
-		// Отправляем результат всем ожидающим
-		for _, pp := range pendingPurchases {
+	for purchase := range bpu.deadLetters {
+		batch := []ItemPurchase{purchase}
+	gather:
+		for len(batch) < bpu.batchSize {
 			select {
-			case pp.result <- err:
-			case <-bpu.ctx.Done():
-				return
+			case next, ok := <-bpu.deadLetters:
+				if !ok {
+					break gather
+				}
+				batch = append(batch, next)
+			default:
+				break gather
 			}
 		}
-	}()
+		bpu.retryDeadLetters(batch)
+	}
+}
+
+// retryDeadLetters повторяет пачку из очереди, пока она не запишется или не истечет срок дописывания в Close
+func (bpu *BatchPurchaseUpdater) retryDeadLetters(batch []ItemPurchase) {
+	defer bpu.stats.depth.Add(-int64(len(batch)))
+	defer bpu.leaveQueue(batch)
+
+	bpu.mu.Lock()
+	backoff := bpu.retryBackoff
+	bpu.mu.Unlock()
+
+	for {
+		purchased, err := bpu.repo.purchaseBatch(bpu.ctx, batch)
+		if err == nil {
+			for _, p := range batch {
+				if purchased[purchaseKey{saleID: p.SaleID, itemID: p.ItemID}] {
+					bpu.stats.recovered.Add(1)
+					continue
+				}
+				// Пока покупка ждала, лот продали в БД другому
+				bpu.stats.lost.Add(1)
+				log.Printf("❌ Dead-lettered purchase lost, lot already sold: sale_id=%d, item_id=%d, user_id=%d", p.SaleID, p.ItemID, p.UserID)
+				bpu.reportLost(p, true)
+			}
+			return
+		}
+
+		select {
+		case <-bpu.stopping:
+			bpu.stats.lost.Add(int64(len(batch)))
+			log.Printf("❌ %d dead-lettered purchases lost on close: %v", len(batch), err)
+			for _, p := range batch {
+				bpu.reportLost(p, false)
+			}
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxDeadLetterBackoff)
+	}
+}
+
+// leaveQueue отмечает время, когда покупки пачки покинули очередь недоставленных
+func (bpu *BatchPurchaseUpdater) leaveQueue(batch []ItemPurchase) {
+	now := time.Now()
+	bpu.deferredMu.Lock()
+	for _, p := range batch {
+		bpu.deferred[purchaseKey{saleID: p.SaleID, itemID: p.ItemID}] = now
+	}
+	bpu.deferredMu.Unlock()
+}
+
+// reportLost передает незаписанную покупку обработчику SetLostHandler, если он задан
+func (bpu *BatchPurchaseUpdater) reportLost(purchase ItemPurchase, sold bool) {
+	bpu.mu.Lock()
+	handler := bpu.onLost
+	bpu.mu.Unlock()
+
+	if handler != nil {
+		handler(purchase, sold)
+	}
 }
 
 // Flush принудительно выполняет все накопленные покупки
@@ -335,32 +631,41 @@ func (bpu *BatchPurchaseUpdater) Flush() error {
 	bpu.mu.Unlock()
 
 	// Выполняем обновление
-	purchases := make([]ItemPurchase, len(allPending))
-	for i, pp := range allPending {
-		purchases[i] = pp.purchase
-	}
-
-	err := bpu.repo.BatchPurchaseItem(bpu.ctx, purchases)
-
-	// Отправляем результат всем ожидающим
-	for _, pp := range allPending {
-		select {
-		case pp.result <- err:
-		case <-bpu.ctx.Done():
-			return bpu.ctx.Err()
-		}
-	}
-
-	return err
+	return bpu.executeBatch(allPending)
 }
 
 // Close завершает работу батчера
 func (bpu *BatchPurchaseUpdater) Close() error {
-	if err := bpu.Flush(); err != nil {
-		return err
+	bpu.mu.Lock()
+	if bpu.closed {
+		bpu.mu.Unlock()
+		return nil
+	}
+	bpu.closed = true
+	bpu.mu.Unlock()
+
+	// Покупки, принятые до закрытия, выполняются и получают ответ до отмены контекста
+	err := bpu.Flush()
+	bpu.inFlight.Wait()
+
+	// Новых недоставленных больше не будет: очередь дописывается не дольше drainTimeout,
+	// затем оставшиеся пачки получают по последней попытке
+	bpu.mu.Lock()
+	drainTimeout := bpu.drainTimeout
+	bpu.mu.Unlock()
+
+	close(bpu.deadLetters)
+	drain := time.NewTimer(drainTimeout)
+	select {
+	case <-bpu.deadLetterDone:
+	case <-drain.C:
+		close(bpu.stopping)
+		<-bpu.deadLetterDone
 	}
+	drain.Stop()
+
 	bpu.cancel()
-	return nil
+	return err
 }
 
 // GetAvailableItems возвращает доступные лоты для покупки
@@ -373,7 +678,7 @@ func (r *SaleItemsRepository) GetAvailableItems(ctx context.Context, saleID int6
 		ORDER BY item_id 
 		LIMIT $2`
 
-	rows, err := r.db.QueryContext(ctx, query, saleID, limit)
+	rows, err := r.server.QueryReplicaContext(ctx, query, saleID, limit)
 	if err != nil {
 		return nil, fmt.Errorf("query available items: %w", err)
 	}
@@ -406,7 +711,7 @@ func (r *SaleItemsRepository) GetPurchasedItems(ctx context.Context, userID int6
 		WHERE purchased_by = $1 
 		ORDER BY purchased_at DESC`
 
-	rows, err := r.db.QueryContext(ctx, query, userID)
+	rows, err := r.server.QueryReplicaContext(ctx, query, userID)
 	if err != nil {
 		return nil, fmt.Errorf("query purchased items: %w", err)
 	}
@@ -430,6 +735,75 @@ func (r *SaleItemsRepository) GetPurchasedItems(ctx context.Context, userID int6
 	return items, nil
 }
 
+// GetPurchasedItemsPaged возвращает страницу купленных лотов пользователя (новые первыми) и их общее количество.
+// Пустая страница - пустой срез, а не nil
+func (r *SaleItemsRepository) GetPurchasedItemsPaged(ctx context.Context, userID int64, limit, offset int) ([]SaleItem, int64, error) {
+	if limit <= 0 || offset < 0 {
+		return nil, 0, fmt.Errorf("invalid page: limit=%d offset=%d", limit, offset)
+	}
+
+	total, err := r.countPurchasedItems(ctx, userID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	items := []SaleItem{}
+	if total == 0 || int64(offset) >= total {
+		return items, total, nil
+	}
+
+	// id разрешает равные purchased_at, чтобы страницы не пересекались
+	query := `
+		SELECT id, sale_id, sale_start_hour, item_id, item_name, image_url, 
+		       purchased, purchased_by, purchased_at
+		FROM sale_items 
+		WHERE purchased_by = $1 
+		ORDER BY purchased_at DESC, id DESC
+		LIMIT $2 OFFSET $3`
+
+	rows, err := r.server.QueryReplicaContext(ctx, query, userID, limit, offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("query purchased items page: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var item SaleItem
+		err := rows.Scan(&item.ID, &item.SaleID, &item.SaleStartHour, &item.ItemID,
+			&item.ItemName, &item.ImageURL, &item.Purchased, &item.PurchasedBy, &item.PurchasedAt)
+		if err != nil {
+			return nil, 0, fmt.Errorf("scan item: %w", err)
+		}
+		items = append(items, item)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("rows error: %w", err)
+	}
+
+	return items, total, nil
+}
+
+// countPurchasedItems считает купленные пользователем лоты на реплике, как и сами страницы
+func (r *SaleItemsRepository) countPurchasedItems(ctx context.Context, userID int64) (int64, error) {
+	rows, err := r.server.QueryReplicaContext(ctx, `SELECT COUNT(*) FROM sale_items WHERE purchased_by = $1`, userID)
+	if err != nil {
+		return 0, fmt.Errorf("count purchased items: %w", err)
+	}
+	defer rows.Close()
+
+	var total int64
+	if rows.Next() {
+		if err := rows.Scan(&total); err != nil {
+			return 0, fmt.Errorf("scan purchased items count: %w", err)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("rows error: %w", err)
+	}
+	return total, nil
+}
+
 // GetUserPurchaseStats возвращает статистику покупок пользователей для восстановления кеша
 func (r *SaleItemsRepository) GetPurchaseStats(ctx context.Context, saleID int64) ([]megacache.SaleItems, error) {
 	query := `
@@ -460,15 +834,18 @@ func (r *SaleItemsRepository) GetPurchaseStats(ctx context.Context, saleID int64
 	return stats, nil
 }
 
-// GetSoldItemsForSale возвращает проданные лоты для конкретной продажи
+// GetSoldItemsForSale возвращает проданные лоты для конкретной продажи, читает с реплики
 func (r *SaleItemsRepository) GetSoldItemsForSale(ctx context.Context, saleID int64) (map[int64]bool, error) {
-	query := `
+	return r.soldItemsForSale(ctx, r.server.QueryReplicaContext, saleID)
+}
+
+// soldItemsForSale читает проданные лоты через query: реплику для API или основную БД для восстановления
+func (r *SaleItemsRepository) soldItemsForSale(ctx context.Context, query func(context.Context, string, ...interface{}) (*sql.Rows, error), saleID int64) (map[int64]bool, error) {
+	rows, err := query(ctx, `
 		SELECT item_id
 		FROM sale_items 
 		WHERE sale_id = $1 AND purchased = true
-		ORDER BY item_id`
-
-	rows, err := r.db.QueryContext(ctx, query, saleID)
+		ORDER BY item_id`, saleID)
 	if err != nil {
 		return nil, fmt.Errorf("query sold items: %w", err)
 	}
@@ -491,6 +868,66 @@ func (r *SaleItemsRepository) GetSoldItemsForSale(ctx context.Context, saleID in
 	return soldItems, nil
 }
 
+// GetAllStatuses возвращает флаг purchased для всех лотов распродажи
+func (r *SaleItemsRepository) GetAllStatuses(ctx context.Context, saleID int64) (map[int64]bool, error) {
+	query := `
+		SELECT item_id, purchased
+		FROM sale_items 
+		WHERE sale_id = $1`
+
+	rows, err := r.db.QueryContext(ctx, query, saleID)
+	if err != nil {
+		return nil, fmt.Errorf("query lot statuses: %w", err)
+	}
+	defer rows.Close()
+
+	statuses := make(map[int64]bool)
+	for rows.Next() {
+		var itemID int64
+		var purchased bool
+		if err := rows.Scan(&itemID, &purchased); err != nil {
+			return nil, fmt.Errorf("scan lot status: %w", err)
+		}
+		statuses[itemID] = purchased
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return statuses, nil
+}
+
+// GetLotInfo возвращает название и картинку всех лотов распродажи
+func (r *SaleItemsRepository) GetLotInfo(ctx context.Context, saleID int64) (map[int64]megacache.LotInfo, error) {
+	query := `
+		SELECT item_id, item_name, image_url
+		FROM sale_items 
+		WHERE sale_id = $1`
+
+	rows, err := r.db.QueryContext(ctx, query, saleID)
+	if err != nil {
+		return nil, fmt.Errorf("query lot info: %w", err)
+	}
+	defer rows.Close()
+
+	info := make(map[int64]megacache.LotInfo)
+	for rows.Next() {
+		var itemID int64
+		var lotInfo megacache.LotInfo
+		if err := rows.Scan(&itemID, &lotInfo.Name, &lotInfo.ImageURL); err != nil {
+			return nil, fmt.Errorf("scan lot info: %w", err)
+		}
+		info[itemID] = lotInfo
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return info, nil
+}
+
 // GetSaleItemsCount возвращает общее количество лотов в продаже
 func (r *SaleItemsRepository) GetSaleItemsCount(ctx context.Context, saleID int64) (int64, error) {
 	query := `SELECT COUNT(*) FROM sale_items WHERE sale_id = $1`
@@ -540,6 +977,24 @@ func (c *CacheDataConverter) ConvertCheckoutRecordsToCache(records []CheckoutRec
 	return checkouts
 }
 
+// ConvertCacheToCheckoutRecords преобразует резервы кеша в DB записи распродажи saleID
+func (c *CacheDataConverter) ConvertCacheToCheckoutRecords(saleID int64, checkouts []megacache.Checkout) []CheckoutRecord {
+	records := make([]CheckoutRecord, len(checkouts))
+
+	for i, checkout := range checkouts {
+		records[i] = CheckoutRecord{
+			SaleID:    saleID,
+			UserID:    checkout.UserID,
+			ItemID:    checkout.LotIndex,
+			Code:      checkout.Code,
+			CreatedAt: checkout.CreatedAt,
+			ExpiresAt: checkout.ExpiresAt,
+		}
+	}
+
+	return records
+}
+
 // ===== Пример использования для восстановления кеша =====
 
 // CacheRecoveryService объединяет логику восстановления кеша
@@ -547,6 +1002,17 @@ type CacheRecoveryService struct {
 	checkoutRepo  *CheckoutRepository
 	saleItemsRepo *SaleItemsRepository
 	converter     *CacheDataConverter
+
+	// KeepExpired оставляет в БД истекшие резервации текущей распродажи, строки прошлых не удаляются в любом случае
+	KeepExpired bool
+
+	// Deferred покупки из очереди недоставленных: сверка не откатывает их лоты, хотя в БД их еще нет
+	Deferred DeferredPurchases
+}
+
+// DeferredPurchases сообщает лоты, чьи подтвержденные в кеше покупки еще не записаны в БД
+type DeferredPurchases interface {
+	DeferredItems(saleID int64, since time.Time) map[int64]bool
 }
 
 // NewCacheRecoveryService создает новый сервис восстановления
@@ -561,11 +1027,18 @@ func NewCacheRecoveryService(checkoutRepo *CheckoutRepository, saleItemsRepo *Sa
 // RecoverCache восстанавливает кеш из базы данных
 func (s *CacheRecoveryService) RecoverCache(ctx context.Context, cache *megacache.Megacache, saleID int64) error {
 	// 1. Загружаем активные резервации
-	reservationRecords, err := s.checkoutRepo.GetActiveReservations(ctx)
+	// Только резервации текущей распродажи: после рестарта в БД могут остаться резервы прошлой
+	reservationRecords, err := s.checkoutRepo.GetActiveReservations(ctx, saleID)
 	if err != nil {
 		return fmt.Errorf("load reservations: %w", err)
 	}
 
+	// Резервы уже купленных лотов не загружаем: процесс мог упасть между коммитом покупки и ConfirmPurchase
+	reservationRecords, err = dropPurchasedReservations(ctx, s.saleItemsRepo, reservationRecords, saleID)
+	if err != nil {
+		return err
+	}
+
 	// Конвертируем в формат кеша
 	reservations := s.converter.ConvertCheckoutRecordsToCache(reservationRecords)
 
@@ -584,20 +1057,99 @@ func (s *CacheRecoveryService) RecoverCache(ctx context.Context, cache *megacach
 		return fmt.Errorf("load user data to cache: %w", err)
 	}
 
-	// 3. Очищаем истекшие резервации из БД
-	// cleaned, err := s.checkoutRepo.CleanupExpiredReservations(ctx)
-	// if err != nil {
-	// 	return fmt.Errorf("cleanup expired reservations: %w", err)
-	// }
+	// 3. Загружаем названия и картинки лотов для read API
+	if err := loadLotInfo(ctx, s.saleItemsRepo, cache, saleID); err != nil {
+		return err
+	}
 
-	// cache.
+	// 4. Очищаем истекшие резервации текущей распродажи, активные уже загружены в кеш
+	cleaned := int64(0)
+	if !s.KeepExpired {
+		cleaned, err = s.checkoutRepo.CleanupExpiredReservations(ctx, saleID)
+		if err != nil {
+			return fmt.Errorf("cleanup expired reservations: %w", err)
+		}
+	}
 
-	// fmt.Printf("Cache recovery completed: loaded %d reservations, %d users, cleaned %d expired\n",
-	// 	len(reservations), len(userData), cleaned)
+	log.Printf("Cache recovery completed: loaded %d reservations, %d users, cleaned %d expired",
+		len(reservations), len(userData), cleaned)
 
 	return nil
 }
 
+// dropPurchasedReservations отбрасывает резервы, чьи лоты в основной БД уже проданы.
+// Такой лот помечается проданным и учитывается в countLots через LoadUserDataFromDB, а не висит активным резервом
+func dropPurchasedReservations(ctx context.Context, source soldItemsSource, records []CheckoutRecord, saleID int64) ([]CheckoutRecord, error) {
+	if len(records) == 0 {
+		return records, nil
+	}
+
+	soldItems, err := source.primarySoldItems(ctx, saleID)
+	if err != nil {
+		return nil, fmt.Errorf("load sold items: %w", err)
+	}
+
+	kept := records[:0]
+	for _, record := range records {
+		if !soldItems[record.ItemID] {
+			kept = append(kept, record)
+		}
+	}
+
+	if dropped := len(records) - len(kept); dropped > 0 {
+		log.Printf("🔧 Dropped %d reservations of sale %d whose lots are already purchased", dropped, saleID)
+	}
+
+	return kept, nil
+}
+
+// purchaseStatsSource источник подтвержденных покупок распродажи
+type purchaseStatsSource interface {
+	GetPurchaseStats(ctx context.Context, saleID int64) ([]megacache.SaleItems, error)
+}
+
+// lotInfoSource источник метаданных лотов распродажи
+type lotInfoSource interface {
+	GetLotInfo(ctx context.Context, saleID int64) (map[int64]megacache.LotInfo, error)
+}
+
+// purchaseRecoverySource источник данных для восстановления кеша только из sale_items
+type purchaseRecoverySource interface {
+	purchaseStatsSource
+	lotInfoSource
+}
+
+// loadLotInfo загружает метаданные лотов в кеш
+func loadLotInfo(ctx context.Context, source lotInfoSource, cache *megacache.Megacache, saleID int64) error {
+	info, err := source.GetLotInfo(ctx, saleID)
+	if err != nil {
+		return fmt.Errorf("load lot info: %w", err)
+	}
+
+	cache.LoadLotInfo(info)
+	return nil
+}
+
+// RecoverCacheFromPurchases восстанавливает кеш только из sale_items.
+// Используется, когда checkout не сохраняются в БД: незавершенные резервы теряются при рестарте
+func (s *CacheRecoveryService) RecoverCacheFromPurchases(ctx context.Context, cache *megacache.Megacache, saleID int64) error {
+	return recoverCacheFromPurchases(ctx, s.saleItemsRepo, cache, saleID)
+}
+
+// recoverCacheFromPurchases загружает проданные лоты и счетчики пользователей из произвольного источника
+func recoverCacheFromPurchases(ctx context.Context, source purchaseRecoverySource, cache *megacache.Megacache, saleID int64) error {
+	userData, err := source.GetPurchaseStats(ctx, saleID)
+	if err != nil {
+		return fmt.Errorf("load user stats: %w", err)
+	}
+
+	if err := cache.LoadUserDataFromDB(userData); err != nil {
+		return fmt.Errorf("load user data to cache: %w", err)
+	}
+
+	return loadLotInfo(ctx, source, cache, saleID)
+}
+
 // RecoverCacheWithSoldItems восстанавливает кеш с учетом проданных лотов
 func (s *CacheRecoveryService) RecoverCacheWithSoldItems(ctx context.Context, cache *megacache.Megacache, saleID int64) error {
 	// Сначала стандартное восстановление
@@ -606,16 +1158,75 @@ func (s *CacheRecoveryService) RecoverCacheWithSoldItems(ctx context.Context, ca
 		return err
 	}
 
-	// Дополнительно загружаем проданные лоты для корректировки статусов
-	soldItems, err := s.saleItemsRepo.GetSoldItemsForSale(ctx, saleID)
+	return applySoldItems(ctx, s.saleItemsRepo, cache, saleID)
+}
+
+// soldItemsSource источник всех проданных лотов распродажи из основной БД
+type soldItemsSource interface {
+	primarySoldItems(ctx context.Context, saleID int64) (map[int64]bool, error)
+}
+
+// primarySoldItems читает проданные лоты из основной БД:
+// отстающая реплика вернула бы уже проданные лоты как свободные
+func (r *SaleItemsRepository) primarySoldItems(ctx context.Context, saleID int64) (map[int64]bool, error) {
+	return r.soldItemsForSale(ctx, r.server.QueryContext, saleID)
+}
+
+// applySoldItems помечает проданными лоты, которые GetPurchaseStats пропустил,
+// например купленные с purchased_by = NULL. Уже учтенные лоты не считаются повторно
+func applySoldItems(ctx context.Context, source soldItemsSource, cache *megacache.Megacache, saleID int64) error {
+	soldItems, err := source.primarySoldItems(ctx, saleID)
 	if err != nil {
 		return fmt.Errorf("load sold items: %w", err)
 	}
 
-	// Здесь можно добавить логику для установки статуса "продан"
-	// для соответствующих лотов в кеше, если такой метод будет добавлен
+	itemIDs := make([]int64, 0, len(soldItems))
+	for itemID, sold := range soldItems {
+		if sold {
+			itemIDs = append(itemIDs, itemID)
+		}
+	}
+
+	if marked := cache.MarkLotsSold(itemIDs); marked > 0 {
+		log.Printf("🔧 Marked %d sold lots of sale %d missed by purchase stats", marked, saleID)
+	}
+
+	return nil
+}
 
-	fmt.Printf("Loaded %d sold items for cache correction\n", len(soldItems))
+// lotStatusSource источник флагов purchased для сверки статусов лотов
+type lotStatusSource interface {
+	GetAllStatuses(ctx context.Context, saleID int64) (map[int64]bool, error)
+}
+
+// ReconcileLotStatuses исправляет только статусы лотов и countLots в кеше по данным БД.
+// Резервы и счетчики пользователей не трогаются, безопасно вызывать во время обслуживания запросов
+func (s *CacheRecoveryService) ReconcileLotStatuses(ctx context.Context, cache *megacache.Megacache, saleID int64) error {
+	return reconcileLotStatuses(ctx, s.saleItemsRepo, s.Deferred, cache, saleID)
+}
+
+// reconcileLotStatuses выполняет сверку статусов лотов из произвольного источника, deferred может быть nil
+func reconcileLotStatuses(ctx context.Context, source lotStatusSource, deferred DeferredPurchases, cache *megacache.Megacache, saleID int64) error {
+	// Момент чтения фиксируем ДО запроса: покупки, подтвержденные позже, не откатываются
+	asOf := time.Now()
+
+	statuses, err := source.GetAllStatuses(ctx, saleID)
+	if err != nil {
+		return fmt.Errorf("load lot statuses: %w", err)
+	}
+
+	// Отложенная покупка подтверждена в кеше раньше, чем попала в БД: ее лот не сверяется
+	if deferred != nil {
+		for itemID := range deferred.DeferredItems(saleID, asOf) {
+			delete(statuses, itemID)
+		}
+	}
+
+	markedSold, markedAvailable := cache.ApplyLotStatuses(statuses, asOf)
+	if markedSold > 0 || markedAvailable > 0 {
+		log.Printf("🔧 Lot statuses reconciled for sale %d: %d marked sold, %d marked available",
+			saleID, markedSold, markedAvailable)
+	}
 
 	return nil
 }
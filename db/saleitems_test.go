@@ -0,0 +1,858 @@
+package db
+
+import (
+	"contest_notcoin/leaktest"
+	"contest_notcoin/megacache"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestServer connects to the PostgreSQL from TEST_DB_HOST or skips the test
+func newTestServer(t testing.TB) *Server {
+	t.Helper()
+
+	host := os.Getenv("TEST_DB_HOST")
+	if host == "" {
+		t.Skip("TEST_DB_HOST is not set, skipping integration test")
+	}
+
+	config := DefaultConfig()
+	config.Host = host
+	config.PoolSampleInterval = 0
+
+	s, err := Connect(config)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// TestCreateNewSaleItemsCount tests that create_new_sale generates the requested number of lots
+func TestCreateNewSaleItemsCount(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+
+	// Everything runs in a rolled back transaction so existing sales are untouched
+	tx, err := s.DB().BeginTx(ctx, nil)
+	require.NoError(t, err)
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM sale_items")
+	require.NoError(t, err)
+
+	createSale := func(itemsCount int) int64 {
+		var saleID int64
+		err := tx.QueryRowContext(ctx, "SELECT create_new_sale($1, $2, $3)",
+			itemsCount, "Item {item}", "img/{sale}/{item}").Scan(&saleID)
+		require.NoError(t, err)
+		return saleID
+	}
+	countItems := func(saleID int64) int {
+		var count int
+		err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM sale_items WHERE sale_id = $1", saleID).Scan(&count)
+		require.NoError(t, err)
+		return count
+	}
+
+	firstSale := createSale(5)
+	assert.Equal(t, 5, countItems(firstSale))
+
+	// Move the first sale an hour back so the next call creates a new sale
+	_, err = tx.ExecContext(ctx, "UPDATE sale_items SET sale_start_hour = sale_start_hour - INTERVAL '1 hour'")
+	require.NoError(t, err)
+
+	secondSale := createSale(12)
+	require.NotEqual(t, firstSale, secondSale)
+	assert.Equal(t, 12, countItems(secondSale))
+
+	var name, imageURL string
+	err = tx.QueryRowContext(ctx, "SELECT item_name, image_url FROM sale_items WHERE sale_id = $1 AND item_id = 3",
+		secondSale).Scan(&name, &imageURL)
+	require.NoError(t, err)
+	assert.Equal(t, "Item 3", name)
+	assert.Equal(t, fmt.Sprintf("img/%d/3", secondSale), imageURL)
+}
+
+// fakeLotStatusSource returns fixed purchased flags
+type fakeLotStatusSource struct {
+	statuses map[int64]bool
+}
+
+func (f *fakeLotStatusSource) GetAllStatuses(ctx context.Context, saleID int64) (map[int64]bool, error) {
+	return f.statuses, nil
+}
+
+// TestReconcileLotStatuses tests that only drifted lot statuses are corrected
+func TestReconcileLotStatuses(t *testing.T) {
+	cache := megacache.NewMegacache(10, 10)
+	defer cache.Close()
+
+	// Lot 0: active reservation
+	reserved, err := cache.Checkout(1, 0)
+	require.NoError(t, err)
+
+	// Lot 2: purchase confirmed in cache that the DB lost
+	lost, err := cache.Checkout(2, 2)
+	require.NoError(t, err)
+	_, ok := cache.TryPurchase(lost.Code)
+	require.True(t, ok)
+	cache.ConfirmPurchase(lost.Code)
+
+	// Lot 3: purchase in flight, not in DB yet
+	inFlight, err := cache.Checkout(3, 3)
+	require.NoError(t, err)
+	_, ok = cache.TryPurchase(inFlight.Code)
+	require.True(t, ok)
+
+	// DB: lot 1 sold elsewhere, everything else unsold
+	source := &fakeLotStatusSource{statuses: map[int64]bool{}}
+	for i := int64(0); i < 10; i++ {
+		source.statuses[i] = i == 1
+	}
+
+	require.NoError(t, reconcileLotStatuses(context.Background(), source, nil, cache, 1))
+
+	wantStatuses := map[int64]megacache.LotStatus{
+		0: megacache.StatusReserved,  // reservation untouched
+		1: megacache.StatusSold,      // drift corrected from DB
+		2: megacache.StatusAvailable, // drift corrected from DB
+		3: megacache.StatusSold,      // in-flight purchase kept
+		4: megacache.StatusAvailable,
+	}
+	for itemID, want := range wantStatuses {
+		status, err := cache.GetLotStatus(itemID)
+		require.NoError(t, err)
+		assert.Equal(t, want, status, "lot %d", itemID)
+	}
+
+	// Reservations and user counts are left alone
+	_, exists := cache.GetCheckoutInfo(reserved.Code)
+	assert.True(t, exists)
+	count, _ := cache.GetPurchaseCount(2)
+	assert.Equal(t, int64(1), count)
+	count, _ = cache.GetPurchaseCount(3)
+	assert.Equal(t, int64(1), count)
+}
+
+// TestReconcileLotStatusesKeepsDeferred tests that a purchase confirmed in the cache but still dead-lettered is not reverted
+func TestReconcileLotStatusesKeepsDeferred(t *testing.T) {
+	cache := megacache.NewMegacache(10, 10)
+	defer cache.Close()
+
+	repo := &flakyBatchPurchaser{purchased: make(map[int64]bool)}
+	repo.failing.Store(true)
+	bpu := newBatchPurchaseUpdater(repo, 1, time.Hour)
+	bpu.SetRetry(1, time.Hour)
+	bpu.SetDrainTimeout(0)
+	defer bpu.Close()
+
+	checkout, err := cache.Checkout(1, 2)
+	require.NoError(t, err)
+	_, ok := cache.TryPurchase(checkout.Code)
+	require.True(t, ok)
+	require.ErrorIs(t, bpu.Purchase(1, 2, 1), ErrPurchaseDeferred)
+	require.NoError(t, cache.ConfirmPurchase(checkout.Code))
+
+	// The DB does not have the purchase yet
+	unsold := func() *fakeLotStatusSource {
+		return &fakeLotStatusSource{statuses: map[int64]bool{2: false}}
+	}
+
+	require.NoError(t, reconcileLotStatuses(context.Background(), unsold(), bpu, cache, 1))
+	status, err := cache.GetLotStatus(2)
+	require.NoError(t, err)
+	assert.Equal(t, megacache.StatusSold, status, "a queued purchase must not be sold twice")
+
+	// Without the queue the same lot would be reverted
+	require.NoError(t, reconcileLotStatuses(context.Background(), unsold(), nil, cache, 1))
+	status, err = cache.GetLotStatus(2)
+	require.NoError(t, err)
+	assert.Equal(t, megacache.StatusAvailable, status)
+}
+
+// fakePurchaseStatsSource returns fixed confirmed purchases and lot metadata
+type fakePurchaseStatsSource struct {
+	items []megacache.SaleItems
+	info  map[int64]megacache.LotInfo
+}
+
+func (f *fakePurchaseStatsSource) GetPurchaseStats(ctx context.Context, saleID int64) ([]megacache.SaleItems, error) {
+	return f.items, nil
+}
+
+func (f *fakePurchaseStatsSource) GetLotInfo(ctx context.Context, saleID int64) (map[int64]megacache.LotInfo, error) {
+	return f.info, nil
+}
+
+// fakeSoldItemsSource returns fixed sold flags
+type fakeSoldItemsSource struct {
+	sold map[int64]bool
+}
+
+func (f *fakeSoldItemsSource) primarySoldItems(ctx context.Context, saleID int64) (map[int64]bool, error) {
+	return f.sold, nil
+}
+
+// TestApplySoldItems tests that a lot sold without a buyer ends up sold after recovery, without counting known purchases twice
+func TestApplySoldItems(t *testing.T) {
+	cache := megacache.NewMegacache(10, 10)
+	defer cache.Close()
+
+	// Purchase stats only see lots with purchased_by set
+	require.NoError(t, cache.LoadUserDataFromDB([]megacache.SaleItems{
+		{ItemID: 1, Purchased: true, UserID: 7},
+		{ItemID: 2, Purchased: true, UserID: 7},
+	}))
+
+	// Lot 4 was sold with purchased_by = NULL
+	source := &fakeSoldItemsSource{sold: map[int64]bool{1: true, 2: true, 4: true, 5: false}}
+	require.NoError(t, applySoldItems(context.Background(), source, cache, 1))
+
+	for itemID, want := range map[int64]megacache.LotStatus{
+		1: megacache.StatusSold,
+		2: megacache.StatusSold,
+		4: megacache.StatusSold,
+		5: megacache.StatusAvailable,
+	} {
+		status, err := cache.GetLotStatus(itemID)
+		require.NoError(t, err)
+		assert.Equal(t, want, status, "lot %d", itemID)
+	}
+	assert.Equal(t, int64(3), cache.SoldCount())
+}
+
+// TestDropPurchasedReservations reproduces a crash between the DB purchase commit and ConfirmPurchase
+func TestDropPurchasedReservations(t *testing.T) {
+	// Before the crash: lot 3 is bought in cache and committed to DB, ConfirmPurchase never ran
+	before := megacache.NewMegacache(10, 10)
+	defer before.Close()
+	bought, err := before.Checkout(7, 3)
+	require.NoError(t, err)
+	pending, err := before.Checkout(8, 4)
+	require.NoError(t, err)
+	_, ok := before.TryPurchase(bought.Code)
+	require.True(t, ok)
+	require.Equal(t, int64(0), before.SoldCount(), "countLots is only bumped by ConfirmPurchase")
+
+	// After the restart: the checkouts table still holds both reservations as active
+	now := time.Now()
+	records := []CheckoutRecord{
+		{SaleID: 1, UserID: 7, ItemID: 3, Code: bought.Code, CreatedAt: now, ExpiresAt: now.Add(time.Minute)},
+		{SaleID: 1, UserID: 8, ItemID: 4, Code: pending.Code, CreatedAt: now, ExpiresAt: now.Add(time.Minute)},
+	}
+	source := &fakeSoldItemsSource{sold: map[int64]bool{3: true}}
+	records, err = dropPurchasedReservations(context.Background(), source, records, 1)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, pending.Code, records[0].Code)
+
+	cache := megacache.NewMegacache(10, 10)
+	defer cache.Close()
+	cache.LoadReservationsFromDB((&CacheDataConverter{}).ConvertCheckoutRecordsToCache(records))
+	require.NoError(t, cache.LoadUserDataFromDB([]megacache.SaleItems{
+		{ItemID: 3, Purchased: true, UserID: 7},
+	}))
+
+	// The purchased lot is sold and counted once, its reservation is gone
+	status, err := cache.GetLotStatus(3)
+	require.NoError(t, err)
+	assert.Equal(t, megacache.StatusSold, status)
+	assert.Equal(t, int64(1), cache.SoldCount())
+	_, exists := cache.GetCheckoutInfo(bought.Code)
+	assert.False(t, exists, "purchased reservation must not be recovered")
+	count, ok := cache.GetPurchaseCount(7)
+	require.True(t, ok)
+	assert.Equal(t, int64(1), count)
+
+	// The unpaid reservation is still active and can be purchased
+	status, err = cache.GetLotStatus(4)
+	require.NoError(t, err)
+	assert.Equal(t, megacache.StatusReserved, status)
+	assert.Equal(t, 1, cache.GetActiveReservationsCount())
+	_, ok = cache.TryPurchase(pending.Code)
+	assert.True(t, ok)
+}
+
+// TestRecoverCacheFromPurchases tests cache recovery when checkouts are not persisted
+func TestRecoverCacheFromPurchases(t *testing.T) {
+	cache := megacache.NewMegacache(10, 2)
+	defer cache.Close()
+
+	source := &fakePurchaseStatsSource{items: []megacache.SaleItems{
+		{ItemID: 1, Purchased: true, UserID: 7},
+		{ItemID: 2, Purchased: true, UserID: 7},
+		{ItemID: 3, Purchased: true, UserID: 8},
+	}, info: map[int64]megacache.LotInfo{
+		1: {Name: "Item 1", ImageURL: "https://example.com/1.png"},
+		4: {Name: "Item 4", ImageURL: "https://example.com/4.png"},
+	}}
+	require.NoError(t, recoverCacheFromPurchases(context.Background(), source, cache, 1))
+
+	for _, itemID := range []int64{1, 2, 3} {
+		status, err := cache.GetLotStatus(itemID)
+		require.NoError(t, err)
+		assert.Equal(t, megacache.StatusSold, status, "item %d", itemID)
+	}
+	status, err := cache.GetLotStatus(4)
+	require.NoError(t, err)
+	assert.Equal(t, megacache.StatusAvailable, status)
+
+	// Metadata is served from memory alongside the recovered status
+	name, imageURL, status, err := cache.GetLotInfo(1)
+	require.NoError(t, err)
+	assert.Equal(t, "Item 1", name)
+	assert.Equal(t, "https://example.com/1.png", imageURL)
+	assert.Equal(t, megacache.StatusSold, status)
+
+	// In-flight reservations are gone, but purchase limits survive the restart
+	assert.Equal(t, 0, cache.GetActiveReservationsCount())
+	count, ok := cache.GetPurchaseCount(7)
+	require.True(t, ok)
+	assert.Equal(t, int64(2), count)
+	_, err = cache.Checkout(7, 5)
+	assert.Error(t, err, "user 7 already reached the limit")
+	_, err = cache.Checkout(8, 5)
+	assert.NoError(t, err)
+}
+
+// TestRecoverCacheLoadsOnlyCurrentSale tests that reservations of other sales are not recovered
+func TestRecoverCacheLoadsOnlyCurrentSale(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+
+	checkoutRepo, err := NewCheckoutRepository(s)
+	require.NoError(t, err)
+	defer checkoutRepo.Close()
+	saleItemsRepo, err := NewSaleItemsRepository(s)
+	require.NoError(t, err)
+	defer saleItemsRepo.Close()
+
+	// Sale IDs far from real ones so the test doesn't collide with existing data
+	previousSale := time.Now().UnixNano() % 1_000_000_000
+	currentSale := previousSale + 1
+	t.Cleanup(func() {
+		s.ExecContext(context.Background(), "DELETE FROM checkouts WHERE sale_id IN ($1, $2)", previousSale, currentSale)
+	})
+
+	now := time.Now()
+	record := func(saleID, itemID int64) CheckoutRecord {
+		return CheckoutRecord{
+			SaleID:    saleID,
+			UserID:    itemID + 1,
+			ItemID:    itemID,
+			Code:      uuid.New(),
+			CreatedAt: now,
+			ExpiresAt: now.Add(time.Minute),
+		}
+	}
+	stale := record(previousSale, 1)
+	current := record(currentSale, 2)
+	_, err = checkoutRepo.MultiRowInsert(ctx, []CheckoutRecord{stale, current})
+	require.NoError(t, err)
+
+	cache := megacache.NewMegacache(10, 10)
+	defer cache.Close()
+
+	recovery := NewCacheRecoveryService(checkoutRepo, saleItemsRepo)
+	require.NoError(t, recovery.RecoverCache(ctx, cache, currentSale))
+
+	_, exists := cache.GetCheckoutInfo(current.Code)
+	assert.True(t, exists, "current sale reservation must be recovered")
+	_, exists = cache.GetCheckoutInfo(stale.Code)
+	assert.False(t, exists, "previous sale reservation must not be recovered")
+
+	status, err := cache.GetLotStatus(1)
+	require.NoError(t, err)
+	assert.Equal(t, megacache.StatusAvailable, status)
+}
+
+// TestWarmupStatements tests that prepared statements are usable on every warmed connection
+func TestWarmupStatements(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+
+	checkoutRepo, err := NewCheckoutRepository(s)
+	require.NoError(t, err)
+	defer checkoutRepo.Close()
+	saleItemsRepo, err := NewSaleItemsRepository(s)
+	require.NoError(t, err)
+	defer saleItemsRepo.Close()
+
+	conns, err := checkoutRepo.Warmup(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, s.config.StatementWarmupConns, conns)
+	_, err = saleItemsRepo.Warmup(ctx)
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, s.Stats().Idle, conns, "warmed connections must stay in the pool")
+
+	saleID := time.Now().UnixNano() % 1_000_000_000
+	t.Cleanup(func() {
+		s.ExecContext(context.Background(), "DELETE FROM checkouts WHERE sale_id = $1", saleID)
+	})
+
+	// Use the statement concurrently so it runs on many warmed connections at once
+	var wg sync.WaitGroup
+	errs := make(chan error, conns)
+	now := time.Now()
+	for i := 0; i < conns; i++ {
+		wg.Add(1)
+		go func(itemID int64) {
+			defer wg.Done()
+			_, err := checkoutRepo.batchInsertStmt.ExecContext(ctx, saleID, itemID+1, itemID, uuid.New(), now, now.Add(time.Minute))
+			errs <- err
+		}(int64(i))
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+}
+
+// TestWarmupStatementsDisabled tests that a zero connection count skips warmup
+func TestWarmupStatementsDisabled(t *testing.T) {
+	s := newTestServer(t)
+	s.config.StatementWarmupConns = 0
+
+	checkoutRepo, err := NewCheckoutRepository(s)
+	require.NoError(t, err)
+	defer checkoutRepo.Close()
+
+	conns, err := checkoutRepo.Warmup(context.Background())
+	require.NoError(t, err)
+	assert.Zero(t, conns)
+}
+
+// BenchmarkStatementWarmup measures the slowest of the first concurrent inserts on a fresh pool, with and without warmup
+func BenchmarkStatementWarmup(b *testing.B) {
+	host := os.Getenv("TEST_DB_HOST")
+	if host == "" {
+		b.Skip("TEST_DB_HOST is not set, skipping integration test")
+	}
+
+	for _, warm := range []bool{false, true} {
+		name := "Cold"
+		if warm {
+			name = "Warm"
+		}
+		b.Run(name, func(b *testing.B) {
+			var slowest time.Duration
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				config := DefaultConfig()
+				config.Host = host
+				config.PoolSampleInterval = 0
+				s, err := Connect(config)
+				require.NoError(b, err)
+				repo, err := NewCheckoutRepository(s)
+				require.NoError(b, err)
+				conns := config.StatementWarmupConns
+				if warm {
+					conns, err = repo.Warmup(context.Background())
+					require.NoError(b, err)
+				}
+				saleID := time.Now().UnixNano() % 1_000_000_000
+				b.StartTimer()
+
+				// One insert per connection, like the first burst of checkouts after a restart
+				var wg sync.WaitGroup
+				latencies := make([]time.Duration, conns)
+				now := time.Now()
+				for j := range latencies {
+					wg.Add(1)
+					go func(j int) {
+						defer wg.Done()
+						start := time.Now()
+						if _, err := repo.batchInsertStmt.ExecContext(context.Background(), saleID, j+1, j, uuid.New(), now, now.Add(time.Minute)); err != nil {
+							b.Error(err)
+						}
+						latencies[j] = time.Since(start)
+					}(j)
+				}
+				wg.Wait()
+
+				b.StopTimer()
+				for _, latency := range latencies {
+					slowest = max(slowest, latency)
+				}
+				s.ExecContext(context.Background(), "DELETE FROM checkouts WHERE sale_id = $1", saleID)
+				repo.Close()
+				s.Close()
+				b.StartTimer()
+			}
+			b.ReportMetric(float64(slowest.Microseconds()), "slowest-µs")
+		})
+	}
+}
+
+// TestUserIDBeyondInt32 tests that user IDs wider than 32 bits round-trip through checkouts and sale_items
+func TestUserIDBeyondInt32(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+
+	checkoutRepo, err := NewCheckoutRepository(s)
+	require.NoError(t, err)
+	defer checkoutRepo.Close()
+	saleItemsRepo, err := NewSaleItemsRepository(s)
+	require.NoError(t, err)
+	defer saleItemsRepo.Close()
+
+	saleID, err := s.CreateInitialSale()
+	require.NoError(t, err)
+
+	// Unique per run so repeated runs don't find old purchases
+	userID := int64(math.MaxInt32) + time.Now().UnixNano()%1_000_000_000
+	now := time.Now()
+	record := CheckoutRecord{
+		SaleID:    saleID,
+		UserID:    userID,
+		ItemID:    0,
+		Code:      uuid.New(),
+		CreatedAt: now,
+		ExpiresAt: now.Add(time.Minute),
+	}
+	_, err = checkoutRepo.MultiRowInsert(ctx, []CheckoutRecord{record})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		s.ExecContext(context.Background(), "DELETE FROM checkouts WHERE code = $1", record.Code)
+	})
+
+	stored, err := checkoutRepo.GetReservationByCode(ctx, record.Code)
+	require.NoError(t, err)
+	require.NotNil(t, stored)
+	assert.Equal(t, userID, stored.UserID)
+
+	// Take the first lot still on sale
+	available, err := saleItemsRepo.GetAvailableItems(ctx, saleID, 1)
+	require.NoError(t, err)
+	require.Len(t, available, 1)
+	itemID := int64(available[0].ItemID)
+
+	require.NoError(t, saleItemsRepo.BatchPurchaseItem(ctx, []ItemPurchase{{SaleID: saleID, ItemID: itemID, UserID: userID}}))
+
+	purchased, err := saleItemsRepo.GetPurchasedItems(ctx, userID)
+	require.NoError(t, err)
+	require.Len(t, purchased, 1)
+	require.NotNil(t, purchased[0].PurchasedBy)
+	assert.Equal(t, userID, *purchased[0].PurchasedBy)
+}
+
+// TestGetPurchasedItemsPaged tests pages, the total count and a user without purchases
+func TestGetPurchasedItemsPaged(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+
+	saleItemsRepo, err := NewSaleItemsRepository(s)
+	require.NoError(t, err)
+	defer saleItemsRepo.Close()
+
+	saleID, err := s.CreateInitialSale()
+	require.NoError(t, err)
+
+	// Unique per run so repeated runs don't find old purchases
+	userID := int64(math.MaxInt32) + time.Now().UnixNano()%1_000_000_000
+
+	empty, total, err := saleItemsRepo.GetPurchasedItemsPaged(ctx, userID, 2, 0)
+	require.NoError(t, err)
+	assert.NotNil(t, empty)
+	assert.Empty(t, empty)
+	assert.Zero(t, total)
+
+	available, err := saleItemsRepo.GetAvailableItems(ctx, saleID, 3)
+	require.NoError(t, err)
+	require.Len(t, available, 3)
+	purchases := make([]ItemPurchase, 0, len(available))
+	for _, item := range available {
+		purchases = append(purchases, ItemPurchase{SaleID: saleID, ItemID: int64(item.ItemID), UserID: userID})
+	}
+	require.NoError(t, saleItemsRepo.BatchPurchaseItem(ctx, purchases))
+
+	first, total, err := saleItemsRepo.GetPurchasedItemsPaged(ctx, userID, 2, 0)
+	require.NoError(t, err)
+	assert.Len(t, first, 2)
+	assert.Equal(t, int64(3), total)
+
+	second, total, err := saleItemsRepo.GetPurchasedItemsPaged(ctx, userID, 2, 2)
+	require.NoError(t, err)
+	require.Len(t, second, 1)
+	assert.Equal(t, int64(3), total)
+	for _, item := range first {
+		assert.NotEqual(t, item.ID, second[0].ID, "pages must not overlap")
+	}
+
+	past, _, err := saleItemsRepo.GetPurchasedItemsPaged(ctx, userID, 2, 4)
+	require.NoError(t, err)
+	assert.NotNil(t, past)
+	assert.Empty(t, past)
+
+	_, _, err = saleItemsRepo.GetPurchasedItemsPaged(ctx, userID, 0, 0)
+	assert.Error(t, err)
+}
+
+// fakeBatchPurchaser marks every lot purchased except the ones listed as sold
+type fakeBatchPurchaser struct {
+	sold map[int64]bool
+	err  error
+}
+
+func (f *fakeBatchPurchaser) purchaseBatch(ctx context.Context, purchases []ItemPurchase) (map[purchaseKey]bool, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	purchased := make(map[purchaseKey]bool, len(purchases))
+	for _, p := range purchases {
+		if !f.sold[p.ItemID] {
+			purchased[purchaseKey{saleID: p.SaleID, itemID: p.ItemID}] = true
+		}
+	}
+	return purchased, nil
+}
+
+// TestBatchPurchaseUpdaterResults tests that each purchase in a batch gets its own outcome
+func TestBatchPurchaseUpdaterResults(t *testing.T) {
+	// Batch of 2 so both purchases land in the same UPDATE
+	bpu := newBatchPurchaseUpdater(&fakeBatchPurchaser{sold: map[int64]bool{2: true}}, 2, time.Hour)
+	defer bpu.Close()
+
+	results := make(chan error, 2)
+	var wg sync.WaitGroup
+	for _, itemID := range []int64{1, 2} {
+		wg.Add(1)
+		go func(itemID int64) {
+			defer wg.Done()
+			err := bpu.Purchase(1, itemID, 10+itemID)
+			if itemID == 1 {
+				assert.NoError(t, err, "a lot sold elsewhere must not fail the rest of the batch")
+			}
+			results <- err
+		}(itemID)
+	}
+	wg.Wait()
+	close(results)
+
+	var notAvailable int
+	for err := range results {
+		if err != nil {
+			assert.ErrorIs(t, err, ErrItemNotAvailable)
+			notAvailable++
+		}
+	}
+	assert.Equal(t, 1, notAvailable)
+}
+
+// TestBatchPurchaseUpdaterDBError tests that a DB failure is not reported as a sold lot
+func TestBatchPurchaseUpdaterDBError(t *testing.T) {
+	bpu := newBatchPurchaseUpdater(&fakeBatchPurchaser{err: errors.New("connection reset")}, 1, time.Hour)
+	defer bpu.Close()
+	bpu.SetDrainTimeout(0) // The DB never recovers, Close need not wait for it
+
+	err := bpu.Purchase(1, 1, 1)
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, ErrItemNotAvailable)
+}
+
+// recordingBatchPurchaser remembers purchased lots and answers after a delay
+type recordingBatchPurchaser struct {
+	delay     time.Duration
+	mu        sync.Mutex
+	purchased map[int64]bool
+}
+
+func (f *recordingBatchPurchaser) purchaseBatch(ctx context.Context, purchases []ItemPurchase) (map[purchaseKey]bool, error) {
+	time.Sleep(f.delay)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	result := make(map[purchaseKey]bool, len(purchases))
+	for _, p := range purchases {
+		f.purchased[p.ItemID] = true
+		result[purchaseKey{saleID: p.SaleID, itemID: p.ItemID}] = true
+	}
+	return result, nil
+}
+
+// TestBatchPurchaseUpdaterCloseConcurrent tests that Close acks every accepted purchase and rejects the rest explicitly
+func TestBatchPurchaseUpdaterCloseConcurrent(t *testing.T) {
+	defer leaktest.Check(t)()
+	repo := &recordingBatchPurchaser{delay: 5 * time.Millisecond, purchased: make(map[int64]bool)}
+	// Small batches keep several background batches in flight while Close runs
+	bpu := newBatchPurchaseUpdater(repo, 4, time.Millisecond)
+
+	const purchases = 200
+	results := make([]error, purchases)
+	var wg sync.WaitGroup
+	for i := range purchases {
+		wg.Add(1)
+		go func(itemID int) {
+			defer wg.Done()
+			results[itemID] = bpu.Purchase(1, int64(itemID), 1)
+		}(i)
+	}
+
+	time.Sleep(2 * time.Millisecond)
+	require.NoError(t, bpu.Close())
+	wg.Wait()
+
+	var accepted int
+	for itemID, err := range results {
+		if err == nil {
+			accepted++
+			assert.True(t, repo.purchased[int64(itemID)], "acked purchase %d must reach the DB", itemID)
+			continue
+		}
+		assert.ErrorIs(t, err, ErrUpdaterClosed, "purchase %d", itemID)
+	}
+	assert.Positive(t, accepted)
+
+	// Accepted purchases were never dropped silently
+	assert.Len(t, repo.purchased, accepted)
+	assert.ErrorIs(t, bpu.Purchase(1, purchases, 1), ErrUpdaterClosed)
+	assert.NoError(t, bpu.Close(), "Close must be idempotent")
+}
+
+// flakyBatchPurchaser fails every batch while failing is set and records the rest
+type flakyBatchPurchaser struct {
+	failing   atomic.Bool
+	failures  int // batches to fail before failing is checked
+	mu        sync.Mutex
+	calls     int
+	purchased map[int64]bool
+}
+
+func (f *flakyBatchPurchaser) purchaseBatch(ctx context.Context, purchases []ItemPurchase) (map[purchaseKey]bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	if f.calls <= f.failures || f.failing.Load() {
+		return nil, fmt.Errorf("%w: connection reset", ErrConnection)
+	}
+
+	result := make(map[purchaseKey]bool, len(purchases))
+	for _, p := range purchases {
+		f.purchased[p.ItemID] = true
+		result[purchaseKey{saleID: p.SaleID, itemID: p.ItemID}] = true
+	}
+	return result, nil
+}
+
+// TestBatchPurchaseUpdaterRetry tests that a transient failure is retried before the caller sees it
+func TestBatchPurchaseUpdaterRetry(t *testing.T) {
+	repo := &flakyBatchPurchaser{failures: 2, purchased: make(map[int64]bool)}
+	bpu := newBatchPurchaseUpdater(repo, 1, time.Hour)
+	defer bpu.Close()
+	bpu.SetRetry(3, time.Millisecond)
+
+	require.NoError(t, bpu.Purchase(1, 5, 1))
+	assert.True(t, repo.purchased[5])
+	assert.Equal(t, int64(2), bpu.Stats().Retries)
+	assert.Zero(t, bpu.Stats().DeadLettered)
+}
+
+// TestBatchPurchaseUpdaterDeadLetter tests that a batch failing every attempt is written in the background once the DB recovers
+func TestBatchPurchaseUpdaterDeadLetter(t *testing.T) {
+	defer leaktest.Check(t)()
+	repo := &flakyBatchPurchaser{purchased: make(map[int64]bool)}
+	repo.failing.Store(true)
+	bpu := newBatchPurchaseUpdater(repo, 1, time.Hour)
+	bpu.SetRetry(2, time.Millisecond)
+
+	err := bpu.Purchase(1, 5, 1)
+	assert.ErrorIs(t, err, ErrPurchaseDeferred)
+	assert.ErrorIs(t, err, ErrConnection, "the cause is kept")
+	stats := bpu.Stats()
+	assert.Equal(t, int64(1), stats.DeadLettered)
+	assert.Equal(t, int64(1), stats.DeadLetterDepth)
+
+	repo.failing.Store(false)
+	require.Eventually(t, func() bool { return bpu.Stats().DeadLetterDepth == 0 }, time.Second, time.Millisecond)
+	assert.Equal(t, int64(1), bpu.Stats().Recovered)
+	repo.mu.Lock()
+	assert.True(t, repo.purchased[5])
+	repo.mu.Unlock()
+
+	require.NoError(t, bpu.Close())
+}
+
+// TestBatchPurchaseUpdaterDeadLetterClose tests that Close gives queued purchases a last attempt instead of hanging on a dead DB
+func TestBatchPurchaseUpdaterDeadLetterClose(t *testing.T) {
+	defer leaktest.Check(t)()
+	repo := &flakyBatchPurchaser{purchased: make(map[int64]bool)}
+	repo.failing.Store(true)
+	bpu := newBatchPurchaseUpdater(repo, 1, time.Hour)
+	bpu.SetRetry(1, time.Hour)
+	bpu.SetDrainTimeout(10 * time.Millisecond)
+
+	var lost []ItemPurchase
+	bpu.SetLostHandler(func(purchase ItemPurchase, sold bool) {
+		assert.False(t, sold, "the DB never saw the lot")
+		lost = append(lost, purchase)
+	})
+
+	assert.ErrorIs(t, bpu.Purchase(1, 5, 1), ErrPurchaseDeferred)
+	require.NoError(t, bpu.Close())
+
+	stats := bpu.Stats()
+	assert.Equal(t, int64(1), stats.Lost)
+	assert.Zero(t, stats.DeadLetterDepth)
+	assert.Equal(t, []ItemPurchase{{SaleID: 1, ItemID: 5, UserID: 1}}, lost)
+}
+
+// TestBatchPurchaseUpdaterDeadLetterDrain tests that Close keeps retrying the queue until the drain timeout
+func TestBatchPurchaseUpdaterDeadLetterDrain(t *testing.T) {
+	defer leaktest.Check(t)()
+	repo := &flakyBatchPurchaser{purchased: make(map[int64]bool)}
+	repo.failing.Store(true)
+	bpu := newBatchPurchaseUpdater(repo, 1, time.Hour)
+	bpu.SetRetry(1, time.Millisecond)
+	bpu.SetLostHandler(func(purchase ItemPurchase, sold bool) {
+		t.Errorf("purchase %+v lost", purchase)
+	})
+
+	assert.ErrorIs(t, bpu.Purchase(1, 5, 1), ErrPurchaseDeferred)
+
+	// The DB comes back while Close is draining
+	time.AfterFunc(20*time.Millisecond, func() { repo.failing.Store(false) })
+	require.NoError(t, bpu.Close())
+
+	stats := bpu.Stats()
+	assert.Equal(t, int64(1), stats.Recovered)
+	assert.Zero(t, stats.Lost)
+	repo.mu.Lock()
+	assert.True(t, repo.purchased[5])
+	repo.mu.Unlock()
+}
+
+// TestBatchPurchaseUpdaterDeferredItems tests that queued lots are reported until they leave the queue before since
+func TestBatchPurchaseUpdaterDeferredItems(t *testing.T) {
+	repo := &flakyBatchPurchaser{purchased: make(map[int64]bool)}
+	repo.failing.Store(true)
+	bpu := newBatchPurchaseUpdater(repo, 1, time.Hour)
+	defer bpu.Close()
+	bpu.SetRetry(1, time.Millisecond)
+
+	require.ErrorIs(t, bpu.Purchase(1, 5, 1), ErrPurchaseDeferred)
+	assert.Equal(t, map[int64]bool{5: true}, bpu.DeferredItems(1, time.Now()))
+	assert.Empty(t, bpu.DeferredItems(2, time.Now()), "other sales are filtered out")
+
+	before := time.Now()
+	repo.failing.Store(false)
+	require.Eventually(t, func() bool { return bpu.Stats().DeadLetterDepth == 0 }, time.Second, time.Millisecond)
+
+	// A DB read started before the write may have missed it
+	assert.Equal(t, map[int64]bool{5: true}, bpu.DeferredItems(1, before))
+	assert.Empty(t, bpu.DeferredItems(1, time.Now()))
+	assert.Empty(t, bpu.DeferredItems(1, before), "entries older than since are forgotten")
+}
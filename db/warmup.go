@@ -0,0 +1,85 @@
+// warmup.go
+
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+)
+
+// WarmupStatements подготавливает выражения на нескольких соединениях пула.
+// database/sql готовит *sql.Stmt лениво на каждом новом соединении, поэтому
+// первые запросы на каждом соединении платят лишний round-trip на PREPARE.
+// Прогрев переносит эту задержку на старт сервиса.
+// Возвращает количество прогретых соединений.
+func (s *Server) WarmupStatements(ctx context.Context, stmts ...*sql.Stmt) (int, error) {
+	db := s.DB()
+	if db == nil {
+		return 0, fmt.Errorf("database connection is nil")
+	}
+
+	count := s.config.StatementWarmupConns
+	// Соединения сверх MaxIdleConns закроются сразу после возврата в пул
+	if count > s.config.MaxIdleConns {
+		count = s.config.MaxIdleConns
+	}
+	if count <= 0 || len(stmts) == 0 {
+		return 0, nil
+	}
+
+	// Держим все соединения одновременно, иначе пул вернет одно и то же
+	conns := make([]*sql.Conn, 0, count)
+	defer func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+	for i := 0; i < count; i++ {
+		conn, err := db.Conn(ctx)
+		if err != nil {
+			return 0, fmt.Errorf("acquire connection %d: %w", i, err)
+		}
+		conns = append(conns, conn)
+	}
+
+	var wg sync.WaitGroup
+	errCh := make(chan error, len(conns))
+	for _, conn := range conns {
+		wg.Add(1)
+		go func(conn *sql.Conn) {
+			defer wg.Done()
+			if err := warmupConn(ctx, conn, stmts); err != nil {
+				errCh <- err
+			}
+		}(conn)
+	}
+	wg.Wait()
+	close(errCh)
+
+	if err := <-errCh; err != nil {
+		return 0, err
+	}
+	return len(conns), nil
+}
+
+// warmupConn подготавливает выражения на конкретном соединении.
+// Tx.StmtContext готовит выражение на соединении транзакции и запоминает его
+// в родительском *sql.Stmt, так что оно переиспользуется и вне транзакции.
+func warmupConn(ctx context.Context, conn *sql.Conn, stmts []*sql.Stmt) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin warmup tx: %w", err)
+	}
+	// Откат закрывает выражения транзакции, подготовленные на соединении остаются
+	defer tx.Rollback()
+
+	for _, stmt := range stmts {
+		txStmt := tx.StmtContext(ctx, stmt)
+		if err := txStmt.Close(); err != nil {
+			return fmt.Errorf("prepare statement: %w", err)
+		}
+	}
+	return nil
+}
@@ -7,7 +7,8 @@
 -- Таблица для хранения всех checkout запросов
 CREATE TABLE IF NOT EXISTS checkouts (
     id BIGSERIAL PRIMARY KEY,                      -- Unique checkout ID / Уникальный ID checkout
-    user_id INTEGER NOT NULL,                      -- User who initiated checkout / Пользователь, инициировавший checkout
+    sale_id INTEGER NOT NULL DEFAULT 0,            -- Sale the reservation belongs to / Распродажа, к которой относится резерв
+    user_id BIGINT NOT NULL,                       -- User who initiated checkout / Пользователь, инициировавший checkout
     item_id INTEGER NOT NULL,                      -- Item being checked out / Товар в процессе покупки
     code UUID UNIQUE NOT NULL,                     -- Unique checkout code / Уникальный код checkout
     created_at TIMESTAMP NOT NULL DEFAULT NOW(),   -- When checkout was created / Время создания checkout
@@ -18,11 +19,16 @@ CREATE TABLE IF NOT EXISTS checkouts (
 -- Индексы для производительности
 CREATE INDEX IF NOT EXISTS idx_checkouts_expires_at ON checkouts(expires_at);  -- Index for cleanup queries / Индекс для запросов очистки
 
+-- Migration for databases created before checkouts were scoped to a sale
+-- Миграция для баз, созданных до привязки checkouts к распродаже
+ALTER TABLE checkouts ADD COLUMN IF NOT EXISTS sale_id INTEGER NOT NULL DEFAULT 0;
+CREATE INDEX IF NOT EXISTS idx_checkouts_sale_expires ON checkouts(sale_id, expires_at);  -- Index for cache recovery / Индекс для восстановления кеша
+
 -- =============================================================================
 
 -- Table for sale items (lots) for each flash sale
 -- Таблица лотов для каждой распродажи
-CREATE TABLE sale_items (
+CREATE TABLE IF NOT EXISTS sale_items (
     id BIGSERIAL PRIMARY KEY,                      -- Unique item record ID / Уникальный ID записи товара
     sale_id INTEGER NOT NULL,                      -- Sale ID / ID распродажи 
     sale_start_hour TIMESTAMP NOT NULL,            -- Sale start hour / Час начала распродажи
@@ -30,7 +36,7 @@ CREATE TABLE sale_items (
     item_name VARCHAR(255) NOT NULL,               -- Product name / Название товара
     image_url VARCHAR(500) NOT NULL,               -- Image URL / URL картинки
     purchased BOOLEAN NOT NULL DEFAULT FALSE,      -- Purchase status flag / Флаг, куплен ли лот
-    purchased_by INTEGER NULL,                     -- User ID who purchased / ID пользователя, кто купил
+    purchased_by BIGINT NULL,                      -- User ID who purchased / ID пользователя, кто купил
     purchased_at TIMESTAMP NULL                    -- Purchase timestamp / Время покупки
 );
 
@@ -38,11 +44,30 @@ CREATE TABLE sale_items (
 -- Составной индекс для быстрого поиска
 CREATE UNIQUE INDEX IF NOT EXISTS idx_sale_items_sale_item ON sale_items(sale_id, item_id);
 
+-- Partial index for a user's purchase history, unsold lots are left out
+-- Частичный индекс для истории покупок пользователя, непроданные лоты в него не попадают
+CREATE INDEX IF NOT EXISTS idx_sale_items_purchased_by ON sale_items(purchased_by, purchased_at DESC) WHERE purchased_by IS NOT NULL;
+
+-- Migration for databases created with 32-bit user IDs, a no-op once columns are BIGINT
+-- Миграция для баз с 32-битными ID пользователей, no-op для уже расширенных колонок
+ALTER TABLE checkouts ALTER COLUMN user_id TYPE BIGINT;
+ALTER TABLE sale_items ALTER COLUMN purchased_by TYPE BIGINT;
+
 -- =============================================================================
 
 -- Stored procedure to create a new sale based on existing data
 -- Процедура для создания новой распродажи на основе существующих данных
-CREATE OR REPLACE FUNCTION create_new_sale() RETURNS INTEGER AS $$
+-- Drop older versions, they would make create_new_sale() calls ambiguous
+-- Удаляем старые версии, иначе вызовы create_new_sale() станут неоднозначными
+DROP FUNCTION IF EXISTS create_new_sale();
+DROP FUNCTION IF EXISTS create_new_sale(INTEGER, TEXT, TEXT);
+
+CREATE OR REPLACE FUNCTION create_new_sale(
+    items_count INTEGER DEFAULT 10000,                                                 -- Number of lots / Количество лотов
+    name_template TEXT DEFAULT 'Flash Item #{item} (Sale {sale})',                     -- Item name template / Шаблон названия товара
+    image_template TEXT DEFAULT 'https://picsum.photos/200/200?random={sale}_{item}',  -- Image URL template / Шаблон URL картинки
+    sale_interval INTERVAL DEFAULT '1 hour'                                            -- Sale length / Длительность распродажи
+) RETURNS INTEGER AS $$
 DECLARE
     max_sale_hour TIMESTAMP;    -- Latest sale hour in database / Последний час распродажи в базе
     max_sale_id INTEGER;        -- Latest sale ID in database / Последний ID распродажи в базе
@@ -51,9 +76,15 @@ DECLARE
     current_hour TIMESTAMP;     -- Current hour (truncated) / Текущий час (округленный)
     items_generated INTEGER;    -- Number of items created / Количество созданных товаров
 BEGIN
-    -- Get current hour (truncated to hour)
-    -- Получаем текущий час (округленный)
-    current_hour := date_trunc('hour', NOW());
+    -- Validate item count
+    -- Проверяем количество лотов
+    IF items_count IS NULL OR items_count <= 0 THEN
+        RAISE EXCEPTION 'items_count must be positive, got %', items_count;
+    END IF;
+
+    -- Get start of the current interval (the current hour for hourly sales)
+    -- Получаем начало текущего интервала (при интервале в час - текущий час)
+    current_hour := date_bin(sale_interval, NOW(), TIMESTAMPTZ '2001-01-01 00:00:00+00');
     
     -- Find the maximum record in the table
     -- Находим максимальную запись в таблице
@@ -91,7 +122,7 @@ BEGIN
         -- Last sale is in the future - create next sequential sale
         -- Последняя распродажа в будущем - создаем следующую по порядку
         new_sale_id := max_sale_id + 1;
-        new_sale_hour := max_sale_hour + INTERVAL '1 hour';
+        new_sale_hour := max_sale_hour + sale_interval;
         RAISE NOTICE 'Creating next sequential sale % for hour %', new_sale_id, new_sale_hour;
     END IF;
     
@@ -102,8 +133,8 @@ BEGIN
         RETURN new_sale_id;
     END IF;
     
-    -- Create 10,000 lots for the new sale
-    -- Создаем 10,000 лотов для новой распродажи
+    -- Create items_count lots for the new sale
+    -- Создаем items_count лотов для новой распродажи
     INSERT INTO sale_items (
         sale_id,
         sale_start_hour,
@@ -117,24 +148,24 @@ BEGIN
     SELECT 
         new_sale_id,                                                                    -- Sale ID / ID распродажи
         new_sale_hour,                                                                  -- Sale hour / Час распродажи
-        item_counter,                                                                   -- Item ID (0-9999) / ID товара (0-9999)
-        'Flash Item #' || item_counter || ' (Sale ' || new_sale_id || ')',            -- Generated item name / Сгенерированное название товара
-        'https://picsum.photos/200/200?random=' || new_sale_id || '_' || item_counter, -- Random image URL / Случайный URL картинки
+        item_counter,                                                                   -- Item ID (0..items_count-1) / ID товара (0..items_count-1)
+        replace(replace(name_template, '{item}', item_counter::text), '{sale}', new_sale_id::text),  -- Generated item name / Сгенерированное название товара
+        replace(replace(image_template, '{item}', item_counter::text), '{sale}', new_sale_id::text), -- Image URL / URL картинки
         false,                                                                          -- Not purchased initially / Изначально не куплен
         NULL,                                                                           -- No purchaser initially / Изначально нет покупателя
         NULL                                                                            -- No purchase time initially / Изначально нет времени покупки
-    FROM generate_series(0, 9999) AS item_counter;  -- Generate 10,000 items (0-9999) / Генерируем 10,000 товаров (0-9999)
+    FROM generate_series(0, items_count - 1) AS item_counter;  -- Generate items_count items / Генерируем items_count товаров
     
     -- Check number of created records
     -- Проверяем количество созданных записей
     GET DIAGNOSTICS items_generated = ROW_COUNT;
     
-    IF items_generated = 10000 THEN
+    IF items_generated = items_count THEN
         RAISE NOTICE 'Successfully created sale % with % items for hour %', 
             new_sale_id, items_generated, new_sale_hour;
     ELSE
-        RAISE WARNING 'Expected 10000 items but created % for sale %', 
-            items_generated, new_sale_id;
+        RAISE WARNING 'Expected % items but created % for sale %', 
+            items_count, items_generated, new_sale_id;
     END IF;
     
     RETURN new_sale_id;  -- Return new sale ID / Возвращаем ID новой распродажи
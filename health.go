@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// healthChecker reports database connectivity / сообщает о доступности базы данных
+type healthChecker interface {
+	IsHealthy() bool
+}
+
+// healthStatus is the /health response body / тело ответа /health
+type healthStatus struct {
+	Accepting bool  `json:"accepting"`
+	SaleID    int64 `json:"sale_id"`
+	Database  bool  `json:"database"`
+}
+
+// healthHandler answers load balancer probes without touching the cache / отвечает на пробы балансировщика, не трогая кеш
+func (s *ServerInstance) healthHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	status := healthStatus{
+		Accepting: s.isAcceptingRequests(),
+		SaleID:    s.saleID,
+		Database:  s.dbHealth != nil && s.dbHealth.IsHealthy(),
+	}
+
+	// Ready only when serving requests and Postgres is reachable / Готов, только если принимаем запросы и Postgres доступен
+	code := http.StatusOK
+	if !status.Accepting || !status.Database {
+		code = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(status)
+}
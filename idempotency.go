@@ -0,0 +1,146 @@
+package main
+
+import (
+	"container/list"
+	"net/http"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// Idempotency headers of /purchase / Заголовки идемпотентности /purchase
+const (
+	idempotencyKeyHeader     = "Idempotency-Key"
+	idempotentReplayedHeader = "Idempotent-Replayed"
+)
+
+// Keys of the purchase outcomes LRU / Ключи LRU исходов покупок
+const (
+	purchaseOutcomeKeyPrefix  = "key:"  // client keys and codes never collide / ключи клиента и коды никогда не совпадают
+	purchaseOutcomeCodePrefix = "code:" // requests without a key are keyed by their code / запросы без ключа идентифицируются кодом
+)
+
+// defaultPurchaseOutcomes is the default IDEMPOTENCY_CACHE_SIZE / значение IDEMPOTENCY_CACHE_SIZE по умолчанию
+const defaultPurchaseOutcomes = 10000
+
+// purchaseOutcome is the remembered answer to a finished purchase / запомненный ответ на завершенную покупку
+type purchaseOutcome struct {
+	code   uuid.UUID
+	status int
+	body   purchaseResponse // set for successful purchases / задан для успешных покупок
+}
+
+// succeeded reports whether the purchase went through / сообщает, прошла ли покупка
+func (o purchaseOutcome) succeeded() bool {
+	return o.status == http.StatusOK || o.status == http.StatusAccepted
+}
+
+// purchaseOutcomes is a bounded LRU of recent purchase outcomes for replaying retried requests /
+// ограниченный LRU недавних исходов покупок для повтора ответов на повторные запросы
+type purchaseOutcomes struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List               // front is most recently used / в начале недавно использованные
+	entries map[string]*list.Element // key -> element of order / ключ -> элемент order
+}
+
+// purchaseOutcomeEntry is one element of purchaseOutcomes.order / один элемент purchaseOutcomes.order
+type purchaseOutcomeEntry struct {
+	key     string
+	outcome purchaseOutcome
+}
+
+// newPurchaseOutcomes creates an LRU for size outcomes / создает LRU на size исходов
+func newPurchaseOutcomes(size int) *purchaseOutcomes {
+	if size <= 0 {
+		size = 1
+	}
+	return &purchaseOutcomes{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[string]*list.Element, size),
+	}
+}
+
+// Get returns the outcome remembered for key, a nil LRU remembers nothing / возвращает запомненный для key исход, nil LRU ничего не помнит
+func (c *purchaseOutcomes) Get(key string) (purchaseOutcome, bool) {
+	if c == nil {
+		return purchaseOutcome{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return purchaseOutcome{}, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*purchaseOutcomeEntry).outcome, true
+}
+
+// Put remembers an outcome; a success is never replaced by the failure of a racing retry /
+// запоминает исход; успех никогда не заменяется неудачей параллельного повтора
+func (c *purchaseOutcomes) Put(key string, outcome purchaseOutcome) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		entry := elem.Value.(*purchaseOutcomeEntry)
+		if !entry.outcome.succeeded() || outcome.succeeded() {
+			entry.outcome = outcome
+		}
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&purchaseOutcomeEntry{key: key, outcome: outcome})
+
+	// Evict the least recently used outcome / Вытесняем самый давно использованный исход
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*purchaseOutcomeEntry).key)
+	}
+}
+
+// Revoke replaces every remembered outcome of code with status, so retries of a purchase that was later undone stop replaying its success /
+// заменяет все запомненные исходы code на status, чтобы повторы отмененной позже покупки перестали получать ее успех
+func (c *purchaseOutcomes) Revoke(code uuid.UUID, status int) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Client keys don't reveal the code, so every entry is checked; purchases are revoked rarely /
+	// Ключи клиента не раскрывают код, поэтому проверяется каждая запись; покупки отменяются редко
+	for _, elem := range c.entries {
+		entry := elem.Value.(*purchaseOutcomeEntry)
+		if entry.outcome.code == code {
+			entry.outcome = purchaseOutcome{code: code, status: status}
+		}
+	}
+}
+
+// purchaseOutcomeKey picks the LRU key: the client's Idempotency-Key or else the code, false for an unusable key /
+// выбирает ключ LRU: Idempotency-Key клиента или код, false для негодного ключа
+func purchaseOutcomeKey(r *http.Request, code uuid.UUID) (string, bool) {
+	key := r.Header.Get(idempotencyKeyHeader)
+	if key == "" {
+		return purchaseOutcomeCodePrefix + code.String(), true
+	}
+	// Same rules as X-Request-ID: short and printable / Те же правила, что и для X-Request-ID: короткий и печатный
+	if !validRequestID(key) {
+		return "", false
+	}
+	return purchaseOutcomeKeyPrefix + key, true
+}
+
+// finalPurchaseStatus reports whether retrying can't change the outcome, 5xx answers are worth a retry /
+// сообщает, что повтор не изменит исход, на 5xx стоит повторить
+func finalPurchaseStatus(status int) bool {
+	return status < http.StatusInternalServerError
+}
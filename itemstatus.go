@@ -0,0 +1,41 @@
+package main
+
+import (
+	"contest_notcoin/megacache"
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+// itemStatus is the /item response body / тело ответа /item
+type itemStatus struct {
+	ItemID   int64               `json:"item_id"`
+	Name     string              `json:"name,omitempty"`
+	ImageURL string              `json:"image_url,omitempty"`
+	Status   megacache.LotStatus `json:"status"` // encoded by name / кодируется именем
+}
+
+// itemStatusHandler returns a single lot's status and metadata from memory, read-only so it keeps working during shutdown / возвращает статус и метаданные одного лота из памяти, только чтение, поэтому работает и во время остановки
+func (s *ServerInstance) itemStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	itemID, err := strconv.ParseInt(r.URL.Query().Get("item_id"), 10, 64)
+	if err != nil || itemID < 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// IDs past the last lot don't exist in the current sale / ID после последнего лота не существуют в текущей распродаже
+	name, imageURL, status, err := s.cache.GetLotInfo(itemID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(itemStatus{ItemID: itemID, Name: name, ImageURL: imageURL, Status: status})
+}
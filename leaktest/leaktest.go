@@ -0,0 +1,93 @@
+// Package leaktest fails tests that leave goroutines behind after closing a component / проваливает тесты, после которых остаются горутины закрытого компонента
+package leaktest
+
+import (
+	"bytes"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// settleTimeout is how long goroutines get to exit after Close returns / сколько горутины могут завершаться после возврата Close
+const settleTimeout = 2 * time.Second
+
+// ignored lists goroutines the runtime starts once per process and never stops / горутины, которые рантайм запускает один раз на процесс и не останавливает
+var ignored = []string{
+	"os/signal.signal_recv",
+	"os/signal.loop",
+}
+
+// Check snapshots running goroutines; the returned func fails t if new ones are still alive after settleTimeout /
+// запоминает запущенные горутины; возвращенная функция проваливает t, если новые горутины живы дольше settleTimeout
+//
+//	defer leaktest.Check(t)()
+func Check(t testing.TB) func() {
+	t.Helper()
+	before := make(map[string]bool)
+	for id := range goroutines() {
+		before[id] = true
+	}
+
+	return func() {
+		t.Helper()
+		deadline := time.Now().Add(settleTimeout)
+		for {
+			leaked := leakedSince(before)
+			if len(leaked) == 0 {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Errorf("%d goroutine(s) leaked:\n\n%s", len(leaked), strings.Join(leaked, "\n\n"))
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+// leakedSince returns stacks of goroutines started after the snapshot / возвращает стеки горутин, запущенных после снимка
+func leakedSince(before map[string]bool) []string {
+	var leaked []string
+	for id, stack := range goroutines() {
+		if before[id] || isIgnored(stack) {
+			continue
+		}
+		leaked = append(leaked, stack)
+	}
+	return leaked
+}
+
+// isIgnored reports whether a stack belongs to a process-wide runtime goroutine / сообщает, принадлежит ли стек общей горутине рантайма
+func isIgnored(stack string) bool {
+	for _, fn := range ignored {
+		if strings.Contains(stack, fn) {
+			return true
+		}
+	}
+	return false
+}
+
+// goroutines returns stacks of all goroutines keyed by goroutine ID / возвращает стеки всех горутин по их ID
+func goroutines() map[string]string {
+	buf := make([]byte, 64<<10)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+
+	stacks := make(map[string]string)
+	for _, stack := range bytes.Split(buf, []byte("\n\n")) {
+		// Each stack starts with "goroutine <id> [<state>]:" / Каждый стек начинается с "goroutine <id> [<state>]:"
+		fields := strings.Fields(string(stack))
+		if len(fields) < 2 || fields[0] != "goroutine" {
+			continue
+		}
+		stacks[fields[1]] = string(stack)
+	}
+	return stacks
+}
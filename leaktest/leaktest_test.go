@@ -0,0 +1,36 @@
+package leaktest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingTB captures failures instead of failing the real test
+type recordingTB struct {
+	testing.TB
+	failed bool
+}
+
+func (r *recordingTB) Helper()                           {}
+func (r *recordingTB) Errorf(format string, args ...any) { r.failed = true }
+
+// TestCheckDetectsLeak tests that a goroutine outliving the check is reported and a finished one is not
+func TestCheckDetectsLeak(t *testing.T) {
+	stop := make(chan struct{})
+	defer close(stop)
+
+	leaky := &recordingTB{TB: t}
+	check := Check(leaky)
+	go func() { <-stop }()
+	check()
+	assert.True(t, leaky.failed, "a blocked goroutine must be reported")
+
+	clean := &recordingTB{TB: t}
+	check = Check(clean)
+	done := make(chan struct{})
+	go func() { close(done) }()
+	<-done
+	check()
+	assert.False(t, clean.failed, "an exited goroutine must not be reported")
+}
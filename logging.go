@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// requestIDHeader carries the request correlation ID both ways / заголовок с ID корреляции запроса в обе стороны
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds a client supplied request ID / ограничивает длину ID запроса от клиента
+const maxRequestIDLength = 128
+
+// requestIDKey is the context key of the request ID / ключ контекста для ID запроса
+type requestIDKey struct{}
+
+// parseLogLevel maps LOG_LEVEL to a slog level / сопоставляет LOG_LEVEL уровню slog
+func parseLogLevel(value string) (slog.Level, bool) {
+	switch strings.ToLower(value) {
+	case "debug":
+		return slog.LevelDebug, true
+	case "info", "":
+		return slog.LevelInfo, true
+	case "warn", "warning":
+		return slog.LevelWarn, true
+	case "error":
+		return slog.LevelError, true
+	}
+	return slog.LevelInfo, false
+}
+
+// newLogger writes JSON lines at level and above / пишет строки JSON с уровня level и выше
+func newLogger(w io.Writer, level slog.Level) *slog.Logger {
+	return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level}))
+}
+
+// setupLogging installs the JSON logger as the default; log.Printf lines, banners included, go through it at info level /
+// устанавливает JSON логгер по умолчанию; строки log.Printf, включая баннеры, проходят через него на уровне info
+func setupLogging() {
+	value := os.Getenv("LOG_LEVEL")
+	level, ok := parseLogLevel(value)
+	slog.SetDefault(newLogger(os.Stderr, level))
+	// slog adds its own time / slog добавляет свое время
+	log.SetFlags(0)
+	if !ok {
+		slog.Warn("invalid LOG_LEVEL, using info", "value", value)
+	}
+}
+
+// validRequestID accepts short printable client IDs, anything else is replaced / принимает короткие печатные ID клиента, остальные заменяются
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		if c < 0x21 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// withRequestID tags the request with an ID, reusing the client's X-Request-ID, and echoes it back /
+// помечает запрос ID, используя X-Request-ID клиента, и возвращает его в ответе
+func withRequestID(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = uuid.NewString()
+		}
+		w.Header().Set(requestIDHeader, id)
+		next(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	}
+}
+
+// requestLogger returns the default logger tagged with the request ID, if any / возвращает логгер по умолчанию с ID запроса, если он есть
+func requestLogger(ctx context.Context) *slog.Logger {
+	if id, ok := ctx.Value(requestIDKey{}).(string); ok {
+		return slog.Default().With("request_id", id)
+	}
+	return slog.Default()
+}
@@ -3,32 +3,76 @@ package main
 import (
 	"contest_notcoin/db"
 	"contest_notcoin/megacache"
+	"contest_notcoin/ratelimit"
+	"contest_notcoin/token"
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"math"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
 	"strconv"
+	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+// publicPort is the default port of the sale API / порт API распродажи по умолчанию
+const publicPort = "8080"
+
+// defaultListenAddr is where the sale API listens unless LISTEN_ADDR says otherwise / адрес API распродажи, если LISTEN_ADDR не задан
+const defaultListenAddr = ":" + publicPort
+
+// checkoutInfoPath is the reservation info endpoint returned in Location / эндпоинт информации о резервировании, возвращаемый в Location
+const checkoutInfoPath = "/checkout/info"
+
 // ServerInstance represents a single server instance with all its dependencies / представляет один экземпляр сервера со всеми его зависимостями
 type ServerInstance struct {
-	server           *db.Server               // Database server connection / Подключение к серверу базы данных
-	checkoutRepo     *db.CheckoutRepository   // Repository for checkout operations / Репозиторий для операций checkout
-	batchInserter    *db.BatchInserter        // Batch inserter for performance / Пакетная вставка для производительности
-	saleItemsRepo    *db.SaleItemsRepository  // Repository for sale items / Репозиторий для товаров в продаже
-	batchPurchase    *db.BatchPurchaseUpdater // Batch purchase updater / Пакетное обновление покупок
-	cache            *megacache.Megacache     // Local cache for fast operations / Локальный кеш для быстрых операций
-	saleID           int64                    // Current sale ID / ID текущей распродажи
-	httpServer       *http.Server             // HTTP server instance / Экземпляр HTTP сервера
-	isAcceptingReqs  int32                    // Atomic boolean for request acceptance / Атомарный флаг приема запросов
-	shutdownComplete chan struct{}            // Channel to signal shutdown completion / Канал для сигнала завершения остановки
-	dbHost           string                   // Database host address / Адрес хоста базы данных
+	server           *db.Server              // Database server connection / Подключение к серверу базы данных
+	checkoutRepo     *db.CheckoutRepository  // Repository for checkout operations / Репозиторий для операций checkout
+	batchInserter    checkoutSaver           // Batch inserter for performance / Пакетная вставка для производительности
+	persister        *db.CheckoutPersister   // Background bulk persister (background mode) / Фоновое пакетное сохранение (режим background)
+	saleItemsRepo    *db.SaleItemsRepository // Repository for sale items / Репозиторий для товаров в продаже
+	batchPurchase    purchaseSaver           // Batch purchase updater / Пакетное обновление покупок
+	purchases        purchaseHistory         // Confirmed purchases reader / Чтение подтвержденных покупок
+	reservations     reservationLookup       // Persisted reservations reader / Чтение сохраненных резервов
+	cancels          reservationDeleter      // Persisted reservations remover / Удаление сохраненных резервов
+	renewals         reservationRenewer      // Persisted reservation expiry updater / Обновление срока сохраненных резервов
+	dbHealth         healthChecker           // DB connectivity check for /health / Проверка доступности БД для /health
+	dbPool           poolStatsSource         // DB pool usage for backpressure / Использование пула БД для обратного давления
+	cache            *megacache.Megacache    // Local cache for fast operations / Локальный кеш для быстрых операций
+	saleID           int64                   // Current sale ID / ID текущей распродажи
+	httpServer       *http.Server            // HTTP server instance / Экземпляр HTTP сервера
+	isAcceptingReqs  int32                   // Atomic boolean for request acceptance / Атомарный флаг приема запросов
+	shutdownComplete chan struct{}           // Channel to signal shutdown completion / Канал для сигнала завершения остановки
+	dbHost           string                  // Database host address / Адрес хоста базы данных
+	config           *AppConfig              // Service settings / Настройки сервиса
+	tokens           *token.Signer           // Reservation token signer, nil returns raw UUIDs / Подпись токенов резерва, nil - отдаем UUID
+	checkoutLimiter  *ratelimit.Limiter      // Per-user checkout rate limit, nil disables / Лимит частоты checkout на пользователя, nil - выключено
+	writes           writeAmplification      // Checkout rows vs purchases counters / Счетчики строк checkout и покупок
+	metrics          serviceMetrics          // Handler outcome counters for /metrics / Счетчики исходов обработчиков для /metrics
+	audit            *auditLog               // Admin action audit, nil disables it / Аудит админских действий, nil отключает его
+	purchaseOutcomes *purchaseOutcomes       // Recent purchase answers replayed to retries, nil disables / Недавние ответы на покупки для повторов, nil - выключено
+	startedAt        time.Time               // Instance start, reported by /version / Запуск экземпляра, отдается в /version
+}
+
+// checkoutSaver persists a single reservation / сохраняет одно резервирование
+type checkoutSaver interface {
+	AddContext(ctx context.Context, record db.CheckoutRecord) error
+	Close() error
+}
+
+// purchaseSaver persists a single purchase / сохраняет одну покупку
+type purchaseSaver interface {
+	Purchase(saleID, itemID, userID int64) error
+	Close() error
 }
 
 // Initialize timezone to UTC for consistent time handling / Инициализация временной зоны в UTC для консистентной работы с временем
@@ -38,55 +82,101 @@ func init() {
 
 var (
 	currentInstance atomic.Value // *ServerInstance - Current active server instance / Текущий активный экземпляр сервера
+	instanceMu      sync.Mutex   // Serializes hourly restarts with the final shutdown / Упорядочивает ежечасные перезапуски и финальную остановку
 )
 
-// Global database host variable / Глобальная переменная хоста базы данных
-var dbHost string
+// Global service settings / Глобальные настройки сервиса
+var appConfig = DefaultAppConfig()
+
+// Global admin audit sink, shared by every instance / Глобальный приемник аудита, общий для всех экземпляров
+var adminAudit *auditLog
 
 // Main function - entry point of the application / точка входа в приложение
 func main() {
-	// Get database host from environment variable or use default / Получение хоста базы данных из переменной окружения или использование значения по умолчанию
-	dbHost = os.Getenv("DB_HOST")
-	if dbHost == "" {
-		dbHost = "localhost"
+	// Read service settings / Читаем настройки сервиса
+	// Structured logging first, so config warnings are JSON too / Сначала структурные логи, чтобы предупреждения конфига тоже были JSON
+	setupLogging()
+	appConfig = loadAppConfig()
+
+	// Open the admin audit sink once, instances come and go every restart / Открываем приемник аудита один раз, экземпляры меняются при каждом перезапуске
+	if appConfig.AdminToken != "" {
+		audit, err := openAuditLog(appConfig.AuditLog)
+		if err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		adminAudit = audit
+	}
+
+	// Profiling lives on its own port and outlives instance restarts / Профилирование живет на своем порту и переживает перезапуски экземпляров
+	pprofServer, err := startPprofServer(appConfig.PprofAddr)
+	if err != nil {
+		log.Fatalf("❌ Failed to start pprof server: %v", err)
+	}
+
+	// Connect to the database once, every instance shares this pool / Подключаемся к БД один раз, все экземпляры используют этот пул
+	// Credentials and pool size come from DB_* variables / Учетные данные и размер пула берутся из переменных DB_*
+	config := db.ConfigFromEnv()
+	log.Printf("🗄️  Database config: %s", config)
+	config.LotsCount = appConfig.LotsCount
+	config.StatementWarmupConns = appConfig.StatementWarmupConns
+	config.MinIdleConns = appConfig.MinIdleConns
+	config.QueryCacheSize = appConfig.QueryCacheSize
+	config.ReplicaDSN = appConfig.ReplicaDSN
+	config.DisableReplicas = appConfig.DisableReplicas
+	config.SaleInterval = appConfig.RestartInterval
+	dbServer, err := db.Connect(config)
+	if err != nil {
+		log.Fatalf("❌ Failed to initialize database: %v", err)
 	}
 
 	// Start the first server instance / Запускаем первый экземпляр сервера
-	if err := startNewServerInstance(); err != nil {
+	if err := startNewServerInstance(dbServer); err != nil {
 		log.Fatalf("❌ Failed to start initial server instance: %v", err)
 	}
 
-	// Setup timer for hourly restarts /  Настраиваем таймер для перезапуска каждый час
-	setupHourlyRestart()
+	// Setup timer for restarts, hourly by default / Настраиваем таймер для перезапусков, по умолчанию каждый час
+	setupHourlyRestart(dbServer, appConfig.RestartInterval)
 
-	// Block main goroutine indefinitely / Блокируем main goroutine
-	select {}
+	// Block main goroutine until SIGTERM/SIGINT / Блокируем main goroutine до SIGTERM/SIGINT
+	waitForShutdownSignal(dbServer)
+
+	if pprofServer != nil {
+		pprofServer.Close()
+	}
 }
 
-// startNewServerInstance creates and starts a new server instance / создает и запускает новый экземпляр сервера
-func startNewServerInstance() error {
+// startNewServerInstance creates and starts a new server instance on the given database / создает и запускает новый экземпляр сервера на переданной БД
+func startNewServerInstance(server *db.Server) error {
 	log.Println("🚀 Starting new server instance...")
 
-	// Initialize global database server / Инициализация глобального сервера БД
-	config := db.DefaultConfig()
-	config.Host = dbHost
-	if err := db.InitGlobalServer(config); err != nil {
-		return fmt.Errorf("failed to initialize database: %w", err)
+	if server == nil {
+		return fmt.Errorf("server is nil")
 	}
 
 	// Create new server instance / Создаем новый экземпляр сервера
 	instance := &ServerInstance{
+		server:           server,
+		dbHealth:         server,
+		dbPool:           server,
 		shutdownComplete: make(chan struct{}),
+		config:           appConfig,
+		startedAt:        time.Now(),
+		audit:            adminAudit,
+		purchaseOutcomes: newPurchaseOutcomes(appConfig.IdempotencyCacheSize),
 	}
 
-	var err error
+	// Signed reservation tokens are opt-in, the load tester expects raw UUIDs / Подписанные токены включаются явно, нагрузочный тестер ожидает UUID
+	if appConfig.TokenSecret != "" {
+		instance.tokens = token.NewSigner([]byte(appConfig.TokenSecret))
+	}
 
-	// Initialize database components / Инициализация БД компонентов
-	instance.server = db.GetGlobalServer()
-	if instance.server == nil {
-		return fmt.Errorf("server is nil")
+	// Bots hammering /checkout with one user_id are cut off early / Боты, долбящие /checkout одним user_id, отсекаются сразу
+	if appConfig.CheckoutRateLimit > 0 {
+		instance.checkoutLimiter = ratelimit.New(float64(appConfig.CheckoutRateLimit), appConfig.CheckoutRateBurst)
 	}
 
+	var err error
+
 	// Create initial sale record / Создание записи начальной распродажи
 	instance.saleID, err = instance.server.CreateInitialSale()
 	if err != nil {
@@ -99,8 +189,8 @@ func startNewServerInstance() error {
 		return fmt.Errorf("failed to create checkout repository: %w", err)
 	}
 
-	// Initialize batch inserter with 100 batch size and 50ms flush interval / Инициализация пакетной вставки с размером пакета 100 и интервалом сброса 50мс
-	instance.batchInserter = db.NewBatchInserter(instance.checkoutRepo, 100, 50*time.Millisecond)
+	// Initialize batch inserter with the configured batch size and flush interval / Инициализация пакетной вставки с настроенными размером пакета и интервалом сброса
+	instance.batchInserter = db.NewBatchInserter(instance.checkoutRepo, instance.config.CheckoutBatchSize, instance.config.CheckoutFlushInterval)
 
 	// Create sale items repository / Создание репозитория товаров в продаже
 	instance.saleItemsRepo, err = db.NewSaleItemsRepository(instance.server)
@@ -109,11 +199,38 @@ func startNewServerInstance() error {
 		return fmt.Errorf("failed to create sale items repository: %w", err)
 	}
 
-	// Initialize batch purchase updater with 10 batch size and 10ms flush interval / Инициализация пакетного обновления покупок с размером пакета 10 и интервалом сброса 10мс
-	instance.batchPurchase = db.NewBatchPurchaseUpdater(instance.saleItemsRepo, 10, 10*time.Millisecond)
-
-	// Initialize local cache with 10000 lots and 10 purchases per user / Инициализация локального кеша с 10000 лотов и 10 покупок на пользователя
-	instance.cache = megacache.NewMegacache(10000, 10)
+	// Initialize batch purchase updater with the configured batch size and flush interval / Инициализация пакетного обновления покупок с настроенными размером пакета и интервалом сброса
+	batchPurchase := db.NewBatchPurchaseUpdater(instance.saleItemsRepo, instance.config.PurchaseBatchSize, instance.config.PurchaseFlushInterval)
+	batchPurchase.SetRetry(instance.config.PurchaseRetryAttempts, db.DefaultPurchaseRetryBackoff)
+	batchPurchase.SetLostHandler(instance.purchaseLost)
+	log.Printf("📦 Batch writers: checkouts %d per batch / %v, purchases %d per batch / %v",
+		instance.config.CheckoutBatchSize, instance.config.CheckoutFlushInterval,
+		instance.config.PurchaseBatchSize, instance.config.PurchaseFlushInterval)
+	instance.batchPurchase = batchPurchase
+	instance.purchases = instance.saleItemsRepo
+	instance.reservations = instance.checkoutRepo
+	instance.cancels = instance.checkoutRepo
+	instance.renewals = instance.checkoutRepo
+
+	// Initialize local cache with configured lots count, reservation TTL and per-user limit / Инициализация локального кеша с настроенным количеством лотов, временем резерва и лимитом на пользователя
+	cacheOptions := append([]megacache.Option{
+		megacache.WithCheckoutTTL(instance.config.CheckoutTTL),
+		megacache.WithMaxCheckouts(instance.config.MaxCheckouts),
+		megacache.WithCleanupInterval(instance.config.CleanupInterval),
+	}, testClockOptions()...)
+	if instance.config.ClaimedLotPolicy == claimedLotProtect {
+		cacheOptions = append(cacheOptions, megacache.WithClaimedLotPolicy(megacache.ClaimedLotProtect))
+	}
+	if instance.config.CheckoutEndsWithSale {
+		// The sale ends at the next restart boundary / Распродажа заканчивается на следующей границе перезапуска
+		cacheOptions = append(cacheOptions, megacache.WithSaleEnd(nextRestart(instance.startedAt, instance.config.RestartInterval)))
+	}
+	instance.cache = megacache.NewMegacache(int64(instance.config.LotsCount), int64(instance.config.LimitPerUser), cacheOptions...)
+	instance.cache.SetSoftCap(megacache.SoftCap{
+		Threshold:  int64(instance.config.SoftCapThreshold),
+		MaxDelay:   instance.config.SoftCapMaxDelay,
+		RejectRate: instance.config.SoftCapRejectRate,
+	})
 
 	// ===== CACHE RECOVERY FROM DATABASE =====
 	// ===== ВОССТАНОВЛЕНИЕ КЕША ИЗ БД =====
@@ -125,27 +242,58 @@ func startNewServerInstance() error {
 
 	// Create cache recovery service / Создаем сервис восстановления кеша
 	recoveryService := db.NewCacheRecoveryService(instance.checkoutRepo, instance.saleItemsRepo)
+	recoveryService.KeepExpired = !instance.config.RecoveryCleanupExpired
+	recoveryService.Deferred = batchPurchase
 
-	// Recover cache considering sold lots / Восстанавливаем кеш с учетом проданных лотов
-	if err := recoveryService.RecoverCacheWithSoldItems(ctx, instance.cache, instance.saleID); err != nil {
+	// Recover cache considering sold lots, without persisted checkouts only purchases are restored / Восстанавливаем кеш с учетом проданных лотов, без сохраненных checkout восстанавливаются только покупки
+	recoverCache := recoveryService.RecoverCacheWithSoldItems
+	if instance.config.CheckoutPersistMode == persistModeNone {
+		recoverCache = recoveryService.RecoverCacheFromPurchases
+	}
+	if err := recoverCache(ctx, instance.cache, instance.saleID); err != nil {
 		instance.cleanup()
 		return fmt.Errorf("failed to recover cache: %w", err)
 	}
 
 	log.Println("✅ Cache recovery completed successfully")
 
+	// Prepare statements on pooled connections so first requests skip PREPARE / Готовим выражения на соединениях пула, чтобы первые запросы не ждали PREPARE
+	instance.warmupStatements(ctx)
+
+	// In background mode checkouts are bulk-persisted from cache snapshots / В фоновом режиме checkout сохраняются пачками из снимков кеша
+	if instance.config.CheckoutPersistMode == persistModeBackground {
+		instance.persister = db.NewCheckoutPersister(instance.checkoutRepo, instance.cache, instance.saleID,
+			instance.config.CheckoutPersistInterval, instance.config.CheckoutPersistBatch)
+		log.Printf("💾 Background checkout persistence every %v (batch %d)",
+			instance.config.CheckoutPersistInterval, instance.config.CheckoutPersistBatch)
+	}
+
 	// Set flag to accept requests / Устанавливаем флаг приема запросов
 	atomic.StoreInt32(&instance.isAcceptingReqs, 1)
 
 	// Setup HTTP server with routes / Настройка HTTP сервера
 	mux := http.NewServeMux()
-	mux.HandleFunc("/checkout", instance.checkoutHandler)
-	mux.HandleFunc("/purchase", instance.purchaseHandler)
-
-	instance.httpServer = &http.Server{
-		Addr:    ":8080",
-		Handler: mux,
-	}
+	// Endpoints that lead to DB writes are shed on a saturated pool / Эндпоинты, ведущие к записи в БД, сбрасываются при насыщенном пуле
+	mux.HandleFunc("/checkout", withRequestID(instance.withPoolBackpressure(instance.checkoutHandler)))
+	mux.HandleFunc("/purchase", withRequestID(instance.withPoolBackpressure(instance.purchaseHandler)))
+	mux.HandleFunc("/buy", withRequestID(instance.withPoolBackpressure(instance.buyHandler)))
+	mux.HandleFunc("/checkout/bulk", withRequestID(instance.withPoolBackpressure(instance.bulkCheckoutHandler)))
+	mux.HandleFunc("/purchase/bulk", withRequestID(instance.withPoolBackpressure(instance.bulkPurchaseHandler)))
+	mux.HandleFunc("/cancel", instance.cancelHandler)
+	mux.HandleFunc("/renew", instance.renewHandler)
+	mux.HandleFunc(checkoutInfoPath, instance.checkoutInfoHandler)
+	mux.HandleFunc("/user/state", instance.userStateHandler)
+	mux.HandleFunc("/purchases", instance.purchasesHandler)
+	mux.HandleFunc("/health", instance.healthHandler)
+	mux.HandleFunc("/item", instance.itemStatusHandler)
+	mux.HandleFunc("/available", instance.availableHandler)
+	mux.HandleFunc("/version", instance.versionHandler)
+	mux.HandleFunc("/metrics", instance.metricsHandler)
+	mux.HandleFunc("/stats", instance.statsHandler)
+	instance.registerAdminRoutes(mux)
+	instance.registerTestRoutes(mux)
+
+	instance.httpServer = newHTTPServer(instance.config, mux)
 
 	// Stop previous instance and wait for completion / Останавливаем предыдущий экземпляр и ждем его завершения
 	if oldInstance := getCurrentInstance(); oldInstance != nil {
@@ -155,13 +303,21 @@ func startNewServerInstance() error {
 		<-oldInstance.shutdownComplete
 	}
 
+	// Bind only now that the previous instance released the address, a failure is reported to the caller /
+	// Занимаем адрес только после того, как предыдущий экземпляр его освободил, ошибка возвращается вызывающему
+	listener, err := net.Listen("tcp", instance.config.ListenAddr)
+	if err != nil {
+		instance.cleanup()
+		return fmt.Errorf("failed to listen on %s: %w", instance.config.ListenAddr, err)
+	}
+
 	// Set new current instance / Устанавливаем новый текущий экземпляр
 	currentInstance.Store(instance)
 
 	// Start HTTP server in separate goroutine / Запускаем HTTP сервер в отдельной горутине
 	go func() {
-		log.Printf("🌐 Server starting on port 8080... Sale ID: %d", instance.saleID)
-		if err := instance.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Printf("🌐 Server listening on %s (h2c: %v)... Sale ID: %d", listener.Addr(), instance.config.EnableH2C, instance.saleID)
+		if err := instance.httpServer.Serve(listener); err != nil && err != http.ErrServerClosed {
 			log.Printf("❌ HTTP server error: %v", err)
 		}
 	}()
@@ -169,6 +325,22 @@ func startNewServerInstance() error {
 	return nil
 }
 
+// newHTTPServer builds the sale API server; ENABLE_H2C adds cleartext HTTP/2 next to HTTP/1.1, TLS is terminated upstream /
+// создает сервер API распродажи; ENABLE_H2C добавляет HTTP/2 без шифрования рядом с HTTP/1.1, TLS завершается выше
+func newHTTPServer(config *AppConfig, handler http.Handler) *http.Server {
+	server := &http.Server{
+		Addr:    config.ListenAddr,
+		Handler: handler,
+	}
+	if config.EnableH2C {
+		protocols := new(http.Protocols)
+		protocols.SetHTTP1(true)
+		protocols.SetUnencryptedHTTP2(true)
+		server.Protocols = protocols
+	}
+	return server
+}
+
 // getCurrentInstance returns the current active server instance / возвращает текущий активный экземпляр сервера
 func getCurrentInstance() *ServerInstance {
 	if instance := currentInstance.Load(); instance != nil {
@@ -177,37 +349,87 @@ func getCurrentInstance() *ServerInstance {
 	return nil
 }
 
-// setupHourlyRestart configures automatic hourly server restarts / настраивает автоматические ежечасные перезапуски сервера
-func setupHourlyRestart() {
+// waitForShutdownSignal blocks until SIGTERM/SIGINT, then drains the current instance so buffered checkouts and purchases reach the DB /
+// блокирует до SIGTERM/SIGINT, затем останавливает текущий экземпляр, чтобы накопленные checkout и покупки попали в БД
+func waitForShutdownSignal(server *db.Server) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+
+	sig := <-signals
+	// A second signal kills the process right away / Повторный сигнал сразу завершает процесс
+	signal.Stop(signals)
+	log.Printf("🛑 Received %v, shutting down...", sig)
+
+	// Never released: no restart may start a new instance after this / Не освобождается: после этого ни один перезапуск не поднимет новый экземпляр
+	instanceMu.Lock()
+
+	if instance := getCurrentInstance(); instance != nil {
+		go instance.gracefulShutdown()
+		<-instance.shutdownComplete
+	}
+
+	if err := server.Close(); err != nil {
+		log.Printf("❌ Database close error: %v", err)
+	}
+	log.Println("👋 Shutdown complete")
+}
+
+// setupHourlyRestart restarts the server on every interval boundary, hourly by default / перезапускает сервер на каждой границе интервала, по умолчанию каждый час
+func setupHourlyRestart(server *db.Server, interval time.Duration) {
 	go func() {
-		// Calculate time until next hour / Вычисляем время до следующего часа
+		// Time until the next boundary / Время до следующей границы
 		now := time.Now()
-		nextHour := now.Truncate(time.Hour).Add(time.Hour)
-		//nextHour := now.Truncate(time.Minute).Add(time.Minute) // For testing: restart every minute / Для тестирования: перезапуск каждую минуту
-		timeUntilNextHour := nextHour.Sub(now)
+		next := nextRestart(now, interval)
 
-		log.Printf("⏰ Next restart scheduled at: %s (in %v)", nextHour.Format("15:04:05"), timeUntilNextHour)
+		log.Printf("⏰ Next restart scheduled at: %s (in %v), then every %v", next.Format("15:04:05"), next.Sub(now), interval)
 
-		// First timer until next hour / Первый таймер до следующего часа
-		timer := time.NewTimer(timeUntilNextHour)
+		// First timer until the next boundary / Первый таймер до следующей границы
+		timer := time.NewTimer(next.Sub(now))
 
 		for {
 			<-timer.C
 
-			log.Println("🔄 Hourly restart triggered")
+			log.Println("🔄 Scheduled restart triggered")
 
 			// Start new server instance / Запускаем новый экземпляр сервера
-			if err := startNewServerInstance(); err != nil {
+			instanceMu.Lock()
+			if err := startNewServerInstance(server); err != nil {
 				log.Printf("❌ Failed to restart server: %v", err)
 			}
+			instanceMu.Unlock()
 
-			// Set timer for next hour / Устанавливаем таймер на следующий час
-			timer.Reset(time.Hour)
-			//timer.Reset(time.Minute) // For testing: restart every minute / Для тестирования: перезапуск каждую минуту
+			// Aim at the next boundary, so time spent restarting doesn't accumulate / Целимся в следующую границу, чтобы время перезапуска не накапливалось
+			timer.Reset(time.Until(nextRestart(time.Now(), interval)))
 		}
 	}()
 }
 
+// saleIntervalOrigin matches the date_bin origin in create_new_sale, so restarts and DB sale windows share boundaries /
+// совпадает с началом отсчета date_bin в create_new_sale, чтобы перезапуски и окна распродаж в БД имели общие границы
+var saleIntervalOrigin = time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// nextRestart returns the first interval boundary after now, e.g. the next full hour / возвращает первую границу интервала после now, например следующий полный час
+func nextRestart(now time.Time, interval time.Duration) time.Time {
+	elapsed := now.Sub(saleIntervalOrigin)
+	return saleIntervalOrigin.Add(elapsed - elapsed%interval + interval)
+}
+
+// warmupStatements prepares repository statements across the pool, failures only cost first-request latency / готовит выражения репозиториев на соединениях пула, ошибка стоит лишь задержки первых запросов
+func (s *ServerInstance) warmupStatements(ctx context.Context) {
+	start := time.Now()
+
+	conns, err := s.checkoutRepo.Warmup(ctx)
+	if err == nil {
+		_, err = s.saleItemsRepo.Warmup(ctx)
+	}
+	if err != nil {
+		log.Printf("⚠️  Statement warmup failed: %v", err)
+		return
+	}
+
+	log.Printf("🔥 Prepared statements warmed up on %d connections in %v", conns, time.Since(start))
+}
+
 // gracefulShutdown performs graceful shutdown of the server instance / выполняет корректное завершение работы экземпляра сервера
 func (s *ServerInstance) gracefulShutdown() {
 	log.Println("🛑 Starting graceful shutdown of server instance...")
@@ -236,12 +458,24 @@ func (s *ServerInstance) gracefulShutdown() {
 
 // cleanup releases all resources used by the server instance / освобождает все ресурсы, используемые экземпляром сервера
 func (s *ServerInstance) cleanup() {
+	// Lost deferred purchases are fed back into the cache, so it closes after the updater / Потерянные отложенные покупки возвращаются в кеш, поэтому он закрывается после обновления
+	if s.batchPurchase != nil {
+		s.batchPurchase.Close()
+	}
+
 	if s.cache != nil {
 		s.cache.Close()
 	}
 
-	if s.batchPurchase != nil {
-		s.batchPurchase.Close()
+	if s.checkoutLimiter != nil {
+		s.checkoutLimiter.Close()
+	}
+
+	// Final snapshot must be written before the repository closes / Финальный снимок должен быть записан до закрытия репозитория
+	if s.persister != nil {
+		if err := s.persister.Close(); err != nil {
+			log.Printf("❌ Final checkout persist failed: %v", err)
+		}
 	}
 
 	if s.saleItemsRepo != nil {
@@ -276,55 +510,187 @@ func (s *ServerInstance) checkoutHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	// Parse query parameters / Парсинг параметров запроса
-	queryParams, err := url.ParseQuery(r.URL.RawQuery)
+	// Parse query and body parameters / Парсинг параметров запроса и тела
+	queryParams, err := parseRequestParams(r, s.config.BodyContentTypePolicy)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		w.WriteHeader(paramsErrorStatus(err))
 		return
 	}
 
 	userIDStr := queryParams.Get("user_id")
 	itemIDStr := queryParams.Get("item_id")
 
-	userID, err := strconv.ParseInt(userIDStr, 10, 64)
+	// Bounded before it reaches the cache or the DB / Проверяется до попадания в кеш или БД
+	userID, err := parseUserID(userIDStr, s.config.MaxUserID)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
+	// Rate limit before any item work / Лимит частоты до любой работы с лотом
+	if !s.allowCheckout(userID) {
+		s.writeRateLimited(w)
+		return
+	}
+
 	itemID, err := strconv.ParseInt(itemIDStr, 10, 64)
-	if err != nil || itemID < 0 || itemID >= 10_000 {
+	if err != nil || itemID < 0 || itemID >= int64(s.config.LotsCount) {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
-	// Stage 1: Reserve in local cache / резервирование в локальном кеше
-	checkout, err := s.cache.Checkout(userID, itemID)
-	if err != nil {
-		w.WriteHeader(http.StatusConflict)
+	// Stage 1-2: Reserve in cache and save to DB / резервирование в кеше и сохранение в БД
+	checkout, status, _ := s.reserveItem(r, userID, itemID)
+	if status != http.StatusOK {
+		w.WriteHeader(status)
 		return
 	}
 
-	// Stage 2: Save reservation to database / сохранение резервирования в БД
-	record := db.CheckoutRecord{
-		UserID:    userID,
-		ItemID:    itemID,
-		Code:      checkout.Code,
-		CreatedAt: checkout.CreatedAt,
-		ExpiresAt: checkout.ExpiresAt,
+	// Return checkout code to client, Location points at the reservation / Возвращаем код checkout клиенту, Location указывает на резервирование
+	code := s.reservationCode(checkout)
+	if s.config.CheckoutCreatedStatus {
+		status = http.StatusCreated
 	}
+	w.Header().Set("Location", checkoutInfoPath+"?code="+url.QueryEscape(code))
+	writeResponse(w, r, status, checkoutResponse{Code: code, ExpiresAt: checkout.ExpiresAt, ItemID: itemID})
+}
 
-	// Add to batch inserter, rollback cache on failure / Добавление в пакетную вставку, откат кеша при ошибке
-	if err := s.batchInserter.Add(record); err != nil {
-		s.cache.DeleteCheckout(checkout.Code)
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+// allowCheckout takes a checkout token for the user, always true without a limiter / забирает токен checkout пользователя, без ограничителя всегда true
+func (s *ServerInstance) allowCheckout(userID int64) bool {
+	if s.checkoutLimiter == nil || s.checkoutLimiter.Allow(userID) {
+		return true
+	}
+	s.metrics.rateLimited.Add(1)
+	return false
+}
+
+// writeRateLimited answers 429 with the wait for the next token / отвечает 429 со временем ожидания следующего токена
+func (s *ServerInstance) writeRateLimited(w http.ResponseWriter) {
+	retryAfter := int(math.Ceil(s.checkoutLimiter.RetryAfter().Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	w.WriteHeader(http.StatusTooManyRequests)
+}
+
+// reserveItem reserves a lot in the cache and saves it to the DB, status is the HTTP outcome for this item /
+// резервирует лот в кеше и сохраняет его в БД, status - HTTP исход для этого лота
+func (s *ServerInstance) reserveItem(r *http.Request, userID, itemID int64) (megacache.Checkout, int, error) {
+	logger := requestLogger(r.Context()).With("user_id", userID, "item_id", itemID)
+
+	// Stage 1: Reserve in local cache, a gone client takes nothing / резервирование в локальном кеше, ушедший клиент ничего не занимает
+	checkout, err := s.cache.CheckoutCtx(r.Context(), userID, itemID)
+	if err != nil {
+		logger.Debug("reserve refused", "error", err)
+		if status, ok := contextErrorStatus(err); ok {
+			return megacache.Checkout{}, status, err
+		}
+		status := checkoutRefusedStatus(err)
+		if status == http.StatusConflict {
+			s.metrics.conflicts.Add(1)
+		}
+		return megacache.Checkout{}, status, err
+	}
+
+	// Stage 2: Save reservation to database (background mode leaves it to the persister) / сохранение резервирования в БД (в фоновом режиме это делает persister)
+	if s.config.CheckoutPersistMode == persistModeSync {
+		record := db.CheckoutRecord{
+			SaleID:    s.saleID,
+			UserID:    userID,
+			ItemID:    itemID,
+			Code:      checkout.Code,
+			CreatedAt: checkout.CreatedAt,
+			ExpiresAt: checkout.ExpiresAt,
+		}
+
+		// Wait for the batch no longer than the client agreed to / Ждем пакет не дольше, чем согласен клиент
+		ctx, cancel := context.WithTimeout(r.Context(), requestWait(r, s.config.MaxClientWait))
+		defer cancel()
+
+		// Add to batch inserter, rollback cache on failure / Добавление в пакетную вставку, откат кеша при ошибке
+		if err := s.batchInserter.AddContext(ctx, record); err != nil {
+			logger.Warn("rollback reserve: db insert failed", "code", checkout.Code, "error", err)
+			// Cancel releases the lot, delete drops the record / Отмена освобождает лот, удаление убирает запись
+			s.cache.CancelCheckout(checkout.Code)
+			s.cache.DeleteCheckout(checkout.Code)
+			if status, ok := contextErrorStatus(err); ok {
+				return megacache.Checkout{}, status, err
+			}
+			if errors.Is(err, db.ErrConnection) {
+				// Lost DB connection, the client may retry / Потеряно соединение с БД, клиент может повторить
+				return megacache.Checkout{}, http.StatusServiceUnavailable, err
+			}
+			s.metrics.internalErrors.Add(1)
+			return megacache.Checkout{}, http.StatusInternalServerError, err
+		}
+		logger.Debug("db insert", "code", checkout.Code)
+		s.writes.recordCheckoutRows(1)
 	}
 
-	// Return checkout code to client / Возвращаем код checkout клиенту
-	w.WriteHeader(http.StatusOK)
-	w.Header().Set("Content-Type", "text/plain")
-	fmt.Fprintf(w, "%s", checkout.Code)
+	logger.Debug("reserve", "code", checkout.Code)
+	s.metrics.checkouts.Add(1)
+	return checkout, http.StatusOK, nil
+}
+
+// checkoutRefusedStatus maps why the cache refused to take a lot to its HTTP status, so clients tell "try another item" from "stop" /
+// сопоставляет причину отказа кеша в захвате лота с HTTP статусом, чтобы клиент отличал "попробуй другой лот" от "хватит"
+func checkoutRefusedStatus(err error) int {
+	switch {
+	case errors.Is(err, megacache.ErrAllItemsPurchased):
+		return http.StatusGone
+	case errors.Is(err, megacache.ErrUserLimitExceeded):
+		return http.StatusTooManyRequests
+	case errors.Is(err, megacache.ErrInvalidItemID):
+		return http.StatusBadRequest
+	case errors.Is(err, megacache.ErrServiceOverloaded):
+		// Soft cap asks the client to retry later / Мягкий лимит просит клиента повторить позже
+		return http.StatusServiceUnavailable
+	default:
+		// This lot is reserved or sold / Этот лот зарезервирован или продан
+		return http.StatusConflict
+	}
+}
+
+// purchaseRefusedStatus maps why the cache refused a purchase to its HTTP status, anything unlisted is a conflict /
+// сопоставляет причину отказа кеша в покупке с HTTP статусом, все прочее - конфликт
+func purchaseRefusedStatus(err error) int {
+	switch {
+	case errors.Is(err, megacache.ErrReservationNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, megacache.ErrReservationExpired):
+		return http.StatusGone
+	case errors.Is(err, megacache.ErrUserLimitExceeded):
+		return http.StatusTooManyRequests
+	default:
+		// Already bought, lot claimed elsewhere, revoked or sold out / Уже куплен, лот занят в обход, отозван или распродано
+		return http.StatusConflict
+	}
+}
+
+// contextErrorStatus maps an abandoned request to 504 on deadline and 503 on cancel, these are not conflicts /
+// сопоставляет брошенный запрос с 504 по дедлайну и 503 по отмене, это не конфликты
+func contextErrorStatus(err error) (int, bool) {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout, true
+	case errors.Is(err, context.Canceled):
+		return http.StatusServiceUnavailable, true
+	}
+	return 0, false
+}
+
+// reservationCode returns the code sent to the client: raw UUID or signed token / возвращает код для клиента: UUID или подписанный токен
+func (s *ServerInstance) reservationCode(checkout megacache.Checkout) string {
+	if s.tokens == nil {
+		return checkout.Code.String()
+	}
+	return s.tokens.Sign(checkout.Code, checkout.ExpiresAt)
+}
+
+// parseReservationCode parses a code produced by reservationCode / разбирает код, выданный reservationCode
+func (s *ServerInstance) parseReservationCode(value string) (uuid.UUID, error) {
+	if s.tokens == nil {
+		return uuid.Parse(value)
+	}
+	return s.tokens.Verify(value, time.Now())
 }
 
 // purchaseHandler handles POST requests to complete purchases using checkout codes / обрабатывает POST запросы для завершения покупок с использованием кодов checkout
@@ -341,41 +707,157 @@ func (s *ServerInstance) purchaseHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	// Parse query parameters / Парсинг параметров запроса
-	queryParams, err := url.ParseQuery(r.URL.RawQuery)
+	// Parse query and body parameters / Парсинг параметров запроса и тела
+	queryParams, err := parseRequestParams(r, s.config.BodyContentTypePolicy)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		w.WriteHeader(paramsErrorStatus(err))
 		return
 	}
 
 	codeStr := queryParams.Get("code")
 
-	// Parse string to UUID / Парсим строку в UUID
-	code, err := uuid.Parse(codeStr)
+	// Parse string to UUID, verifying the signature first when tokens are on / Парсим строку в UUID, при включенных токенах сначала проверяем подпись
+	code, err := s.parseReservationCode(codeStr)
 	if err != nil {
+		if errors.Is(err, token.ErrExpired) {
+			s.metrics.conflicts.Add(1)
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
-	// Stage 1: Attempt purchase in cache / попытка покупки в кеше
-	checkout, success := s.cache.TryPurchase(code)
-	if !success {
-		w.WriteHeader(http.StatusConflict)
+	// A retried request gets the original answer instead of a 409 for its own purchase / Повторный запрос получает исходный ответ вместо 409 на собственную покупку
+	outcomeKey, ok := purchaseOutcomeKey(r, code)
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if outcome, ok := s.purchaseOutcomes.Get(outcomeKey); ok {
+		// One key, one purchase / Один ключ - одна покупка
+		if outcome.code != code {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			return
+		}
+		w.Header().Set(idempotentReplayedHeader, "true")
+		if !outcome.succeeded() {
+			w.WriteHeader(outcome.status)
+			return
+		}
+		writeResponse(w, r, outcome.status, outcome.body)
 		return
 	}
 
+	// Stage 1-3: Purchase in cache, save to DB, confirm in cache / покупка в кеше, сохранение в БД, подтверждение в кеше
+	checkout, status, err := s.purchaseReserved(r.Context(), code)
+	body := s.purchaseBody(codeStr, checkout)
+	if finalPurchaseStatus(status) && !errors.Is(err, errStaleSale) {
+		s.purchaseOutcomes.Put(outcomeKey, purchaseOutcome{code: code, status: status, body: body})
+	}
+	if err != nil {
+		if errors.Is(err, errStaleSale) {
+			s.writeStaleSale(w)
+			return
+		}
+		w.WriteHeader(status)
+		return
+	}
+
+	// 202 when the DB write was deferred / 202, если запись в БД отложена
+	writeResponse(w, r, status, body)
+}
+
+// purchaseLost undoes a deferred purchase that was answered 202 but never written: the cache gives the buyer's slot back,
+// releases the lot unless the DB sold it to someone else, and retries of the code get 409 instead of the old 202 /
+// отменяет отложенную покупку, получившую 202, но так и не записанную: кеш возвращает слот покупателя,
+// освобождает лот, если только БД не продала его другому, а повторы по коду получают 409 вместо прежнего 202
+func (s *ServerInstance) purchaseLost(purchase db.ItemPurchase, sold bool) {
+	if purchase.SaleID != s.saleID {
+		return
+	}
+	code, found := s.cache.RevokePurchase(purchase.UserID, purchase.ItemID, sold)
+	if found {
+		s.purchaseOutcomes.Revoke(code, http.StatusConflict)
+	}
+	log.Printf("❌ Deferred purchase revoked: item_id=%d, user_id=%d, code=%s, sold elsewhere=%v",
+		purchase.ItemID, purchase.UserID, code, sold)
+}
+
+// purchaseBody describes a purchase of the current sale made just now / описывает только что совершенную покупку текущей распродажи
+func (s *ServerInstance) purchaseBody(code string, checkout megacache.Checkout) purchaseResponse {
+	return purchaseResponse{
+		Code:        code,
+		ItemID:      checkout.LotIndex,
+		UserID:      checkout.UserID,
+		PurchasedAt: time.Now().UTC(),
+		SaleID:      s.saleID,
+	}
+}
+
+// purchaseReserved buys a reserved lot in the cache, saves it to the DB and confirms it, status is the HTTP outcome for this code /
+// покупает зарезервированный лот в кеше, сохраняет в БД и подтверждает, status - HTTP исход для этого кода
+func (s *ServerInstance) purchaseReserved(ctx context.Context, code uuid.UUID) (megacache.Checkout, int, error) {
+	logger := requestLogger(ctx).With("code", code)
+
+	// Stage 1: Attempt purchase in cache, skipped once the client is gone / попытка покупки в кеше, пропускается, если клиент ушел
+	checkout, err := s.cache.TryPurchaseCtx(ctx, code)
+	if err != nil {
+		logger.Debug("purchase refused", "error", err)
+		if status, ok := contextErrorStatus(err); ok {
+			return megacache.Checkout{}, status, err
+		}
+		// Codes from a previous sale are never in the current cache / Кодов прошлой распродажи никогда нет в текущем кеше
+		if errors.Is(err, megacache.ErrReservationNotFound) && s.isStaleSaleCode(ctx, code) {
+			return megacache.Checkout{}, s.config.StaleSaleStatus, errStaleSale
+		}
+		status := purchaseRefusedStatus(err)
+		if status == http.StatusConflict {
+			s.metrics.conflicts.Add(1)
+		}
+		return megacache.Checkout{}, status, err
+	}
+
 	// Stage 2: Attempt purchase in database / попытка покупки в БД
+	// A deferred purchase is written in the background, the lot stays with the buyer / Отложенная покупка пишется в фоне, лот остается за покупателем
 	err = s.batchPurchase.Purchase(s.saleID, checkout.LotIndex, checkout.UserID)
-	if err != nil {
+	deferred := errors.Is(err, db.ErrPurchaseDeferred)
+	if err != nil && !deferred {
 		// Rollback purchase in cache on database failure / откат покупки в кеше
 		s.cache.RollbackPurchase(code)
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+		logger.Warn("rollback purchase: db update failed", "user_id", checkout.UserID, "item_id", checkout.LotIndex, "error", err)
+		switch {
+		case errors.Is(err, db.ErrItemNotAvailable):
+			// DB already sold the lot, the cache was behind / БД уже продала лот, кеш отстал
+			s.cache.CancelCheckout(code)
+			s.cache.DeleteCheckout(code)
+			s.cache.MarkSold(checkout.LotIndex)
+			s.metrics.conflicts.Add(1)
+			return megacache.Checkout{}, http.StatusConflict, err
+		case errors.Is(err, db.ErrUpdaterClosed), errors.Is(err, context.Canceled):
+			// Batch updater is closing during restart / Пакетное обновление закрывается при перезапуске
+			return megacache.Checkout{}, http.StatusServiceUnavailable, err
+		case errors.Is(err, db.ErrConnection):
+			// Lost DB connection, the client may retry / Потеряно соединение с БД, клиент может повторить
+			return megacache.Checkout{}, http.StatusServiceUnavailable, err
+		default:
+			s.metrics.internalErrors.Add(1)
+			return megacache.Checkout{}, http.StatusInternalServerError, err
+		}
 	}
 
 	// Stage 3: Confirm purchase in cache / закрываем покупку в кеше
-	s.cache.ConfirmPurchase(code)
+	// The DB already has the purchase, so a failed confirm only means the cache lost the reservation / Покупка уже в БД, поэтому ошибка подтверждения значит лишь, что кеш потерял резерв
+	if err := s.cache.ConfirmPurchase(code); err != nil {
+		logger.Warn("purchase saved but not confirmed in cache", "error", err)
+	}
+	s.writes.recordPurchase()
+	s.metrics.purchases.Add(1)
 
-	w.WriteHeader(http.StatusOK)
-	w.Header().Set("Content-Type", "text/plain")
+	if deferred {
+		logger.Warn("purchase deferred", "user_id", checkout.UserID, "item_id", checkout.LotIndex, "error", err)
+		return checkout, http.StatusAccepted, nil
+	}
+	logger.Debug("purchase", "user_id", checkout.UserID, "item_id", checkout.LotIndex)
+	return checkout, http.StatusOK, nil
 }
@@ -0,0 +1,2143 @@
+package main
+
+import (
+	"bytes"
+	"contest_notcoin/db"
+	"contest_notcoin/megacache"
+	"contest_notcoin/ratelimit"
+	"contest_notcoin/token"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// slowSaver simulates a batch inserter that blocks until its batch is flushed
+type slowSaver struct {
+	delay time.Duration
+}
+
+func (s *slowSaver) AddContext(ctx context.Context, record db.CheckoutRecord) error {
+	select {
+	case <-time.After(s.delay):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *slowSaver) Close() error { return nil }
+
+// newTestInstance builds a ServerInstance backed only by the cache and fakes
+func newTestInstance(itemsCount int64, config *AppConfig, saver checkoutSaver) *ServerInstance {
+	instance := &ServerInstance{
+		cache:            megacache.NewMegacache(itemsCount, int64(config.LimitPerUser)),
+		batchInserter:    saver,
+		saleID:           1,
+		shutdownComplete: make(chan struct{}),
+		config:           config,
+		isAcceptingReqs:  1,
+	}
+	return instance
+}
+
+// noopSaver accepts every record immediately
+type noopSaver struct{}
+
+func (noopSaver) AddContext(ctx context.Context, record db.CheckoutRecord) error { return nil }
+func (noopSaver) Close() error                                                   { return nil }
+
+// failingSaver fails every checkout insert with a fixed error
+type failingSaver struct {
+	err error
+}
+
+func (f failingSaver) AddContext(ctx context.Context, record db.CheckoutRecord) error { return f.err }
+func (f failingSaver) Close() error                                                   { return nil }
+
+// TestCheckoutHandlerDBOutcomes tests that a failed insert releases the lot and maps DB error categories to statuses
+func TestCheckoutHandlerDBOutcomes(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+	}{
+		{"DB connection lost", fmt.Errorf("insert checkouts: %w", db.ErrConnection), http.StatusServiceUnavailable},
+		{"constraint violation", fmt.Errorf("insert checkouts: %w", db.ErrConstraint), http.StatusInternalServerError},
+		{"unclassified failure", errors.New("boom"), http.StatusInternalServerError},
+		{"client gone", context.Canceled, http.StatusServiceUnavailable},
+		{"client deadline", context.DeadlineExceeded, http.StatusGatewayTimeout},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := newTestInstance(100, DefaultAppConfig(), failingSaver{err: tt.err})
+			defer instance.cache.Close()
+
+			rec := httptest.NewRecorder()
+			instance.checkoutHandler(rec, httptest.NewRequest(http.MethodPost, "/checkout?user_id=1&item_id=5", nil))
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			if tt.wantStatus != http.StatusInternalServerError {
+				assert.Zero(t, instance.metrics.internalErrors.Load(), "not a server fault")
+			}
+
+			status, err := instance.cache.GetLotStatus(5)
+			require.NoError(t, err)
+			assert.Equal(t, megacache.StatusAvailable, status)
+		})
+	}
+}
+
+// TestCheckoutHandlerJSONBody tests that checkout params can be sent as JSON
+func TestCheckoutHandlerJSONBody(t *testing.T) {
+	instance := newTestInstance(100, DefaultAppConfig(), noopSaver{})
+	defer instance.cache.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/checkout", strings.NewReader(`{"user_id": 1, "item_id": "5"}`))
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	rec := httptest.NewRecorder()
+	instance.checkoutHandler(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	_, err := uuid.Parse(rec.Body.String())
+	assert.NoError(t, err)
+}
+
+// TestCheckoutHandlerLocation tests the Location header and the configurable success status
+func TestCheckoutHandlerLocation(t *testing.T) {
+	tests := []struct {
+		name       string
+		created    bool
+		wantStatus int
+	}{
+		{"default", false, http.StatusOK},
+		{"created", true, http.StatusCreated},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultAppConfig()
+			config.CheckoutCreatedStatus = tt.created
+			instance := newTestInstance(100, config, noopSaver{})
+			defer instance.cache.Close()
+
+			rec := httptest.NewRecorder()
+			instance.checkoutHandler(rec, httptest.NewRequest(http.MethodPost, "/checkout?user_id=1&item_id=5", nil))
+
+			require.Equal(t, tt.wantStatus, rec.Code)
+			assert.Equal(t, "text/plain", rec.Header().Get("Content-Type"))
+			assert.Equal(t, "/checkout/info?code="+rec.Body.String(), rec.Header().Get("Location"))
+		})
+	}
+}
+
+// TestCheckoutHandlerJSONResponse tests Accept negotiation between JSON and plain-text responses
+func TestCheckoutHandlerJSONResponse(t *testing.T) {
+	tests := []struct {
+		name     string
+		accept   string
+		wantJSON bool
+	}{
+		{"no accept", "", false},
+		{"any", "*/*", false},
+		{"plain text", "text/plain", false},
+		{"json", "application/json", true},
+		{"json in list", "text/html, application/json;q=0.9", true},
+		{"json refused", "application/json;q=0", false},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := newTestInstance(100, DefaultAppConfig(), noopSaver{})
+			defer instance.cache.Close()
+
+			req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/checkout?user_id=1&item_id=%d", i), nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			rec := httptest.NewRecorder()
+			instance.checkoutHandler(rec, req)
+			require.Equal(t, http.StatusOK, rec.Code)
+
+			if !tt.wantJSON {
+				assert.Equal(t, "text/plain", rec.Header().Get("Content-Type"))
+				_, err := uuid.Parse(rec.Body.String())
+				assert.NoError(t, err)
+				return
+			}
+
+			assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+			var resp checkoutResponse
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+			assert.Equal(t, int64(i), resp.ItemID)
+
+			code, err := uuid.Parse(resp.Code)
+			require.NoError(t, err)
+			checkout, ok := instance.cache.GetCheckoutInfo(code)
+			require.True(t, ok)
+			assert.True(t, checkout.ExpiresAt.Equal(resp.ExpiresAt))
+		})
+	}
+}
+
+// TestNegotiateEncoder tests that Accept picks the supported format with the highest quality
+func TestNegotiateEncoder(t *testing.T) {
+	tests := []struct {
+		accept []string
+		want   string
+	}{
+		{nil, contentTypePlain},
+		{[]string{"*/*"}, contentTypePlain},
+		{[]string{"text/html"}, contentTypePlain},
+		{[]string{"text/plain"}, contentTypePlain},
+		{[]string{"application/json"}, contentTypeJSON},
+		{[]string{"application/x-protobuf"}, contentTypeProtobuf},
+		{[]string{"application/json, application/x-protobuf"}, contentTypeJSON},
+		{[]string{"application/json;q=0.5, application/x-protobuf"}, contentTypeProtobuf},
+		{[]string{"text/plain;q=0.1", "application/json;q=0.2"}, contentTypeJSON},
+		{[]string{"application/x-protobuf;q=0"}, contentTypePlain},
+		{[]string{"application/json;q=bad"}, contentTypePlain},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		for _, value := range tt.accept {
+			req.Header.Add("Accept", value)
+		}
+		assert.Equal(t, tt.want, negotiateEncoder(req).contentType(), "Accept %q", tt.accept)
+	}
+}
+
+// TestResponseProtobuf tests the protobuf wire encoding of response bodies
+func TestResponseProtobuf(t *testing.T) {
+	checkout := checkoutResponse{Code: "ab", ExpiresAt: time.UnixMilli(1000), ItemID: 42}
+	assert.Equal(t, []byte{0x0a, 0x02, 'a', 'b', 0x10, 0xe8, 0x07, 0x18, 0x2a}, checkout.protobuf())
+
+	// proto3 omits default values
+	assert.Equal(t, []byte{0x0a, 0x02, 'a', 'b'}, purchaseResponse{Code: "ab"}.protobuf())
+}
+
+// TestPurchaseHandlerResponseFormats tests the purchase confirmation in every negotiated format
+func TestPurchaseHandlerResponseFormats(t *testing.T) {
+	tests := []struct {
+		accept   string
+		wantType string
+	}{
+		{"", contentTypePlain},
+		{"application/json", contentTypeJSON},
+		{"application/x-protobuf", contentTypeProtobuf},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.wantType, func(t *testing.T) {
+			instance := newTestInstance(100, DefaultAppConfig(), noopSaver{})
+			instance.batchPurchase = noopPurchaseSaver{}
+			defer instance.cache.Close()
+
+			checkout, err := instance.cache.Checkout(1, int64(i))
+			require.NoError(t, err)
+			code := checkout.Code.String()
+
+			req := httptest.NewRequest(http.MethodPost, "/purchase?code="+code, nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			rec := httptest.NewRecorder()
+			instance.purchaseHandler(rec, req)
+
+			require.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, tt.wantType, rec.Header().Get("Content-Type"))
+
+			want := purchaseResponse{Code: code, ItemID: int64(i), UserID: 1, SaleID: instance.saleID}
+			switch tt.wantType {
+			case contentTypePlain:
+				assert.Empty(t, rec.Body.String(), "plain-text clients expect an empty body")
+			case contentTypeJSON:
+				var got purchaseResponse
+				require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &got))
+				assert.WithinDuration(t, time.Now(), got.PurchasedAt, time.Minute)
+				want.PurchasedAt = got.PurchasedAt
+				assert.Equal(t, want, got)
+			case contentTypeProtobuf:
+				// purchased_at is the only field that differs between runs
+				head := purchaseResponse{Code: code, ItemID: want.ItemID, UserID: want.UserID}.protobuf()
+				tail := appendProtoInt64(nil, 5, want.SaleID)
+				body := rec.Body.Bytes()
+				assert.True(t, bytes.HasPrefix(body, head), "code, item_id and user_id come first")
+				assert.True(t, bytes.HasSuffix(body, tail), "sale_id comes last")
+				assert.Greater(t, len(body), len(head)+len(tail), "purchased_at sits in between")
+			}
+		})
+	}
+}
+
+// TestSuccessHeadersBeforeWriteHeader tests that headers of successful checkouts and purchases are sent, not set after WriteHeader
+func TestSuccessHeadersBeforeWriteHeader(t *testing.T) {
+	instance := newTestInstance(100, DefaultAppConfig(), noopSaver{})
+	instance.batchPurchase = noopPurchaseSaver{}
+	defer instance.cache.Close()
+
+	// Result() snapshots headers at WriteHeader, unlike rec.Header() which also sees late writes
+	for i, accept := range []string{"", contentTypeJSON, contentTypeProtobuf} {
+		req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/checkout?user_id=%d&item_id=%d", i+1, i), nil)
+		req.Header.Set("Accept", accept)
+		rec := httptest.NewRecorder()
+		instance.checkoutHandler(rec, req)
+		result := rec.Result()
+		require.Equal(t, http.StatusOK, result.StatusCode)
+		assert.Equal(t, negotiateEncoder(req).contentType(), result.Header.Get("Content-Type"), "checkout, Accept %q", accept)
+
+		location, err := url.Parse(result.Header.Get("Location"))
+		require.NoError(t, err)
+		code := location.Query().Get("code")
+		require.NotEmpty(t, code)
+
+		req = httptest.NewRequest(http.MethodPost, "/purchase?code="+code, nil)
+		req.Header.Set("Accept", accept)
+		rec = httptest.NewRecorder()
+		instance.purchaseHandler(rec, req)
+		result = rec.Result()
+		require.Equal(t, http.StatusOK, result.StatusCode)
+		assert.Equal(t, negotiateEncoder(req).contentType(), result.Header.Get("Content-Type"), "purchase, Accept %q", accept)
+	}
+}
+
+// TestCheckoutHandlerBodyContentType tests the configured policy for bodies without a supported Content-Type
+func TestCheckoutHandlerBodyContentType(t *testing.T) {
+	tests := []struct {
+		name        string
+		policy      string
+		contentType string
+		wantStatus  int
+	}{
+		{"no content type, ignore", bodyPolicyIgnore, "", http.StatusOK},
+		{"wrong content type, ignore", bodyPolicyIgnore, "text/xml", http.StatusOK},
+		{"no content type, reject", bodyPolicyReject, "", http.StatusUnsupportedMediaType},
+		{"wrong content type, reject", bodyPolicyReject, "text/xml", http.StatusUnsupportedMediaType},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultAppConfig()
+			config.BodyContentTypePolicy = tt.policy
+			instance := newTestInstance(100, config, noopSaver{})
+			defer instance.cache.Close()
+
+			// Body params point to another lot; with "ignore" the query params must win
+			req := httptest.NewRequest(http.MethodPost, "/checkout?user_id=1&item_id=5",
+				strings.NewReader(`{"user_id": 2, "item_id": 6}`))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			rec := httptest.NewRecorder()
+			instance.checkoutHandler(rec, req)
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			if tt.wantStatus == http.StatusOK {
+				checkout, ok := instance.cache.GetCheckoutInfo(uuid.MustParse(rec.Body.String()))
+				require.True(t, ok)
+				assert.Equal(t, int64(5), checkout.LotIndex)
+			}
+		})
+	}
+}
+
+// TestPurchaseHandlerMalformedJSON tests that a broken JSON body is a bad request
+func TestPurchaseHandlerMalformedJSON(t *testing.T) {
+	instance := newTestInstance(100, DefaultAppConfig(), noopSaver{})
+	defer instance.cache.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/purchase", strings.NewReader(`{"code":`))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	instance.purchaseHandler(rec, req)
+
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+// TestCheckoutHandlerClientDeadline tests that a short X-Max-Wait-Ms yields a timely 504 and releases the lot
+func TestCheckoutHandlerClientDeadline(t *testing.T) {
+	instance := newTestInstance(100, DefaultAppConfig(), &slowSaver{delay: time.Second})
+	defer instance.cache.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/checkout?user_id=1&item_id=5", nil)
+	req.Header.Set(maxWaitHeader, "20")
+	rec := httptest.NewRecorder()
+
+	start := time.Now()
+	instance.checkoutHandler(rec, req)
+	elapsed := time.Since(start)
+
+	assert.Equal(t, http.StatusGatewayTimeout, rec.Code)
+	assert.Less(t, elapsed, 500*time.Millisecond)
+
+	status, err := instance.cache.GetLotStatus(5)
+	require.NoError(t, err)
+	assert.Equal(t, megacache.StatusAvailable, status, "timed out checkout must release the lot")
+	assert.Equal(t, 0, instance.cache.GetActiveReservationsCount())
+}
+
+// TestRequestWait tests parsing and clamping of X-Max-Wait-Ms
+func TestRequestWait(t *testing.T) {
+	const serverMax = 2 * time.Second
+
+	tests := []struct {
+		header string
+		want   time.Duration
+	}{
+		{"", serverMax},
+		{"150", 150 * time.Millisecond},
+		{"60000", serverMax},
+		{"0", serverMax},
+		{"-5", serverMax},
+		{"soon", serverMax},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/checkout", nil)
+		if tt.header != "" {
+			req.Header.Set(maxWaitHeader, tt.header)
+		}
+		assert.Equal(t, tt.want, requestWait(req, serverMax), "header %q", tt.header)
+	}
+}
+
+// TestReservationTokens tests that checkout returns a signed token and purchase rejects forged ones
+func TestReservationTokens(t *testing.T) {
+	instance := newTestInstance(100, DefaultAppConfig(), noopSaver{})
+	instance.tokens = token.NewSigner([]byte("test-secret"))
+	defer instance.cache.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/checkout?user_id=1&item_id=5", nil)
+	rec := httptest.NewRecorder()
+	instance.checkoutHandler(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	tok := rec.Body.String()
+	_, err := uuid.Parse(tok)
+	assert.Error(t, err, "raw UUID must not be returned when tokens are enabled")
+
+	code, err := instance.parseReservationCode(tok)
+	require.NoError(t, err)
+	_, exists := instance.cache.GetCheckoutInfo(code)
+	assert.True(t, exists)
+
+	// Forged codes are rejected before any cache lookup
+	for _, forged := range []string{code.String(), "x" + tok, token.NewSigner([]byte("other")).Sign(code, time.Now().Add(time.Minute))} {
+		req := httptest.NewRequest(http.MethodPost, "/purchase?code="+forged, nil)
+		rec := httptest.NewRecorder()
+		instance.purchaseHandler(rec, req)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, "code %q", forged)
+	}
+}
+
+// fakePurchaseHistory returns fixed DB purchases
+type fakePurchaseHistory struct {
+	items []db.SaleItem
+}
+
+func (f *fakePurchaseHistory) GetPurchasedItems(ctx context.Context, userID int64) ([]db.SaleItem, error) {
+	return f.items, nil
+}
+
+func (f *fakePurchaseHistory) GetPurchasedItemsPaged(ctx context.Context, userID int64, limit, offset int) ([]db.SaleItem, int64, error) {
+	if offset >= len(f.items) {
+		return nil, int64(len(f.items)), nil
+	}
+	return f.items[offset:min(offset+limit, len(f.items))], int64(len(f.items)), nil
+}
+
+// TestUserStateHandler tests merging DB purchases with cache reservations
+func TestUserStateHandler(t *testing.T) {
+	instance := newTestInstance(100, DefaultAppConfig(), noopSaver{})
+	defer instance.cache.Close()
+
+	// Item 7: purchase already confirmed in DB but still in flight in cache
+	inFlight, err := instance.cache.Checkout(1, 7)
+	require.NoError(t, err)
+	_, ok := instance.cache.TryPurchase(inFlight.Code)
+	require.True(t, ok)
+
+	// Item 4: purchase not yet written to DB
+	pending, err := instance.cache.Checkout(1, 4)
+	require.NoError(t, err)
+	_, ok = instance.cache.TryPurchase(pending.Code)
+	require.True(t, ok)
+
+	// Item 3: plain reservation; item 5 belongs to another user
+	reserved, err := instance.cache.Checkout(1, 3)
+	require.NoError(t, err)
+	_, err = instance.cache.Checkout(2, 5)
+	require.NoError(t, err)
+
+	instance.purchases = &fakePurchaseHistory{items: []db.SaleItem{
+		{SaleID: 1, ItemID: 7, Purchased: true},
+		{SaleID: 0, ItemID: 4, Purchased: true}, // same item ID in a previous sale
+	}}
+
+	req := httptest.NewRequest(http.MethodGet, "/user/state?user_id=1", nil)
+	rec := httptest.NewRecorder()
+	instance.userStateHandler(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var state userState
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &state))
+
+	assert.Equal(t, int64(1), state.UserID)
+	assert.Len(t, state.Purchases, 2)
+	require.Len(t, state.PendingPurchases, 1)
+	assert.Equal(t, pending.Code, state.PendingPurchases[0].Code)
+	require.Len(t, state.Reservations, 1)
+	assert.Equal(t, reserved.Code, state.Reservations[0].Code)
+}
+
+// TestPurchasesHandler tests purchase history pages, the empty history and page validation
+func TestPurchasesHandler(t *testing.T) {
+	instance := newTestInstance(100, DefaultAppConfig(), noopSaver{})
+	defer instance.cache.Close()
+
+	get := func(query string) (*httptest.ResponseRecorder, purchasesResponse) {
+		rec := httptest.NewRecorder()
+		instance.purchasesHandler(rec, httptest.NewRequest(http.MethodGet, "/purchases?"+query, nil))
+		var response purchasesResponse
+		if rec.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+		}
+		return rec, response
+	}
+
+	// No purchases at all: an empty array, not null
+	instance.purchases = &fakePurchaseHistory{}
+	rec, response := get("user_id=1")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"purchases":[]`)
+	assert.Equal(t, 1, response.Page)
+	assert.Zero(t, response.Total)
+
+	items := make([]db.SaleItem, purchaseHistoryPageSize+3)
+	for i := range items {
+		items[i] = db.SaleItem{SaleID: 1, ItemID: i, Purchased: true}
+	}
+	instance.purchases = &fakePurchaseHistory{items: items}
+
+	_, response = get("user_id=1")
+	assert.Len(t, response.Purchases, purchaseHistoryPageSize)
+	assert.Equal(t, int64(len(items)), response.Total)
+
+	_, response = get("user_id=1&page=2")
+	require.Len(t, response.Purchases, 3)
+	assert.Equal(t, purchaseHistoryPageSize, response.Purchases[0].ItemID)
+
+	rec, response = get("user_id=1&page=3")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, response.Purchases)
+	assert.Contains(t, rec.Body.String(), `"purchases":[]`)
+
+	for _, query := range []string{"user_id=0", "user_id=1&page=0", "user_id=1&page=x", "user_id=1&page=999999999"} {
+		rec, _ := get(query)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, query)
+	}
+}
+
+// TestAvailableHandler tests paging through available lots with metadata and limit validation
+func TestAvailableHandler(t *testing.T) {
+	instance := newTestInstance(700, DefaultAppConfig(), noopSaver{})
+	defer instance.cache.Close()
+
+	_, err := instance.cache.Checkout(1, 1)
+	require.NoError(t, err)
+	instance.cache.LoadLotInfo(map[int64]megacache.LotInfo{2: {Name: "Mug", ImageURL: "https://example.com/mug.png"}})
+
+	get := func(query string) (*httptest.ResponseRecorder, availableResponse) {
+		rec := httptest.NewRecorder()
+		instance.availableHandler(rec, httptest.NewRequest(http.MethodGet, "/available?"+query, nil))
+		var response availableResponse
+		if rec.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+		}
+		return rec, response
+	}
+
+	rec, page := get("offset=0&limit=2")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, []availableItem{{ItemID: 0}, {ItemID: 2, Name: "Mug", ImageURL: "https://example.com/mug.png"}}, page.Items)
+	require.NotNil(t, page.NextOffset)
+	assert.Equal(t, 3, *page.NextOffset)
+
+	// Limit is clamped and the last page has no next offset
+	rec, page = get("offset=3&limit=10000")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Len(t, page.Items, maxAvailableLimit)
+	require.NotNil(t, page.NextOffset)
+	rec, page = get(fmt.Sprintf("offset=%d&limit=%d", *page.NextOffset, maxAvailableLimit))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Len(t, page.Items, 197)
+	assert.Nil(t, page.NextOffset)
+
+	rec, page = get("offset=700")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"items":[],"next_offset":null}`, rec.Body.String())
+
+	for _, query := range []string{"offset=-1", "offset=x", "limit=0", "limit=-5", "limit=abc"} {
+		rec, _ = get(query)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, "query %q", query)
+	}
+}
+
+// TestItemStatusHandler tests lot status lookup, validation and availability during shutdown
+func TestItemStatusHandler(t *testing.T) {
+	instance := newTestInstance(100, DefaultAppConfig(), noopSaver{})
+	defer instance.cache.Close()
+
+	_, err := instance.cache.Checkout(1, 5)
+	require.NoError(t, err)
+	sold, err := instance.cache.Checkout(2, 6)
+	require.NoError(t, err)
+	_, ok := instance.cache.TryPurchase(sold.Code)
+	require.True(t, ok)
+	instance.cache.LoadLotInfo(map[int64]megacache.LotInfo{6: {Name: "Cap", ImageURL: "https://example.com/cap.png"}})
+
+	// Read-only endpoint keeps answering in the shutdown window
+	instance.isAcceptingReqs = 0
+
+	tests := []struct {
+		query      string
+		wantStatus int
+		wantLot    string
+	}{
+		{"item_id=4", http.StatusOK, "available"},
+		{"item_id=5", http.StatusOK, "reserved"},
+		{"item_id=6", http.StatusOK, "sold"},
+		{"item_id=-1", http.StatusBadRequest, ""},
+		{"item_id=abc", http.StatusBadRequest, ""},
+		{"", http.StatusBadRequest, ""},
+		{"item_id=100", http.StatusNotFound, ""},
+	}
+
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		instance.itemStatusHandler(rec, httptest.NewRequest(http.MethodGet, "/item?"+tt.query, nil))
+		require.Equal(t, tt.wantStatus, rec.Code, "query %q", tt.query)
+
+		if tt.wantStatus == http.StatusOK {
+			var status itemStatus
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &status))
+			assert.Equal(t, tt.wantLot, status.Status.String(), "query %q", tt.query)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	instance.itemStatusHandler(rec, httptest.NewRequest(http.MethodGet, "/item?item_id=6", nil))
+	assert.JSONEq(t, `{"item_id":6,"name":"Cap","image_url":"https://example.com/cap.png","status":"sold"}`, rec.Body.String())
+}
+
+// TestVersionHandler tests that /version reports the injected build info and instance start
+func TestVersionHandler(t *testing.T) {
+	version, commit := buildVersion, buildCommit
+	buildVersion, buildCommit = "v1.2.3", "abc1234"
+	defer func() { buildVersion, buildCommit = version, commit }()
+
+	instance := newTestInstance(100, DefaultAppConfig(), noopSaver{})
+	instance.startedAt = time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	defer instance.cache.Close()
+
+	rec := httptest.NewRecorder()
+	instance.versionHandler(rec, httptest.NewRequest(http.MethodGet, "/version", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var info versionInfo
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &info))
+	assert.Equal(t, "v1.2.3", info.Version)
+	assert.Equal(t, "abc1234", info.Commit)
+	assert.True(t, instance.startedAt.Equal(info.InstanceStartedAt))
+	assert.True(t, processStartedAt.Equal(info.StartedAt))
+	assert.Equal(t, instance.saleID, info.SaleID)
+
+	rec = httptest.NewRecorder()
+	instance.versionHandler(rec, httptest.NewRequest(http.MethodPost, "/version", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+// TestPprofServer tests that profiles are served only when an address is configured, and never on the public port
+func TestPprofServer(t *testing.T) {
+	server, err := startPprofServer("")
+	require.NoError(t, err)
+	assert.Nil(t, server, "pprof must be off by default")
+
+	server, err = startPprofServer("127.0.0.1:0")
+	require.NoError(t, err)
+	defer server.Close()
+
+	for _, path := range []string{"/debug/pprof/", "/debug/pprof/goroutine?debug=1", "/debug/pprof/cmdline"} {
+		resp, err := http.Get("http://" + server.Addr + path)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode, path)
+	}
+
+	t.Setenv("PPROF_ADDR", ":"+publicPort)
+	assert.Empty(t, loadAppConfig().PprofAddr)
+	t.Setenv("PPROF_ADDR", "127.0.0.1:6060")
+	assert.Equal(t, "127.0.0.1:6060", loadAppConfig().PprofAddr)
+}
+
+// TestListenConfig tests LISTEN_ADDR, ENABLE_H2C and keeping pprof off the listen port
+func TestListenConfig(t *testing.T) {
+	config := loadAppConfig()
+	assert.Equal(t, defaultListenAddr, config.ListenAddr)
+	assert.False(t, config.EnableH2C)
+
+	t.Setenv("LISTEN_ADDR", "127.0.0.1:9090")
+	t.Setenv("ENABLE_H2C", "true")
+	t.Setenv("PPROF_ADDR", "127.0.0.1:9090")
+	config = loadAppConfig()
+	assert.Equal(t, "127.0.0.1:9090", config.ListenAddr)
+	assert.True(t, config.EnableH2C)
+	assert.Empty(t, config.PprofAddr, "pprof must not share the listen port")
+
+	t.Setenv("LISTEN_ADDR", "9090")
+	assert.Equal(t, defaultListenAddr, loadAppConfig().ListenAddr)
+}
+
+// TestBatchConfig tests batch sizes and flush intervals from the environment, invalid values keep the defaults
+func TestBatchConfig(t *testing.T) {
+	config := loadAppConfig()
+	assert.Equal(t, 100, config.CheckoutBatchSize)
+	assert.Equal(t, 50*time.Millisecond, config.CheckoutFlushInterval)
+	assert.Equal(t, 10, config.PurchaseBatchSize)
+	assert.Equal(t, 10*time.Millisecond, config.PurchaseFlushInterval)
+
+	t.Setenv("CHECKOUT_BATCH_SIZE", "500")
+	t.Setenv("CHECKOUT_FLUSH_MS", "5")
+	t.Setenv("PURCHASE_BATCH_SIZE", "0")
+	t.Setenv("PURCHASE_FLUSH_MS", "1.5")
+	config = loadAppConfig()
+	assert.Equal(t, 500, config.CheckoutBatchSize)
+	assert.Equal(t, 5*time.Millisecond, config.CheckoutFlushInterval)
+	assert.Equal(t, 10, config.PurchaseBatchSize)
+	assert.Equal(t, 10*time.Millisecond, config.PurchaseFlushInterval)
+}
+
+// TestHTTPServerH2C tests that ENABLE_H2C serves HTTP/2 without TLS and keeps HTTP/1.1 working
+func TestHTTPServerH2C(t *testing.T) {
+	for _, h2c := range []bool{false, true} {
+		t.Run(fmt.Sprintf("h2c=%v", h2c), func(t *testing.T) {
+			config := DefaultAppConfig()
+			config.ListenAddr = "127.0.0.1:0"
+			config.EnableH2C = h2c
+			server := newHTTPServer(config, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(r.Proto))
+			}))
+
+			listener, err := net.Listen("tcp", config.ListenAddr)
+			require.NoError(t, err)
+			go server.Serve(listener)
+			defer server.Close()
+
+			protocols := new(http.Protocols)
+			protocols.SetUnencryptedHTTP2(true)
+			h2Client := &http.Client{Transport: &http.Transport{Protocols: protocols}}
+
+			get := func(client *http.Client) (string, error) {
+				resp, err := client.Get("http://" + listener.Addr().String())
+				if err != nil {
+					return "", err
+				}
+				defer resp.Body.Close()
+				body, err := io.ReadAll(resp.Body)
+				return string(body), err
+			}
+
+			proto, err := get(http.DefaultClient)
+			require.NoError(t, err)
+			assert.Equal(t, "HTTP/1.1", proto)
+
+			proto, err = get(h2Client)
+			if h2c {
+				require.NoError(t, err)
+				assert.Equal(t, "HTTP/2.0", proto)
+			} else {
+				assert.Error(t, err, "HTTP/2 prior knowledge must fail without h2c")
+			}
+		})
+	}
+}
+
+// TestNextRestart tests that restarts land on interval boundaries
+func TestNextRestart(t *testing.T) {
+	at := func(hour, min, sec int) time.Time { return time.Date(2025, 3, 10, hour, min, sec, 0, time.UTC) }
+
+	tests := []struct {
+		now      time.Time
+		interval time.Duration
+		want     time.Time
+	}{
+		{at(12, 34, 56), time.Hour, at(13, 0, 0)},
+		{at(12, 0, 0), time.Hour, at(13, 0, 0)},
+		{at(12, 34, 56), 10 * time.Minute, at(12, 40, 0)},
+		{at(23, 55, 0), 10 * time.Minute, at(0, 0, 0).AddDate(0, 0, 1)},
+		{at(12, 34, 56), 15 * time.Minute, at(12, 45, 0)},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, nextRestart(tt.now, tt.interval), "%v every %v", tt.now, tt.interval)
+	}
+}
+
+// fakeHealth reports a fixed DB state
+type fakeHealth bool
+
+func (f fakeHealth) IsHealthy() bool { return bool(f) }
+
+// TestHealthHandler tests readiness for accepting, shutting down and DB-down states
+func TestHealthHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		accepting  int32
+		dbHealthy  bool
+		wantStatus int
+	}{
+		{"ready", 1, true, http.StatusOK},
+		{"graceful shutdown", 0, true, http.StatusServiceUnavailable},
+		{"database down", 1, false, http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := newTestInstance(100, DefaultAppConfig(), noopSaver{})
+			defer instance.cache.Close()
+			instance.isAcceptingReqs = tt.accepting
+			instance.dbHealth = fakeHealth(tt.dbHealthy)
+
+			rec := httptest.NewRecorder()
+			instance.healthHandler(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+			assert.Equal(t, tt.wantStatus, rec.Code)
+
+			var status healthStatus
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &status))
+			assert.Equal(t, tt.accepting == 1, status.Accepting)
+			assert.Equal(t, tt.dbHealthy, status.Database)
+			assert.Equal(t, instance.saleID, status.SaleID)
+		})
+	}
+
+	t.Run("GET only", func(t *testing.T) {
+		instance := newTestInstance(100, DefaultAppConfig(), noopSaver{})
+		defer instance.cache.Close()
+
+		rec := httptest.NewRecorder()
+		instance.healthHandler(rec, httptest.NewRequest(http.MethodPost, "/health", nil))
+		assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	})
+}
+
+// fakeReservationLookup returns reservations from a fixed map
+type fakeReservationLookup struct {
+	records map[uuid.UUID]db.CheckoutRecord
+}
+
+func (f *fakeReservationLookup) GetReservationByCode(ctx context.Context, code uuid.UUID) (*db.CheckoutRecord, error) {
+	record, ok := f.records[code]
+	if !ok {
+		return nil, nil
+	}
+	return &record, nil
+}
+
+// fakeReservationDeleter records deleted codes
+type fakeReservationDeleter struct {
+	deleted []uuid.UUID
+}
+
+func (f *fakeReservationDeleter) DeleteReservation(ctx context.Context, code uuid.UUID) error {
+	f.deleted = append(f.deleted, code)
+	return nil
+}
+
+func (f *fakeReservationDeleter) BatchDeleteReservations(ctx context.Context, codes []uuid.UUID) error {
+	f.deleted = append(f.deleted, codes...)
+	return nil
+}
+
+// TestCancelHandler tests early release of a reservation and the error statuses
+func TestCancelHandler(t *testing.T) {
+	instance := newTestInstance(100, DefaultAppConfig(), noopSaver{})
+	instance.batchPurchase = noopPurchaseSaver{}
+	deleter := &fakeReservationDeleter{}
+	instance.cancels = deleter
+	defer instance.cache.Close()
+
+	cancel := func(code string) int {
+		rec := httptest.NewRecorder()
+		instance.cancelHandler(rec, httptest.NewRequest(http.MethodPost, "/cancel?code="+code, nil))
+		return rec.Code
+	}
+	lotStatus := func(itemID int64) megacache.LotStatus {
+		status, err := instance.cache.GetLotStatus(itemID)
+		require.NoError(t, err)
+		return status
+	}
+
+	t.Run("releases the lot", func(t *testing.T) {
+		checkout, err := instance.cache.Checkout(1, 5)
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusOK, cancel(checkout.Code.String()))
+		assert.Equal(t, megacache.StatusAvailable, lotStatus(5))
+		assert.Equal(t, []uuid.UUID{checkout.Code}, deleter.deleted)
+
+		// Gone after the first cancel
+		assert.Equal(t, http.StatusNotFound, cancel(checkout.Code.String()))
+	})
+
+	t.Run("purchase in flight", func(t *testing.T) {
+		checkout, err := instance.cache.Checkout(1, 6)
+		require.NoError(t, err)
+		_, ok := instance.cache.TryPurchase(checkout.Code)
+		require.True(t, ok)
+
+		assert.Equal(t, http.StatusConflict, cancel(checkout.Code.String()))
+		assert.Equal(t, megacache.StatusSold, lotStatus(6))
+	})
+
+	t.Run("confirmed purchase", func(t *testing.T) {
+		checkout, err := instance.cache.Checkout(1, 7)
+		require.NoError(t, err)
+		instance.reservations = &fakeReservationLookup{records: map[uuid.UUID]db.CheckoutRecord{
+			checkout.Code: {SaleID: instance.saleID, ItemID: 7, Code: checkout.Code},
+		}}
+		defer func() { instance.reservations = nil }()
+
+		rec := httptest.NewRecorder()
+		instance.purchaseHandler(rec, httptest.NewRequest(http.MethodPost, "/purchase?code="+checkout.Code.String(), nil))
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		assert.Equal(t, http.StatusConflict, cancel(checkout.Code.String()))
+	})
+
+	t.Run("unknown and malformed codes", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, cancel(uuid.NewString()))
+		assert.Equal(t, http.StatusBadRequest, cancel("not-a-uuid"))
+	})
+
+	t.Run("none mode skips the DB", func(t *testing.T) {
+		instance.config = DefaultAppConfig()
+		instance.config.CheckoutPersistMode = persistModeNone
+		defer func() { instance.config = DefaultAppConfig() }()
+		deleter.deleted = nil
+
+		checkout, err := instance.cache.Checkout(1, 8)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, cancel(checkout.Code.String()))
+		assert.Empty(t, deleter.deleted)
+	})
+}
+
+// fakeReservationRenewer records renewed reservation expiries
+type fakeReservationRenewer struct {
+	renewed map[uuid.UUID]time.Time
+}
+
+func (f *fakeReservationRenewer) UpdateExpiresAt(ctx context.Context, code uuid.UUID, expiresAt time.Time) error {
+	f.renewed[code] = expiresAt
+	return nil
+}
+
+// TestRenewHandler tests reservation renewal over HTTP, the renewal cap and the DB update
+func TestRenewHandler(t *testing.T) {
+	instance := newTestInstance(100, DefaultAppConfig(), noopSaver{})
+	instance.batchPurchase = noopPurchaseSaver{}
+	renewer := &fakeReservationRenewer{renewed: make(map[uuid.UUID]time.Time)}
+	instance.renewals = renewer
+	defer instance.cache.Close()
+
+	renew := func(code string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/renew?code="+code, nil)
+		req.Header.Set("Accept", "application/json")
+		rec := httptest.NewRecorder()
+		instance.renewHandler(rec, req)
+		return rec
+	}
+
+	checkout, err := instance.cache.Checkout(1, 5)
+	require.NoError(t, err)
+
+	for i := 1; i <= 3; i++ {
+		rec := renew(checkout.Code.String())
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		var resp checkoutResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		assert.Equal(t, checkout.Code.String(), resp.Code)
+		assert.Equal(t, int64(5), resp.ItemID)
+		assert.True(t, resp.ExpiresAt.Equal(checkout.ExpiresAt.Add(time.Duration(i)*instance.config.CheckoutTTL)))
+		assert.True(t, resp.ExpiresAt.Equal(renewer.renewed[checkout.Code]), "DB row must follow the cache")
+	}
+
+	assert.Equal(t, http.StatusConflict, renew(checkout.Code.String()).Code, "renewals are capped")
+
+	purchased, err := instance.cache.Checkout(1, 6)
+	require.NoError(t, err)
+	_, ok := instance.cache.TryPurchase(purchased.Code)
+	require.True(t, ok)
+	assert.Equal(t, http.StatusConflict, renew(purchased.Code.String()).Code)
+
+	assert.Equal(t, http.StatusNotFound, renew(uuid.NewString()).Code)
+	assert.Equal(t, http.StatusBadRequest, renew("not-a-uuid").Code)
+
+	rec := httptest.NewRecorder()
+	instance.renewHandler(rec, httptest.NewRequest(http.MethodGet, "/renew?code="+checkout.Code.String(), nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+// TestCheckoutInfoHandler tests that the Location from /checkout resolves and the lookup outcomes
+func TestCheckoutInfoHandler(t *testing.T) {
+	instance := newTestInstance(100, DefaultAppConfig(), noopSaver{})
+	defer instance.cache.Close()
+
+	info := func(target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Header.Set("Accept", "application/json")
+		rec := httptest.NewRecorder()
+		instance.checkoutInfoHandler(rec, req)
+		return rec
+	}
+
+	rec := httptest.NewRecorder()
+	instance.checkoutHandler(rec, httptest.NewRequest(http.MethodPost, "/checkout?user_id=1&item_id=5", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	code := rec.Body.String()
+
+	// Read-only endpoint keeps answering in the shutdown window
+	instance.isAcceptingReqs = 0
+
+	rec = info(rec.Header().Get("Location"))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var resp checkoutResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.Equal(t, code, resp.Code)
+	assert.Equal(t, int64(5), resp.ItemID)
+
+	purchased, err := instance.cache.Checkout(1, 6)
+	require.NoError(t, err)
+	_, ok := instance.cache.TryPurchase(purchased.Code)
+	require.True(t, ok)
+	assert.Equal(t, http.StatusConflict, info("/checkout/info?code="+purchased.Code.String()).Code)
+
+	cancelled, err := instance.cache.Checkout(2, 7)
+	require.NoError(t, err)
+	require.NoError(t, instance.cache.CancelCheckout(cancelled.Code))
+	assert.Equal(t, http.StatusNotFound, info("/checkout/info?code="+cancelled.Code.String()).Code)
+
+	assert.Equal(t, http.StatusNotFound, info("/checkout/info?code="+uuid.NewString()).Code)
+	assert.Equal(t, http.StatusBadRequest, info("/checkout/info?code=not-a-uuid").Code)
+
+	rec = httptest.NewRecorder()
+	instance.checkoutInfoHandler(rec, httptest.NewRequest(http.MethodPost, "/checkout/info?code="+code, nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+// TestPurchaseHandlerStaleSaleCode tests that a code from a previous sale gets its own response
+func TestPurchaseHandlerStaleSaleCode(t *testing.T) {
+	instance := newTestInstance(100, DefaultAppConfig(), noopSaver{})
+	defer instance.cache.Close()
+
+	// Code issued by the previous sale, persisted but absent from the new cache
+	staleCode := uuid.New()
+	// Code of the current sale that is no longer in the cache
+	expiredCode := uuid.New()
+	instance.reservations = &fakeReservationLookup{records: map[uuid.UUID]db.CheckoutRecord{
+		staleCode:   {SaleID: instance.saleID - 1, Code: staleCode},
+		expiredCode: {SaleID: instance.saleID, Code: expiredCode},
+	}}
+
+	purchase := func(code uuid.UUID) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/purchase?code="+code.String(), nil)
+		rec := httptest.NewRecorder()
+		instance.purchaseHandler(rec, req)
+		return rec
+	}
+
+	rec := purchase(staleCode)
+	assert.Equal(t, http.StatusGone, rec.Code)
+	assert.Equal(t, staleSaleMessage, rec.Body.String())
+
+	assert.Equal(t, http.StatusNotFound, purchase(expiredCode).Code)
+	assert.Equal(t, http.StatusNotFound, purchase(uuid.New()).Code, "unknown codes are not found")
+
+	// The status is configurable
+	instance.config.StaleSaleStatus = http.StatusConflict
+	rec = purchase(staleCode)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	assert.Equal(t, staleSaleMessage, rec.Body.String())
+}
+
+// noopPurchaseSaver accepts every purchase immediately
+type noopPurchaseSaver struct{}
+
+func (noopPurchaseSaver) Purchase(saleID, itemID, userID int64) error { return nil }
+func (noopPurchaseSaver) Close() error                                { return nil }
+
+// TestWriteAmplification tests the checkout rows per purchase ratio after abandoned checkouts
+func TestWriteAmplification(t *testing.T) {
+	instance := newTestInstance(100, DefaultAppConfig(), noopSaver{})
+	instance.batchPurchase = noopPurchaseSaver{}
+	defer instance.cache.Close()
+
+	assert.Zero(t, instance.writeAmplificationStats().Ratio, "no purchases yet")
+
+	// 10 checkouts, only 4 of them are bought, the rest are abandoned
+	var codes []string
+	for i := 0; i < 10; i++ {
+		req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/checkout?user_id=%d&item_id=%d", i+1, i), nil)
+		rec := httptest.NewRecorder()
+		instance.checkoutHandler(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code)
+		codes = append(codes, rec.Body.String())
+	}
+	for _, code := range codes[:4] {
+		req := httptest.NewRequest(http.MethodPost, "/purchase?code="+code, nil)
+		rec := httptest.NewRecorder()
+		instance.purchaseHandler(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code)
+	}
+
+	stats := instance.writeAmplificationStats()
+	assert.Equal(t, int64(10), stats.CheckoutRows)
+	assert.Equal(t, int64(4), stats.Purchases)
+	assert.InDelta(t, 2.5, stats.Ratio, 1e-9)
+}
+
+// failingPurchaseSaver fails every purchase with a fixed error
+type failingPurchaseSaver struct {
+	err error
+}
+
+func (f failingPurchaseSaver) Purchase(saleID, itemID, userID int64) error { return f.err }
+func (f failingPurchaseSaver) Close() error                                { return nil }
+
+// TestPurchaseHandlerDBOutcomes tests the response and cache state for each DB purchase failure
+func TestPurchaseHandlerDBOutcomes(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		wantLot    megacache.LotStatus
+	}{
+		{"already sold in DB", fmt.Errorf("%w: sale_id=1, item_id=5", db.ErrItemNotAvailable), http.StatusConflict, megacache.StatusSold},
+		{"DB failure", errors.New("connection reset"), http.StatusInternalServerError, megacache.StatusReserved},
+		{"updater closing", context.Canceled, http.StatusServiceUnavailable, megacache.StatusReserved},
+		{"updater closed", db.ErrUpdaterClosed, http.StatusServiceUnavailable, megacache.StatusReserved},
+		{"DB connection lost", fmt.Errorf("execute batch purchase: %w", db.ErrConnection), http.StatusServiceUnavailable, megacache.StatusReserved},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := newTestInstance(100, DefaultAppConfig(), noopSaver{})
+			instance.batchPurchase = failingPurchaseSaver{err: tt.err}
+			defer instance.cache.Close()
+
+			checkout, err := instance.cache.Checkout(1, 5)
+			require.NoError(t, err)
+
+			req := httptest.NewRequest(http.MethodPost, "/purchase?code="+checkout.Code.String(), nil)
+			rec := httptest.NewRecorder()
+			instance.purchaseHandler(rec, req)
+			assert.Equal(t, tt.wantStatus, rec.Code)
+
+			status, err := instance.cache.GetLotStatus(5)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantLot, status)
+
+			// The user's purchase counter is always rolled back
+			count, _ := instance.cache.GetPurchaseCount(1)
+			assert.Zero(t, count)
+		})
+	}
+}
+
+// TestMetricsHandler tests that handler outcomes show up in the Prometheus exposition
+func TestMetricsHandler(t *testing.T) {
+	instance := newTestInstance(100, DefaultAppConfig(), noopSaver{})
+	instance.batchPurchase = noopPurchaseSaver{}
+	defer instance.cache.Close()
+
+	post := func(handler http.HandlerFunc, target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodPost, target, nil))
+		return rec
+	}
+
+	// Two reservations, one of them bought, plus a conflict on a reserved lot
+	first := post(instance.checkoutHandler, "/checkout?user_id=1&item_id=1")
+	require.Equal(t, http.StatusOK, first.Code)
+	require.Equal(t, http.StatusOK, post(instance.checkoutHandler, "/checkout?user_id=2&item_id=2").Code)
+	require.Equal(t, http.StatusConflict, post(instance.checkoutHandler, "/checkout?user_id=3&item_id=2").Code)
+	require.Equal(t, http.StatusOK, post(instance.purchaseHandler, "/purchase?code="+first.Body.String()).Code)
+
+	// A DB failure on purchase counts as an internal error
+	checkout, err := instance.cache.Checkout(4, 4)
+	require.NoError(t, err)
+	instance.batchPurchase = failingPurchaseSaver{err: errors.New("connection reset")}
+	require.Equal(t, http.StatusInternalServerError, post(instance.purchaseHandler, "/purchase?code="+checkout.Code.String()).Code)
+
+	rec := httptest.NewRecorder()
+	instance.metricsHandler(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, metricsContentType, rec.Header().Get("Content-Type"))
+
+	body := rec.Body.String()
+	for _, line := range []string{
+		"# TYPE flashsale_checkouts_total counter",
+		"flashsale_checkouts_total 2\n",
+		"flashsale_purchases_total 1\n",
+		"flashsale_conflicts_total 1\n",
+		"flashsale_internal_errors_total 1\n",
+		"flashsale_rate_limited_total 0\n",
+		"# TYPE flashsale_active_reservations gauge",
+		"flashsale_active_reservations 2\n",
+		"flashsale_sold_lots 1\n",
+		fmt.Sprintf("flashsale_cache_estimated_bytes %d\n", instance.cache.EstimatedMemoryBytes()),
+		"flashsale_cache_checkouts 3\n",
+	} {
+		assert.Contains(t, body, line)
+	}
+
+	rec = httptest.NewRecorder()
+	instance.metricsHandler(rec, httptest.NewRequest(http.MethodPost, "/metrics", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+// countingSaver counts persisted checkout rows
+type countingSaver struct {
+	rows atomic.Int64
+}
+
+func (c *countingSaver) AddContext(ctx context.Context, record db.CheckoutRecord) error {
+	c.rows.Add(1)
+	return nil
+}
+
+func (c *countingSaver) Close() error { return nil }
+
+// countingPurchaseSaver counts persisted purchase rows
+type countingPurchaseSaver struct {
+	rows atomic.Int64
+}
+
+func (c *countingPurchaseSaver) Purchase(saleID, itemID, userID int64) error {
+	c.rows.Add(1)
+	return nil
+}
+
+func (c *countingPurchaseSaver) Close() error { return nil }
+
+// benchmarkWriteVolume reports DB rows written per request for a checkout workflow where half the reservations are abandoned
+func benchmarkWriteVolume(b *testing.B, mode string) {
+	const itemsCount = 10_000
+
+	config := DefaultAppConfig()
+	config.CheckoutPersistMode = mode
+	checkouts := &countingSaver{}
+	purchases := &countingPurchaseSaver{}
+	instance := newTestInstance(itemsCount, config, checkouts)
+	instance.batchPurchase = purchases
+	defer func() { instance.cache.Close() }()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		itemID := i % itemsCount
+		if i > 0 && itemID == 0 {
+			b.StopTimer()
+			instance.cache.Close()
+			instance.cache = megacache.NewMegacache(itemsCount, 10)
+			b.StartTimer()
+		}
+
+		req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/checkout?user_id=%d&item_id=%d", i+1, itemID), nil)
+		rec := httptest.NewRecorder()
+		instance.checkoutHandler(rec, req)
+		if rec.Code != http.StatusOK {
+			b.Fatalf("unexpected checkout status %d", rec.Code)
+		}
+
+		// Every second reservation is abandoned
+		if i%2 == 0 {
+			req = httptest.NewRequest(http.MethodPost, "/purchase?code="+rec.Body.String(), nil)
+			rec = httptest.NewRecorder()
+			instance.purchaseHandler(rec, req)
+			if rec.Code != http.StatusOK {
+				b.Fatalf("unexpected purchase status %d", rec.Code)
+			}
+		}
+	}
+	b.StopTimer()
+
+	b.ReportMetric(float64(checkouts.rows.Load())/float64(b.N), "checkout-rows/op")
+	b.ReportMetric(float64(checkouts.rows.Load()+purchases.rows.Load())/float64(b.N), "db-rows/op")
+}
+
+// BenchmarkWriteVolumeSync benchmarks DB write volume when every checkout is persisted
+func BenchmarkWriteVolumeSync(b *testing.B) {
+	benchmarkWriteVolume(b, persistModeSync)
+}
+
+// BenchmarkWriteVolumeNone benchmarks DB write volume when only purchases are persisted
+func BenchmarkWriteVolumeNone(b *testing.B) {
+	benchmarkWriteVolume(b, persistModeNone)
+}
+
+// benchmarkCheckoutHandler measures handler latency for the given persist mode
+func benchmarkCheckoutHandler(b *testing.B, mode string) {
+	const itemsCount = 10_000
+
+	config := DefaultAppConfig()
+	config.CheckoutPersistMode = mode
+
+	// 1ms approximates waiting for a batch flush round-trip to Postgres
+	instance := newTestInstance(itemsCount, config, &slowSaver{delay: time.Millisecond})
+	defer func() { instance.cache.Close() }()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		itemID := i % itemsCount
+		if i > 0 && itemID == 0 {
+			// Fresh cache once every lot is reserved so every request succeeds
+			b.StopTimer()
+			instance.cache.Close()
+			instance.cache = megacache.NewMegacache(itemsCount, 10)
+			b.StartTimer()
+		}
+
+		req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/checkout?user_id=%d&item_id=%d", i+1, itemID), nil)
+		rec := httptest.NewRecorder()
+		instance.checkoutHandler(rec, req)
+
+		if rec.Code != http.StatusOK {
+			b.Fatalf("unexpected status %d", rec.Code)
+		}
+	}
+}
+
+// BenchmarkCheckoutHandlerSync benchmarks the per-request persistence mode
+func BenchmarkCheckoutHandlerSync(b *testing.B) {
+	benchmarkCheckoutHandler(b, persistModeSync)
+}
+
+// BenchmarkCheckoutHandlerBackground benchmarks the background bulk persistence mode
+func BenchmarkCheckoutHandlerBackground(b *testing.B) {
+	benchmarkCheckoutHandler(b, persistModeBackground)
+}
+
+// TestAdminAudit tests that admin requests, rejected ones included, leave audit records
+func TestAdminAudit(t *testing.T) {
+	config := DefaultAppConfig()
+	config.AdminToken = "secret"
+	instance := newTestInstance(100, config, noopSaver{})
+	defer instance.cache.Close()
+
+	var sink bytes.Buffer
+	instance.audit = newAuditLog(&sink)
+	mux := http.NewServeMux()
+	instance.registerAdminRoutes(mux)
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/write-amplification?verbose=1", nil)
+	req.Header.Set(adminTokenHeader, "secret")
+	req.Header.Set(adminActorHeader, "alice")
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	req = httptest.NewRequest(http.MethodGet, "/admin/write-amplification", nil)
+	req.Header.Set(adminTokenHeader, "guess")
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	lines := strings.Split(strings.TrimSpace(sink.String()), "\n")
+	require.Len(t, lines, 2)
+
+	var allowed, denied auditRecord
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &allowed))
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &denied))
+
+	assert.Equal(t, "alice", allowed.Actor)
+	assert.Equal(t, tokenFingerprint("secret"), allowed.Token)
+	assert.True(t, allowed.Authorized)
+	assert.Equal(t, http.MethodGet, allowed.Method)
+	assert.Equal(t, "/admin/write-amplification", allowed.Path)
+	assert.Equal(t, "verbose=1", allowed.Query)
+	assert.Equal(t, http.StatusOK, allowed.Status)
+	assert.Equal(t, instance.saleID, allowed.SaleID)
+	assert.NotEmpty(t, allowed.RemoteAddr)
+	assert.WithinDuration(t, time.Now(), allowed.Time, time.Minute)
+
+	assert.Empty(t, denied.Actor)
+	assert.False(t, denied.Authorized)
+	assert.Equal(t, http.StatusUnauthorized, denied.Status)
+	assert.Equal(t, tokenFingerprint("guess"), denied.Token)
+	assert.NotContains(t, sink.String(), "secret", "the token itself is never logged")
+}
+
+// TestPurchaseCutoffHandler tests that reservations made before an admin cutoff can't be purchased while newer ones can
+func TestPurchaseCutoffHandler(t *testing.T) {
+	config := DefaultAppConfig()
+	config.AdminToken = "secret"
+	instance := newTestInstance(100, config, noopSaver{})
+	instance.batchPurchase = noopPurchaseSaver{}
+	instance.audit = newAuditLog(io.Discard)
+	defer instance.cache.Close()
+
+	mux := http.NewServeMux()
+	instance.registerAdminRoutes(mux)
+
+	admin := func(method, target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		req.Header.Set(adminTokenHeader, "secret")
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	suspicious, err := instance.cache.Checkout(1, 0)
+	require.NoError(t, err)
+
+	rec := admin(http.MethodPost, "/admin/purchase-cutoff?before=not-a-time")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	cutoff := suspicious.CreatedAt.Add(time.Nanosecond)
+	rec = admin(http.MethodPost, "/admin/purchase-cutoff?before="+url.QueryEscape(cutoff.Format(time.RFC3339Nano)))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var response purchaseCutoffResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&response))
+	require.NotNil(t, response.Cutoff)
+	assert.True(t, response.Cutoff.Equal(cutoff))
+
+	fresh, err := instance.cache.Checkout(2, 1)
+	require.NoError(t, err)
+
+	_, status, err := instance.purchaseReserved(context.Background(), suspicious.Code)
+	assert.Equal(t, http.StatusConflict, status)
+	assert.Error(t, err)
+	_, status, err = instance.purchaseReserved(context.Background(), fresh.Code)
+	assert.Equal(t, http.StatusOK, status)
+	assert.NoError(t, err)
+
+	rec = admin(http.MethodDelete, "/admin/purchase-cutoff")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"cutoff":null}`, rec.Body.String())
+}
+
+// TestCancelUserHandler tests that the admin endpoint releases a flagged user's lots and requires the token
+func TestCancelUserHandler(t *testing.T) {
+	config := DefaultAppConfig()
+	config.AdminToken = "secret"
+	instance := newTestInstance(100, config, noopSaver{})
+	instance.audit = newAuditLog(io.Discard)
+	deleter := &fakeReservationDeleter{}
+	instance.cancels = deleter
+	defer instance.cache.Close()
+
+	mux := http.NewServeMux()
+	instance.registerAdminRoutes(mux)
+
+	admin := func(method, target, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		req.Header.Set(adminTokenHeader, token)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	var codes []uuid.UUID
+	for _, itemID := range []int64{10, 11, 12} {
+		checkout, err := instance.cache.Checkout(7, itemID)
+		require.NoError(t, err)
+		codes = append(codes, checkout.Code)
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, admin(http.MethodPost, "/admin/cancel-user?user_id=7", "wrong").Code)
+	assert.Equal(t, 3, instance.cache.GetActiveReservationsCount())
+
+	rec := admin(http.MethodPost, "/admin/cancel-user?user_id=7", "secret")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"user_id":7,"released":3}`, rec.Body.String())
+	assert.Zero(t, instance.cache.GetActiveReservationsCount())
+	assert.ElementsMatch(t, codes, deleter.deleted, "persisted rows must not come back after a restart")
+	_, exists := instance.cache.GetCheckoutInfo(codes[0])
+	assert.False(t, exists)
+
+	assert.Equal(t, http.StatusBadRequest, admin(http.MethodPost, "/admin/cancel-user?user_id=0", "secret").Code)
+	assert.Equal(t, http.StatusMethodNotAllowed, admin(http.MethodGet, "/admin/cancel-user?user_id=7", "secret").Code)
+}
+
+// TestCheckoutUserIDBounds tests that user IDs outside [1, MaxUserID] are rejected with 400 before touching the cache
+func TestCheckoutUserIDBounds(t *testing.T) {
+	instance := newTestInstance(100, DefaultAppConfig(), noopSaver{})
+	defer instance.cache.Close()
+
+	tests := []struct {
+		userID     string
+		wantStatus int
+	}{
+		{"-1", http.StatusBadRequest},
+		{"0", http.StatusBadRequest},
+		{"1", http.StatusOK},
+		{"2147483647", http.StatusOK},
+		{"2147483648", http.StatusBadRequest},
+		{"9223372036854775807", http.StatusBadRequest},
+		{"9223372036854775808", http.StatusBadRequest},
+		{"abc", http.StatusBadRequest},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.userID, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			instance.checkoutHandler(rec, httptest.NewRequest(http.MethodPost, fmt.Sprintf("/checkout?user_id=%s&item_id=%d", tt.userID, i), nil))
+			assert.Equal(t, tt.wantStatus, rec.Code)
+
+			rec = httptest.NewRecorder()
+			instance.bulkCheckoutHandler(rec, httptest.NewRequest(http.MethodPost, fmt.Sprintf("/checkout/bulk?user_id=%s&item_ids=%d", tt.userID, 50+i), nil))
+			assert.Equal(t, tt.wantStatus, rec.Code)
+		})
+	}
+
+	// Only the two valid requests of each handler reserved anything
+	assert.Equal(t, 4, instance.cache.GetActiveReservationsCount())
+
+	// The bound is configurable, the columns are BIGINT
+	instance.config = DefaultAppConfig()
+	instance.config.MaxUserID = math.MaxInt64
+	rec := httptest.NewRecorder()
+	instance.checkoutHandler(rec, httptest.NewRequest(http.MethodPost, "/checkout?user_id=9223372036854775807&item_id=99", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+// TestCheckoutRateLimit tests that a user over the limit gets 429 while other users are unaffected
+func TestCheckoutRateLimit(t *testing.T) {
+	instance := newTestInstance(100, DefaultAppConfig(), noopSaver{})
+	instance.checkoutLimiter = ratelimit.New(1, 2)
+	defer instance.cleanup()
+
+	checkout := func(userID, itemID int) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		instance.checkoutHandler(rec, httptest.NewRequest(http.MethodPost, fmt.Sprintf("/checkout?user_id=%d&item_id=%d", userID, itemID), nil))
+		return rec
+	}
+
+	assert.Equal(t, http.StatusOK, checkout(1, 0).Code)
+	assert.Equal(t, http.StatusConflict, checkout(1, 0).Code, "a conflict still spends a token")
+
+	rec := checkout(1, 1)
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "1", rec.Header().Get("Retry-After"))
+	assert.Equal(t, int64(1), instance.metrics.rateLimited.Load())
+
+	assert.Equal(t, http.StatusOK, checkout(2, 1).Code)
+
+	// Bulk items spend tokens too
+	req := httptest.NewRequest(http.MethodPost, "/checkout/bulk?user_id=3&item_ids=2,3,4", nil)
+	rec = httptest.NewRecorder()
+	instance.bulkCheckoutHandler(rec, req)
+	assert.Equal(t, http.StatusMultiStatus, rec.Code)
+	var response bulkResponse[bulkCheckoutResult]
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&response))
+	limited := 0
+	for _, result := range response.Results {
+		if result.Status == http.StatusTooManyRequests {
+			limited++
+		}
+	}
+	assert.Equal(t, 1, limited)
+}
+
+// TestBuyHandler tests buying without a checkout and the cache state after DB failures
+func TestBuyHandler(t *testing.T) {
+	buy := func(instance *ServerInstance, userID, itemID int) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/buy?user_id=%d&item_id=%d", userID, itemID), nil)
+		req.Header.Set("Accept", "application/json")
+		instance.buyHandler(rec, req)
+		return rec
+	}
+
+	t.Run("success", func(t *testing.T) {
+		instance := newTestInstance(10, DefaultAppConfig(), noopSaver{})
+		defer instance.cache.Close()
+		saver := &countingPurchaseSaver{}
+		instance.batchPurchase = saver
+
+		rec := buy(instance, 1, 3)
+		require.Equal(t, http.StatusOK, rec.Code)
+		var body purchaseResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		assert.Equal(t, int64(3), body.ItemID)
+		assert.Equal(t, int64(1), saver.rows.Load())
+		assert.Equal(t, int64(1), instance.cache.SoldCount())
+
+		// The lot is gone for checkouts and other buyers
+		assert.Equal(t, http.StatusConflict, buy(instance, 2, 3).Code)
+		rec = httptest.NewRecorder()
+		instance.checkoutHandler(rec, httptest.NewRequest(http.MethodPost, "/checkout?user_id=2&item_id=3", nil))
+		assert.Equal(t, http.StatusConflict, rec.Code)
+
+		assert.Equal(t, http.StatusBadRequest, buy(instance, 0, 3).Code)
+		assert.Equal(t, http.StatusBadRequest, buy(instance, 1, -1).Code)
+	})
+
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		wantLot    megacache.LotStatus
+	}{
+		{"sold in DB", db.ErrItemNotAvailable, http.StatusConflict, megacache.StatusSold},
+		{"connection lost", db.ErrConnection, http.StatusServiceUnavailable, megacache.StatusAvailable},
+		{"unknown", errors.New("boom"), http.StatusInternalServerError, megacache.StatusAvailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := newTestInstance(10, DefaultAppConfig(), noopSaver{})
+			defer instance.cache.Close()
+			instance.batchPurchase = failingPurchaseSaver{err: tt.err}
+
+			assert.Equal(t, tt.wantStatus, buy(instance, 1, 3).Code)
+			status, err := instance.cache.GetLotStatus(3)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantLot, status)
+
+			// The user's slot is returned either way
+			count, _ := instance.cache.GetPurchaseCount(1)
+			assert.Zero(t, count)
+		})
+	}
+}
+
+// TestStatsHandler tests the /stats summary of lots, reservations and users
+func TestStatsHandler(t *testing.T) {
+	instance := newTestInstance(10, DefaultAppConfig(), noopSaver{})
+	defer instance.cache.Close()
+	instance.saleID = 7
+	instance.startedAt = time.Now().Add(-time.Minute)
+
+	_, err := instance.cache.Checkout(1, 0)
+	require.NoError(t, err)
+	bought, err := instance.cache.BuyNow(2, 1)
+	require.NoError(t, err)
+	require.NoError(t, instance.cache.ConfirmPurchase(bought.Code))
+
+	rec := httptest.NewRecorder()
+	instance.statsHandler(rec, httptest.NewRequest(http.MethodGet, "/stats", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var stats statsResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &stats))
+	assert.Equal(t, int64(7), stats.SaleID)
+	assert.GreaterOrEqual(t, stats.UptimeSeconds, 60.0)
+	assert.Equal(t, int64(1), stats.Sold)
+	assert.Equal(t, int64(1), stats.Reserved)
+	assert.Equal(t, int64(8), stats.Available)
+	assert.Equal(t, 1, stats.ActiveReservations)
+	assert.Equal(t, 1, stats.TotalUsers)
+	assert.Equal(t, cacheSizeStats{Checkouts: 2, Users: 1, Lots: 10, EstimatedBytes: instance.cache.EstimatedMemoryBytes()}, stats.Cache)
+	assert.Nil(t, stats.DBStats, "no database in this test")
+
+	rec = httptest.NewRecorder()
+	instance.statsHandler(rec, httptest.NewRequest(http.MethodPost, "/stats", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+// TestPurchaseDeferred tests that a dead-lettered purchase keeps the lot and answers 202
+func TestPurchaseDeferred(t *testing.T) {
+	instance := newTestInstance(100, DefaultAppConfig(), noopSaver{})
+	defer instance.cache.Close()
+	instance.batchPurchase = failingPurchaseSaver{err: fmt.Errorf("%w: %w", db.ErrPurchaseDeferred, db.ErrConnection)}
+
+	checkout, err := instance.cache.Checkout(1, 5)
+	require.NoError(t, err)
+	rec := httptest.NewRecorder()
+	instance.purchaseHandler(rec, httptest.NewRequest(http.MethodPost, "/purchase?code="+checkout.Code.String(), nil))
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+
+	rec = httptest.NewRecorder()
+	instance.buyHandler(rec, httptest.NewRequest(http.MethodPost, "/buy?user_id=1&item_id=6", nil))
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+
+	for _, itemID := range []int64{5, 6} {
+		status, err := instance.cache.GetLotStatus(itemID)
+		require.NoError(t, err)
+		assert.Equal(t, megacache.StatusSold, status)
+	}
+	count, _ := instance.cache.GetPurchaseCount(1)
+	assert.Equal(t, int64(2), count)
+	assert.Equal(t, int64(2), instance.cache.SoldCount())
+}
+
+// TestPurchaseLost tests that a deferred purchase the DB never wrote is undone in the cache and no longer replayed as 202
+func TestPurchaseLost(t *testing.T) {
+	instance := newTestInstance(100, DefaultAppConfig(), noopSaver{})
+	defer instance.cache.Close()
+	instance.purchaseOutcomes = newPurchaseOutcomes(10)
+	instance.batchPurchase = failingPurchaseSaver{err: fmt.Errorf("%w: %w", db.ErrPurchaseDeferred, db.ErrConnection)}
+
+	checkout, err := instance.cache.Checkout(1, 5)
+	require.NoError(t, err)
+	purchase := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		instance.purchaseHandler(rec, httptest.NewRequest(http.MethodPost, "/purchase?code="+checkout.Code.String(), nil))
+		return rec
+	}
+	require.Equal(t, http.StatusAccepted, purchase().Code)
+
+	rec := httptest.NewRecorder()
+	instance.buyHandler(rec, httptest.NewRequest(http.MethodPost, "/buy?user_id=1&item_id=6", nil))
+	require.Equal(t, http.StatusAccepted, rec.Code)
+
+	// Lot 5 was never written, lot 6 was sold to someone else in the DB
+	instance.purchaseLost(db.ItemPurchase{SaleID: 1, ItemID: 5, UserID: 1}, false)
+	instance.purchaseLost(db.ItemPurchase{SaleID: 1, ItemID: 6, UserID: 1}, true)
+
+	rec = purchase()
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	assert.Equal(t, "true", rec.Header().Get(idempotentReplayedHeader))
+
+	wantStatuses := map[int64]megacache.LotStatus{5: megacache.StatusAvailable, 6: megacache.StatusSold}
+	for itemID, want := range wantStatuses {
+		status, err := instance.cache.GetLotStatus(itemID)
+		require.NoError(t, err)
+		assert.Equal(t, want, status, "lot %d", itemID)
+	}
+	count, _ := instance.cache.GetPurchaseCount(1)
+	assert.Zero(t, count, "both slots must be returned")
+	assert.Equal(t, int64(1), instance.cache.SoldCount())
+
+	// Purchases of another sale are not this cache's
+	instance.purchaseLost(db.ItemPurchase{SaleID: 2, ItemID: 6, UserID: 1}, false)
+	status, err := instance.cache.GetLotStatus(6)
+	require.NoError(t, err)
+	assert.Equal(t, megacache.StatusSold, status)
+}
+
+// TestCancelledRequest tests that a request the client already abandoned takes no lot and is not counted as a conflict
+func TestCancelledRequest(t *testing.T) {
+	instance := newTestInstance(100, DefaultAppConfig(), noopSaver{})
+	defer instance.cache.Close()
+	saver := &countingPurchaseSaver{}
+	instance.batchPurchase = saver
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	rec := httptest.NewRecorder()
+	instance.checkoutHandler(rec, httptest.NewRequest(http.MethodPost, "/checkout?user_id=1&item_id=5", nil).WithContext(ctx))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	status, err := instance.cache.GetLotStatus(5)
+	require.NoError(t, err)
+	assert.Equal(t, megacache.StatusAvailable, status)
+
+	checkout, err := instance.cache.Checkout(1, 5)
+	require.NoError(t, err)
+	rec = httptest.NewRecorder()
+	instance.purchaseHandler(rec, httptest.NewRequest(http.MethodPost, "/purchase?code="+checkout.Code.String(), nil).WithContext(ctx))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Zero(t, saver.rows.Load())
+	count, _ := instance.cache.GetPurchaseCount(1)
+	assert.Zero(t, count)
+
+	deadline, cancelDeadline := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancelDeadline()
+	rec = httptest.NewRecorder()
+	instance.purchaseHandler(rec, httptest.NewRequest(http.MethodPost, "/purchase?code="+checkout.Code.String(), nil).WithContext(deadline))
+	assert.Equal(t, http.StatusGatewayTimeout, rec.Code)
+	assert.Zero(t, instance.metrics.conflicts.Load())
+
+	// The reservation survives for the client's retry
+	rec = httptest.NewRecorder()
+	instance.purchaseHandler(rec, httptest.NewRequest(http.MethodPost, "/purchase?code="+checkout.Code.String(), nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+// TestPurchaseRefusedStatus tests that each reason the cache refuses a purchase gets its own status
+func TestPurchaseRefusedStatus(t *testing.T) {
+	config := DefaultAppConfig()
+	config.LimitPerUser = 1
+	instance := newTestInstance(100, config, noopSaver{})
+	now := time.Now()
+	instance.cache = megacache.NewMegacache(100, 1, megacache.WithClock(func() time.Time { return now }))
+	defer instance.cache.Close()
+	instance.batchPurchase = noopPurchaseSaver{}
+
+	purchase := func(code uuid.UUID) int {
+		rec := httptest.NewRecorder()
+		instance.purchaseHandler(rec, httptest.NewRequest(http.MethodPost, "/purchase?code="+code.String(), nil))
+		return rec.Code
+	}
+
+	bought, err := instance.cache.Checkout(1, 0)
+	require.NoError(t, err)
+	second, err := instance.cache.Checkout(1, 1)
+	require.NoError(t, err)
+	expiring, err := instance.cache.Checkout(2, 2)
+	require.NoError(t, err)
+
+	assert.Equal(t, http.StatusOK, purchase(bought.Code))
+	assert.Equal(t, http.StatusConflict, purchase(bought.Code), "already bought")
+	assert.Equal(t, http.StatusTooManyRequests, purchase(second.Code), "user limit reached")
+	assert.Equal(t, http.StatusNotFound, purchase(uuid.New()))
+
+	now = now.Add(time.Hour)
+	assert.Equal(t, http.StatusGone, purchase(expiring.Code))
+	assert.Equal(t, http.StatusGone, purchase(expiring.Code), "still expired after the hold was released")
+	assert.Equal(t, int64(1), instance.metrics.conflicts.Load(), "only 409s count as conflicts")
+}
+
+// TestCheckoutRefusedStatus tests that clients can tell a taken lot from a sold-out sale and a reached limit
+func TestCheckoutRefusedStatus(t *testing.T) {
+	config := DefaultAppConfig()
+	config.LimitPerUser = 1
+	instance := newTestInstance(3, config, noopSaver{})
+	defer instance.cache.Close()
+	instance.batchPurchase = noopPurchaseSaver{}
+
+	post := func(handler http.HandlerFunc, target string) int {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodPost, target, nil))
+		return rec.Code
+	}
+
+	checkout, err := instance.cache.Checkout(1, 0)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusConflict, post(instance.checkoutHandler, "/checkout?user_id=2&item_id=0"), "reserved")
+	require.Equal(t, http.StatusOK, post(instance.purchaseHandler, "/purchase?code="+checkout.Code.String()))
+	assert.Equal(t, http.StatusConflict, post(instance.checkoutHandler, "/checkout?user_id=2&item_id=0"), "sold")
+	assert.Equal(t, http.StatusTooManyRequests, post(instance.checkoutHandler, "/checkout?user_id=1&item_id=1"), "user limit reached")
+	assert.Equal(t, http.StatusTooManyRequests, post(instance.buyHandler, "/buy?user_id=1&item_id=1"), "user limit reached")
+
+	instance.cache.MarkSold(1)
+	instance.cache.MarkSold(2)
+	assert.Equal(t, http.StatusGone, post(instance.checkoutHandler, "/checkout?user_id=3&item_id=1"), "sold out")
+	assert.Equal(t, http.StatusGone, post(instance.buyHandler, "/buy?user_id=3&item_id=2"), "sold out")
+	assert.Equal(t, int64(2), instance.metrics.conflicts.Load(), "only 409s count as conflicts")
+
+	// The handlers reject bad lots before the cache, the mapping still covers it
+	assert.Equal(t, http.StatusBadRequest, checkoutRefusedStatus(megacache.ErrInvalidItemID))
+	assert.Equal(t, http.StatusServiceUnavailable, checkoutRefusedStatus(megacache.ErrServiceOverloaded))
+	assert.Equal(t, http.StatusConflict, checkoutRefusedStatus(megacache.ErrItemAlreadyReserved))
+	assert.Equal(t, http.StatusConflict, checkoutRefusedStatus(megacache.ErrItemAlreadySold))
+}
+
+// fakePool reports fixed pool usage
+type fakePool struct {
+	inUse, maxOpen int
+}
+
+func (f *fakePool) Stats() sql.DBStats {
+	return sql.DBStats{InUse: f.inUse, MaxOpenConnections: f.maxOpen}
+}
+
+// TestPoolBackpressure tests that writes are shed with 503 and Retry-After once the pool reaches the threshold
+func TestPoolBackpressure(t *testing.T) {
+	config := DefaultAppConfig()
+	config.PoolSaturationThreshold = 0.9
+	instance := newTestInstance(100, config, noopSaver{})
+	defer instance.cache.Close()
+	pool := &fakePool{inUse: 89, maxOpen: 100}
+	instance.dbPool = pool
+
+	checkout := instance.withPoolBackpressure(instance.checkoutHandler)
+	post := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		checkout(rec, httptest.NewRequest(http.MethodPost, "/checkout?user_id=1&item_id=5", nil))
+		return rec
+	}
+
+	assert.Equal(t, http.StatusOK, post().Code, "below the threshold")
+
+	pool.inUse = 90
+	rec := post()
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, "1", rec.Header().Get("Retry-After"))
+	assert.Equal(t, int64(1), instance.metrics.poolShed.Load())
+
+	// Unlimited pools and a zero threshold never shed
+	pool.maxOpen = 0
+	assert.False(t, instance.poolSaturated())
+	pool.maxOpen = 100
+	config.PoolSaturationThreshold = 0
+	assert.False(t, instance.poolSaturated())
+	instance.dbPool = nil
+	config.PoolSaturationThreshold = 0.9
+	assert.False(t, instance.poolSaturated())
+}
+
+// TestPurchaseIdempotency tests that retried purchases get the original answer, keyed by code or Idempotency-Key
+func TestPurchaseIdempotency(t *testing.T) {
+	instance := newTestInstance(100, DefaultAppConfig(), noopSaver{})
+	defer instance.cache.Close()
+	instance.purchaseOutcomes = newPurchaseOutcomes(10)
+	saver := &countingPurchaseSaver{}
+	instance.batchPurchase = saver
+
+	purchase := func(code uuid.UUID, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/purchase?code="+code.String(), nil)
+		req.Header.Set("Accept", "application/json")
+		if key != "" {
+			req.Header.Set(idempotencyKeyHeader, key)
+		}
+		rec := httptest.NewRecorder()
+		instance.purchaseHandler(rec, req)
+		return rec
+	}
+
+	t.Run("replayed success", func(t *testing.T) {
+		checkout, err := instance.cache.Checkout(1, 5)
+		require.NoError(t, err)
+
+		first := purchase(checkout.Code, "")
+		require.Equal(t, http.StatusOK, first.Code)
+		assert.Empty(t, first.Header().Get(idempotentReplayedHeader))
+
+		retry := purchase(checkout.Code, "")
+		assert.Equal(t, http.StatusOK, retry.Code)
+		assert.Equal(t, "true", retry.Header().Get(idempotentReplayedHeader))
+		assert.JSONEq(t, first.Body.String(), retry.Body.String())
+		assert.Equal(t, int64(1), saver.rows.Load(), "the retry must not buy again")
+	})
+
+	t.Run("replayed failure", func(t *testing.T) {
+		unknown := uuid.New()
+		assert.Equal(t, http.StatusNotFound, purchase(unknown, "k-1").Code)
+		retry := purchase(unknown, "k-1")
+		assert.Equal(t, http.StatusNotFound, retry.Code)
+		assert.Equal(t, "true", retry.Header().Get(idempotentReplayedHeader))
+
+		// A key belongs to the purchase it was first used with
+		checkout, err := instance.cache.Checkout(2, 6)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusUnprocessableEntity, purchase(checkout.Code, "k-1").Code)
+		assert.Equal(t, http.StatusOK, purchase(checkout.Code, "k-2").Code)
+		assert.Equal(t, http.StatusBadRequest, purchase(checkout.Code, strings.Repeat("k", maxRequestIDLength+1)).Code)
+	})
+
+	t.Run("server errors are retried", func(t *testing.T) {
+		checkout, err := instance.cache.Checkout(3, 7)
+		require.NoError(t, err)
+
+		instance.batchPurchase = failingPurchaseSaver{err: db.ErrConnection}
+		assert.Equal(t, http.StatusServiceUnavailable, purchase(checkout.Code, "").Code)
+
+		instance.batchPurchase = saver
+		retry := purchase(checkout.Code, "")
+		assert.Equal(t, http.StatusOK, retry.Code)
+		assert.Empty(t, retry.Header().Get(idempotentReplayedHeader))
+	})
+}
+
+// TestPurchaseOutcomes tests LRU eviction and that a success is not replaced by a failure
+func TestPurchaseOutcomes(t *testing.T) {
+	outcomes := newPurchaseOutcomes(2)
+	code := uuid.New()
+	outcomes.Put("a", purchaseOutcome{code: code, status: http.StatusOK, body: purchaseResponse{ItemID: 1}})
+	outcomes.Put("b", purchaseOutcome{code: code, status: http.StatusConflict})
+	_, ok := outcomes.Get("a")
+	require.True(t, ok)
+	outcomes.Put("c", purchaseOutcome{code: code, status: http.StatusNotFound})
+
+	_, ok = outcomes.Get("b")
+	assert.False(t, ok, "least recently used is evicted")
+
+	outcomes.Put("a", purchaseOutcome{code: code, status: http.StatusConflict})
+	outcome, ok := outcomes.Get("a")
+	require.True(t, ok)
+	assert.Equal(t, http.StatusOK, outcome.status)
+
+	var disabled *purchaseOutcomes
+	disabled.Put("a", outcome)
+	_, ok = disabled.Get("a")
+	assert.False(t, ok)
+}
+
+// TestRequestIDLogging tests that checkout and purchase echo X-Request-ID and tag their log lines with it
+func TestRequestIDLogging(t *testing.T) {
+	var logs bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(newLogger(&logs, slog.LevelDebug))
+	defer slog.SetDefault(previous)
+
+	instance := newTestInstance(100, DefaultAppConfig(), noopSaver{})
+	defer instance.cache.Close()
+	instance.batchPurchase = noopPurchaseSaver{}
+
+	// A client ID is kept
+	req := httptest.NewRequest(http.MethodPost, "/checkout?user_id=1&item_id=7", nil)
+	req.Header.Set(requestIDHeader, "client-id-1")
+	rec := httptest.NewRecorder()
+	withRequestID(instance.checkoutHandler)(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "client-id-1", rec.Header().Get(requestIDHeader))
+
+	code := strings.TrimSpace(rec.Body.String())
+
+	// A missing or malformed ID is replaced by a generated one
+	req = httptest.NewRequest(http.MethodPost, "/purchase?code="+code, nil)
+	req.Header.Set(requestIDHeader, "bad id")
+	rec = httptest.NewRecorder()
+	withRequestID(instance.purchaseHandler)(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+	purchaseID := rec.Header().Get(requestIDHeader)
+	_, err := uuid.Parse(purchaseID)
+	assert.NoError(t, err)
+
+	events := map[string]map[string]any{}
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		var entry map[string]any
+		require.NoError(t, json.Unmarshal([]byte(line), &entry), line)
+		events[entry["msg"].(string)] = entry
+	}
+	require.Contains(t, events, "reserve")
+	assert.Equal(t, "client-id-1", events["reserve"]["request_id"])
+	assert.Equal(t, float64(1), events["reserve"]["user_id"])
+	assert.Equal(t, float64(7), events["reserve"]["item_id"])
+	assert.Equal(t, code, events["reserve"]["code"])
+	require.Contains(t, events, "purchase")
+	assert.Equal(t, purchaseID, events["purchase"]["request_id"])
+	assert.Equal(t, code, events["purchase"]["code"])
+}
+
+// TestParseLogLevel tests LOG_LEVEL values
+func TestParseLogLevel(t *testing.T) {
+	for value, want := range map[string]slog.Level{"": slog.LevelInfo, "debug": slog.LevelDebug, "INFO": slog.LevelInfo, "warn": slog.LevelWarn, "error": slog.LevelError} {
+		level, ok := parseLogLevel(value)
+		assert.True(t, ok, value)
+		assert.Equal(t, want, level, value)
+	}
+	_, ok := parseLogLevel("verbose")
+	assert.False(t, ok)
+}
+
+// TestBulkCheckoutHandler tests the 200 / 207 / all-fail convention of /checkout/bulk
+func TestBulkCheckoutHandler(t *testing.T) {
+	tests := []struct {
+		name        string
+		itemIDs     string
+		multiStatus bool
+		wantStatus  int
+		wantItems   []int
+	}{
+		{"all succeed", "1,2,3", true, http.StatusOK, []int{200, 200, 200}},
+		{"partial", "4,0,5", true, http.StatusMultiStatus, []int{200, 409, 200}},
+		{"partial without multi-status", "4,0", false, http.StatusOK, []int{200, 409}},
+		{"all fail", "0", true, http.StatusConflict, []int{409}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultAppConfig()
+			config.LotsCount = 100
+			config.BulkMultiStatus = tt.multiStatus
+			instance := newTestInstance(100, config, noopSaver{})
+			defer instance.cache.Close()
+
+			// Lot 0 is held by someone else
+			_, err := instance.cache.Checkout(99, 0)
+			require.NoError(t, err)
+
+			rec := httptest.NewRecorder()
+			instance.bulkCheckoutHandler(rec, httptest.NewRequest(http.MethodPost, "/checkout/bulk?user_id=1&item_ids="+tt.itemIDs, nil))
+			require.Equal(t, tt.wantStatus, rec.Code)
+			assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+			var body bulkResponse[bulkCheckoutResult]
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+			require.Len(t, body.Results, len(tt.wantItems))
+			for i, result := range body.Results {
+				assert.Equal(t, tt.wantItems[i], result.Status)
+				if result.Status != http.StatusOK {
+					assert.Equal(t, megacache.ErrItemAlreadyReserved.Error(), result.Error)
+					assert.Empty(t, result.Code)
+					continue
+				}
+				checkout, ok := instance.cache.GetCheckoutInfo(uuid.MustParse(result.Code))
+				require.True(t, ok)
+				assert.Equal(t, result.ItemID, checkout.LotIndex)
+				require.NotNil(t, result.ExpiresAt)
+			}
+		})
+	}
+
+	// Malformed lists are rejected as a whole
+	instance := newTestInstance(100, DefaultAppConfig(), noopSaver{})
+	defer instance.cache.Close()
+	for _, itemIDs := range []string{"", "1,,2", "1,1", "1,x", "1,-1"} {
+		rec := httptest.NewRecorder()
+		instance.bulkCheckoutHandler(rec, httptest.NewRequest(http.MethodPost, "/checkout/bulk?user_id=1&item_ids="+itemIDs, nil))
+		assert.Equal(t, http.StatusBadRequest, rec.Code, itemIDs)
+	}
+}
+
+// TestBulkPurchaseHandler tests the 200 / 207 / all-fail convention of /purchase/bulk
+func TestBulkPurchaseHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		codes      func(valid []string) []string
+		wantStatus int
+		wantItems  []int
+	}{
+		{"all succeed", func(valid []string) []string { return valid }, http.StatusOK, []int{200, 200}},
+		{"partial", func(valid []string) []string { return []string{valid[0], uuid.NewString(), "bogus"} }, http.StatusMultiStatus, []int{200, 404, 400}},
+		{"all fail alike", func(valid []string) []string { return []string{uuid.NewString(), uuid.NewString()} }, http.StatusNotFound, []int{404, 404}},
+		{"all fail mixed", func(valid []string) []string { return []string{uuid.NewString(), "bogus"} }, http.StatusConflict, []int{404, 400}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := newTestInstance(100, DefaultAppConfig(), noopSaver{})
+			instance.batchPurchase = noopPurchaseSaver{}
+			defer instance.cache.Close()
+
+			var valid []string
+			for _, itemID := range []int64{3, 7} {
+				checkout, err := instance.cache.Checkout(1, itemID)
+				require.NoError(t, err)
+				valid = append(valid, checkout.Code.String())
+			}
+
+			codes := tt.codes(valid)
+			rec := httptest.NewRecorder()
+			instance.bulkPurchaseHandler(rec, httptest.NewRequest(http.MethodPost, "/purchase/bulk?codes="+strings.Join(codes, ","), nil))
+			require.Equal(t, tt.wantStatus, rec.Code)
+
+			var body bulkResponse[bulkPurchaseResult]
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+			require.Len(t, body.Results, len(tt.wantItems))
+			for i, result := range body.Results {
+				assert.Equal(t, codes[i], result.Code)
+				assert.Equal(t, tt.wantItems[i], result.Status)
+				if result.Status == http.StatusOK {
+					assert.Empty(t, result.Error)
+					status, err := instance.cache.GetLotStatus(result.ItemID)
+					require.NoError(t, err)
+					assert.Equal(t, megacache.StatusSold, status)
+				} else {
+					assert.NotEmpty(t, result.Error)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,236 @@
+// Package cachetest drives configurable mixed workloads against a Megacache and checks its invariants, in tests or as an in-process fuzzer /
+// запускает настраиваемую смешанную нагрузку на Megacache и проверяет его инварианты, в тестах или как встроенный фаззер
+package cachetest
+
+import (
+	"contest_notcoin/megacache"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// Mix holds relative weights of operations, zero weight disables one / относительные веса операций, нулевой вес отключает операцию
+type Mix struct {
+	Checkout     int
+	CheckoutAnyN int
+	Purchase     int
+	Cancel       int
+	Renew        int
+}
+
+// DefaultMix mirrors a flash sale: mostly checkouts, half of them bought / повторяет распродажу: в основном checkout, половина из них покупается
+var DefaultMix = Mix{Checkout: 4, CheckoutAnyN: 1, Purchase: 3, Cancel: 1, Renew: 1}
+
+// Workload describes a run, zero fields take defaults / описывает прогон, нулевые поля получают значения по умолчанию
+type Workload struct {
+	Workers      int     // concurrent goroutines, default 8 / параллельных горутин, по умолчанию 8
+	OpsPerWorker int     // operations per goroutine, default 1000 / операций на горутину, по умолчанию 1000
+	Users        int64   // user IDs are drawn from [1, Users], default 100 / ID пользователей берутся из [1, Users], по умолчанию 100
+	Seed         int64   // worker i uses Seed+i, log it to replay a failure / горутина i использует Seed+i, логируйте его для повтора падения
+	RollbackRate float64 // share of purchases rolled back as if the DB failed / доля покупок, откатываемых как при ошибке БД
+	Mix          Mix     // zero value means DefaultMix / нулевое значение - DefaultMix
+}
+
+// Result counts successful operations of a run / считает успешные операции прогона
+type Result struct {
+	Checkouts int64
+	Purchases int64
+	Rollbacks int64
+	Cancels   int64
+	Renewals  int64
+}
+
+// withDefaults fills zero fields / заполняет нулевые поля
+func (w Workload) withDefaults() Workload {
+	if w.Workers <= 0 {
+		w.Workers = 8
+	}
+	if w.OpsPerWorker <= 0 {
+		w.OpsPerWorker = 1000
+	}
+	if w.Users <= 0 {
+		w.Users = 100
+	}
+	if w.Mix == (Mix{}) {
+		w.Mix = DefaultMix
+	}
+	return w
+}
+
+// Run executes the workload and returns once every worker is done; every purchase is confirmed or rolled back /
+// выполняет нагрузку и возвращается, когда все горутины закончили; каждая покупка подтверждается или откатывается
+func Run(cache *megacache.Megacache, w Workload) Result {
+	w = w.withDefaults()
+	available, reserved, sold := cache.GetLotsByStatus()
+	items := available + reserved + sold // lot count isn't exported / количество лотов не экспортируется
+
+	var (
+		wg                                                 sync.WaitGroup
+		checkouts, purchases, rollbacks, cancels, renewals atomic.Int64
+	)
+	for worker := 0; worker < w.Workers; worker++ {
+		wg.Add(1)
+		go func(rng *rand.Rand) {
+			defer wg.Done()
+
+			var codes []uuid.UUID
+			// take removes a random held code / забирает случайный удерживаемый код
+			take := func() (uuid.UUID, bool) {
+				if len(codes) == 0 {
+					return uuid.UUID{}, false
+				}
+				i := rng.Intn(len(codes))
+				code := codes[i]
+				codes[i] = codes[len(codes)-1]
+				codes = codes[:len(codes)-1]
+				return code, true
+			}
+
+			for op := 0; op < w.OpsPerWorker; op++ {
+				userID := 1 + rng.Int63n(w.Users)
+				switch w.pick(rng) {
+				case opCheckout:
+					if items == 0 {
+						continue
+					}
+					if checkout, err := cache.Checkout(userID, rng.Int63n(items)); err == nil {
+						codes = append(codes, checkout.Code)
+						checkouts.Add(1)
+					}
+				case opCheckoutAnyN:
+					batch, _ := cache.CheckoutAnyN(userID, 1+rng.Int63n(3))
+					for _, checkout := range batch {
+						codes = append(codes, checkout.Code)
+					}
+					checkouts.Add(int64(len(batch)))
+				case opPurchase:
+					code, ok := take()
+					if !ok {
+						continue
+					}
+					if _, ok := cache.TryPurchase(code); !ok {
+						continue
+					}
+					if rng.Float64() < w.RollbackRate {
+						// The reservation stays active and can be bought again / Резерв остается активным, его можно купить снова
+						cache.RollbackPurchase(code)
+						codes = append(codes, code)
+						rollbacks.Add(1)
+						continue
+					}
+					if cache.ConfirmPurchase(code) == nil {
+						purchases.Add(1)
+					}
+				case opCancel:
+					code, ok := take()
+					if !ok {
+						continue
+					}
+					if cache.CancelCheckout(code) == nil {
+						cancels.Add(1)
+					}
+					cache.DeleteCheckout(code)
+				case opRenew:
+					if len(codes) == 0 {
+						continue
+					}
+					if _, err := cache.RenewCheckout(codes[rng.Intn(len(codes))]); err == nil {
+						renewals.Add(1)
+					}
+				}
+			}
+		}(rand.New(rand.NewSource(w.Seed + int64(worker))))
+	}
+	wg.Wait()
+
+	return Result{
+		Checkouts: checkouts.Load(),
+		Purchases: purchases.Load(),
+		Rollbacks: rollbacks.Load(),
+		Cancels:   cancels.Load(),
+		Renewals:  renewals.Load(),
+	}
+}
+
+// Operations picked by weight / Операции, выбираемые по весу
+const (
+	opCheckout = iota
+	opCheckoutAnyN
+	opPurchase
+	opCancel
+	opRenew
+)
+
+// pick draws an operation by Mix weights / выбирает операцию по весам Mix
+func (w Workload) pick(rng *rand.Rand) int {
+	weights := [...]int{w.Mix.Checkout, w.Mix.CheckoutAnyN, w.Mix.Purchase, w.Mix.Cancel, w.Mix.Renew}
+	total := 0
+	for _, weight := range weights {
+		total += max(weight, 0)
+	}
+	if total == 0 {
+		return opCheckout
+	}
+
+	n := rng.Intn(total)
+	for op, weight := range weights {
+		n -= max(weight, 0)
+		if n < 0 {
+			return op
+		}
+	}
+	return opCheckout
+}
+
+// Invariants checks cache consistency; call it with no operations in flight and a TTL longer than the run, so background cleanup stays idle /
+// проверяет согласованность кеша; вызывайте без операций в процессе и с TTL длиннее прогона, чтобы фоновая очистка простаивала
+func Invariants(cache *megacache.Megacache, limitPerUser int64) error {
+	var errs []error
+	_, reserved, sold := cache.GetLotsByStatus()
+
+	if sold != cache.SoldCount() {
+		errs = append(errs, fmt.Errorf("%d lots sold, SoldCount reports %d", sold, cache.SoldCount()))
+	}
+
+	var purchased int64
+	for userID, count := range cache.GetPurchaseCountsSnapshot() {
+		if count < 0 || count > limitPerUser {
+			errs = append(errs, fmt.Errorf("user %d has %d purchases, limit %d", userID, count, limitPerUser))
+		}
+		purchased += count
+	}
+	if purchased != sold {
+		errs = append(errs, fmt.Errorf("users bought %d lots, %d lots sold", purchased, sold))
+	}
+
+	if active := int64(cache.GetActiveReservationsCount()); active != reserved {
+		errs = append(errs, fmt.Errorf("%d active reservations, %d lots reserved", active, reserved))
+	}
+
+	holders := make(map[int64]uuid.UUID)
+	for _, checkout := range cache.GetActiveCheckouts() {
+		if other, taken := holders[checkout.LotIndex]; taken {
+			errs = append(errs, fmt.Errorf("lot %d held by reservations %s and %s", checkout.LotIndex, other, checkout.Code))
+		}
+		holders[checkout.LotIndex] = checkout.Code
+
+		if status, err := cache.GetLotStatus(checkout.LotIndex); err != nil || status != megacache.StatusReserved {
+			errs = append(errs, fmt.Errorf("active reservation %s holds lot %d with status %v", checkout.Code, checkout.LotIndex, status))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Check fails t with every violated invariant / проваливает t с каждым нарушенным инвариантом
+func Check(t testing.TB, cache *megacache.Megacache, limitPerUser int64) {
+	t.Helper()
+	if err := Invariants(cache, limitPerUser); err != nil {
+		t.Errorf("cache invariants violated:\n%v", err)
+	}
+}
@@ -0,0 +1,61 @@
+package cachetest
+
+import (
+	"contest_notcoin/megacache"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestRandomizedWorkload runs mixed workloads with several seeds and checks cache invariants after each
+func TestRandomizedWorkload(t *testing.T) {
+	const limitPerUser = 3
+	seed := time.Now().UnixNano()
+	t.Logf("seed %d", seed)
+
+	for run := int64(0); run < 5; run++ {
+		// The TTL outlives the run so background cleanup doesn't race the check
+		cache := megacache.NewMegacache(500, limitPerUser, megacache.WithCheckoutTTL(time.Minute))
+
+		result := Run(cache, Workload{
+			Workers:      16,
+			OpsPerWorker: 2000,
+			Users:        200,
+			Seed:         seed + run*1000,
+			RollbackRate: 0.2,
+		})
+		cache.Close()
+
+		assert.Positive(t, result.Checkouts, "seed %d", seed+run*1000)
+		assert.Positive(t, result.Purchases, "seed %d", seed+run*1000)
+		assert.Positive(t, result.Rollbacks, "seed %d", seed+run*1000)
+		Check(t, cache, limitPerUser)
+	}
+}
+
+// TestRunMix tests that a single-operation mix only performs that operation
+func TestRunMix(t *testing.T) {
+	cache := megacache.NewMegacache(100, 10, megacache.WithCheckoutTTL(time.Minute))
+	defer cache.Close()
+
+	result := Run(cache, Workload{Workers: 4, OpsPerWorker: 50, Mix: Mix{Checkout: 1}})
+	assert.Positive(t, result.Checkouts)
+	assert.Equal(t, Result{Checkouts: result.Checkouts}, result)
+	assert.Equal(t, int(result.Checkouts), cache.GetActiveReservationsCount())
+	Check(t, cache, 10)
+}
+
+// TestInvariantsDetectViolation tests that a sold lot nobody bought is reported
+func TestInvariantsDetectViolation(t *testing.T) {
+	cache := megacache.NewMegacache(10, 5)
+	defer cache.Close()
+	require.NoError(t, Invariants(cache, 5))
+
+	// MarkSold is a DB correction: the lot is sold but no user counter moves
+	require.True(t, cache.MarkSold(3))
+	err := Invariants(cache, 5)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "users bought 0 lots, 1 lots sold")
+}
@@ -0,0 +1,95 @@
+package megacache
+
+import (
+	"encoding/binary"
+	"sync"
+	"sync/atomic"
+
+	"github.com/google/uuid"
+)
+
+// Default number of checkouts map shards / Количество шардов map checkouts по умолчанию
+const defaultCheckoutShards = 64
+
+// checkoutShard is a part of the checkouts map with its own lock / часть map checkouts со своей блокировкой
+type checkoutShard struct {
+	mu        sync.RWMutex
+	checkouts map[uuid.UUID]Checkout
+	_         [32]byte // keeps neighbouring locks on different cache lines / держит соседние блокировки в разных кеш-линиях
+}
+
+// checkoutShards splits reservations by code so requests for different codes don't contend on one mutex /
+// разбивает резервы по коду, чтобы запросы к разным кодам не боролись за один мьютекс
+type checkoutShards struct {
+	shards []checkoutShard
+	mask   uint32
+	size   atomic.Int64 // reservations in all shards / резервов во всех шардах
+}
+
+// newCheckoutShards creates n shards, rounded up to a power of two / создает n шардов, округляя вверх до степени двойки
+func newCheckoutShards(n int) *checkoutShards {
+	count := 1
+	for count < n {
+		count <<= 1
+	}
+
+	s := &checkoutShards{
+		shards: make([]checkoutShard, count),
+		mask:   uint32(count - 1),
+	}
+	for i := range s.shards {
+		s.shards[i].checkouts = make(map[uuid.UUID]Checkout)
+	}
+	return s
+}
+
+// shard returns the shard owning code; the last bytes are random for both v4 and v7 UUIDs /
+// возвращает шард, которому принадлежит code; последние байты случайны и у v4, и у v7 UUID
+func (s *checkoutShards) shard(code uuid.UUID) *checkoutShard {
+	return &s.shards[binary.BigEndian.Uint32(code[12:])&s.mask]
+}
+
+// insertLocked adds or replaces a reservation, the caller holds sh.mu / добавляет или заменяет резерв, вызывающий держит sh.mu
+func (s *checkoutShards) insertLocked(sh *checkoutShard, checkout Checkout) {
+	if _, exists := sh.checkouts[checkout.Code]; !exists {
+		s.size.Add(1)
+	}
+	sh.checkouts[checkout.Code] = checkout
+}
+
+// deleteLocked removes a reservation, the caller holds sh.mu / удаляет резерв, вызывающий держит sh.mu
+func (s *checkoutShards) deleteLocked(sh *checkoutShard, code uuid.UUID) {
+	if _, exists := sh.checkouts[code]; exists {
+		delete(sh.checkouts, code)
+		s.size.Add(-1)
+	}
+}
+
+// len returns the number of reservations without locking / возвращает количество резервов без блокировок
+func (s *checkoutShards) len() int {
+	return int(s.size.Load())
+}
+
+// reset drops every reservation, keeping the map allocations / удаляет все резервы, сохраняя выделенную память map
+func (s *checkoutShards) reset() {
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.Lock()
+		s.size.Add(-int64(len(sh.checkouts)))
+		clear(sh.checkouts)
+		sh.mu.Unlock()
+	}
+}
+
+// each calls fn for every reservation, read-locking one shard at a time; fn must not lock shards /
+// вызывает fn для каждого резерва, блокируя на чтение по одному шарду; fn не должна блокировать шарды
+func (s *checkoutShards) each(fn func(checkout Checkout)) {
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.RLock()
+		for _, checkout := range sh.checkouts {
+			fn(checkout)
+		}
+		sh.mu.RUnlock()
+	}
+}
@@ -3,10 +3,14 @@ package megacache
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log"
+	"math/rand/v2"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
+	"unsafe"
 
 	"github.com/google/uuid"
 )
@@ -14,42 +18,85 @@ import (
 var (
 	// Reservation errors / Ошибки резервирования
 
-	ErrGeneral              = errors.New("something went wrong")          // ERROR: something went wrong / ОШИБКА: что-то пошло не так
-	ErrItemAlreadyReserved  = errors.New("item already reserved")         // ERROR: item already reserved / ОШИБКА: лот уже зарезервирован
-	ErrItemAlreadySold      = errors.New("item already sold")             // ERROR: item already sold / ОШИБКА: лот уже продан
-	ErrInvalidItemID        = errors.New("invalid item ID")               // ERROR: invalid item ID / ОШИБКА: неверный ID лота
-	ErrReservationNotFound  = errors.New("reservation not found")         // ERROR: reservation not found / ОШИБКА: резерв не найден
-	ErrReservationCompleted = errors.New("reservation already completed") // ERROR: reservation already completed / ОШИБКА: резерв уже завершён
+	ErrGeneral              = errors.New("something went wrong")              // ERROR: something went wrong / ОШИБКА: что-то пошло не так
+	ErrItemAlreadyReserved  = errors.New("item already reserved")             // ERROR: item already reserved / ОШИБКА: лот уже зарезервирован
+	ErrItemAlreadySold      = errors.New("item already sold")                 // ERROR: item already sold / ОШИБКА: лот уже продан
+	ErrInvalidItemID        = errors.New("invalid item ID")                   // ERROR: invalid item ID / ОШИБКА: неверный ID лота
+	ErrReservationNotFound  = errors.New("reservation not found")             // ERROR: reservation not found / ОШИБКА: резерв не найден
+	ErrReservationCompleted = errors.New("reservation already completed")     // ERROR: reservation already completed / ОШИБКА: резерв уже завершён
+	ErrDuplicateItemID      = errors.New("duplicate item ID")                 // ERROR: duplicate item ID / ОШИБКА: повторяющийся ID лота
+	ErrReservationExpired   = errors.New("reservation expired")               // ERROR: reservation expired / ОШИБКА: резерв истек
+	ErrRenewalLimitReached  = errors.New("reservation renewal limit reached") // ERROR: renewal limit reached / ОШИБКА: достигнут лимит продлений резерва
+	ErrItemClaimedElsewhere = errors.New("item was claimed elsewhere")        // ERROR: reserved lot was marked sold outside the reservation / ОШИБКА: зарезервированный лот помечен проданным в обход резерва
+	ErrReservationRevoked   = errors.New("reservation revoked")               // ERROR: reservation created before the purchase cutoff / ОШИБКА: резерв создан до отсечки покупок
 
 	// User limitation errors / Ошибки пользовательских ограничений
 
 	ErrAllItemsPurchased  = errors.New("all items already purchased")                // ERROR: all items already purchased / ОШИБКА: все товары уже куплены
-	ErrUserLimitExceeded  = errors.New("user purchase limit reached (max 10 items)") // ERROR: user purchase limit reached / ОШИБКА: достигнут лимит покупок (макс. 10)
+	ErrUserLimitExceeded  = errors.New("user purchase limit reached")                // ERROR: user purchase limit reached, the limit is set per cache / ОШИБКА: достигнут лимит покупок, лимит задается для кеша
 	ErrServiceOverloaded  = errors.New("service overloaded, please try again later") // ERROR: service overloaded / ОШИБКА: сервис перегружен
 	ErrPurchaseNotAllowed = errors.New("purchase not allowed")                       // ERROR: purchase not allowed / ОШИБКА: покупка невозможна
+	ErrCacheClosed        = errors.New("cache closed")                               // ERROR: cache closed while waiting / ОШИБКА: кеш закрыт во время ожидания
 )
 
-// Checkout timeout duration / Время блокировки лота
+// Default checkout timeout duration / Время блокировки лота по умолчанию
 const checkoutTime = 3 * time.Second
 
+// Max renewals per reservation, so a lot can't be held indefinitely / Макс. продлений одного резерва, чтобы лот нельзя было держать бесконечно
+const maxRenewals = 3
+
+// Upper bound for the expired reservations cleanup interval / Верхняя граница интервала очистки истекших резервов
+const maxCleanupInterval = 5 * time.Second
+
+// Cleanup passes per checkout TTL when the interval is not set / Проходов очистки за TTL резерва, если интервал не задан
+const cleanupsPerTTL = 3
+
 // UnifiedCache - unified cache for reservations and user limitations / бъединенный кеш для резервирования и ограничений пользователей
 type Megacache struct {
 	// Mutexes for data protection / Мьютексы для защиты доступа
-	checkoutMu sync.RWMutex // protects checkouts / для защиты checkouts
-	userMu     sync.RWMutex // protects users / для защиты users
+	userMu sync.RWMutex // protects users / для защиты users
 
 	// Reservation data / Данные резервирования
-	checkouts map[uuid.UUID]Checkout // checkout cache / кеш для хранения checkout
-	lots      []Lot                  // array of lots / массив лотов
+	checkouts *checkoutShards // checkout cache, each shard has its own lock / кеш для хранения checkout, у каждого шарда своя блокировка
+	lots      []Lot           // array of lots / массив лотов
+	info      []LotInfo       // lot metadata, parallel to lots / метаданные лотов, параллельно lots
 
 	// User data / Данные пользователей
 	users        map[int64]*int64 // userID -> purchaseCount
 	limitPerUser int64            // max purchases per user / макс. количество покупок у пользователя
 	// countUsers   int64            // current count of users who purchased something / текущее кол-во пользователей которые что-то купили
 	limitUsers int64 // max number of users / макс. количество пользователей
-	countLots  int64 // сколько лотов уже купленно
+	countLots  int64 // sold lots, atomic only / сколько лотов уже куплено, только атомарно
 	nLots      int64 // кол-во лотов
 
+	// Endgame smoothing / Сглаживание конца распродажи
+	softCap SoftCap
+
+	// Scan start of the next CheckoutAnyN (atomic) / Начало сканирования следующего CheckoutAnyN (атомарная переменная)
+	anyOffset int64
+
+	// Reservation lifetime / Время жизни резерва
+	checkoutTTL time.Duration
+	saleEnd     time.Time        // reservations never outlive it, zero disables / резервы не живут дольше, ноль - выключено
+	now         func() time.Time // clock for expiry checks / часы для проверки истечения
+
+	// Expired reservations cleanup period, 0 derives it from the TTL / Период очистки истекших резервов, 0 - вычисляется из TTL
+	cleanupEvery time.Duration
+
+	// Checkouts map cap, 0 disables / Предел размера map checkouts, 0 - выключено
+	maxCheckouts int
+	evictSignal  chan struct{} // wakes the cleanup task out of band / будит задачу очистки вне расписания
+
+	// What MarkSold does to lots held by a reservation / Что MarkSold делает с лотами, удерживаемыми резервом
+	claimedLots ClaimedLotPolicy
+
+	// Reservations created before it can't be purchased, unix nanos, 0 disables (atomic) /
+	// Резервы, созданные раньше, нельзя купить, наносекунды unix, 0 - выключено (атомарная переменная)
+	purchaseCutoff int64
+
+	// Callers of CheckoutOrWait parked on reserved lots / Вызовы CheckoutOrWait, ожидающие зарезервированные лоты
+	waiters waitQueues
+
 	// Background task management / Для управления фоновой задачей
 	ctx    context.Context
 	cancel context.CancelFunc
@@ -61,8 +108,9 @@ type CheckoutStatus int
 
 const (
 	CheckoutStatusActive    CheckoutStatus = iota // 0 - active reservation / активный резерв
-	CheckoutStatusPurchased                       // 1 - purchase confirmed / покупка подтверждена
+	CheckoutStatusPurchased                       // 1 - purchased, awaiting confirmation / куплен, ожидает подтверждения
 	CheckoutStatusCancelled                       // 2 - reservation cancelled / резерв отменен
+	CheckoutStatusConfirmed                       // 3 - purchase confirmed, kept to recognize repeated confirms / покупка подтверждена, хранится, чтобы распознать повторные подтверждения
 )
 
 // Checkout represents a reservation record / представляет запись о резервировании
@@ -73,18 +121,90 @@ type Checkout struct {
 	ExpiresAt time.Time      // Reservation expiration time / время истечения резерва
 	Status    CheckoutStatus // Reservation status / статус резерва
 	CreatedAt time.Time      // Creation time (for logging) / время создания (для логирования)
+	Renewals  int            // Times the hold was extended / сколько раз резерв продлевался
 }
 
 // Lot represents a single lot with atomic status / представляет отдельный лот с атомарным статусом
 type Lot struct {
-	status uint32 // lot status (atomic variable) / статус лота (атомарная переменная)
+	status      uint32 // lot status (atomic variable) / статус лота (атомарная переменная)
+	confirmedAt int64  // unix nanos of purchase confirmation (atomic) / время подтверждения покупки в наносекундах (атомарная переменная)
 }
 
+// LotStatus is the state of a single lot / состояние отдельного лота
+type LotStatus uint32
+
 // Lot status constants / Константы статуса лота
 const (
-	StatusAvailable uint32 = iota // 0 - lot available / лот доступен
-	StatusReserved                // 1 - lot reserved / лот зарезервирован
-	StatusSold                    // 2 - lot sold / лот продан
+	StatusAvailable LotStatus = iota // 0 - lot available / лот доступен
+	StatusReserved                   // 1 - lot reserved / лот зарезервирован
+	StatusSold                       // 2 - lot sold / лот продан
+)
+
+// lotStatusNames maps lot statuses to their API names / сопоставляет статусы лотов их именам в API
+var lotStatusNames = [...]string{
+	StatusAvailable: "available",
+	StatusReserved:  "reserved",
+	StatusSold:      "sold",
+}
+
+// String returns the API name of the status / возвращает имя статуса в API
+func (s LotStatus) String() string {
+	if int(s) < len(lotStatusNames) {
+		return lotStatusNames[s]
+	}
+	return "LotStatus(" + strconv.FormatUint(uint64(s), 10) + ")"
+}
+
+// MarshalText encodes the status as its name, so JSON carries strings / кодирует статус его именем, чтобы в JSON были строки
+func (s LotStatus) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText decodes a status name produced by MarshalText / декодирует имя статуса, выданное MarshalText
+func (s *LotStatus) UnmarshalText(text []byte) error {
+	for status, name := range lotStatusNames {
+		if name == string(text) {
+			*s = LotStatus(status)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown lot status %q", text)
+}
+
+// LotStatusString names a raw lot status value / возвращает имя сырого значения статуса лота
+func LotStatusString(status uint32) string {
+	return LotStatus(status).String()
+}
+
+// loadStatus reads the lot status atomically / атомарно читает статус лота
+func (l *Lot) loadStatus() LotStatus {
+	return LotStatus(atomic.LoadUint32(&l.status))
+}
+
+// casStatus swaps the lot status if it is still old / меняет статус лота, если он все еще old
+func (l *Lot) casStatus(old, next LotStatus) bool {
+	return atomic.CompareAndSwapUint32(&l.status, uint32(old), uint32(next))
+}
+
+// storeStatus sets the lot status atomically / атомарно устанавливает статус лота
+func (l *Lot) storeStatus(status LotStatus) {
+	atomic.StoreUint32(&l.status, uint32(status))
+}
+
+// SoftCap smooths the final scramble when few lots remain / сглаживает борьбу за последние лоты
+type SoftCap struct {
+	Threshold  int64         // engages when unsold lots drop below it, 0 disables / включается, когда непроданных лотов меньше порога, 0 - выключено
+	MaxDelay   time.Duration // random delay before reserving, [0, MaxDelay) / случайная задержка перед резервированием
+	RejectRate float64       // probability to reject with ErrServiceOverloaded / вероятность отказа с ErrServiceOverloaded
+}
+
+// ClaimedLotPolicy decides who wins when a lot is marked sold while a reservation holds it /
+// решает, кто побеждает, когда лот помечается проданным, пока его удерживает резерв
+type ClaimedLotPolicy int
+
+const (
+	ClaimedLotReject  ClaimedLotPolicy = iota // MarkSold wins, the holder's purchase fails with ErrItemClaimedElsewhere / побеждает MarkSold, покупка держателя завершается ErrItemClaimedElsewhere
+	ClaimedLotProtect                         // MarkSold leaves reserved lots alone, the purchase write decides / MarkSold не трогает зарезервированные лоты, решает запись покупки
 )
 
 // SaleItems -  данные таблицы sale_items БД
@@ -94,14 +214,95 @@ type SaleItems struct {
 	UserID    int64
 }
 
-// NewUnifiedCache creates a new unified cache / создает новый объединенный кеш
-func NewMegacache(itemsCount int64, limitPerUser int64) *Megacache {
+// Option configures a Megacache at construction / настраивает Megacache при создании
+type Option func(*Megacache)
+
+// WithCheckoutTTL sets how long a reservation holds a lot, non-positive values keep the default / задает, сколько резерв держит лот, неположительные значения оставляют значение по умолчанию
+func WithCheckoutTTL(ttl time.Duration) Option {
+	return func(c *Megacache) {
+		if ttl <= 0 {
+			log.Printf("⚠️  NewMegacache: non-positive checkout TTL %v, using %v", ttl, c.checkoutTTL)
+			return
+		}
+		c.checkoutTTL = ttl
+	}
+}
+
+// WithCleanupInterval sets how often expired reservations are released, non-positive values derive it from the checkout TTL /
+// задает, как часто освобождаются истекшие резервы, неположительные значения вычисляют его из TTL резерва
+func WithCleanupInterval(interval time.Duration) Option {
+	return func(c *Megacache) {
+		if interval > 0 {
+			c.cleanupEvery = interval
+		}
+	}
+}
+
+// WithClock replaces time.Now for reservation timestamps and expiry, nil keeps the real clock / заменяет time.Now для меток времени и истечения резервов, nil оставляет реальные часы
+func WithClock(now func() time.Time) Option {
+	return func(c *Megacache) {
+		if now != nil {
+			c.now = now
+		}
+	}
+}
+
+// WithSaleEnd caps reservation expiry at the sale end so holds don't span the restart, zero time disables it /
+// ограничивает срок резерва концом распродажи, чтобы резервы не переживали перезапуск, нулевое время выключает ограничение
+func WithSaleEnd(end time.Time) Option {
+	return func(c *Megacache) {
+		c.saleEnd = end
+	}
+}
+
+// WithClaimedLotPolicy sets how MarkSold treats lots held by a reservation / задает, как MarkSold обращается с лотами, удерживаемыми резервом
+func WithClaimedLotPolicy(policy ClaimedLotPolicy) Option {
+	return func(c *Megacache) {
+		c.claimedLots = policy
+	}
+}
+
+// WithMaxCheckouts triggers an out-of-band cleanup once the checkouts map grows past max, non-positive values disable it /
+// запускает внеплановую очистку, когда map checkouts превышает max, неположительные значения выключают ее
+func WithMaxCheckouts(max int) Option {
+	return func(c *Megacache) {
+		if max > 0 {
+			c.maxCheckouts = max
+		}
+	}
+}
+
+// WithCheckoutShards sets the number of checkouts map shards (rounded up to a power of two), 1 means a single mutex; non-positive values keep the default /
+// задает количество шардов map checkouts (округляется вверх до степени двойки), 1 - один мьютекс; неположительные значения оставляют значение по умолчанию
+func WithCheckoutShards(n int) Option {
+	return func(c *Megacache) {
+		if n > 0 {
+			c.checkouts = newCheckoutShards(n)
+		}
+	}
+}
+
+// NewMegacache creates a new unified cache / создает новый объединенный кеш
+// Zero items is an empty sale (Checkout returns ErrAllItemsPurchased), zero limit forbids purchases, negative values are treated as zero /
+// ноль лотов - пустая распродажа (Checkout вернет ErrAllItemsPurchased), нулевой лимит запрещает покупки, отрицательные значения считаются нулем
+func NewMegacache(itemsCount int64, limitPerUser int64, opts ...Option) *Megacache {
+	// Defensive clamp, make() panics on negative size / Защитное ограничение, make() паникует на отрицательном размере
+	if itemsCount < 0 {
+		log.Printf("⚠️  NewMegacache: negative items count %d, using 0", itemsCount)
+		itemsCount = 0
+	}
+	if limitPerUser < 0 {
+		log.Printf("⚠️  NewMegacache: negative per-user limit %d, using 0", limitPerUser)
+		limitPerUser = 0
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 
 	cache := &Megacache{
 		// Initialize reservation data / Инициализация данных резервирования
-		checkouts: make(map[uuid.UUID]Checkout),
+		checkouts: newCheckoutShards(defaultCheckoutShards),
 		lots:      make([]Lot, itemsCount),
+		info:      make([]LotInfo, itemsCount),
 
 		// Initialize user data / Инициализация пользовательских данных
 		users:        make(map[int64]*int64, itemsCount),
@@ -109,12 +310,20 @@ func NewMegacache(itemsCount int64, limitPerUser int64) *Megacache {
 		limitUsers:   itemsCount,
 		countLots:    0,
 		nLots:        itemsCount,
+		checkoutTTL:  checkoutTime,
+		now:          time.Now,
+		evictSignal:  make(chan struct{}, 1),
+		waiters:      waitQueues{queues: make(map[int64][]*waiter)},
 
 		// Context for background tasks / Контекст для фоновых задач
 		ctx:    ctx,
 		cancel: cancel,
 	}
 
+	for _, opt := range opts {
+		opt(cache)
+	}
+
 	// Start background task for cleaning expired reservations / Запускаем фоновую задачу для удаления истекших резервов
 	cache.wg.Add(1)
 	go func() {
@@ -126,7 +335,18 @@ func NewMegacache(itemsCount int64, limitPerUser int64) *Megacache {
 
 // Checkout reserves a lot for a user with limit checks / резервирует лот для пользователя с проверкой лимитов
 func (c *Megacache) Checkout(userID int64, itemID int64) (checkout Checkout, err error) {
-	if c.countLots >= int64(len(c.lots)) {
+	return c.CheckoutCtx(context.Background(), userID, itemID)
+}
+
+// CheckoutCtx is Checkout that gives up with ctx.Err() once the caller is gone, before the soft cap delay and before taking the lot /
+// Checkout, который сдается с ctx.Err(), когда вызывающий ушел, до задержки мягкого лимита и до захвата лота
+func (c *Megacache) CheckoutCtx(ctx context.Context, userID int64, itemID int64) (checkout Checkout, err error) {
+	if err := ctx.Err(); err != nil {
+		return Checkout{}, err
+	}
+
+	// Same atomic value ConfirmPurchase and MarkSold publish / То же атомарное значение, которое публикуют ConfirmPurchase и MarkSold
+	if atomic.LoadInt64(&c.countLots) >= int64(len(c.lots)) {
 		return Checkout{}, ErrAllItemsPurchased
 	}
 
@@ -144,52 +364,186 @@ func (c *Megacache) Checkout(userID int64, itemID int64) (checkout Checkout, err
 	lot := &c.lots[itemID]
 
 	// Check current lot status / Проверяем текущий статус лота
-	currentStatus := atomic.LoadUint32(&lot.status)
+	currentStatus := lot.loadStatus()
 
 	// Lot already reserved / Лот уже зарезервирован
 	if currentStatus == StatusReserved {
 		return Checkout{}, ErrItemAlreadyReserved
 	}
 
+	// Smooth the endgame for lots still worth fighting for / Сглаживаем конец распродажи для лотов, за которые еще идет борьба
+	if currentStatus == StatusAvailable {
+		if err := c.applySoftCap(ctx); err != nil {
+			return Checkout{}, err
+		}
+	}
+
 	// Lot already sold / Лот уже продан
 	if currentStatus == StatusSold {
 		return Checkout{}, ErrItemAlreadySold
 	}
 
-	// Attempt to reserve the lot / Попытка зарезервировать лот
-	if atomic.CompareAndSwapUint32(&lot.status, StatusAvailable, StatusReserved) {
-		code := uuid.New()
-		now := time.Now()
-		expiresAt := now.Add(checkoutTime)
-
-		checkout := Checkout{
-			Code:      code,
-			UserID:    userID,
-			LotIndex:  itemID,
-			ExpiresAt: expiresAt,
-			Status:    CheckoutStatusActive,
-			CreatedAt: now,
-		}
-
-		// Safely add reservation to map / Безопасно добавляем резерв в map
-		c.checkoutMu.Lock()
-		c.checkouts[code] = checkout
-		c.checkoutMu.Unlock()
+	// The delay may have outlived the client, don't take a lot nobody will buy / Задержка могла пережить клиента, не занимаем лот, который никто не купит
+	if err := ctx.Err(); err != nil {
+		return Checkout{}, err
+	}
 
-		return checkout, nil
+	// Attempt to reserve the lot / Попытка зарезервировать лот
+	if lot.casStatus(StatusAvailable, StatusReserved) {
+		return c.addCheckout(userID, itemID), nil
 	}
 
 	// If reservation failed, check final status / Если не удалось зарезервировать, проверяем окончательный статус
-	finalStatus := atomic.LoadUint32(&lot.status)
+	finalStatus := lot.loadStatus()
 	if finalStatus == StatusSold {
 		return Checkout{}, ErrItemAlreadySold
 	}
 	return Checkout{}, ErrItemAlreadyReserved
 }
 
-// checkUserLimits checks user limits (internal method) / проверяет лимиты пользователя (внутренний метод)
-func (c *Megacache) checkUserLimits(userID int64) error {
-	// Check if there are still items available for purchase / Проверка что еще есть товары для покупок
+// addCheckout records a reservation for a lot the caller already switched to reserved / записывает резерв лота, который вызывающий уже перевел в статус зарезервирован
+func (c *Megacache) addCheckout(userID int64, itemID int64) Checkout {
+	now := c.now()
+	checkout := Checkout{
+		Code:      uuid.New(),
+		UserID:    userID,
+		LotIndex:  itemID,
+		ExpiresAt: c.capExpiry(now.Add(c.checkoutTTL)),
+		Status:    CheckoutStatusActive,
+		CreatedAt: now,
+	}
+
+	// Safely add reservation to map / Безопасно добавляем резерв в map
+	sh := c.checkouts.shard(checkout.Code)
+	sh.mu.Lock()
+	c.checkouts.insertLocked(sh, checkout)
+	sh.mu.Unlock()
+
+	if c.maxCheckouts > 0 && c.checkouts.len() > c.maxCheckouts {
+		c.requestEviction()
+	}
+
+	return checkout
+}
+
+// capExpiry returns min(expiresAt, saleEnd) / возвращает min(expiresAt, saleEnd)
+func (c *Megacache) capExpiry(expiresAt time.Time) time.Time {
+	if !c.saleEnd.IsZero() && expiresAt.After(c.saleEnd) {
+		return c.saleEnd
+	}
+	return expiresAt
+}
+
+// CheckoutAnyN reserves up to n of whatever lots are available, fewer if inventory runs out /
+// резервирует до n любых доступных лотов, меньше, если лоты заканчиваются
+// n beyond the user's remaining purchases fails with ErrUserLimitExceeded and reserves nothing; non-positive n reserves nothing /
+// n сверх оставшихся покупок пользователя завершается ErrUserLimitExceeded без резервов; неположительное n ничего не резервирует
+func (c *Megacache) CheckoutAnyN(userID int64, n int64) ([]Checkout, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+
+	if err := c.checkUserAllowance(userID, n); err != nil {
+		return nil, err
+	}
+	if err := c.applySoftCap(context.Background()); err != nil {
+		return nil, err
+	}
+
+	// Each call starts where the previous one stopped, so concurrent callers don't fight over the same lots /
+	// Каждый вызов начинает с того места, где остановился предыдущий, чтобы параллельные вызовы не боролись за одни и те же лоты
+	total := int64(len(c.lots))
+	start := (atomic.AddInt64(&c.anyOffset, n) - n) % total
+
+	checkouts := make([]Checkout, 0, n)
+	for i := int64(0); i < total && int64(len(checkouts)) < n; i++ {
+		itemID := (start + i) % total
+		if c.lots[itemID].casStatus(StatusAvailable, StatusReserved) {
+			checkouts = append(checkouts, c.addCheckout(userID, itemID))
+		}
+	}
+
+	if len(checkouts) == 0 {
+		if atomic.LoadInt64(&c.countLots) >= c.nLots {
+			return nil, ErrAllItemsPurchased
+		}
+		return nil, ErrItemAlreadyReserved
+	}
+
+	// Purchases confirmed while scanning may have used up the allowance / Покупки, подтвержденные во время сканирования, могли исчерпать лимит
+	if err := c.checkUserAllowance(userID, int64(len(checkouts))); err != nil {
+		for _, checkout := range checkouts {
+			c.CancelCheckout(checkout.Code)
+			c.DeleteCheckout(checkout.Code)
+		}
+		return nil, err
+	}
+
+	return checkouts, nil
+}
+
+// CheckoutMany reserves every listed lot or none of them, errors name the lot that failed /
+// резервирует все перечисленные лоты или ни одного, ошибки указывают лот, на котором произошел сбой
+func (c *Megacache) CheckoutMany(userID int64, itemIDs []int64) ([]Checkout, error) {
+	if len(itemIDs) == 0 {
+		return nil, nil
+	}
+
+	// Validate the whole batch before touching any lot / Проверяем весь пакет до того, как трогать лоты
+	seen := make(map[int64]bool, len(itemIDs))
+	for _, itemID := range itemIDs {
+		if itemID < 0 || itemID >= int64(len(c.lots)) {
+			return nil, fmt.Errorf("%w: item_id=%d", ErrInvalidItemID, itemID)
+		}
+		if seen[itemID] {
+			return nil, fmt.Errorf("%w: item_id=%d", ErrDuplicateItemID, itemID)
+		}
+		seen[itemID] = true
+	}
+
+	if err := c.checkUserAllowance(userID, int64(len(itemIDs))); err != nil {
+		return nil, err
+	}
+	if err := c.applySoftCap(context.Background()); err != nil {
+		return nil, err
+	}
+
+	// Lots are switched first and checkouts added only for a complete batch, so a partial batch is never visible /
+	// Сначала переключаем лоты, а checkout добавляем только для полного пакета, чтобы частичный пакет не был виден
+	for i, itemID := range itemIDs {
+		if c.lots[itemID].casStatus(StatusAvailable, StatusReserved) {
+			continue
+		}
+
+		c.releaseLots(itemIDs[:i])
+		if c.lots[itemID].loadStatus() == StatusSold {
+			return nil, fmt.Errorf("%w: item_id=%d", ErrItemAlreadySold, itemID)
+		}
+		return nil, fmt.Errorf("%w: item_id=%d", ErrItemAlreadyReserved, itemID)
+	}
+
+	// Purchases confirmed meanwhile may have used up the allowance / Покупки, подтвержденные за это время, могли исчерпать лимит
+	if err := c.checkUserAllowance(userID, int64(len(itemIDs))); err != nil {
+		c.releaseLots(itemIDs)
+		return nil, err
+	}
+
+	checkouts := make([]Checkout, 0, len(itemIDs))
+	for _, itemID := range itemIDs {
+		checkouts = append(checkouts, c.addCheckout(userID, itemID))
+	}
+	return checkouts, nil
+}
+
+// releaseLots returns lots reserved without a checkout to available / возвращает в доступные лоты, зарезервированные без checkout
+func (c *Megacache) releaseLots(itemIDs []int64) {
+	for _, itemID := range itemIDs {
+		c.releaseLot(itemID)
+	}
+}
+
+// checkUserAllowance checks that the user can still buy n more lots / проверяет, что пользователь еще может купить n лотов
+func (c *Megacache) checkUserAllowance(userID int64, n int64) error {
 	if atomic.LoadInt64(&c.countLots) >= c.limitUsers {
 		return ErrAllItemsPurchased
 	}
@@ -198,66 +552,185 @@ func (c *Megacache) checkUserLimits(userID int64) error {
 	userCount, exists := c.users[userID]
 	c.userMu.RUnlock()
 
-	if exists && atomic.LoadInt64(userCount) >= c.limitPerUser {
+	var currentCount int64
+	if exists {
+		currentCount = atomic.LoadInt64(userCount)
+	}
+	if currentCount+n > c.limitPerUser {
 		return ErrUserLimitExceeded
 	}
 
 	return nil
 }
 
+// SetSoftCap configures endgame smoothing, must be called before serving requests / настраивает сглаживание конца распродажи, вызывать до начала обработки запросов
+func (c *Megacache) SetSoftCap(softCap SoftCap) {
+	c.softCap = softCap
+}
+
+// applySoftCap delays or rejects a checkout when few lots remain, the delay ends early with ctx /
+// задерживает или отклоняет checkout, когда лотов осталось мало, задержка прерывается вместе с ctx
+func (c *Megacache) applySoftCap(ctx context.Context) error {
+	if c.softCap.Threshold <= 0 {
+		return nil
+	}
+
+	remaining := c.nLots - atomic.LoadInt64(&c.countLots)
+	if remaining >= c.softCap.Threshold {
+		return nil
+	}
+
+	if c.softCap.RejectRate > 0 && rand.Float64() < c.softCap.RejectRate {
+		return ErrServiceOverloaded
+	}
+
+	if c.softCap.MaxDelay > 0 {
+		timer := time.NewTimer(rand.N(c.softCap.MaxDelay))
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// checkUserLimits checks user limits (internal method) / проверяет лимиты пользователя (внутренний метод)
+func (c *Megacache) checkUserLimits(userID int64) error {
+	return c.checkUserAllowance(userID, 1)
+}
+
 // TryPurchase attempts to purchase a reserved lot with user limit checks / попытка купить зарезервированный лот с учетом лимитов пользователя
 func (c *Megacache) TryPurchase(code uuid.UUID) (Checkout, bool) {
-	if c.countLots >= int64(len(c.lots)) {
-		return Checkout{}, false
+	checkout, err := c.TryPurchaseE(code)
+	return checkout, err == nil
+}
+
+// TryPurchaseE is TryPurchase with the failure reason: ErrReservationNotFound, ErrReservationCompleted, ErrReservationExpired, ErrUserLimitExceeded and the like /
+// TryPurchase с причиной отказа: ErrReservationNotFound, ErrReservationCompleted, ErrReservationExpired, ErrUserLimitExceeded и подобные
+func (c *Megacache) TryPurchaseE(code uuid.UUID) (Checkout, error) {
+	return c.TryPurchaseCtx(context.Background(), code)
+}
+
+// TryPurchaseCtx is TryPurchaseE that gives up with ctx.Err() before it touches the user counter or the lot; ErrItemClaimedElsewhere when the lot was marked sold under an active reservation /
+// TryPurchaseE, который сдается с ctx.Err() до изменения счетчика пользователя или лота; ErrItemClaimedElsewhere, если лот помечен проданным при активном резерве
+func (c *Megacache) TryPurchaseCtx(ctx context.Context, code uuid.UUID) (Checkout, error) {
+	if err := ctx.Err(); err != nil {
+		return Checkout{}, err
+	}
+
+	// Same atomic value ConfirmPurchase and MarkSold publish / То же атомарное значение, которое публикуют ConfirmPurchase и MarkSold
+	if atomic.LoadInt64(&c.countLots) >= int64(len(c.lots)) {
+		return Checkout{}, ErrAllItemsPurchased
 	}
 	// Safely read reservation information / Безопасно читаем информацию о резерве
-	c.checkoutMu.RLock()
-	checkout, exists := c.checkouts[code]
-	c.checkoutMu.RUnlock()
+	sh := c.checkouts.shard(code)
+	sh.mu.RLock()
+	checkout, exists := sh.checkouts[code]
+	sh.mu.RUnlock()
 
 	if !exists {
-		return Checkout{}, false // reservation not found / резерв не найден
+		return Checkout{}, ErrReservationNotFound // reservation not found / резерв не найден
 	}
 
-	// Check reservation status / Проверяем статус резерва
-	if checkout.Status != CheckoutStatusActive {
-		return Checkout{}, false // reservation already completed or cancelled / резерв уже завершен или отменен
+	// Check reservation status, a hold cancelled on expiry still reports the expiry / Проверяем статус резерва, резерв, отмененный по истечении, по-прежнему сообщает об истечении
+	switch checkout.Status {
+	case CheckoutStatusPurchased, CheckoutStatusConfirmed:
+		return Checkout{}, ErrReservationCompleted
+	case CheckoutStatusCancelled:
+		if !checkout.ExpiresAt.After(c.now()) {
+			return Checkout{}, ErrReservationExpired
+		}
+		return Checkout{}, ErrReservationNotFound
 	}
 
 	// Check if reservation has expired / Проверяем, не истек ли срок резерва
-	if checkout.ExpiresAt.Before(time.Now()) {
+	if checkout.ExpiresAt.Before(c.now()) {
+		c.CancelCheckout(code)
+		return Checkout{}, ErrReservationExpired
+	}
+
+	// Revoked cohort: end the hold so the lot goes back on sale / Отозванная когорта: завершаем резерв, чтобы лот вернулся в продажу
+	if c.revoked(checkout) {
 		c.CancelCheckout(code)
-		return Checkout{}, false
+		return Checkout{}, ErrReservationRevoked
 	}
 
 	// Check array bounds / Проверяем границы массива
 	if checkout.LotIndex < 0 || checkout.LotIndex >= int64(len(c.lots)) {
 		c.CancelCheckout(code)
-		return Checkout{}, false
+		return Checkout{}, ErrInvalidItemID
+	}
+
+	// Last point to back out without undoing anything / Последний момент, когда можно отказаться без отката
+	if err := ctx.Err(); err != nil {
+		return Checkout{}, err
 	}
 
 	// Check and increment user purchase counter / Проверяем и увеличиваем счетчик покупок пользователя
 	newCount, err := c.incrementUserPurchase(checkout.UserID)
 	if err != nil {
-		return Checkout{}, false
+		return Checkout{}, err
 	}
 
 	// Attempt to purchase lot (change status from "reserved" to "sold")/ Попытка купить лот (изменить статус с "зарезервирован" на "продан")
 	lot := &c.lots[checkout.LotIndex]
-	if atomic.CompareAndSwapUint32(&lot.status, StatusReserved, StatusSold) {
+	if lot.casStatus(StatusReserved, StatusSold) {
 		// Change reservation status to "purchased" / Меняем статус резерва на "куплен"
-		c.checkoutMu.Lock()
-		if existingCheckout, exists := c.checkouts[code]; exists && existingCheckout.Status == CheckoutStatusActive {
+		sh.mu.Lock()
+		existingCheckout, exists := sh.checkouts[code]
+		stillActive := exists && existingCheckout.Status == CheckoutStatusActive
+		if stillActive {
 			existingCheckout.Status = CheckoutStatusPurchased
-			c.checkouts[code] = existingCheckout
+			sh.checkouts[code] = existingCheckout
 		}
-		c.checkoutMu.Unlock()
-		return checkout, true
+		sh.mu.Unlock()
+		if stillActive {
+			return checkout, nil
+		}
+
+		// Cancelled while we were buying, the cancel wins and the lot goes back / Отменен, пока мы покупали: отмена побеждает, лот возвращается
+		if lot.casStatus(StatusSold, StatusAvailable) {
+			c.wakeWaiters(checkout.LotIndex)
+		}
+		err = ErrPurchaseNotAllowed
+	} else if lot.loadStatus() == StatusSold && c.CancelCheckout(code) == nil {
+		// Only MarkSold sells a lot past an active reservation; the hold is worthless now, so end it /
+		// Только MarkSold продает лот в обход активного резерва; резерв больше ничего не стоит, завершаем его
+		err = ErrItemClaimedElsewhere
+	} else {
+		err = ErrPurchaseNotAllowed
 	}
 
 	// Instead rollback directly / Вместо этого откатываем напрямую
 	c.rollbackUserPurchase(checkout.UserID, newCount)
-	return Checkout{}, false
+	return Checkout{}, err
+}
+
+// SetPurchaseCutoff revokes every reservation created before cutoff, zero time lifts the cutoff /
+// отзывает все резервы, созданные до cutoff, нулевое время снимает отсечку
+func (c *Megacache) SetPurchaseCutoff(cutoff time.Time) {
+	var nanos int64
+	if !cutoff.IsZero() {
+		nanos = cutoff.UnixNano()
+	}
+	atomic.StoreInt64(&c.purchaseCutoff, nanos)
+}
+
+// PurchaseCutoff returns the current cutoff, zero time if none / возвращает текущую отсечку, нулевое время, если ее нет
+func (c *Megacache) PurchaseCutoff() time.Time {
+	nanos := atomic.LoadInt64(&c.purchaseCutoff)
+	if nanos == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos)
+}
+
+// revoked reports whether the checkout predates the purchase cutoff / сообщает, создан ли резерв до отсечки покупок
+func (c *Megacache) revoked(checkout Checkout) bool {
+	nanos := atomic.LoadInt64(&c.purchaseCutoff)
+	return nanos != 0 && checkout.CreatedAt.UnixNano() < nanos
 }
 
 // rollbackUserPurchase rolls back specific counter increment (without blocking) / откатывает конкретное увеличение счетчика (без блокировки)
@@ -300,7 +773,10 @@ func (c *Megacache) incrementUserPurchase(userID int64) (int64, error) {
 			currentCount = atomic.LoadInt64(userCount)
 		}
 	} else {
-		// New user / Новый пользователь
+		// New user, limit may be zero / Новый пользователь, лимит может быть нулевым
+		if c.limitPerUser < 1 {
+			return 0, ErrUserLimitExceeded
+		}
 		count := int64(1)
 		c.users[userID] = &count
 		return 1, nil
@@ -326,33 +802,50 @@ func (c *Megacache) decrementUserPurchase(userID int64) {
 	}
 }
 
-// ConfirmPurchase confirms purchase and removes reservation / подтверждает покупку и удаляет резерв
-func (c *Megacache) ConfirmPurchase(code uuid.UUID) {
-	c.checkoutMu.Lock()
-	defer c.checkoutMu.Unlock()
-
-	checkout, exists := c.checkouts[code]
-	if !exists || checkout.Status != CheckoutStatusPurchased {
-		return
+// ConfirmPurchase confirms a purchase exactly once, so retried confirms can't count a lot twice /
+// подтверждает покупку ровно один раз, чтобы повторные подтверждения не посчитали лот дважды
+// ErrReservationCompleted if already confirmed, ErrReservationNotFound for unknown or cancelled codes, ErrPurchaseNotAllowed if not purchased yet /
+// ErrReservationCompleted, если уже подтверждена, ErrReservationNotFound для неизвестных или отмененных кодов, ErrPurchaseNotAllowed, если еще не куплен
+func (c *Megacache) ConfirmPurchase(code uuid.UUID) error {
+	sh := c.checkouts.shard(code)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	checkout, exists := sh.checkouts[code]
+	switch {
+	case !exists || checkout.Status == CheckoutStatusCancelled:
+		return ErrReservationNotFound
+	case checkout.Status == CheckoutStatusConfirmed:
+		return ErrReservationCompleted
+	case checkout.Status != CheckoutStatusPurchased:
+		return ErrPurchaseNotAllowed
 	}
 
 	atomic.AddInt64(&c.countLots, 1)
-	// Remove reservation - purchase confirmed / Удаляем резерв - покупка подтверждена
-	delete(c.checkouts, code)
+	if checkout.LotIndex >= 0 && checkout.LotIndex < int64(len(c.lots)) {
+		// Compared with DB snapshot times, so always the real clock / Сравнивается со временем снимков БД, поэтому всегда реальные часы
+		atomic.StoreInt64(&c.lots[checkout.LotIndex].confirmedAt, time.Now().UnixNano())
+	}
+	// Keep a tombstone until cleanup drops it, at most one per lot / Храним метку до удаления очисткой, не больше одной на лот
+	checkout.Status = CheckoutStatusConfirmed
+	sh.checkouts[code] = checkout
+	return nil
 }
 
 // RollbackPurchase rolls back a purchase / откатывает покупку
 func (c *Megacache) RollbackPurchase(code uuid.UUID) {
-	c.checkoutMu.Lock()
-	checkout, exists := c.checkouts[code]
+	sh := c.checkouts.shard(code)
+	sh.mu.Lock()
+	checkout, exists := sh.checkouts[code]
 	if exists && checkout.Status == CheckoutStatusPurchased {
 		// Return reservation status to active / Возвращаем статус резерва в активный
 		checkout.Status = CheckoutStatusActive
-		c.checkouts[code] = checkout
+		sh.checkouts[code] = checkout
 	}
-	c.checkoutMu.Unlock()
+	sh.mu.Unlock()
 
-	if !exists {
+	// A confirmed purchase is final / Подтвержденная покупка окончательна
+	if !exists || checkout.Status == CheckoutStatusConfirmed {
 		return
 	}
 
@@ -362,41 +855,218 @@ func (c *Megacache) RollbackPurchase(code uuid.UUID) {
 	// Rollback lot status / Откатываем статус лота
 	if checkout.LotIndex >= 0 && checkout.LotIndex < int64(len(c.lots)) {
 		lot := &c.lots[checkout.LotIndex]
-		atomic.CompareAndSwapUint32(&lot.status, StatusSold, StatusReserved)
+		lot.casStatus(StatusSold, StatusReserved)
 	}
 }
 
-// CancelCheckout cancels a reservation / отменяет резерв
+// BuyNow sells an available lot without a prior reservation, switching it straight from available to sold /
+// продает доступный лот без предварительного резерва, переводя его сразу из доступного в проданный
+// The returned checkout is purchased: confirm it with ConfirmPurchase once persisted, or undo it with AbortBuyNow /
+// Возвращаемый резерв куплен: подтвердите его ConfirmPurchase после сохранения или отмените AbortBuyNow
+func (c *Megacache) BuyNow(userID int64, itemID int64) (Checkout, error) {
+	// Same atomic value ConfirmPurchase and MarkSold publish / То же атомарное значение, которое публикуют ConfirmPurchase и MarkSold
+	if atomic.LoadInt64(&c.countLots) >= int64(len(c.lots)) {
+		return Checkout{}, ErrAllItemsPurchased
+	}
+
+	// Check array bounds / Проверяем границы массива
+	if itemID < 0 || itemID >= int64(len(c.lots)) {
+		return Checkout{}, ErrInvalidItemID
+	}
+
+	lot := &c.lots[itemID]
+	switch lot.loadStatus() {
+	case StatusReserved:
+		return Checkout{}, ErrItemAlreadyReserved
+	case StatusSold:
+		return Checkout{}, ErrItemAlreadySold
+	}
+
+	// Smooth the endgame like a checkout would / Сглаживаем конец распродажи, как это сделал бы checkout
+	if err := c.applySoftCap(context.Background()); err != nil {
+		return Checkout{}, err
+	}
+
+	// Take the user's slot first, so the lot is never sold past the limit / Сначала занимаем слот пользователя, чтобы лот не продался сверх лимита
+	if _, err := c.incrementUserPurchase(userID); err != nil {
+		return Checkout{}, err
+	}
+
+	// A concurrent Checkout or BuyNow may win the lot, then the slot goes back / Конкурентный Checkout или BuyNow может забрать лот, тогда слот возвращается
+	if !lot.casStatus(StatusAvailable, StatusSold) {
+		c.decrementUserPurchase(userID)
+		if lot.loadStatus() == StatusSold {
+			return Checkout{}, ErrItemAlreadySold
+		}
+		return Checkout{}, ErrItemAlreadyReserved
+	}
+
+	// Purchased, not confirmed: recovery treats the lot as in flight until the DB has it /
+	// Куплен, но не подтвержден: восстановление считает лот покупаемым, пока он не появится в БД
+	now := c.now()
+	checkout := Checkout{
+		Code:      uuid.New(),
+		UserID:    userID,
+		LotIndex:  itemID,
+		ExpiresAt: now,
+		Status:    CheckoutStatusPurchased,
+		CreatedAt: now,
+	}
+
+	sh := c.checkouts.shard(checkout.Code)
+	sh.mu.Lock()
+	c.checkouts.insertLocked(sh, checkout)
+	sh.mu.Unlock()
+
+	if c.maxCheckouts > 0 && c.checkouts.len() > c.maxCheckouts {
+		c.requestEviction()
+	}
+
+	return checkout, nil
+}
+
+// AbortBuyNow undoes an unconfirmed BuyNow: the user's slot is returned and the lot goes back on sale /
+// отменяет неподтвержденный BuyNow: слот пользователя возвращается, лот снова в продаже
+// ErrReservationCompleted once confirmed, ErrReservationNotFound for unknown or already aborted codes /
+// ErrReservationCompleted после подтверждения, ErrReservationNotFound для неизвестных или уже отмененных кодов
+func (c *Megacache) AbortBuyNow(code uuid.UUID) error {
+	sh := c.checkouts.shard(code)
+	sh.mu.Lock()
+	checkout, exists := sh.checkouts[code]
+	purchased := exists && checkout.Status == CheckoutStatusPurchased
+	if purchased {
+		checkout.Status = CheckoutStatusCancelled
+		sh.checkouts[code] = checkout
+	}
+	sh.mu.Unlock()
+
+	if !purchased {
+		if exists && checkout.Status == CheckoutStatusConfirmed {
+			return ErrReservationCompleted
+		}
+		return ErrReservationNotFound
+	}
+
+	c.decrementUserPurchase(checkout.UserID)
+	if checkout.LotIndex >= 0 && checkout.LotIndex < int64(len(c.lots)) {
+		// Hand the lot to the next waiter, if any / Передаем лот следующему ожидающему, если он есть
+		if c.lots[checkout.LotIndex].casStatus(StatusSold, StatusAvailable) {
+			c.wakeWaiters(checkout.LotIndex)
+		}
+	}
+	return nil
+}
+
+// RevokePurchase undoes a confirmed purchase the DB never recorded: the buyer's slot is returned and the confirmation is dropped,
+// so a retried purchase of the code is no longer told it completed; the lot goes back on sale unless sold says the DB sold it to someone else.
+// The slot is returned even if the cache no longer remembers the purchase, so call it only for purchases that were confirmed.
+// Returns the purchase code, false if the cache no longer remembers it /
+// отменяет подтвержденную покупку, которую БД так и не записала: слот покупателя возвращается, подтверждение снимается,
+// чтобы повторной покупке по коду больше не отвечали, что она завершена; лот снова в продаже, если только sold не говорит, что БД продала его другому.
+// Слот возвращается, даже если кеш уже не помнит покупку, поэтому вызывайте только для подтвержденных покупок.
+// Возвращает код покупки, false, если кеш его уже не помнит
+func (c *Megacache) RevokePurchase(userID, itemID int64, sold bool) (uuid.UUID, bool) {
+	if itemID < 0 || itemID >= int64(len(c.lots)) {
+		return uuid.Nil, false
+	}
+
+	// The tombstone is found by lot and buyer, at most one per lot / Метка ищется по лоту и покупателю, на лот их не больше одной
+	var code uuid.UUID
+	found := false
+	for i := range c.checkouts.shards {
+		sh := &c.checkouts.shards[i]
+		sh.mu.Lock()
+		for key, checkout := range sh.checkouts {
+			if checkout.Status == CheckoutStatusConfirmed && checkout.LotIndex == itemID && checkout.UserID == userID {
+				checkout.Status = CheckoutStatusCancelled
+				sh.checkouts[key] = checkout
+				code, found = key, true
+				break
+			}
+		}
+		sh.mu.Unlock()
+		if found {
+			break
+		}
+	}
+
+	c.decrementUserPurchase(userID)
+	if !sold {
+		lot := &c.lots[itemID]
+		atomic.StoreInt64(&lot.confirmedAt, 0)
+		if lot.casStatus(StatusSold, StatusAvailable) {
+			atomic.AddInt64(&c.countLots, -1)
+			c.wakeWaiters(itemID)
+		}
+	}
+	return code, found
+}
+
+// CancelCheckout cancels an active reservation / отменяет активный резерв
+// ErrReservationNotFound for unknown or already cancelled codes, ErrReservationCompleted for purchased ones /
+// ErrReservationNotFound для неизвестных или уже отмененных кодов, ErrReservationCompleted для купленных
 func (c *Megacache) CancelCheckout(code uuid.UUID) error {
-	c.checkoutMu.Lock()
-	checkout, exists := c.checkouts[code]
-	if exists {
+	sh := c.checkouts.shard(code)
+	sh.mu.Lock()
+	checkout, exists := sh.checkouts[code]
+	active := exists && checkout.Status == CheckoutStatusActive
+	if active {
 		checkout.Status = CheckoutStatusCancelled
-		c.checkouts[code] = checkout
+		sh.checkouts[code] = checkout
 	}
-	c.checkoutMu.Unlock()
+	sh.mu.Unlock()
 
-	if !exists {
+	// The lot of a finished reservation may already belong to someone else, leave it alone / Лот завершенного резерва может уже принадлежать другому, не трогаем его
+	if !active {
+		if exists && (checkout.Status == CheckoutStatusPurchased || checkout.Status == CheckoutStatusConfirmed) {
+			return ErrReservationCompleted
+		}
 		return ErrReservationNotFound
 	}
 
-	// Release the lot / Освобождаем лот
+	// Release the lot or hand it to the next waiter / Освобождаем лот или передаем его следующему ожидающему
 	if checkout.LotIndex >= 0 && checkout.LotIndex < int64(len(c.lots)) {
-		lot := &c.lots[checkout.LotIndex]
-		atomic.CompareAndSwapUint32(&lot.status, StatusReserved, StatusAvailable)
+		c.releaseLot(checkout.LotIndex)
 	}
 
 	return nil
 }
 
+// RenewCheckout extends an active reservation by the checkout TTL, capped at the sale end, and returns the new expiry, at most maxRenewals times /
+// продлевает активный резерв на TTL резерва, но не дальше конца распродажи, и возвращает новое время истечения, не более maxRenewals раз
+func (c *Megacache) RenewCheckout(code uuid.UUID) (time.Time, error) {
+	sh := c.checkouts.shard(code)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	checkout, exists := sh.checkouts[code]
+	switch {
+	case !exists || checkout.Status == CheckoutStatusCancelled:
+		return time.Time{}, ErrReservationNotFound
+	case checkout.Status == CheckoutStatusPurchased || checkout.Status == CheckoutStatusConfirmed:
+		return time.Time{}, ErrReservationCompleted
+	case !checkout.ExpiresAt.After(c.now()):
+		// Cleanup may not have released the lot yet, but the hold is over / Очистка могла еще не освободить лот, но резерв уже закончился
+		return time.Time{}, ErrReservationExpired
+	case checkout.Renewals >= maxRenewals:
+		return time.Time{}, ErrRenewalLimitReached
+	}
+
+	checkout.ExpiresAt = c.capExpiry(checkout.ExpiresAt.Add(c.checkoutTTL))
+	checkout.Renewals++
+	sh.checkouts[code] = checkout
+	return checkout.ExpiresAt, nil
+}
+
 // DeleteCheckout completely removes reservation from memory / полностью удаляет резерв из памяти
 func (c *Megacache) DeleteCheckout(code uuid.UUID) {
-	c.checkoutMu.Lock()
-	defer c.checkoutMu.Unlock()
+	sh := c.checkouts.shard(code)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
 
-	if checkout, exists := c.checkouts[code]; exists {
-		if checkout.Status == CheckoutStatusCancelled || checkout.Status == CheckoutStatusPurchased {
-			delete(c.checkouts, code)
+	if checkout, exists := sh.checkouts[code]; exists {
+		if checkout.Status != CheckoutStatusActive {
+			c.checkouts.deleteLocked(sh, code)
 		}
 	}
 }
@@ -413,41 +1083,302 @@ func (c *Megacache) GetPurchaseCount(userID int64) (int64, bool) {
 	return atomic.LoadInt64(userCount), true
 }
 
+// GetPurchaseCountsSnapshot copies purchase counts of all users, e.g. to dump top buyers at sale end; O(number of buyers) under userMu.RLock /
+// копирует счетчики покупок всех пользователей, например для выгрузки топ покупателей в конце распродажи; O(число покупателей) под userMu.RLock
+func (c *Megacache) GetPurchaseCountsSnapshot() map[int64]int64 {
+	c.userMu.RLock()
+	defer c.userMu.RUnlock()
+
+	snapshot := make(map[int64]int64, len(c.users))
+	for userID, userCount := range c.users {
+		snapshot[userID] = atomic.LoadInt64(userCount)
+	}
+	return snapshot
+}
+
+// UserCount returns how many users have bought something / возвращает, сколько пользователей что-то купили
+func (c *Megacache) UserCount() int {
+	c.userMu.RLock()
+	defer c.userMu.RUnlock()
+	return len(c.users)
+}
+
 // GetCheckoutInfo returns reservation information / возвращает информацию о резерве
 func (c *Megacache) GetCheckoutInfo(code uuid.UUID) (Checkout, bool) {
-	c.checkoutMu.RLock()
-	defer c.checkoutMu.RUnlock()
-	checkout, exists := c.checkouts[code]
+	sh := c.checkouts.shard(code)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+	checkout, exists := sh.checkouts[code]
 	return checkout, exists
 }
 
+// TimeRemaining returns time left until an active reservation expires, false if it is unknown, expired or no longer active /
+// возвращает время до истечения активного резерва, false если резерв неизвестен, истек или уже не активен
+func (c *Megacache) TimeRemaining(code uuid.UUID) (time.Duration, bool) {
+	checkout, exists := c.GetCheckoutInfo(code)
+	if !exists || checkout.Status != CheckoutStatusActive {
+		return 0, false
+	}
+
+	remaining := checkout.ExpiresAt.Sub(c.now())
+	if remaining <= 0 {
+		return 0, false
+	}
+	return remaining, true
+}
+
 // GetLotStatus returns current lot status / возвращает текущий статус лота
-func (c *Megacache) GetLotStatus(itemID int64) (uint32, error) {
+func (c *Megacache) GetLotStatus(itemID int64) (LotStatus, error) {
 	if itemID < 0 || itemID >= int64(len(c.lots)) {
 		return 0, ErrInvalidItemID
 	}
-	return atomic.LoadUint32(&c.lots[itemID].status), nil
+	return c.lots[itemID].loadStatus(), nil
 }
 
-// GetActiveReservationsCount returns number of active reservations / возвращает количество активных резервов
-func (c *Megacache) GetActiveReservationsCount() int {
-	c.checkoutMu.RLock()
-	defer c.checkoutMu.RUnlock()
+// LotInfo - display metadata of a lot / отображаемые метаданные лота
+type LotInfo struct {
+	Name     string
+	ImageURL string
+}
+
+// LoadLotInfo stores lot metadata on startup; must be called before serving requests, unknown item IDs are ignored /
+// сохраняет метаданные лотов при старте; вызывать до обслуживания запросов, неизвестные item ID игнорируются
+func (c *Megacache) LoadLotInfo(info map[int64]LotInfo) {
+	for itemID, lotInfo := range info {
+		if itemID < 0 || itemID >= int64(len(c.info)) {
+			continue
+		}
+		c.info[itemID] = lotInfo
+	}
+}
+
+// GetLotInfo returns lot metadata together with its current status / возвращает метаданные лота вместе с его текущим статусом
+func (c *Megacache) GetLotInfo(itemID int64) (name, imageURL string, status LotStatus, err error) {
+	if itemID < 0 || itemID >= int64(len(c.lots)) {
+		return "", "", 0, ErrInvalidItemID
+	}
+	info := c.info[itemID]
+	return info.Name, info.ImageURL, c.lots[itemID].loadStatus(), nil
+}
+
+// GetLotsByStatus counts lots per status without taking any mutex; under concurrency it is a best-effort snapshot, lots may change while being counted /
+// считает лоты по статусам без мьютексов; при конкурентной работе это приблизительный снимок, лоты могут меняться во время подсчета
+func (c *Megacache) GetLotsByStatus() (available, reserved, sold int64) {
+	for i := range c.lots {
+		switch c.lots[i].loadStatus() {
+		case StatusAvailable:
+			available++
+		case StatusReserved:
+			reserved++
+		case StatusSold:
+			sold++
+		}
+	}
+	return available, reserved, sold
+}
 
+// ListAvailable returns up to limit available item IDs in ascending order, scanning from item offset; like GetLotsByStatus it is a lock-free snapshot /
+// возвращает до limit доступных item ID по возрастанию, начиная сканирование с лота offset; как и GetLotsByStatus, это снимок без блокировок
+func (c *Megacache) ListAvailable(offset, limit int) []int64 {
+	if offset < 0 || limit <= 0 {
+		return nil
+	}
+
+	items := make([]int64, 0, min(limit, 64))
+	for i := offset; i < len(c.lots) && len(items) < limit; i++ {
+		if c.lots[i].loadStatus() == StatusAvailable {
+			items = append(items, int64(i))
+		}
+	}
+	return items
+}
+
+// SoldCount returns number of sold lots / возвращает количество проданных лотов
+func (c *Megacache) SoldCount() int64 {
+	return atomic.LoadInt64(&c.countLots)
+}
+
+// mapEntryBytes approximates one map entry: key and value in buckets kept ~80% full plus a tophash byte /
+// приближает одну запись map: ключ и значение в бакетах, заполненных примерно на 80%, плюс байт tophash
+func mapEntryBytes(key, value uintptr) int64 {
+	return int64(key+value)*5/4 + 1
+}
+
+// SizeStats returns the entries held in the checkouts map (finished reservations included until cleanup), the user counters and the lots; O(1), a checkouts count growing without bound means cleanup stalled /
+// возвращает число записей в map checkouts (включая завершенные резервы до очистки), счетчиков пользователей и лотов; O(1), неограниченный рост checkouts значит, что очистка встала
+func (c *Megacache) SizeStats() (checkouts, users, lots int) {
+	c.userMu.RLock()
+	users = len(c.users)
+	c.userMu.RUnlock()
+	return c.checkouts.len(), users, len(c.lots)
+}
+
+// EstimatedMemoryBytes estimates the memory held by lots, reservations and user counters, for capacity planning; an estimate, not a measurement /
+// оценивает память лотов, резервов и счетчиков пользователей для планирования мощностей; это оценка, а не измерение
+func (c *Megacache) EstimatedMemoryBytes() int64 {
+	// Lots and their metadata, fixed by the configured lot count / Лоты и их метаданные, заданы настроенным количеством лотов
+	total := int64(len(c.lots))*int64(unsafe.Sizeof(Lot{})) + int64(len(c.info))*int64(unsafe.Sizeof(LotInfo{}))
+	// Metadata is written once before serving, so reading it unlocked is safe / Метаданные пишутся один раз до обслуживания, поэтому читать их без блокировок безопасно
+	for i := range c.info {
+		total += int64(len(c.info[i].Name) + len(c.info[i].ImageURL))
+	}
+
+	// Reservations, finished ones included until cleanup drops them / Резервы, включая завершенные, пока очистка их не удалит
+	total += int64(len(c.checkouts.shards)) * int64(unsafe.Sizeof(checkoutShard{}))
+	total += int64(c.checkouts.len()) * mapEntryBytes(unsafe.Sizeof(uuid.UUID{}), unsafe.Sizeof(Checkout{}))
+
+	// Users: map entry plus the counter it points to / Пользователи: запись map плюс счетчик, на который она указывает
+	c.userMu.RLock()
+	users := int64(len(c.users))
+	c.userMu.RUnlock()
+	total += users * (mapEntryBytes(unsafe.Sizeof(int64(0)), unsafe.Sizeof((*int64)(nil))) + int64(unsafe.Sizeof(int64(0))))
+
+	return total
+}
+
+// GetActiveReservationsCount returns number of active reservations summed across shards / возвращает количество активных резервов по всем шардам
+func (c *Megacache) GetActiveReservationsCount() int {
 	count := 0
-	for _, checkout := range c.checkouts {
+	c.checkouts.each(func(checkout Checkout) {
 		if checkout.Status == CheckoutStatusActive {
 			count++
 		}
-	}
+	})
 	return count
 }
 
+// GetActiveCheckouts returns a snapshot of active non-expired reservations / возвращает снимок активных неистекших резервов
+func (c *Megacache) GetActiveCheckouts() []Checkout {
+	now := c.now()
+
+	checkouts := make([]Checkout, 0, c.checkouts.len())
+	c.checkouts.each(func(checkout Checkout) {
+		if checkout.Status == CheckoutStatusActive && checkout.ExpiresAt.After(now) {
+			checkouts = append(checkouts, checkout)
+		}
+	})
+	return checkouts
+}
+
+// GetUserReservations returns user's active reservations and purchases awaiting DB confirmation / возвращает активные резервы пользователя и покупки, ожидающие подтверждения в БД
+func (c *Megacache) GetUserReservations(userID int64) []Checkout {
+	now := c.now()
+
+	var checkouts []Checkout
+	c.checkouts.each(func(checkout Checkout) {
+		if checkout.UserID != userID {
+			return
+		}
+		switch {
+		case checkout.Status == CheckoutStatusActive && checkout.ExpiresAt.After(now):
+			checkouts = append(checkouts, checkout)
+		case checkout.Status == CheckoutStatusPurchased:
+			checkouts = append(checkouts, checkout)
+		}
+	})
+	return checkouts
+}
+
+// CancelUserCheckouts releases every active reservation of a user, expired ones included, and returns the released codes; purchases are kept /
+// освобождает все активные резервы пользователя, включая истекшие, и возвращает коды освобожденных; покупки сохраняются
+func (c *Megacache) CancelUserCheckouts(userID int64) []uuid.UUID {
+	// Collect codes first, each releases the shard lock before CancelCheckout takes it / Сначала собираем коды, each освобождает блокировку шарда до того, как ее возьмет CancelCheckout
+	var codes []uuid.UUID
+	c.checkouts.each(func(checkout Checkout) {
+		if checkout.UserID == userID && checkout.Status == CheckoutStatusActive {
+			codes = append(codes, checkout.Code)
+		}
+	})
+
+	// A purchase racing with us wins, its code is not returned / Параллельная покупка побеждает, ее код не возвращается
+	released := codes[:0]
+	for _, code := range codes {
+		if c.CancelCheckout(code) == nil {
+			released = append(released, code)
+		}
+	}
+	return released
+}
+
+// MarkSold marks a lot sold if it is not, keeping countLots in sync; returns true if changed / помечает лот проданным, если он еще не продан, синхронно обновляя countLots; возвращает true при изменении
+// Reserved lots are skipped under ClaimedLotProtect / Зарезервированные лоты пропускаются при ClaimedLotProtect
+func (c *Megacache) MarkSold(itemID int64) bool {
+	if itemID < 0 || itemID >= int64(len(c.lots)) {
+		return false
+	}
+
+	lot := &c.lots[itemID]
+	for {
+		status := lot.loadStatus()
+		if status == StatusSold {
+			return false
+		}
+		if status == StatusReserved && c.claimedLots == ClaimedLotProtect {
+			return false
+		}
+		if lot.casStatus(status, StatusSold) {
+			atomic.AddInt64(&c.countLots, 1)
+			return true
+		}
+	}
+}
+
+// MarkLotsSold marks each lot sold via MarkSold, lots already sold are not counted twice; returns how many changed /
+// помечает каждый лот проданным через MarkSold, уже проданные лоты повторно не считаются; возвращает число измененных
+func (c *Megacache) MarkLotsSold(itemIDs []int64) int {
+	marked := 0
+	for _, itemID := range itemIDs {
+		if c.MarkSold(itemID) {
+			marked++
+		}
+	}
+	return marked
+}
+
+// ApplyLotStatuses corrects lot statuses from DB purchased flags read at asOf, leaving reservations and user counts alone;
+// callers leave out lots whose confirmed purchase is still waiting to be written /
+// исправляет статусы лотов по флагам purchased из БД, прочитанным в момент asOf, не трогая резервы и счетчики пользователей;
+// вызывающий не передает лоты, чья подтвержденная покупка еще ждет записи
+func (c *Megacache) ApplyLotStatuses(purchased map[int64]bool, asOf time.Time) (markedSold, markedAvailable int) {
+	// Lots with a reservation in the cache may have a purchase in flight / У лотов с резервом в кеше может идти покупка
+	inFlight := make(map[int64]bool, c.checkouts.len())
+	c.checkouts.each(func(checkout Checkout) {
+		if checkout.Status != CheckoutStatusConfirmed {
+			inFlight[checkout.LotIndex] = true
+		}
+	})
+
+	for itemID, isPurchased := range purchased {
+		if itemID < 0 || itemID >= int64(len(c.lots)) {
+			continue
+		}
+
+		if isPurchased {
+			if c.MarkSold(itemID) {
+				markedSold++
+			}
+			continue
+		}
+
+		// Revert only purchases confirmed before the DB read: the DB should already have them /
+		// Откатываем только покупки, подтвержденные до чтения БД: в БД они уже должны быть
+		lot := &c.lots[itemID]
+		if inFlight[itemID] || atomic.LoadInt64(&lot.confirmedAt) >= asOf.UnixNano() {
+			continue
+		}
+		if lot.casStatus(StatusSold, StatusAvailable) {
+			atomic.AddInt64(&c.countLots, -1)
+			markedAvailable++
+		}
+	}
+	return markedSold, markedAvailable
+}
+
 // cleanupExpiredReservations - background task for cleaning expired reservations / фоновая задача для очистки истекших резервов
 func (c *Megacache) cleanupExpiredReservations() {
 	defer c.wg.Done() // Mark goroutine as done / Отмечаем завершение горутины
 
-	ticker := time.NewTicker(5 * time.Second)
+	ticker := time.NewTicker(c.cleanupInterval())
 	defer ticker.Stop()
 
 	for {
@@ -456,31 +1387,91 @@ func (c *Megacache) cleanupExpiredReservations() {
 			return // Context cancelled / Контекст отменен
 		case <-ticker.C:
 			c.cleanupExpired()
+		case <-c.evictSignal:
+			c.evictCheckouts()
+		}
+	}
+}
+
+// requestEviction asks the cleanup task for an eviction pass; requests made while one is pending collapse into it /
+// просит задачу очистки выполнить вытеснение; запросы, пока предыдущий ожидает, объединяются с ним
+func (c *Megacache) requestEviction() {
+	select {
+	case c.evictSignal <- struct{}{}:
+	default:
+	}
+}
+
+// evictCheckouts cancels expired reservations and, if the map is still over the cap, drops cancelled and confirmed ones regardless of age;
+// active reservations and purchases awaiting ConfirmPurchase/RollbackPurchase/AbortBuyNow are never evicted /
+// отменяет истекшие резервы и, если map все еще больше предела, удаляет отмененные и подтвержденные независимо от возраста;
+// активные резервы и покупки, ожидающие ConfirmPurchase/RollbackPurchase/AbortBuyNow, не вытесняются
+func (c *Megacache) evictCheckouts() {
+	c.cleanupExpired()
+
+	if c.checkouts.len() <= c.maxCheckouts {
+		return
+	}
+	for i := range c.checkouts.shards {
+		sh := &c.checkouts.shards[i]
+		sh.mu.Lock()
+		for code, checkout := range sh.checkouts {
+			if checkout.Status == CheckoutStatusCancelled || checkout.Status == CheckoutStatusConfirmed {
+				c.checkouts.deleteLocked(sh, code)
+			}
 		}
+		sh.mu.Unlock()
+	}
+}
+
+// cleanupInterval is the configured period, or a third of the TTL capped at maxCleanupInterval, so an abandoned lot stays locked at most about TTL*4/3 /
+// настроенный период или треть TTL, но не больше maxCleanupInterval, чтобы брошенный лот оставался заблокированным не дольше примерно TTL*4/3
+func (c *Megacache) cleanupInterval() time.Duration {
+	if c.cleanupEvery > 0 {
+		return c.cleanupEvery
 	}
+	interval := c.checkoutTTL / cleanupsPerTTL
+	if interval <= 0 {
+		// Nanosecond TTLs, the ticker needs a positive period / TTL в наносекунды, тикеру нужен положительный период
+		return c.checkoutTTL
+	}
+	return min(interval, maxCleanupInterval)
+}
+
+// CleanupInterval returns how often expired reservations are released / возвращает, как часто освобождаются истекшие резервы
+func (c *Megacache) CleanupInterval() time.Duration {
+	return c.cleanupInterval()
+}
+
+// CheckoutTTL returns reservation lifetime of this cache / возвращает время жизни резерва этого кеша
+func (c *Megacache) CheckoutTTL() time.Duration {
+	return c.checkoutTTL
+}
+
+// CleanupExpired runs a cleanup pass now instead of waiting for the next tick / выполняет очистку сразу, не дожидаясь следующего тика
+func (c *Megacache) CleanupExpired() {
+	c.cleanupExpired()
 }
 
 // cleanupExpired cleans expired reservations WITHOUT DEADLOCK / очищает истекшие резервы БЕЗ ДЕДЛОКА
 func (c *Megacache) cleanupExpired() {
-	now := time.Now()
+	now := c.now()
 	var expiredCodes []uuid.UUID
 	var oldCodes []uuid.UUID
 
-	// Collect codes of expired active reservations / Собираем коды истекших активных резервов
-	c.checkoutMu.RLock()
-	for code, checkout := range c.checkouts {
+	// Collect codes of expired active reservations; each releases the shard lock BEFORE other methods are called /
+	// Собираем коды истекших активных резервов; each освобождает блокировку шарда ДО вызова других методов
+	oldThreshold := now.Add(-1 * time.Hour)
+	c.checkouts.each(func(checkout Checkout) {
 		if checkout.Status == CheckoutStatusActive && checkout.ExpiresAt.Before(now) {
-			expiredCodes = append(expiredCodes, code)
+			expiredCodes = append(expiredCodes, checkout.Code)
 		}
 
 		// Collect old completed reservations (older than 1 hour) in the same loop / Собираем старые завершенные резервы (старше 1 часа) в том же цикле
-		oldThreshold := now.Add(-1 * time.Hour)
-		if (checkout.Status == CheckoutStatusCancelled || checkout.Status == CheckoutStatusPurchased) &&
-			checkout.CreatedAt.Before(oldThreshold) {
-			oldCodes = append(oldCodes, code)
+		if checkout.Status != CheckoutStatusActive && checkout.CreatedAt.Before(oldThreshold) {
+			oldCodes = append(oldCodes, checkout.Code)
 		}
-	}
-	c.checkoutMu.RUnlock() // IMPORTANT! Release lock BEFORE calling other methods / ВАЖНО! Освобождаем блокировку ДО вызова других методов
+	})
 
 	// Now cancel all expired reservations (WITHOUT holding RLock) / Теперь отменяем все истекшие резервы (БЕЗ удержания RLock)
 	for _, code := range expiredCodes {
@@ -498,12 +1489,10 @@ func (c *Megacache) LoadUserDataFromDB(saleItems []SaleItems) error {
 	c.userMu.Lock()
 	defer c.userMu.Unlock()
 
-	// Clear current data / Очищаем текущие данные
-	c.users = make(map[int64]*int64, len(saleItems))
+	// Clear current data, keeping the map allocation for Reset / Очищаем текущие данные, сохраняя память map для Reset
+	clear(c.users)
 	atomic.StoreInt64(&c.countLots, 0)
 
-	c.countLots = 0
-
 	// Counters for statistics / Счетчики для статистики
 	var totalPurchasedItems int64
 	var uniqueUsers int64
@@ -519,10 +1508,10 @@ func (c *Megacache) LoadUserDataFromDB(saleItems []SaleItems) error {
 			// Increase purchase counter for user / Увеличиваем счетчик покупок для пользователя
 			userPurchaseCounts[val.UserID]++
 			totalPurchasedItems++
-			c.countLots++
+			atomic.AddInt64(&c.countLots, 1)
 
 			// Mark lot as sold / Устанавливаем статус лота как проданный
-			atomic.StoreUint32(&c.lots[val.ItemID].status, StatusSold)
+			c.lots[val.ItemID].storeStatus(StatusSold)
 		}
 	}
 
@@ -539,7 +1528,7 @@ func (c *Megacache) LoadUserDataFromDB(saleItems []SaleItems) error {
 	var soldItems int64
 
 	for i := range c.lots {
-		status := atomic.LoadUint32(&c.lots[i].status)
+		status := c.lots[i].loadStatus()
 		switch status {
 		case StatusAvailable:
 			availableItems++
@@ -558,7 +1547,9 @@ func (c *Megacache) LoadUserDataFromDB(saleItems []SaleItems) error {
 	log.Printf("   💰 Sold items: %d", soldItems)
 	log.Printf("   👥 Unique users: %d", uniqueUsers)
 	log.Printf("   🛒 Total purchases: %d", totalPurchasedItems)
-	log.Printf("   📈 Sales rate: %.2f%%", float64(soldItems)/float64(len(c.lots))*100)
+	if len(c.lots) > 0 {
+		log.Printf("   📈 Sales rate: %.2f%%", float64(soldItems)/float64(len(c.lots))*100)
+	}
 
 	// User statistics (top buyers) / Статистика по пользователям (топ покупателей)
 	if len(userPurchaseCounts) > 0 {
@@ -590,21 +1581,21 @@ func (c *Megacache) LoadUserDataFromDB(saleItems []SaleItems) error {
 
 // LoadReservationsFromDB loads reservations from database on startup / загружает резервы из БД при старте
 func (c *Megacache) LoadReservationsFromDB(reservations []Checkout) {
-	c.checkoutMu.Lock()
-	defer c.checkoutMu.Unlock()
-
 	var activeReservations int64
 	var expiredReservations int64
 	var completedReservations int64
-	now := time.Now()
+	now := c.now()
 
 	for _, reservation := range reservations {
 		// Check lot index validity / Проверяем валидность индекса лота
 		if reservation.LotIndex >= 0 && reservation.LotIndex < int64(len(c.lots)) {
-			atomic.StoreUint32(&c.lots[reservation.LotIndex].status, StatusReserved)
+			c.lots[reservation.LotIndex].storeStatus(StatusReserved)
 		}
 
-		c.checkouts[reservation.Code] = reservation
+		sh := c.checkouts.shard(reservation.Code)
+		sh.mu.Lock()
+		c.checkouts.insertLocked(sh, reservation)
+		sh.mu.Unlock()
 
 		// Analyze reservation status / Анализируем статус резервации
 		switch reservation.Status {
@@ -614,7 +1605,7 @@ func (c *Megacache) LoadReservationsFromDB(reservations []Checkout) {
 			} else {
 				activeReservations++
 			}
-		case CheckoutStatusPurchased:
+		case CheckoutStatusPurchased, CheckoutStatusConfirmed:
 			completedReservations++
 		case CheckoutStatusCancelled:
 			completedReservations++
@@ -633,6 +1624,40 @@ func (c *Megacache) LoadReservationsFromDB(reservations []Checkout) {
 	}
 }
 
+// Reset rotates the cache to a new sale in place, reusing its allocations: reservations, lot statuses, lot metadata and the purchase cutoff are cleared,
+// parked CheckoutOrWait callers get ErrCacheClosed and user data is reloaded from newSaleSoldItems. saleEnd replaces the WithSaleEnd cap, zero disables it;
+// other options are kept. Traffic must be stopped first, a request racing Reset may act on the old sale /
+// переводит кеш на новую распродажу на месте, переиспользуя память: резервы, статусы и метаданные лотов и отсечка покупок очищаются,
+// ожидающие CheckoutOrWait получают ErrCacheClosed, а данные пользователей загружаются заново из newSaleSoldItems. saleEnd заменяет ограничение WithSaleEnd,
+// ноль выключает его; остальные опции сохраняются. Сначала нужно остановить трафик, запрос, идущий параллельно с Reset, может работать со старой распродажей
+func (c *Megacache) Reset(newSaleSoldItems []SaleItems, saleEnd time.Time) error {
+	// Waiters of the old sale would never be handed a lot / Ожидающим старой распродажи лот уже не передадут
+	c.waiters.mu.Lock()
+	for itemID, queue := range c.waiters.queues {
+		for _, w := range queue {
+			w.result <- waitResult{err: ErrCacheClosed}
+		}
+		delete(c.waiters.queues, itemID)
+	}
+	c.waiters.mu.Unlock()
+
+	c.checkouts.reset()
+	for i := range c.lots {
+		c.lots[i].storeStatus(StatusAvailable)
+		// A stale confirmation time would keep reconciliation from reverting the lot in the new sale /
+		// Устаревшее время подтверждения помешало бы сверке откатить лот в новой распродаже
+		atomic.StoreInt64(&c.lots[i].confirmedAt, 0)
+	}
+	clear(c.info)
+	atomic.StoreInt64(&c.anyOffset, 0)
+	atomic.StoreInt64(&c.purchaseCutoff, 0)
+	// The old sale's end is already past and would expire every new reservation at once / Конец старой распродажи уже прошел, и каждый новый резерв истекал бы сразу
+	c.saleEnd = saleEnd
+
+	// Also resets countLots / Заодно сбрасывает countLots
+	return c.LoadUserDataFromDB(newSaleSoldItems)
+}
+
 // Close stops background tasks and releases resources / останавливает фоновые задачи и освобождает ресурсы
 func (c *Megacache) Close() {
 	c.cancel()
@@ -1,10 +1,17 @@
 package megacache
 
 import (
+	"contest_notcoin/leaktest"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+	"unsafe"
 
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
@@ -45,6 +52,50 @@ func TestNewMegacache(t *testing.T) {
 	}
 }
 
+// TestNewMegacacheEdgeSizes tests zero and negative constructor arguments
+func TestNewMegacacheEdgeSizes(t *testing.T) {
+	t.Run("zero items", func(t *testing.T) {
+		cache := NewMegacache(0, 10)
+		defer cache.Close()
+
+		_, err := cache.Checkout(1, 0)
+		assert.Equal(t, ErrAllItemsPurchased, err)
+		assert.Equal(t, 0, cache.GetActiveReservationsCount())
+		assert.NoError(t, cache.LoadUserDataFromDB(nil))
+	})
+
+	t.Run("negative items", func(t *testing.T) {
+		cache := NewMegacache(-5, 10)
+		defer cache.Close()
+
+		assert.Empty(t, cache.lots)
+		assert.Equal(t, int64(0), cache.nLots)
+		_, err := cache.Checkout(1, 0)
+		assert.Equal(t, ErrAllItemsPurchased, err)
+	})
+
+	t.Run("zero limit", func(t *testing.T) {
+		cache := NewMegacache(10, 0)
+		defer cache.Close()
+
+		_, err := cache.Checkout(1, 0)
+		assert.Equal(t, ErrUserLimitExceeded, err)
+
+		status, err := cache.GetLotStatus(0)
+		require.NoError(t, err)
+		assert.Equal(t, StatusAvailable, status)
+	})
+
+	t.Run("negative limit", func(t *testing.T) {
+		cache := NewMegacache(10, -1)
+		defer cache.Close()
+
+		assert.Equal(t, int64(0), cache.limitPerUser)
+		_, err := cache.Checkout(1, 0)
+		assert.Equal(t, ErrUserLimitExceeded, err)
+	})
+}
+
 // TestCheckoutBasic tests basic checkout functionality
 func TestCheckoutBasic(t *testing.T) {
 	cache := NewMegacache(10, 3)
@@ -180,14 +231,79 @@ func TestConfirmPurchase(t *testing.T) {
 	initialCount := cache.countLots
 
 	// Confirm purchase
-	cache.ConfirmPurchase(checkout.Code)
+	require.NoError(t, cache.ConfirmPurchase(checkout.Code))
 
 	// Check countLots increased
 	assert.Equal(t, initialCount+1, cache.countLots)
 
-	// Check reservation is removed
-	_, exists := cache.GetCheckoutInfo(checkout.Code)
+	// Check reservation is kept as confirmed and no longer active
+	info, exists := cache.GetCheckoutInfo(checkout.Code)
+	require.True(t, exists)
+	assert.Equal(t, CheckoutStatusConfirmed, info.Status)
+	assert.Equal(t, 0, cache.GetActiveReservationsCount())
+
+	// A retried confirm is recognized and counts nothing
+	assert.ErrorIs(t, cache.ConfirmPurchase(checkout.Code), ErrReservationCompleted)
+	assert.Equal(t, initialCount+1, cache.countLots)
+
+	// A confirmed purchase can't be cancelled or rolled back
+	assert.ErrorIs(t, cache.CancelCheckout(checkout.Code), ErrReservationCompleted)
+	cache.RollbackPurchase(checkout.Code)
+	status, _ := cache.GetLotStatus(0)
+	assert.Equal(t, StatusSold, status)
+	count, _ := cache.GetPurchaseCount(1)
+	assert.Equal(t, int64(1), count)
+
+	// Unknown, cancelled and not yet purchased reservations
+	assert.ErrorIs(t, cache.ConfirmPurchase(uuid.New()), ErrReservationNotFound)
+	active, err := cache.Checkout(1, 1)
+	require.NoError(t, err)
+	assert.ErrorIs(t, cache.ConfirmPurchase(active.Code), ErrPurchaseNotAllowed)
+	require.NoError(t, cache.CancelCheckout(active.Code))
+	assert.ErrorIs(t, cache.ConfirmPurchase(active.Code), ErrReservationNotFound)
+
+	// Cleanup drops the tombstone like any finished reservation
+	cache.DeleteCheckout(checkout.Code)
+	_, exists = cache.GetCheckoutInfo(checkout.Code)
 	assert.False(t, exists)
+	assert.ErrorIs(t, cache.ConfirmPurchase(checkout.Code), ErrReservationNotFound)
+}
+
+// TestConfirmPurchaseConcurrent races confirms of the same code; countLots must move exactly once
+func TestConfirmPurchaseConcurrent(t *testing.T) {
+	cache := NewMegacache(10, 3)
+	defer cache.Close()
+
+	checkout, err := cache.Checkout(1, 0)
+	require.NoError(t, err)
+	_, ok := cache.TryPurchase(checkout.Code)
+	require.True(t, ok)
+
+	const confirms = 50
+	var wg sync.WaitGroup
+	var succeeded, completed atomic.Int64
+	start := make(chan struct{})
+	for i := 0; i < confirms; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			switch err := cache.ConfirmPurchase(checkout.Code); {
+			case err == nil:
+				succeeded.Add(1)
+			case errors.Is(err, ErrReservationCompleted):
+				completed.Add(1)
+			default:
+				t.Errorf("unexpected error: %v", err)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	assert.Equal(t, int64(1), succeeded.Load())
+	assert.Equal(t, int64(confirms-1), completed.Load())
+	assert.Equal(t, int64(1), cache.SoldCount())
 }
 
 // TestRollbackPurchase tests purchase rollback
@@ -249,6 +365,276 @@ func TestCancelCheckout(t *testing.T) {
 		err := cache.CancelCheckout(fakeCode)
 		assert.Equal(t, ErrReservationNotFound, err)
 	})
+
+	t.Run("second cancel leaves the next reservation alone", func(t *testing.T) {
+		first, err := cache.Checkout(1, 1)
+		require.NoError(t, err)
+		require.NoError(t, cache.CancelCheckout(first.Code))
+
+		_, err = cache.Checkout(2, 1)
+		require.NoError(t, err)
+
+		assert.Equal(t, ErrReservationNotFound, cache.CancelCheckout(first.Code))
+		status, err := cache.GetLotStatus(1)
+		require.NoError(t, err)
+		assert.Equal(t, StatusReserved, status, "stale cancel must not free another user's lot")
+	})
+
+	t.Run("cancel purchased reservation", func(t *testing.T) {
+		checkout, err := cache.Checkout(1, 2)
+		require.NoError(t, err)
+		_, ok := cache.TryPurchase(checkout.Code)
+		require.True(t, ok)
+
+		assert.Equal(t, ErrReservationCompleted, cache.CancelCheckout(checkout.Code))
+		status, err := cache.GetLotStatus(2)
+		require.NoError(t, err)
+		assert.Equal(t, StatusSold, status)
+	})
+}
+
+// TestCheckoutAnyN tests reserving n of whatever lots are left
+func TestCheckoutAnyN(t *testing.T) {
+	t.Run("within limit", func(t *testing.T) {
+		cache := NewMegacache(10, 5)
+		defer cache.Close()
+
+		checkouts, err := cache.CheckoutAnyN(1, 3)
+		require.NoError(t, err)
+		require.Len(t, checkouts, 3)
+
+		seen := make(map[int64]bool)
+		for _, checkout := range checkouts {
+			assert.False(t, seen[checkout.LotIndex], "lot %d reserved twice", checkout.LotIndex)
+			seen[checkout.LotIndex] = true
+			assert.Equal(t, int64(1), checkout.UserID)
+
+			status, err := cache.GetLotStatus(checkout.LotIndex)
+			require.NoError(t, err)
+			assert.Equal(t, StatusReserved, status)
+
+			_, exists := cache.GetCheckoutInfo(checkout.Code)
+			assert.True(t, exists)
+		}
+	})
+
+	t.Run("exceeding user limit", func(t *testing.T) {
+		cache := NewMegacache(10, 5)
+		defer cache.Close()
+
+		// Two purchases leave an allowance of three
+		for itemID := int64(0); itemID < 2; itemID++ {
+			checkout, err := cache.Checkout(1, itemID)
+			require.NoError(t, err)
+			_, ok := cache.TryPurchase(checkout.Code)
+			require.True(t, ok)
+			cache.ConfirmPurchase(checkout.Code)
+		}
+
+		_, err := cache.CheckoutAnyN(1, 4)
+		assert.Equal(t, ErrUserLimitExceeded, err)
+		available, reserved, _ := cache.GetLotsByStatus()
+		assert.Equal(t, int64(8), available, "a refused request must reserve nothing")
+		assert.Zero(t, reserved)
+
+		checkouts, err := cache.CheckoutAnyN(1, 3)
+		require.NoError(t, err)
+		assert.Len(t, checkouts, 3)
+	})
+
+	t.Run("exceeding remaining inventory", func(t *testing.T) {
+		cache := NewMegacache(3, 10)
+		defer cache.Close()
+
+		_, err := cache.Checkout(2, 1)
+		require.NoError(t, err)
+
+		checkouts, err := cache.CheckoutAnyN(1, 5)
+		require.NoError(t, err)
+		assert.Len(t, checkouts, 2)
+
+		_, err = cache.CheckoutAnyN(1, 1)
+		assert.Equal(t, ErrItemAlreadyReserved, err)
+	})
+
+	t.Run("non-positive n", func(t *testing.T) {
+		cache := NewMegacache(3, 10)
+		defer cache.Close()
+
+		checkouts, err := cache.CheckoutAnyN(1, 0)
+		assert.NoError(t, err)
+		assert.Empty(t, checkouts)
+	})
+
+	t.Run("concurrent callers never share a lot", func(t *testing.T) {
+		cache := NewMegacache(100, 10)
+		defer cache.Close()
+
+		var mu sync.Mutex
+		reserved := make(map[int64]int64)
+		var wg sync.WaitGroup
+		for userID := int64(1); userID <= 20; userID++ {
+			wg.Add(1)
+			go func(userID int64) {
+				defer wg.Done()
+				checkouts, _ := cache.CheckoutAnyN(userID, 7)
+				mu.Lock()
+				defer mu.Unlock()
+				for _, checkout := range checkouts {
+					reserved[checkout.LotIndex]++
+				}
+			}(userID)
+		}
+		wg.Wait()
+
+		assert.Len(t, reserved, 100, "140 requested lots must drain all 100")
+		for itemID, count := range reserved {
+			assert.Equal(t, int64(1), count, "lot %d", itemID)
+		}
+	})
+}
+
+// TestCheckoutMany tests all-or-nothing reservation of a bundle of lots
+func TestCheckoutMany(t *testing.T) {
+	t.Run("reserves every lot", func(t *testing.T) {
+		cache := NewMegacache(10, 5)
+		defer cache.Close()
+
+		checkouts, err := cache.CheckoutMany(1, []int64{2, 5, 7})
+		require.NoError(t, err)
+		require.Len(t, checkouts, 3)
+
+		for i, itemID := range []int64{2, 5, 7} {
+			assert.Equal(t, itemID, checkouts[i].LotIndex)
+			assert.Equal(t, int64(1), checkouts[i].UserID)
+			_, exists := cache.GetCheckoutInfo(checkouts[i].Code)
+			assert.True(t, exists)
+		}
+		_, reserved, _ := cache.GetLotsByStatus()
+		assert.Equal(t, int64(3), reserved)
+	})
+
+	t.Run("rolls back on a taken lot", func(t *testing.T) {
+		cache := NewMegacache(10, 5)
+		defer cache.Close()
+
+		_, err := cache.Checkout(2, 3)
+		require.NoError(t, err)
+		sold, err := cache.Checkout(2, 4)
+		require.NoError(t, err)
+		_, ok := cache.TryPurchase(sold.Code)
+		require.True(t, ok)
+
+		_, err = cache.CheckoutMany(1, []int64{0, 1, 3})
+		assert.ErrorIs(t, err, ErrItemAlreadyReserved)
+		assert.Contains(t, err.Error(), "item_id=3")
+
+		_, err = cache.CheckoutMany(1, []int64{0, 1, 4})
+		assert.ErrorIs(t, err, ErrItemAlreadySold)
+		assert.Contains(t, err.Error(), "item_id=4")
+
+		for _, itemID := range []int64{0, 1} {
+			status, err := cache.GetLotStatus(itemID)
+			require.NoError(t, err)
+			assert.Equal(t, StatusAvailable, status, "lot %d must be released", itemID)
+		}
+		assert.Empty(t, cache.GetUserReservations(1))
+	})
+
+	t.Run("validates up front", func(t *testing.T) {
+		cache := NewMegacache(10, 3)
+		defer cache.Close()
+
+		_, err := cache.CheckoutMany(1, []int64{0, 10})
+		assert.ErrorIs(t, err, ErrInvalidItemID)
+		assert.Contains(t, err.Error(), "item_id=10")
+
+		_, err = cache.CheckoutMany(1, []int64{0, 1, 0})
+		assert.ErrorIs(t, err, ErrDuplicateItemID)
+		assert.Contains(t, err.Error(), "item_id=0")
+
+		_, err = cache.CheckoutMany(1, []int64{0, 1, 2, 3})
+		assert.Equal(t, ErrUserLimitExceeded, err, "the limit covers the whole bundle")
+
+		available, _, _ := cache.GetLotsByStatus()
+		assert.Equal(t, int64(10), available, "a refused bundle must reserve nothing")
+
+		checkouts, err := cache.CheckoutMany(1, nil)
+		assert.NoError(t, err)
+		assert.Empty(t, checkouts)
+	})
+
+	t.Run("overlapping bundles under contention", func(t *testing.T) {
+		cache := NewMegacache(50, 10)
+		defer cache.Close()
+
+		var mu sync.Mutex
+		owners := make(map[int64]int64)
+		var wg sync.WaitGroup
+		for userID := int64(1); userID <= 40; userID++ {
+			wg.Add(1)
+			go func(userID int64) {
+				defer wg.Done()
+				// Every bundle overlaps its neighbours, so most of them lose a lot midway
+				start := (userID * 3) % 50
+				bundle := []int64{start, (start + 1) % 50, (start + 2) % 50, (start + 3) % 50, (start + 4) % 50}
+				checkouts, err := cache.CheckoutMany(userID, bundle)
+				if err != nil {
+					assert.NotErrorIs(t, err, ErrInvalidItemID)
+					return
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				for _, checkout := range checkouts {
+					assert.Zero(t, owners[checkout.LotIndex], "lot %d reserved twice", checkout.LotIndex)
+					owners[checkout.LotIndex] = userID
+				}
+			}(userID)
+		}
+		wg.Wait()
+
+		// Only lots of complete bundles stay reserved, everything rolled back is available again
+		_, reserved, _ := cache.GetLotsByStatus()
+		assert.Equal(t, int64(len(owners)), reserved)
+		assert.Equal(t, len(owners), cache.GetActiveReservationsCount())
+		assert.Zero(t, len(owners)%5, "bundles are reserved whole")
+	})
+}
+
+// TestCancelDuringPurchase tests that a cancel racing a purchase never leaves both succeeding
+func TestCancelDuringPurchase(t *testing.T) {
+	for i := 0; i < 1000; i++ {
+		cache := NewMegacache(1, 10)
+		checkout, err := cache.Checkout(1, 0)
+		require.NoError(t, err)
+
+		var cancelErr error
+		var purchased bool
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			cancelErr = cache.CancelCheckout(checkout.Code)
+		}()
+		go func() {
+			defer wg.Done()
+			_, purchased = cache.TryPurchase(checkout.Code)
+		}()
+		wg.Wait()
+
+		status, err := cache.GetLotStatus(0)
+		require.NoError(t, err)
+		if purchased {
+			assert.Equal(t, ErrReservationCompleted, cancelErr)
+			assert.Equal(t, StatusSold, status)
+		} else {
+			assert.NoError(t, cancelErr)
+			assert.Equal(t, StatusAvailable, status)
+			count, _ := cache.GetPurchaseCount(1)
+			assert.Zero(t, count, "lost purchase must be rolled back")
+		}
+		cache.Close()
+	}
 }
 
 // TestDeleteCheckout tests reservation deletion
@@ -322,6 +708,658 @@ func TestGetActiveReservationsCount(t *testing.T) {
 	assert.Equal(t, 0, cache.GetActiveReservationsCount())
 }
 
+// TestGetLotsByStatus tests lot counts for a mix of available, reserved and sold lots
+func TestGetLotsByStatus(t *testing.T) {
+	cache := NewMegacache(10, 5)
+	defer cache.Close()
+
+	available, reserved, sold := cache.GetLotsByStatus()
+	assert.Equal(t, int64(10), available)
+	assert.Zero(t, reserved)
+	assert.Zero(t, sold)
+
+	// Lots 0-2 reserved, lots 3-4 bought and confirmed, lot 5 bought but not yet confirmed
+	for i := int64(0); i < 6; i++ {
+		checkout, err := cache.Checkout(i+1, i)
+		require.NoError(t, err)
+		if i >= 3 {
+			_, ok := cache.TryPurchase(checkout.Code)
+			require.True(t, ok)
+		}
+		if i == 3 || i == 4 {
+			cache.ConfirmPurchase(checkout.Code)
+		}
+	}
+
+	available, reserved, sold = cache.GetLotsByStatus()
+	assert.Equal(t, int64(4), available)
+	assert.Equal(t, int64(3), reserved)
+	assert.Equal(t, int64(3), sold)
+}
+
+// TestLotStatusString tests lot status names and their JSON encoding
+func TestLotStatusString(t *testing.T) {
+	tests := []struct {
+		status LotStatus
+		want   string
+	}{
+		{StatusAvailable, "available"},
+		{StatusReserved, "reserved"},
+		{StatusSold, "sold"},
+		{LotStatus(7), "LotStatus(7)"},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, tt.status.String())
+		assert.Equal(t, tt.want, LotStatusString(uint32(tt.status)))
+	}
+
+	encoded, err := json.Marshal(map[string]LotStatus{"status": StatusSold})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"status":"sold"}`, string(encoded))
+
+	var decoded struct {
+		Status LotStatus `json:"status"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(`{"status":"reserved"}`), &decoded))
+	assert.Equal(t, StatusReserved, decoded.Status)
+	assert.Error(t, json.Unmarshal([]byte(`{"status":"gone"}`), &decoded))
+}
+
+// TestGetLotInfo tests that loaded metadata is returned with the live lot status
+func TestGetLotInfo(t *testing.T) {
+	cache := NewMegacache(5, 2)
+	defer cache.Close()
+
+	cache.LoadLotInfo(map[int64]LotInfo{
+		0:  {Name: "Hoodie", ImageURL: "https://example.com/hoodie.png"},
+		99: {Name: "Out of range", ImageURL: "https://example.com/none.png"},
+	})
+
+	name, imageURL, status, err := cache.GetLotInfo(0)
+	require.NoError(t, err)
+	assert.Equal(t, "Hoodie", name)
+	assert.Equal(t, "https://example.com/hoodie.png", imageURL)
+	assert.Equal(t, StatusAvailable, status)
+
+	_, err = cache.Checkout(1, 0)
+	require.NoError(t, err)
+	_, _, status, err = cache.GetLotInfo(0)
+	require.NoError(t, err)
+	assert.Equal(t, StatusReserved, status)
+
+	// Lots without metadata still report their status
+	name, imageURL, status, err = cache.GetLotInfo(1)
+	require.NoError(t, err)
+	assert.Empty(t, name)
+	assert.Empty(t, imageURL)
+	assert.Equal(t, StatusAvailable, status)
+
+	_, _, _, err = cache.GetLotInfo(5)
+	assert.ErrorIs(t, err, ErrInvalidItemID)
+	_, _, _, err = cache.GetLotInfo(-1)
+	assert.ErrorIs(t, err, ErrInvalidItemID)
+}
+
+// TestListAvailable tests paging through available lots around reserved and sold ones
+func TestListAvailable(t *testing.T) {
+	cache := NewMegacache(10, 10)
+	defer cache.Close()
+
+	_, err := cache.Checkout(1, 1)
+	require.NoError(t, err)
+	sold, err := cache.Checkout(1, 2)
+	require.NoError(t, err)
+	_, ok := cache.TryPurchase(sold.Code)
+	require.True(t, ok)
+
+	assert.Equal(t, []int64{0, 3, 4}, cache.ListAvailable(0, 3))
+	assert.Equal(t, []int64{5, 6, 7, 8, 9}, cache.ListAvailable(5, 100))
+	assert.Empty(t, cache.ListAvailable(10, 5))
+	assert.Empty(t, cache.ListAvailable(0, 0))
+	assert.Empty(t, cache.ListAvailable(-1, 5))
+}
+
+// TestWithCheckoutTTL tests per-instance reservation lifetime
+func TestWithCheckoutTTL(t *testing.T) {
+	t.Run("default", func(t *testing.T) {
+		cache := NewMegacache(10, 5)
+		defer cache.Close()
+		assert.Equal(t, checkoutTime, cache.CheckoutTTL())
+	})
+
+	t.Run("long TTL", func(t *testing.T) {
+		cache := NewMegacache(10, 5, WithCheckoutTTL(15*time.Second))
+		defer cache.Close()
+
+		checkout, err := cache.Checkout(1, 0)
+		require.NoError(t, err)
+		assert.Equal(t, 15*time.Second, checkout.ExpiresAt.Sub(checkout.CreatedAt))
+		assert.Equal(t, maxCleanupInterval, cache.cleanupInterval())
+	})
+
+	t.Run("non-positive keeps default", func(t *testing.T) {
+		cache := NewMegacache(10, 5, WithCheckoutTTL(0))
+		defer cache.Close()
+		assert.Equal(t, checkoutTime, cache.CheckoutTTL())
+	})
+
+	t.Run("cleanup interval", func(t *testing.T) {
+		for _, tt := range []struct {
+			ttl, interval, want time.Duration
+		}{
+			{checkoutTime, 0, time.Second},
+			{time.Minute, 0, maxCleanupInterval},
+			{2 * time.Nanosecond, 0, 2 * time.Nanosecond},
+			{time.Minute, 250 * time.Millisecond, 250 * time.Millisecond},
+			{checkoutTime, -time.Second, time.Second},
+		} {
+			cache := NewMegacache(10, 5, WithCheckoutTTL(tt.ttl), WithCleanupInterval(tt.interval))
+			assert.Equal(t, tt.want, cache.CleanupInterval(), "TTL %v, interval %v", tt.ttl, tt.interval)
+			cache.Close()
+		}
+	})
+
+	t.Run("short TTL is cleaned up promptly", func(t *testing.T) {
+		cache := NewMegacache(10, 5, WithCheckoutTTL(50*time.Millisecond))
+		defer cache.Close()
+
+		_, err := cache.Checkout(1, 0)
+		require.NoError(t, err)
+
+		// Well under the 5s cleanup interval used for the default TTL
+		assert.Eventually(t, func() bool {
+			status, err := cache.GetLotStatus(0)
+			return err == nil && status == StatusAvailable
+		}, time.Second, 10*time.Millisecond)
+	})
+}
+
+// TestWithClock tests that an injected clock drives expiry and cleanup without sleeps
+func TestWithClock(t *testing.T) {
+	now := time.Now()
+	cache := NewMegacache(10, 5, WithClock(func() time.Time { return now }))
+	defer cache.Close()
+
+	checkout, err := cache.Checkout(1, 0)
+	require.NoError(t, err)
+	assert.Equal(t, now, checkout.CreatedAt)
+
+	remaining, ok := cache.TimeRemaining(checkout.Code)
+	require.True(t, ok)
+	assert.Equal(t, checkoutTime, remaining)
+
+	now = now.Add(checkoutTime + time.Millisecond)
+	_, ok = cache.TimeRemaining(checkout.Code)
+	assert.False(t, ok)
+
+	cache.CleanupExpired()
+	status, err := cache.GetLotStatus(0)
+	require.NoError(t, err)
+	assert.Equal(t, StatusAvailable, status)
+}
+
+// TestCheckoutShards tests that reservations spread across shards and every operation finds them in the right one
+func TestCheckoutShards(t *testing.T) {
+	cache := NewMegacache(1000, 1000, WithCheckoutShards(10))
+	defer cache.Close()
+	require.Len(t, cache.checkouts.shards, 16, "shard count is rounded up to a power of two")
+
+	codes := make([]uuid.UUID, 0, 1000)
+	for itemID := int64(0); itemID < 1000; itemID++ {
+		checkout, err := cache.Checkout(1, itemID)
+		require.NoError(t, err)
+		codes = append(codes, checkout.Code)
+	}
+	assert.Equal(t, 1000, cache.checkouts.len())
+	assert.Equal(t, 1000, cache.GetActiveReservationsCount())
+
+	for i := range cache.checkouts.shards {
+		assert.NotEmpty(t, cache.checkouts.shards[i].checkouts, "shard %d", i)
+	}
+
+	for i, code := range codes {
+		switch i % 3 {
+		case 0:
+			_, ok := cache.TryPurchase(code)
+			require.True(t, ok)
+			require.NoError(t, cache.ConfirmPurchase(code))
+			cache.DeleteCheckout(code)
+		case 1:
+			require.NoError(t, cache.CancelCheckout(code))
+			cache.DeleteCheckout(code)
+		}
+	}
+	assert.Equal(t, 333, cache.checkouts.len())
+	assert.Equal(t, 333, cache.GetActiveReservationsCount())
+	assert.Len(t, cache.GetActiveCheckouts(), 333)
+	assert.Equal(t, int64(334), cache.SoldCount())
+
+	// A single shard behaves like the old single mutex
+	single := NewMegacache(10, 10, WithCheckoutShards(1))
+	defer single.Close()
+	require.Len(t, single.checkouts.shards, 1)
+	checkout, err := single.Checkout(1, 0)
+	require.NoError(t, err)
+	info, exists := single.GetCheckoutInfo(checkout.Code)
+	require.True(t, exists)
+	assert.Equal(t, checkout, info)
+}
+
+// TestWithMaxCheckouts tests that a flood of abandoned checkouts is evicted out of band while active reservations survive
+func TestWithMaxCheckouts(t *testing.T) {
+	const maxCheckouts = 100
+	cache := NewMegacache(10, 1_000_000, WithMaxCheckouts(maxCheckouts))
+	defer cache.Close()
+
+	held, err := cache.Checkout(1, 0)
+	require.NoError(t, err)
+
+	// Cancelled reservations would otherwise stay in the map for an hour
+	for i := 0; i < 10_000; i++ {
+		checkout, err := cache.Checkout(2, 1+int64(i%9))
+		require.NoError(t, err)
+		require.NoError(t, cache.CancelCheckout(checkout.Code))
+	}
+
+	require.Eventually(t, func() bool { return cache.checkouts.len() <= maxCheckouts }, time.Second, 5*time.Millisecond)
+
+	info, exists := cache.GetCheckoutInfo(held.Code)
+	require.True(t, exists, "active reservations must not be evicted")
+	assert.Equal(t, CheckoutStatusActive, info.Status)
+}
+
+// TestWithMaxCheckoutsKeepsPurchasesInFlight tests that eviction leaves purchases awaiting confirmation or rollback
+func TestWithMaxCheckoutsKeepsPurchasesInFlight(t *testing.T) {
+	const maxCheckouts = 100
+	cache := NewMegacache(10, 1_000_000, WithMaxCheckouts(maxCheckouts))
+	defer cache.Close()
+
+	reserved, err := cache.Checkout(1, 0)
+	require.NoError(t, err)
+	_, ok := cache.TryPurchase(reserved.Code)
+	require.True(t, ok)
+
+	bought, err := cache.BuyNow(3, 1)
+	require.NoError(t, err)
+
+	for i := 0; i < 10_000; i++ {
+		checkout, err := cache.Checkout(2, 2+int64(i%8))
+		require.NoError(t, err)
+		require.NoError(t, cache.CancelCheckout(checkout.Code))
+	}
+
+	require.Eventually(t, func() bool { return cache.checkouts.len() <= maxCheckouts }, time.Second, 5*time.Millisecond)
+
+	// Both outcomes must still find their entries
+	require.NoError(t, cache.ConfirmPurchase(reserved.Code))
+	require.NoError(t, cache.AbortBuyNow(bought.Code))
+	status, err := cache.GetLotStatus(1)
+	require.NoError(t, err)
+	assert.Equal(t, StatusAvailable, status, "the aborted lot must be released")
+}
+
+// TestWithMaxCheckoutsDisabled tests that without a cap finished reservations wait for the hourly cleanup
+func TestWithMaxCheckoutsDisabled(t *testing.T) {
+	cache := NewMegacache(10, 1_000, WithMaxCheckouts(0))
+	defer cache.Close()
+
+	for i := 0; i < 200; i++ {
+		checkout, err := cache.Checkout(1, int64(i%10))
+		require.NoError(t, err)
+		require.NoError(t, cache.CancelCheckout(checkout.Code))
+	}
+
+	assert.Equal(t, 200, cache.checkouts.len())
+}
+
+// TestWithSaleEnd tests that reservations near the sale boundary expire with the sale
+func TestWithSaleEnd(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 59, 59, 0, time.UTC)
+	saleEnd := time.Date(2025, 1, 1, 13, 0, 0, 0, time.UTC)
+	cache := NewMegacache(10, 5, WithClock(func() time.Time { return now }), WithSaleEnd(saleEnd))
+	defer cache.Close()
+
+	// One second before the boundary the default 3s hold is clamped
+	checkout, err := cache.Checkout(1, 0)
+	require.NoError(t, err)
+	assert.Equal(t, saleEnd, checkout.ExpiresAt)
+
+	expiresAt, err := cache.RenewCheckout(checkout.Code)
+	require.NoError(t, err)
+	assert.Equal(t, saleEnd, expiresAt, "renewal must not outlive the sale either")
+
+	// Far from the boundary the usual TTL applies
+	now = saleEnd.Add(-time.Minute)
+	checkout, err = cache.Checkout(1, 1)
+	require.NoError(t, err)
+	assert.Equal(t, now.Add(checkoutTime), checkout.ExpiresAt)
+
+	// Without a sale end nothing is clamped
+	unbounded := NewMegacache(10, 5, WithClock(func() time.Time { return now }), WithSaleEnd(time.Time{}))
+	defer unbounded.Close()
+	now = saleEnd.Add(-time.Second)
+	checkout, err = unbounded.Checkout(1, 0)
+	require.NoError(t, err)
+	assert.Equal(t, now.Add(checkoutTime), checkout.ExpiresAt)
+}
+
+// queuedWaiters returns how many callers are parked on a lot
+func queuedWaiters(cache *Megacache, itemID int64) int {
+	cache.waiters.mu.Lock()
+	defer cache.waiters.mu.Unlock()
+	return len(cache.waiters.queues[itemID])
+}
+
+// TestCheckoutOrWait tests FIFO handoff of a freed lot to parked callers
+func TestCheckoutOrWait(t *testing.T) {
+	cache := NewMegacache(10, 5)
+	defer cache.Close()
+
+	// A free lot is reserved right away
+	checkout, err := cache.CheckoutOrWait(1, 1, time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), checkout.UserID)
+
+	holder, err := cache.Checkout(1, 0)
+	require.NoError(t, err)
+
+	results := make(chan Checkout, 2)
+	for _, userID := range []int64{2, 3} {
+		go func() {
+			checkout, err := cache.CheckoutOrWait(userID, 0, 5*time.Second)
+			assert.NoError(t, err)
+			results <- checkout
+		}()
+		// Park one at a time so the queue order is known
+		require.Eventually(t, func() bool { return queuedWaiters(cache, 0) == int(userID-1) }, time.Second, time.Millisecond)
+	}
+
+	// Each release goes to the next waiter in line, the lot never becomes available in between
+	require.NoError(t, cache.CancelCheckout(holder.Code))
+	first := <-results
+	assert.Equal(t, int64(2), first.UserID)
+	assert.Equal(t, int64(0), first.LotIndex)
+	status, _ := cache.GetLotStatus(0)
+	assert.Equal(t, StatusReserved, status)
+
+	require.NoError(t, cache.CancelCheckout(first.Code))
+	second := <-results
+	assert.Equal(t, int64(3), second.UserID)
+	assert.Equal(t, 0, queuedWaiters(cache, 0))
+
+	// The handed over reservation can be bought
+	_, ok := cache.TryPurchase(second.Code)
+	require.True(t, ok)
+	cache.ConfirmPurchase(second.Code)
+
+	// Sold lots fail at once instead of waiting
+	_, err = cache.CheckoutOrWait(4, 0, time.Second)
+	assert.ErrorIs(t, err, ErrItemAlreadySold)
+}
+
+// TestCheckoutOrWaitExpiry tests that cleanup of an expired hold hands the lot over
+func TestCheckoutOrWaitExpiry(t *testing.T) {
+	cache := NewMegacache(10, 5, WithCheckoutTTL(50*time.Millisecond))
+	defer cache.Close()
+
+	holder, err := cache.Checkout(1, 0)
+	require.NoError(t, err)
+
+	checkout, err := cache.CheckoutOrWait(2, 0, 2*time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), checkout.UserID)
+
+	info, _ := cache.GetCheckoutInfo(holder.Code)
+	assert.Equal(t, CheckoutStatusCancelled, info.Status)
+}
+
+// TestCheckoutOrWaitGivesUp tests timeout, Close and the user limit at handoff
+func TestCheckoutOrWaitGivesUp(t *testing.T) {
+	cache := NewMegacache(10, 1, WithCheckoutTTL(time.Minute))
+
+	holder, err := cache.Checkout(1, 0)
+	require.NoError(t, err)
+
+	// Timeout leaves the queue empty
+	_, err = cache.CheckoutOrWait(2, 0, 20*time.Millisecond)
+	assert.ErrorIs(t, err, ErrItemAlreadyReserved)
+	assert.Equal(t, 0, queuedWaiters(cache, 0))
+
+	// Zero timeout behaves like Checkout
+	_, err = cache.CheckoutOrWait(2, 0, 0)
+	assert.ErrorIs(t, err, ErrItemAlreadyReserved)
+
+	// A waiter that used up its limit meanwhile is skipped for the next one
+	limited := make(chan error, 1)
+	go func() {
+		_, err := cache.CheckoutOrWait(2, 0, 5*time.Second)
+		limited <- err
+	}()
+	require.Eventually(t, func() bool { return queuedWaiters(cache, 0) == 1 }, time.Second, time.Millisecond)
+	next := make(chan Checkout, 1)
+	go func() {
+		checkout, err := cache.CheckoutOrWait(3, 0, 5*time.Second)
+		assert.NoError(t, err)
+		next <- checkout
+	}()
+	require.Eventually(t, func() bool { return queuedWaiters(cache, 0) == 2 }, time.Second, time.Millisecond)
+
+	bought, err := cache.Checkout(2, 1)
+	require.NoError(t, err)
+	_, ok := cache.TryPurchase(bought.Code)
+	require.True(t, ok)
+	cache.ConfirmPurchase(bought.Code)
+
+	require.NoError(t, cache.CancelCheckout(holder.Code))
+	assert.ErrorIs(t, <-limited, ErrUserLimitExceeded)
+	assert.Equal(t, int64(3), (<-next).UserID)
+
+	// Close releases parked callers
+	closed := make(chan error, 1)
+	go func() {
+		_, err := cache.CheckoutOrWait(4, 0, time.Minute)
+		closed <- err
+	}()
+	require.Eventually(t, func() bool { return queuedWaiters(cache, 0) == 1 }, time.Second, time.Millisecond)
+	cache.Close()
+	select {
+	case err := <-closed:
+		assert.ErrorIs(t, err, ErrCacheClosed)
+	case <-time.After(time.Second):
+		t.Fatal("parked caller not released by Close")
+	}
+	assert.Equal(t, 0, queuedWaiters(cache, 0))
+}
+
+// TestCheckoutOrWaitConcurrent races waiters against cancels; every lot ends up with at most one active holder
+func TestCheckoutOrWaitConcurrent(t *testing.T) {
+	const items, waiters = 4, 40
+	cache := NewMegacache(items, waiters, WithCheckoutTTL(time.Minute))
+	defer cache.Close()
+
+	var wg sync.WaitGroup
+	var got atomic.Int64
+	for i := 0; i < waiters; i++ {
+		wg.Add(1)
+		go func(userID int64) {
+			defer wg.Done()
+			checkout, err := cache.CheckoutOrWait(userID, userID%items, 2*time.Second)
+			if err != nil {
+				return
+			}
+			got.Add(1)
+			// Pass the lot on to the next waiter
+			cache.CancelCheckout(checkout.Code)
+		}(int64(i + 1))
+	}
+	wg.Wait()
+
+	assert.Equal(t, int64(waiters), got.Load(), "every waiter must eventually get the lot")
+	assert.Equal(t, 0, cache.GetActiveReservationsCount())
+	available, _, _ := cache.GetLotsByStatus()
+	assert.Equal(t, int64(items), available)
+}
+
+// TestRenewCheckout tests reservation renewal, its cap and the reservations that can't be renewed
+func TestRenewCheckout(t *testing.T) {
+	now := time.Now()
+	cache := NewMegacache(10, 5, WithClock(func() time.Time { return now }))
+	defer cache.Close()
+
+	t.Run("extends up to the cap", func(t *testing.T) {
+		checkout, err := cache.Checkout(1, 0)
+		require.NoError(t, err)
+
+		want := checkout.ExpiresAt
+		for i := 0; i < maxRenewals; i++ {
+			want = want.Add(checkoutTime)
+			expiresAt, err := cache.RenewCheckout(checkout.Code)
+			require.NoError(t, err)
+			assert.Equal(t, want, expiresAt)
+		}
+
+		_, err = cache.RenewCheckout(checkout.Code)
+		assert.Equal(t, ErrRenewalLimitReached, err)
+
+		info, exists := cache.GetCheckoutInfo(checkout.Code)
+		require.True(t, exists)
+		assert.Equal(t, want, info.ExpiresAt, "a refused renewal must not move the expiry")
+		assert.Equal(t, maxRenewals, info.Renewals)
+	})
+
+	t.Run("renewed hold outlives the original TTL", func(t *testing.T) {
+		checkout, err := cache.Checkout(1, 1)
+		require.NoError(t, err)
+		_, err = cache.RenewCheckout(checkout.Code)
+		require.NoError(t, err)
+
+		now = now.Add(checkoutTime + time.Millisecond)
+		defer func() { now = now.Add(-checkoutTime - time.Millisecond) }()
+		cache.CleanupExpired()
+
+		_, ok := cache.TryPurchase(checkout.Code)
+		assert.True(t, ok)
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		checkout, err := cache.Checkout(2, 2)
+		require.NoError(t, err)
+
+		now = now.Add(checkoutTime)
+		defer func() { now = now.Add(-checkoutTime) }()
+		_, err = cache.RenewCheckout(checkout.Code)
+		assert.Equal(t, ErrReservationExpired, err)
+	})
+
+	t.Run("purchased", func(t *testing.T) {
+		checkout, err := cache.Checkout(2, 3)
+		require.NoError(t, err)
+		_, ok := cache.TryPurchase(checkout.Code)
+		require.True(t, ok)
+
+		_, err = cache.RenewCheckout(checkout.Code)
+		assert.Equal(t, ErrReservationCompleted, err)
+	})
+
+	t.Run("cancelled and unknown", func(t *testing.T) {
+		checkout, err := cache.Checkout(2, 4)
+		require.NoError(t, err)
+		require.NoError(t, cache.CancelCheckout(checkout.Code))
+
+		_, err = cache.RenewCheckout(checkout.Code)
+		assert.Equal(t, ErrReservationNotFound, err)
+
+		_, err = cache.RenewCheckout(uuid.New())
+		assert.Equal(t, ErrReservationNotFound, err)
+	})
+}
+
+// TestTimeRemaining tests remaining reservation time for active, expired and unknown codes
+func TestTimeRemaining(t *testing.T) {
+	cache := NewMegacache(10, 5)
+	defer cache.Close()
+
+	t.Run("active", func(t *testing.T) {
+		checkout, err := cache.Checkout(1, 0)
+		require.NoError(t, err)
+
+		remaining, ok := cache.TimeRemaining(checkout.Code)
+		require.True(t, ok)
+		assert.Greater(t, remaining, time.Duration(0))
+		assert.LessOrEqual(t, remaining, checkoutTime)
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		// Expired but not yet removed by the cleanup
+		code := uuid.New()
+		cache.LoadReservationsFromDB([]Checkout{{
+			Code:      code,
+			UserID:    2,
+			LotIndex:  1,
+			CreatedAt: time.Now().Add(-2 * checkoutTime),
+			ExpiresAt: time.Now().Add(-checkoutTime),
+			Status:    CheckoutStatusActive,
+		}})
+
+		remaining, ok := cache.TimeRemaining(code)
+		assert.False(t, ok)
+		assert.Zero(t, remaining)
+	})
+
+	t.Run("purchased", func(t *testing.T) {
+		checkout, err := cache.Checkout(3, 2)
+		require.NoError(t, err)
+		_, ok := cache.TryPurchase(checkout.Code)
+		require.True(t, ok)
+
+		_, ok = cache.TimeRemaining(checkout.Code)
+		assert.False(t, ok)
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		remaining, ok := cache.TimeRemaining(uuid.New())
+		assert.False(t, ok)
+		assert.Zero(t, remaining)
+	})
+}
+
+// TestGetUserReservations tests that only the user's live reservations are returned
+func TestGetUserReservations(t *testing.T) {
+	cache := NewMegacache(10, 3)
+	defer cache.Close()
+
+	assert.Empty(t, cache.GetUserReservations(1))
+
+	active, err := cache.Checkout(1, 0)
+	require.NoError(t, err)
+	purchased, err := cache.Checkout(1, 1)
+	require.NoError(t, err)
+	cancelled, err := cache.Checkout(1, 2)
+	require.NoError(t, err)
+	_, err = cache.Checkout(2, 3)
+	require.NoError(t, err)
+
+	// Purchase in flight stays visible until confirmed
+	_, ok := cache.TryPurchase(purchased.Code)
+	require.True(t, ok)
+	require.NoError(t, cache.CancelCheckout(cancelled.Code))
+
+	reservations := cache.GetUserReservations(1)
+	require.Len(t, reservations, 2)
+
+	byCode := make(map[uuid.UUID]Checkout)
+	for _, r := range reservations {
+		byCode[r.Code] = r
+	}
+	assert.Equal(t, CheckoutStatusActive, byCode[active.Code].Status)
+	assert.Equal(t, CheckoutStatusPurchased, byCode[purchased.Code].Status)
+
+	// Confirmed purchase leaves the cache
+	cache.ConfirmPurchase(purchased.Code)
+	assert.Len(t, cache.GetUserReservations(1), 1)
+}
+
 // TestLoadUserDataFromDB tests loading user data from database
 func TestLoadUserDataFromDB(t *testing.T) {
 	cache := NewMegacache(10, 3)
@@ -407,15 +1445,123 @@ func TestLoadReservationsFromDB(t *testing.T) {
 		assert.Equal(t, reservation.Status, info.Status)
 	}
 
-	// Check lot statuses
-	status0, _ := cache.GetLotStatus(0)
-	assert.Equal(t, StatusReserved, status0)
+	// Check lot statuses
+	status0, _ := cache.GetLotStatus(0)
+	assert.Equal(t, StatusReserved, status0)
+
+	status1, _ := cache.GetLotStatus(1)
+	assert.Equal(t, StatusReserved, status1)
+
+	status2, _ := cache.GetLotStatus(2)
+	assert.Equal(t, StatusReserved, status2)
+}
+
+// TestReset tests that rotating to a new sale leaves no trace of the old one
+func TestReset(t *testing.T) {
+	cache := NewMegacache(10, 3)
+	defer cache.Close()
+
+	// Old sale: purchases, a live reservation, a waiter, metadata and a cutoff
+	require.NoError(t, cache.LoadUserDataFromDB([]SaleItems{{ItemID: 0, Purchased: true, UserID: 1}}))
+	bought, err := cache.Checkout(2, 1)
+	require.NoError(t, err)
+	_, ok := cache.TryPurchase(bought.Code)
+	require.True(t, ok)
+	require.NoError(t, cache.ConfirmPurchase(bought.Code))
+	held, err := cache.Checkout(3, 2)
+	require.NoError(t, err)
+	cache.LoadLotInfo(map[int64]LotInfo{2: {Name: "old lot"}})
+	cache.SetPurchaseCutoff(time.Now().Add(time.Hour))
+
+	waited := make(chan error, 1)
+	go func() {
+		_, err := cache.CheckoutOrWait(4, 2, 5*time.Second)
+		waited <- err
+	}()
+	require.Eventually(t, func() bool { return queuedWaiters(cache, 2) == 1 }, time.Second, time.Millisecond)
+
+	checkouts := cache.checkouts
+	lots := &cache.lots[0]
+	require.NoError(t, cache.Reset([]SaleItems{
+		{ItemID: 5, Purchased: true, UserID: 7},
+		{ItemID: 6, Purchased: true, UserID: 7},
+		{ItemID: 7, Purchased: false},
+	}, time.Time{}))
+
+	// The waiter of the old sale gives up at once
+	select {
+	case err := <-waited:
+		assert.ErrorIs(t, err, ErrCacheClosed)
+	case <-time.After(time.Second):
+		t.Fatal("waiter still parked after Reset")
+	}
+	assert.Equal(t, 0, queuedWaiters(cache, 2))
+
+	// Same allocations, clean state
+	assert.Same(t, checkouts, cache.checkouts)
+	assert.Same(t, lots, &cache.lots[0])
+	assert.Zero(t, atomic.LoadInt64(&cache.lots[1].confirmedAt), "old confirmation times are cleared")
+	assert.Zero(t, cache.GetActiveReservationsCount())
+	_, exists := cache.GetCheckoutInfo(held.Code)
+	assert.False(t, exists)
+	assert.True(t, cache.PurchaseCutoff().IsZero())
+	name, _, _, err := cache.GetLotInfo(2)
+	require.NoError(t, err)
+	assert.Empty(t, name)
+
+	available, reserved, sold := cache.GetLotsByStatus()
+	assert.Equal(t, int64(8), available)
+	assert.Zero(t, reserved)
+	assert.Equal(t, int64(2), sold)
+	assert.Equal(t, int64(2), cache.SoldCount())
+
+	// Only the new sale's buyers count against limits
+	for _, userID := range []int64{1, 2, 3} {
+		_, exists := cache.GetPurchaseCount(userID)
+		assert.False(t, exists, "user %d", userID)
+	}
+	count, _ := cache.GetPurchaseCount(7)
+	assert.Equal(t, int64(2), count)
+	assert.Equal(t, 1, cache.UserCount())
+
+	// The new sale works as usual, old codes are gone
+	_, ok = cache.TryPurchase(bought.Code)
+	assert.False(t, ok)
+	checkout, err := cache.Checkout(3, 2)
+	require.NoError(t, err)
+	_, ok = cache.TryPurchase(checkout.Code)
+	assert.True(t, ok)
+	_, err = cache.Checkout(8, 5)
+	assert.ErrorIs(t, err, ErrItemAlreadySold)
+}
+
+// TestResetMovesSaleEnd tests that reservations of the next sale are not capped at the end of the previous one
+func TestResetMovesSaleEnd(t *testing.T) {
+	oldEnd := time.Date(2025, 1, 1, 13, 0, 0, 0, time.UTC)
+	now := oldEnd.Add(-time.Minute)
+	cache := NewMegacache(10, 5, WithClock(func() time.Time { return now }), WithSaleEnd(oldEnd))
+	defer cache.Close()
+
+	_, err := cache.Checkout(1, 0)
+	require.NoError(t, err)
+
+	// The hourly rotation runs once the old sale is over
+	now = oldEnd.Add(time.Second)
+	newEnd := oldEnd.Add(time.Hour)
+	require.NoError(t, cache.Reset(nil, newEnd))
 
-	status1, _ := cache.GetLotStatus(1)
-	assert.Equal(t, StatusReserved, status1)
+	checkout, err := cache.Checkout(1, 0)
+	require.NoError(t, err)
+	assert.True(t, checkout.ExpiresAt.After(now), "reservation must not be born expired")
+	assert.Equal(t, now.Add(checkoutTime), checkout.ExpiresAt)
+	_, ok := cache.TryPurchase(checkout.Code)
+	assert.True(t, ok)
 
-	status2, _ := cache.GetLotStatus(2)
-	assert.Equal(t, StatusReserved, status2)
+	// Near the new boundary the new end caps the hold
+	now = newEnd.Add(-time.Second)
+	checkout, err = cache.Checkout(2, 1)
+	require.NoError(t, err)
+	assert.Equal(t, newEnd, checkout.ExpiresAt)
 }
 
 // TestConcurrentCheckouts tests concurrent checkout operations
@@ -541,7 +1687,7 @@ func TestConcurrentPurchases(t *testing.T) {
 
 // TestExpiredReservationCleanup tests automatic cleanup of expired reservations
 func TestExpiredReservationCleanup(t *testing.T) {
-	cache := NewMegacache(10, 3)
+	cache := NewMegacache(10, 3, WithCheckoutTTL(300*time.Millisecond), WithCleanupInterval(100*time.Millisecond))
 	defer cache.Close()
 
 	// Create a reservation
@@ -552,7 +1698,7 @@ func TestExpiredReservationCleanup(t *testing.T) {
 	assert.Equal(t, 1, cache.GetActiveReservationsCount())
 
 	// Wait for expiration + cleanup cycle
-	time.Sleep(checkoutTime + 6*time.Second)
+	time.Sleep(cache.CheckoutTTL() + 2*cache.CleanupInterval())
 
 	// Should be cleaned up
 	assert.Equal(t, 0, cache.GetActiveReservationsCount())
@@ -585,6 +1731,507 @@ func TestGlobalLimitExceeded(t *testing.T) {
 	assert.Equal(t, ErrAllItemsPurchased, err)
 }
 
+// TestSoftCap tests that soft cap engages only below the threshold
+func TestSoftCap(t *testing.T) {
+	cache := NewMegacache(10, 10)
+	defer cache.Close()
+
+	// Always reject once engaged so the result is deterministic
+	cache.SetSoftCap(SoftCap{Threshold: 5, RejectRate: 1})
+
+	// 10..6 lots remaining: soft cap is off, checkouts succeed
+	for i := 0; i < 5; i++ {
+		checkout, err := cache.Checkout(int64(i), int64(i))
+		require.NoError(t, err, "soft cap must not engage with %d lots left", 10-i)
+
+		_, ok := cache.TryPurchase(checkout.Code)
+		require.True(t, ok)
+		cache.ConfirmPurchase(checkout.Code)
+	}
+
+	// Exactly at the threshold: still off
+	checkout, err := cache.Checkout(5, 5)
+	require.NoError(t, err)
+	_, ok := cache.TryPurchase(checkout.Code)
+	require.True(t, ok)
+	cache.ConfirmPurchase(checkout.Code)
+
+	// 4 lots remaining: below the threshold, checkouts are rejected
+	_, err = cache.Checkout(6, 6)
+	assert.Equal(t, ErrServiceOverloaded, err)
+
+	status, err := cache.GetLotStatus(6)
+	require.NoError(t, err)
+	assert.Equal(t, StatusAvailable, status, "rejected checkout must not reserve the lot")
+
+	// Disabled soft cap never interferes
+	cache.SetSoftCap(SoftCap{})
+	_, err = cache.Checkout(6, 6)
+	assert.NoError(t, err)
+}
+
+// TestApplyLotStatuses tests countLots bookkeeping and that purchases confirmed after the DB read survive
+func TestApplyLotStatuses(t *testing.T) {
+	cache := NewMegacache(5, 5)
+	defer cache.Close()
+
+	asOf := time.Now()
+
+	// Confirmed after the DB snapshot was taken: DB not having it yet is expected
+	checkout, err := cache.Checkout(1, 0)
+	require.NoError(t, err)
+	_, ok := cache.TryPurchase(checkout.Code)
+	require.True(t, ok)
+	cache.ConfirmPurchase(checkout.Code)
+	require.Equal(t, int64(1), cache.countLots)
+
+	markedSold, markedAvailable := cache.ApplyLotStatuses(map[int64]bool{0: false, 1: true, 2: true, 99: true}, asOf)
+	assert.Equal(t, 2, markedSold)
+	assert.Equal(t, 0, markedAvailable)
+	assert.Equal(t, int64(3), cache.countLots)
+
+	status, err := cache.GetLotStatus(0)
+	require.NoError(t, err)
+	assert.Equal(t, StatusSold, status)
+
+	// A later snapshot that still misses lot 0 is real drift
+	markedSold, markedAvailable = cache.ApplyLotStatuses(map[int64]bool{0: false, 1: true, 2: true}, time.Now())
+	assert.Equal(t, 0, markedSold)
+	assert.Equal(t, 1, markedAvailable)
+	assert.Equal(t, int64(2), cache.countLots)
+
+	// MarkSold is idempotent
+	assert.False(t, cache.MarkSold(1))
+	assert.False(t, cache.MarkSold(-1))
+}
+
+// TestClaimedLotReject tests a lot marked sold under an active reservation: the holder loses it with a specific error
+func TestClaimedLotReject(t *testing.T) {
+	cache := NewMegacache(5, 5)
+	defer cache.Close()
+
+	checkout, err := cache.Checkout(1, 0)
+	require.NoError(t, err)
+
+	// Reconcile learns from the DB that lot 0 was sold
+	assert.True(t, cache.MarkSold(0))
+
+	_, err = cache.TryPurchaseE(checkout.Code)
+	assert.ErrorIs(t, err, ErrItemClaimedElsewhere)
+
+	// The hold is over, the lot stays sold and the user keeps the purchase slot
+	info, exists := cache.GetCheckoutInfo(checkout.Code)
+	require.True(t, exists)
+	assert.Equal(t, CheckoutStatusCancelled, info.Status)
+	status, err := cache.GetLotStatus(0)
+	require.NoError(t, err)
+	assert.Equal(t, StatusSold, status)
+	count, _ := cache.GetPurchaseCount(1)
+	assert.Equal(t, int64(0), count)
+	assert.Equal(t, int64(1), cache.SoldCount())
+
+	// Retrying sees an ordinary finished reservation
+	_, ok := cache.TryPurchase(checkout.Code)
+	assert.False(t, ok)
+}
+
+// TestClaimedLotProtect tests that MarkSold leaves reserved lots to their holder
+func TestClaimedLotProtect(t *testing.T) {
+	cache := NewMegacache(5, 5, WithClaimedLotPolicy(ClaimedLotProtect))
+	defer cache.Close()
+
+	checkout, err := cache.Checkout(1, 0)
+	require.NoError(t, err)
+
+	assert.False(t, cache.MarkSold(0))
+	markedSold, _ := cache.ApplyLotStatuses(map[int64]bool{0: true, 1: true}, time.Now())
+	assert.Equal(t, 1, markedSold)
+
+	_, err = cache.TryPurchaseE(checkout.Code)
+	require.NoError(t, err)
+	require.NoError(t, cache.ConfirmPurchase(checkout.Code))
+	assert.Equal(t, int64(2), cache.SoldCount())
+
+	// Released lots are marked sold as usual
+	other, err := cache.Checkout(2, 2)
+	require.NoError(t, err)
+	require.NoError(t, cache.CancelCheckout(other.Code))
+	assert.True(t, cache.MarkSold(2))
+}
+
+// TestPurchaseCutoff tests that reservations created before the cutoff can't be purchased while newer ones can
+func TestPurchaseCutoff(t *testing.T) {
+	now := time.Now()
+	cache := NewMegacache(5, 5, WithClock(func() time.Time { return now }))
+	defer cache.Close()
+
+	suspicious, err := cache.Checkout(1, 0)
+	require.NoError(t, err)
+
+	now = now.Add(time.Second)
+	cache.SetPurchaseCutoff(now)
+	assert.True(t, cache.PurchaseCutoff().Equal(now))
+
+	fresh, err := cache.Checkout(2, 1)
+	require.NoError(t, err)
+
+	_, err = cache.TryPurchaseE(suspicious.Code)
+	assert.ErrorIs(t, err, ErrReservationRevoked)
+	_, err = cache.TryPurchaseE(fresh.Code)
+	assert.NoError(t, err)
+
+	// The revoked lot is back on sale
+	again, err := cache.Checkout(3, 0)
+	require.NoError(t, err)
+
+	// Lifting the cutoff lets old reservations through again
+	cache.SetPurchaseCutoff(time.Time{})
+	assert.True(t, cache.PurchaseCutoff().IsZero())
+	_, err = cache.TryPurchaseE(again.Code)
+	assert.NoError(t, err)
+}
+
+// TestBuyNow tests buying a lot without a checkout, its confirm and abort
+func TestBuyNow(t *testing.T) {
+	cache := NewMegacache(5, 2)
+	defer cache.Close()
+
+	bought, err := cache.BuyNow(1, 0)
+	require.NoError(t, err)
+	assert.Equal(t, CheckoutStatusPurchased, bought.Status)
+	status, _ := cache.GetLotStatus(0)
+	assert.Equal(t, StatusSold, status)
+	count, _ := cache.GetPurchaseCount(1)
+	assert.Equal(t, int64(1), count)
+
+	// Sold and reserved lots can't be bought
+	_, err = cache.BuyNow(2, 0)
+	assert.ErrorIs(t, err, ErrItemAlreadySold)
+	_, err = cache.Checkout(2, 1)
+	require.NoError(t, err)
+	_, err = cache.BuyNow(3, 1)
+	assert.ErrorIs(t, err, ErrItemAlreadyReserved)
+	_, err = cache.BuyNow(3, 5)
+	assert.ErrorIs(t, err, ErrInvalidItemID)
+
+	// Confirm counts the lot once, abort is refused afterwards
+	require.NoError(t, cache.ConfirmPurchase(bought.Code))
+	assert.Equal(t, int64(1), cache.SoldCount())
+	assert.ErrorIs(t, cache.AbortBuyNow(bought.Code), ErrReservationCompleted)
+
+	// Abort returns both the lot and the user's slot
+	second, err := cache.BuyNow(1, 2)
+	require.NoError(t, err)
+	_, err = cache.BuyNow(1, 3)
+	assert.ErrorIs(t, err, ErrUserLimitExceeded)
+	require.NoError(t, cache.AbortBuyNow(second.Code))
+	assert.ErrorIs(t, cache.AbortBuyNow(second.Code), ErrReservationNotFound)
+	status, _ = cache.GetLotStatus(2)
+	assert.Equal(t, StatusAvailable, status)
+	count, _ = cache.GetPurchaseCount(1)
+	assert.Equal(t, int64(1), count)
+	_, err = cache.BuyNow(1, 3)
+	assert.NoError(t, err)
+}
+
+// TestRevokePurchase tests undoing confirmed purchases the DB never recorded
+func TestRevokePurchase(t *testing.T) {
+	cache := NewMegacache(5, 2)
+	defer cache.Close()
+
+	reserved, err := cache.Checkout(1, 0)
+	require.NoError(t, err)
+	_, ok := cache.TryPurchase(reserved.Code)
+	require.True(t, ok)
+	require.NoError(t, cache.ConfirmPurchase(reserved.Code))
+	bought, err := cache.BuyNow(1, 1)
+	require.NoError(t, err)
+	require.NoError(t, cache.ConfirmPurchase(bought.Code))
+	require.Equal(t, int64(2), cache.SoldCount())
+
+	// Never written: the lot is back on sale and no longer reported completed
+	code, found := cache.RevokePurchase(1, 0, false)
+	require.True(t, found)
+	assert.Equal(t, reserved.Code, code)
+	status, _ := cache.GetLotStatus(0)
+	assert.Equal(t, StatusAvailable, status)
+	assert.Equal(t, int64(1), cache.SoldCount())
+	_, err = cache.TryPurchaseE(reserved.Code)
+	assert.ErrorIs(t, err, ErrReservationNotFound)
+
+	// Sold to someone else in the DB: the lot stays sold
+	code, found = cache.RevokePurchase(1, 1, true)
+	require.True(t, found)
+	assert.Equal(t, bought.Code, code)
+	status, _ = cache.GetLotStatus(1)
+	assert.Equal(t, StatusSold, status)
+	assert.Equal(t, int64(1), cache.SoldCount())
+
+	// Both slots are returned
+	count, _ := cache.GetPurchaseCount(1)
+	assert.Zero(t, count)
+	_, err = cache.BuyNow(1, 0)
+	assert.NoError(t, err)
+
+	_, found = cache.RevokePurchase(1, 5, false)
+	assert.False(t, found, "invalid lot")
+}
+
+// TestBuyNowConcurrentCheckout tests that BuyNow and Checkout racing for one lot have a single winner
+func TestBuyNowConcurrentCheckout(t *testing.T) {
+	const lots, racers = 200, 8
+	cache := NewMegacache(lots, lots)
+	defer cache.Close()
+
+	var bought, reserved int64
+	var wg sync.WaitGroup
+	for r := 0; r < racers; r++ {
+		wg.Add(1)
+		go func(r int) {
+			defer wg.Done()
+			for itemID := int64(0); itemID < lots; itemID++ {
+				userID := int64(r)
+				if r%2 == 0 {
+					if _, err := cache.BuyNow(userID, itemID); err == nil {
+						atomic.AddInt64(&bought, 1)
+					}
+				} else if _, err := cache.Checkout(userID, itemID); err == nil {
+					atomic.AddInt64(&reserved, 1)
+				}
+			}
+		}(r)
+	}
+	wg.Wait()
+
+	assert.Equal(t, int64(lots), bought+reserved, "every lot goes to exactly one racer")
+	available, reservedLots, sold := cache.GetLotsByStatus()
+	assert.Zero(t, available)
+	assert.Equal(t, reserved, reservedLots)
+	assert.Equal(t, bought, sold)
+
+	var purchases int64
+	for _, count := range cache.GetPurchaseCountsSnapshot() {
+		purchases += count
+	}
+	assert.Equal(t, bought, purchases, "losing BuyNow calls give their slot back")
+}
+
+// TestUserCount tests that only users with a purchase are counted
+func TestUserCount(t *testing.T) {
+	cache := NewMegacache(5, 5)
+	defer cache.Close()
+
+	_, err := cache.Checkout(1, 0)
+	require.NoError(t, err)
+	assert.Zero(t, cache.UserCount(), "a reservation alone is not a purchase")
+
+	_, err = cache.BuyNow(2, 1)
+	require.NoError(t, err)
+	_, err = cache.BuyNow(2, 2)
+	require.NoError(t, err)
+	assert.Equal(t, 1, cache.UserCount())
+}
+
+// TestMarkLotsSold tests that each lot is counted once and invalid IDs are skipped
+func TestMarkLotsSold(t *testing.T) {
+	cache := NewMegacache(5, 5)
+	defer cache.Close()
+
+	bought, err := cache.BuyNow(1, 0)
+	require.NoError(t, err)
+	require.NoError(t, cache.ConfirmPurchase(bought.Code))
+
+	assert.Equal(t, 2, cache.MarkLotsSold([]int64{0, 1, 2, 2, -1, 5}))
+	assert.Equal(t, int64(3), cache.SoldCount())
+	available, _, sold := cache.GetLotsByStatus()
+	assert.Equal(t, int64(2), available)
+	assert.Equal(t, int64(3), sold)
+}
+
+// TestCheckoutCtx tests that an abandoned checkout takes no lot, including one cancelled during the soft cap delay
+func TestCheckoutCtx(t *testing.T) {
+	cache := NewMegacache(5, 5)
+	defer cache.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := cache.CheckoutCtx(ctx, 1, 0)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	status, err := cache.GetLotStatus(0)
+	require.NoError(t, err)
+	assert.Equal(t, StatusAvailable, status)
+	assert.Zero(t, cache.GetActiveReservationsCount())
+
+	// A long delay is cut short by the deadline
+	cache.SetSoftCap(SoftCap{Threshold: 10, MaxDelay: time.Hour})
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = cache.CheckoutCtx(ctx, 1, 1)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second)
+
+	status, err = cache.GetLotStatus(1)
+	require.NoError(t, err)
+	assert.Equal(t, StatusAvailable, status)
+
+	cache.SetSoftCap(SoftCap{})
+	_, err = cache.CheckoutCtx(context.Background(), 1, 1)
+	assert.NoError(t, err)
+}
+
+// TestTryPurchaseCtx tests that a cancelled purchase leaves the reservation and the user counter untouched
+func TestTryPurchaseCtx(t *testing.T) {
+	cache := NewMegacache(5, 5)
+	defer cache.Close()
+
+	checkout, err := cache.Checkout(1, 0)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = cache.TryPurchaseCtx(ctx, checkout.Code)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	status, err := cache.GetLotStatus(0)
+	require.NoError(t, err)
+	assert.Equal(t, StatusReserved, status)
+	assert.Zero(t, cache.UserCount())
+
+	// The reservation is still good for a live request
+	purchased, err := cache.TryPurchaseCtx(context.Background(), checkout.Code)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), purchased.LotIndex)
+}
+
+// TestCancelUserCheckouts tests that only the user's active reservations are released
+func TestCancelUserCheckouts(t *testing.T) {
+	cache := NewMegacache(10, 5)
+	defer cache.Close()
+
+	for itemID := int64(0); itemID < 3; itemID++ {
+		_, err := cache.Checkout(1, itemID)
+		require.NoError(t, err)
+	}
+	bought, err := cache.Checkout(1, 3)
+	require.NoError(t, err)
+	_, ok := cache.TryPurchase(bought.Code)
+	require.True(t, ok)
+	other, err := cache.Checkout(2, 4)
+	require.NoError(t, err)
+
+	assert.Len(t, cache.CancelUserCheckouts(1), 3)
+	for itemID := int64(0); itemID < 3; itemID++ {
+		status, err := cache.GetLotStatus(itemID)
+		require.NoError(t, err)
+		assert.Equal(t, StatusAvailable, status)
+	}
+
+	status, err := cache.GetLotStatus(3)
+	require.NoError(t, err)
+	assert.Equal(t, StatusSold, status, "purchases are kept")
+	require.NoError(t, cache.ConfirmPurchase(bought.Code))
+
+	_, ok = cache.TryPurchase(other.Code)
+	assert.True(t, ok, "other users keep their reservations")
+
+	assert.Empty(t, cache.CancelUserCheckouts(1))
+	assert.Empty(t, cache.CancelUserCheckouts(99))
+}
+
+// TestTryPurchaseE tests the failure reason for each way a purchase can be refused
+func TestTryPurchaseE(t *testing.T) {
+	now := time.Now()
+	cache := NewMegacache(5, 1, WithClock(func() time.Time { return now }))
+	defer cache.Close()
+
+	bought, err := cache.Checkout(1, 0)
+	require.NoError(t, err)
+	second, err := cache.Checkout(1, 1)
+	require.NoError(t, err)
+	cancelled, err := cache.Checkout(2, 2)
+	require.NoError(t, err)
+	expiring, err := cache.Checkout(3, 3)
+	require.NoError(t, err)
+
+	_, err = cache.TryPurchaseE(bought.Code)
+	require.NoError(t, err)
+	_, err = cache.TryPurchaseE(bought.Code)
+	assert.ErrorIs(t, err, ErrReservationCompleted)
+	require.NoError(t, cache.ConfirmPurchase(bought.Code))
+	_, err = cache.TryPurchaseE(bought.Code)
+	assert.ErrorIs(t, err, ErrReservationCompleted)
+
+	_, err = cache.TryPurchaseE(second.Code)
+	assert.ErrorIs(t, err, ErrUserLimitExceeded)
+
+	_, err = cache.TryPurchaseE(uuid.New())
+	assert.ErrorIs(t, err, ErrReservationNotFound)
+
+	require.NoError(t, cache.CancelCheckout(cancelled.Code))
+	_, err = cache.TryPurchaseE(cancelled.Code)
+	assert.ErrorIs(t, err, ErrReservationNotFound, "cancelled before expiry")
+
+	now = now.Add(time.Hour)
+	_, err = cache.TryPurchaseE(expiring.Code)
+	assert.ErrorIs(t, err, ErrReservationExpired)
+	_, err = cache.TryPurchaseE(expiring.Code)
+	assert.ErrorIs(t, err, ErrReservationExpired, "the released hold still reports the expiry")
+}
+
+// TestSizeStats tests that finished reservations stay counted until cleanup and buyers are counted once
+func TestSizeStats(t *testing.T) {
+	cache := NewMegacache(5, 5)
+	defer cache.Close()
+
+	checkouts, users, lots := cache.SizeStats()
+	assert.Zero(t, checkouts)
+	assert.Zero(t, users)
+	assert.Equal(t, 5, lots)
+
+	reserved, err := cache.Checkout(1, 0)
+	require.NoError(t, err)
+	_, err = cache.BuyNow(1, 1)
+	require.NoError(t, err)
+	require.NoError(t, cache.CancelCheckout(reserved.Code))
+
+	checkouts, users, lots = cache.SizeStats()
+	assert.Equal(t, 2, checkouts, "a cancelled reservation is held until cleanup")
+	assert.Equal(t, 1, users)
+	assert.Equal(t, 5, lots)
+
+	cache.DeleteCheckout(reserved.Code)
+	checkouts, _, _ = cache.SizeStats()
+	assert.Equal(t, 1, checkouts)
+}
+
+// TestEstimatedMemoryBytes tests that the estimate grows with the lot count, reservations and users
+func TestEstimatedMemoryBytes(t *testing.T) {
+	small := NewMegacache(1000, 10)
+	defer small.Close()
+	large := NewMegacache(10000, 10)
+	defer large.Close()
+
+	// Lots dominate an idle cache, so ten times the lots is close to ten times the memory
+	assert.Greater(t, large.EstimatedMemoryBytes(), 5*small.EstimatedMemoryBytes())
+
+	idle := small.EstimatedMemoryBytes()
+	for i := int64(0); i < 100; i++ {
+		_, err := small.Checkout(i, i)
+		require.NoError(t, err)
+	}
+	withReservations := small.EstimatedMemoryBytes()
+	assert.GreaterOrEqual(t, withReservations-idle, 100*int64(unsafe.Sizeof(Checkout{})))
+
+	// Purchases add user counters
+	checkout, err := small.Checkout(1000, 500)
+	require.NoError(t, err)
+	_, ok := small.TryPurchase(checkout.Code)
+	require.True(t, ok)
+	assert.Greater(t, small.EstimatedMemoryBytes(), withReservations)
+}
+
 // TestIncrementUserPurchaseRaceCondition tests race conditions in user purchase increment
 func TestIncrementUserPurchaseRaceCondition(t *testing.T) {
 	cache := NewMegacache(100, 5)
@@ -731,6 +2378,35 @@ func BenchmarkCheckout(b *testing.B) {
 	b.Logf("Checkout RPS: %.0f operations/second", rps)
 }
 
+// BenchmarkCheckoutShards compares a single checkouts mutex with the sharded map under parallel checkout, lookup and cancel
+func BenchmarkCheckoutShards(b *testing.B) {
+	for _, shards := range []int{1, defaultCheckoutShards} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			const itemsCount = 1 << 16
+			cache := NewMegacache(itemsCount, math.MaxInt32, WithCheckoutShards(shards))
+			defer cache.Close()
+
+			var worker atomic.Int64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				// Each worker cycles through its own lots so only the checkouts map is shared
+				id := worker.Add(1)
+				itemID := id * 1024
+				for pb.Next() {
+					checkout, err := cache.Checkout(id, itemID%itemsCount)
+					itemID++
+					if err != nil {
+						continue
+					}
+					cache.GetCheckoutInfo(checkout.Code)
+					cache.CancelCheckout(checkout.Code)
+					cache.DeleteCheckout(checkout.Code)
+				}
+			})
+		})
+	}
+}
+
 // BenchmarkTryPurchase benchmarks purchase operation
 func BenchmarkTryPurchase(b *testing.B) {
 	cache := NewMegacache(int64(b.N), 1000)
@@ -938,6 +2614,78 @@ func TestHighConcurrency(t *testing.T) {
 	assert.LessOrEqual(t, cache.countLots, int64(1000))
 }
 
+// TestGetPurchaseCountsSnapshot tests that the snapshot matches purchases and is detached from live counters
+func TestGetPurchaseCountsSnapshot(t *testing.T) {
+	cache := NewMegacache(20, 5)
+	defer cache.Close()
+
+	assert.Empty(t, cache.GetPurchaseCountsSnapshot())
+
+	buy := func(userID, itemID int64) {
+		checkout, err := cache.Checkout(userID, itemID)
+		require.NoError(t, err)
+		_, ok := cache.TryPurchase(checkout.Code)
+		require.True(t, ok)
+		cache.ConfirmPurchase(checkout.Code)
+	}
+	buy(1, 0)
+	buy(1, 1)
+	buy(1, 2)
+	buy(2, 3)
+	buy(3, 4)
+	buy(3, 5)
+
+	// Reservations without a purchase don't count
+	_, err := cache.Checkout(4, 6)
+	require.NoError(t, err)
+
+	snapshot := cache.GetPurchaseCountsSnapshot()
+	assert.Equal(t, map[int64]int64{1: 3, 2: 1, 3: 2}, snapshot)
+
+	buy(2, 7)
+	assert.Equal(t, int64(1), snapshot[2], "snapshot must not follow live counters")
+	assert.Equal(t, int64(2), cache.GetPurchaseCountsSnapshot()[2])
+}
+
+// TestSoldOutRace hammers checkout, purchase and confirm until the sale sells out; run with -race to catch unsynchronized countLots reads
+func TestSoldOutRace(t *testing.T) {
+	const itemsCount = 200
+	const workers = 16
+	cache := NewMegacache(itemsCount, itemsCount)
+	defer cache.Close()
+
+	var wg sync.WaitGroup
+	var purchased atomic.Int64
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(userID int64) {
+			defer wg.Done()
+			for i := int64(0); ; i++ {
+				checkout, err := cache.Checkout(userID, (userID+i)%itemsCount)
+				if errors.Is(err, ErrAllItemsPurchased) {
+					return
+				}
+				if err != nil {
+					continue
+				}
+				if _, ok := cache.TryPurchase(checkout.Code); ok {
+					cache.ConfirmPurchase(checkout.Code)
+					purchased.Add(1)
+				}
+			}
+		}(int64(w))
+	}
+	wg.Wait()
+
+	// Every worker stopped on the sold-out early return, which saw the writers' final count
+	assert.Equal(t, int64(itemsCount), purchased.Load())
+	assert.Equal(t, int64(itemsCount), cache.SoldCount())
+	_, err := cache.Checkout(1, 0)
+	assert.ErrorIs(t, err, ErrAllItemsPurchased)
+	_, ok := cache.TryPurchase(uuid.New())
+	assert.False(t, ok)
+}
+
 // TestDataConsistency tests data consistency across operations
 func TestDataConsistency(t *testing.T) {
 	cache := NewMegacache(50, 10)
@@ -1035,7 +2783,7 @@ func TestCleanupTiming(t *testing.T) {
 		t.Skip("Skipping timing test in short mode")
 	}
 
-	cache := NewMegacache(10, 3)
+	cache := NewMegacache(10, 3, WithCheckoutTTL(time.Second), WithCleanupInterval(200*time.Millisecond))
 	defer cache.Close()
 
 	// Create reservation
@@ -1046,7 +2794,7 @@ func TestCleanupTiming(t *testing.T) {
 
 	// Wait for expiration
 	for {
-		if time.Since(startTime) > checkoutTime+10*time.Second {
+		if time.Since(startTime) > cache.CheckoutTTL()+10*cache.CleanupInterval() {
 			t.Fatal("Cleanup took too long")
 		}
 
@@ -1057,15 +2805,16 @@ func TestCleanupTiming(t *testing.T) {
 			break
 		}
 
-		time.Sleep(100 * time.Millisecond)
+		time.Sleep(20 * time.Millisecond)
 	}
 
 	// Cleanup should happen within reasonable time after expiration
 	cleanupTime := time.Since(startTime)
 	t.Logf("Cleanup completed in: %v", cleanupTime)
+	assert.GreaterOrEqual(t, cleanupTime, cache.CheckoutTTL(), "released before expiry")
 
 	// Should be cleaned up within expiration time + cleanup interval + some buffer
-	maxExpectedTime := checkoutTime + 5*time.Second + 2*time.Second
+	maxExpectedTime := cache.CheckoutTTL() + cache.CleanupInterval() + 200*time.Millisecond
 	assert.Less(t, cleanupTime, maxExpectedTime, "Cleanup took too long")
 }
 
@@ -1191,6 +2940,7 @@ func TestInvalidOperations(t *testing.T) {
 
 // TestContextCancellation tests proper context handling
 func TestContextCancellation(t *testing.T) {
+	defer leaktest.Check(t)()
 	cache := NewMegacache(10, 3)
 
 	// Close immediately to test cancellation
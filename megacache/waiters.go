@@ -0,0 +1,144 @@
+package megacache
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// waitResult is what a parked caller receives / то, что получает ожидающий вызов
+type waitResult struct {
+	checkout Checkout
+	err      error
+}
+
+// waiter is a caller parked on a reserved lot / вызов, ожидающий зарезервированный лот
+type waiter struct {
+	userID int64
+	result chan waitResult // buffered 1, so a handoff never blocks / буфер 1, чтобы передача не блокировалась
+}
+
+// waitQueues holds per-lot FIFO queues of callers waiting for a reserved lot / хранит FIFO очереди вызовов, ожидающих зарезервированный лот
+type waitQueues struct {
+	mu      sync.Mutex
+	queues  map[int64][]*waiter
+	pending atomic.Int64 // CheckoutOrWait calls in progress, releases skip mu while it is 0 / вызовов CheckoutOrWait в процессе, пока 0 - освобождения не берут mu
+}
+
+// CheckoutOrWait reserves a lot like Checkout, but if it is reserved parks the caller until the holder cancels or expires and the lot is handed over /
+// резервирует лот как Checkout, но если он зарезервирован, ждет, пока владелец отменит резерв или тот истечет, и лот будет передан
+// Waiters are served FIFO; a waiter over the user limit at handoff gets ErrUserLimitExceeded. Gives up with ErrItemAlreadyReserved after timeout and ErrCacheClosed on Close /
+// Ожидающие обслуживаются по FIFO; ожидающий сверх лимита пользователя при передаче получает ErrUserLimitExceeded. Сдается с ErrItemAlreadyReserved по таймауту и ErrCacheClosed при Close
+func (c *Megacache) CheckoutOrWait(userID int64, itemID int64, timeout time.Duration) (Checkout, error) {
+	// Counted before the lot status is read, so a release that misses the queue still sees us /
+	// Учитываемся до чтения статуса лота, чтобы освобождение, не заставшее очередь, все равно нас увидело
+	c.waiters.pending.Add(1)
+	defer c.waiters.pending.Add(-1)
+
+	w := &waiter{userID: userID, result: make(chan waitResult, 1)}
+	for {
+		checkout, err := c.Checkout(userID, itemID)
+		if !errors.Is(err, ErrItemAlreadyReserved) || timeout <= 0 {
+			return checkout, err
+		}
+		if c.ctx.Err() != nil {
+			return Checkout{}, ErrCacheClosed
+		}
+
+		// A release flips the lot to available without mu and only then takes mu in wakeWaiters, so a waiter that still sees it reserved
+		// under mu is already queued when that wake-up runs /
+		// Освобождение переключает лот в доступные без mu и только затем берет mu в wakeWaiters, поэтому ожидающий, который под mu
+		// еще видит лот зарезервированным, уже стоит в очереди, когда это пробуждение выполняется
+		c.waiters.mu.Lock()
+		if c.lots[itemID].loadStatus() == StatusReserved {
+			c.waiters.queues[itemID] = append(c.waiters.queues[itemID], w)
+			c.waiters.mu.Unlock()
+			break
+		}
+		c.waiters.mu.Unlock()
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	giveUp := ErrItemAlreadyReserved
+	select {
+	case result := <-w.result:
+		return result.checkout, result.err
+	case <-timer.C:
+	case <-c.ctx.Done():
+		giveUp = ErrCacheClosed
+	}
+
+	if c.removeWaiter(itemID, w) {
+		return Checkout{}, giveUp
+	}
+	// Handed over while giving up, the result is already buffered / Передан в момент отказа, результат уже в буфере
+	result := <-w.result
+	return result.checkout, result.err
+}
+
+// removeWaiter takes w out of the lot queue, false if a handoff already popped it / убирает w из очереди лота, false, если передача уже его забрала
+func (c *Megacache) removeWaiter(itemID int64, w *waiter) bool {
+	c.waiters.mu.Lock()
+	defer c.waiters.mu.Unlock()
+
+	queue := c.waiters.queues[itemID]
+	for i, queued := range queue {
+		if queued != w {
+			continue
+		}
+		queue = append(queue[:i], queue[i+1:]...)
+		if len(queue) == 0 {
+			delete(c.waiters.queues, itemID)
+		} else {
+			c.waiters.queues[itemID] = queue
+		}
+		return true
+	}
+	return false
+}
+
+// releaseLot returns a reserved lot to available and hands it to the first waiter, if any / возвращает зарезервированный лот в доступные и передает его первому ожидающему, если он есть
+func (c *Megacache) releaseLot(itemID int64) {
+	if c.lots[itemID].casStatus(StatusReserved, StatusAvailable) {
+		c.wakeWaiters(itemID)
+	}
+}
+
+// wakeWaiters hands a lot that just became available to its queue; cheap when nobody waits /
+// передает только что освободившийся лот его очереди; дешево, когда никто не ждет
+func (c *Megacache) wakeWaiters(itemID int64) {
+	if c.waiters.pending.Load() == 0 {
+		return
+	}
+
+	c.waiters.mu.Lock()
+	defer c.waiters.mu.Unlock()
+
+	queue := c.waiters.queues[itemID]
+	for len(queue) > 0 {
+		// Someone outside the queue may have grabbed the lot first / Кто-то вне очереди мог успеть забрать лот
+		if !c.lots[itemID].casStatus(StatusAvailable, StatusReserved) {
+			break
+		}
+
+		w := queue[0]
+		queue = queue[1:]
+
+		if err := c.checkUserLimits(w.userID); err != nil {
+			c.lots[itemID].casStatus(StatusReserved, StatusAvailable)
+			w.result <- waitResult{err: err}
+			continue
+		}
+		w.result <- waitResult{checkout: c.addCheckout(w.userID, itemID)}
+		break
+	}
+
+	if len(queue) == 0 {
+		delete(c.waiters.queues, itemID)
+	} else {
+		c.waiters.queues[itemID] = queue
+	}
+}
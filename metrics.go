@@ -0,0 +1,61 @@
+package main
+
+import (
+	"contest_notcoin/db"
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+)
+
+// metricsContentType is the Prometheus text exposition format / текстовый формат экспозиции Prometheus
+const metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// serviceMetrics counts handler outcomes for /metrics / считает исходы обработчиков для /metrics
+type serviceMetrics struct {
+	checkouts      atomic.Int64 // reservations handed to clients / резервы, выданные клиентам
+	purchases      atomic.Int64 // purchases confirmed in DB / покупки, подтвержденные в БД
+	conflicts      atomic.Int64 // 409 answers from checkout and purchase / ответы 409 от checkout и purchase
+	internalErrors atomic.Int64 // 500 answers from checkout and purchase / ответы 500 от checkout и purchase
+	rateLimited    atomic.Int64 // checkouts refused by the per-user limit / checkout, отклоненные лимитом на пользователя
+	poolShed       atomic.Int64 // requests shed on a saturated DB pool / запросы, сброшенные при насыщенном пуле БД
+}
+
+// purchaseStatsSource exposes purchase retry and dead-letter counters / отдает счетчики повторов и очереди недоставленных покупок
+type purchaseStatsSource interface {
+	Stats() db.PurchaseUpdaterStats
+}
+
+// writeMetric writes one metric with its HELP and TYPE lines / пишет одну метрику со строками HELP и TYPE
+func writeMetric(w io.Writer, name, kind, help string, value int64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", name, help, name, kind, name, value)
+}
+
+// metricsHandler exposes counters and gauges in Prometheus text format / отдает счетчики и метрики в текстовом формате Prometheus
+func (s *ServerInstance) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte("Method not allowed"))
+		return
+	}
+
+	w.Header().Set("Content-Type", metricsContentType)
+	w.WriteHeader(http.StatusOK)
+	writeMetric(w, "flashsale_checkouts_total", "counter", "Reservations handed to clients.", s.metrics.checkouts.Load())
+	writeMetric(w, "flashsale_purchases_total", "counter", "Purchases confirmed in the database.", s.metrics.purchases.Load())
+	writeMetric(w, "flashsale_conflicts_total", "counter", "Checkout and purchase requests answered with 409.", s.metrics.conflicts.Load())
+	writeMetric(w, "flashsale_internal_errors_total", "counter", "Checkout and purchase requests answered with 500.", s.metrics.internalErrors.Load())
+	writeMetric(w, "flashsale_rate_limited_total", "counter", "Checkouts refused by the per-user rate limit.", s.metrics.rateLimited.Load())
+	writeMetric(w, "flashsale_pool_shed_total", "counter", "Requests answered with 503 because the DB pool was saturated.", s.metrics.poolShed.Load())
+	writeMetric(w, "flashsale_active_reservations", "gauge", "Active reservations in the cache.", int64(s.cache.GetActiveReservationsCount()))
+	writeMetric(w, "flashsale_sold_lots", "gauge", "Lots sold in the current sale.", s.cache.SoldCount())
+	writeMetric(w, "flashsale_cache_estimated_bytes", "gauge", "Estimated memory held by the cache.", s.cache.EstimatedMemoryBytes())
+	checkouts, _, _ := s.cache.SizeStats()
+	writeMetric(w, "flashsale_cache_checkouts", "gauge", "Entries in the cache checkouts map, finished reservations included until cleanup.", int64(checkouts))
+	if source, ok := s.batchPurchase.(purchaseStatsSource); ok {
+		stats := source.Stats()
+		writeMetric(w, "flashsale_purchase_retries_total", "counter", "Purchase batches retried after a DB failure.", stats.Retries)
+		writeMetric(w, "flashsale_purchase_dead_letter_depth", "gauge", "Purchases waiting in the dead-letter queue.", stats.DeadLetterDepth)
+		writeMetric(w, "flashsale_purchase_dead_letter_lost_total", "counter", "Dead-lettered purchases never written.", stats.Lost)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Policies for body-bearing requests without a supported Content-Type / Политики для запросов с телом без поддерживаемого Content-Type
+const (
+	bodyPolicyIgnore = "ignore" // drop the body and use query params / игнорировать тело и использовать параметры запроса
+	bodyPolicyReject = "reject" // answer 415 Unsupported Media Type / отвечать 415 Unsupported Media Type
+)
+
+// maxWaitHeader lets clients set how long they wait for the DB, in milliseconds / позволяет клиенту задать время ожидания БД в миллисекундах
+const maxWaitHeader = "X-Max-Wait-Ms"
+
+// maxParamsBodySize limits request body size / ограничивает размер тела запроса
+const maxParamsBodySize = 64 * 1024
+
+var (
+	errUnsupportedMediaType = errors.New("unsupported content type")
+	errMalformedBody        = errors.New("malformed request body")
+	errInvalidUserID        = errors.New("invalid user_id")
+)
+
+// parseUserID parses a user_id in [1, max], anything else is a client error / разбирает user_id в диапазоне [1, max], все остальное - ошибка клиента
+func parseUserID(value string, max int64) (int64, error) {
+	userID, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || userID <= 0 || userID > max {
+		return 0, errInvalidUserID
+	}
+	return userID, nil
+}
+
+// parseRequestParams merges query params with JSON or form body params, body wins / объединяет параметры запроса с параметрами из JSON или form тела, тело имеет приоритет
+func parseRequestParams(r *http.Request, policy string) (url.Values, error) {
+	params, err := url.ParseQuery(r.URL.RawQuery)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errMalformedBody, err)
+	}
+
+	// No body - query params only / Нет тела - только параметры запроса
+	if r.Body == nil || r.Body == http.NoBody || r.ContentLength == 0 {
+		return params, nil
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	var bodyParams url.Values
+	switch mediaType {
+	case "application/json":
+		bodyParams, err = parseJSONParams(r)
+	case "application/x-www-form-urlencoded":
+		bodyParams, err = parseFormParams(r)
+	default:
+		// Missing or unexpected Content-Type / Отсутствующий или неожиданный Content-Type
+		if policy == bodyPolicyReject {
+			return nil, errUnsupportedMediaType
+		}
+		return params, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for key, values := range bodyParams {
+		params[key] = values
+	}
+	return params, nil
+}
+
+// parseJSONParams reads a flat JSON object of strings and numbers / читает плоский JSON объект из строк и чисел
+func parseJSONParams(r *http.Request) (url.Values, error) {
+	decoder := json.NewDecoder(http.MaxBytesReader(nil, r.Body, maxParamsBodySize))
+	decoder.UseNumber()
+
+	var body map[string]interface{}
+	if err := decoder.Decode(&body); err != nil {
+		return nil, fmt.Errorf("%w: %v", errMalformedBody, err)
+	}
+
+	params := make(url.Values, len(body))
+	for key, value := range body {
+		switch v := value.(type) {
+		case string:
+			params.Set(key, v)
+		case json.Number:
+			params.Set(key, v.String())
+		default:
+			return nil, fmt.Errorf("%w: field %q must be a string or number", errMalformedBody, key)
+		}
+	}
+	return params, nil
+}
+
+// parseFormParams reads an urlencoded form body / читает тело в формате urlencoded
+func parseFormParams(r *http.Request) (url.Values, error) {
+	r.Body = http.MaxBytesReader(nil, r.Body, maxParamsBodySize)
+	if err := r.ParseForm(); err != nil {
+		return nil, fmt.Errorf("%w: %v", errMalformedBody, err)
+	}
+	return r.PostForm, nil
+}
+
+// paramsErrorStatus maps a params parsing error to an HTTP status / сопоставляет ошибку разбора параметров HTTP статусу
+func paramsErrorStatus(err error) int {
+	if errors.Is(err, errUnsupportedMediaType) {
+		return http.StatusUnsupportedMediaType
+	}
+	return http.StatusBadRequest
+}
+
+// requestWait returns client wait from X-Max-Wait-Ms clamped to serverMax, serverMax if absent or invalid / возвращает время ожидания клиента из X-Max-Wait-Ms, ограниченное serverMax, или serverMax если заголовка нет или он некорректен
+func requestWait(r *http.Request, serverMax time.Duration) time.Duration {
+	value := r.Header.Get(maxWaitHeader)
+	if value == "" {
+		return serverMax
+	}
+
+	ms, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || ms <= 0 {
+		return serverMax
+	}
+
+	// Compare in milliseconds to avoid overflow on huge values / Сравниваем в миллисекундах, чтобы избежать переполнения
+	if ms >= serverMax.Milliseconds() {
+		return serverMax
+	}
+	return time.Duration(ms) * time.Millisecond
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"net/http/pprof"
+)
+
+// pprofMux serves the net/http/pprof handlers / отдает обработчики net/http/pprof
+func pprofMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return mux
+}
+
+// startPprofServer serves profiles on a separate admin address, empty addr disables it; Addr holds the bound address /
+// отдает профили на отдельном админском адресе, пустой addr отключает его; Addr содержит фактический адрес
+func startPprofServer(addr string) (*http.Server, error) {
+	if addr == "" {
+		return nil, nil
+	}
+
+	// Listen first so a taken port fails startup instead of a log line / Сначала слушаем, чтобы занятый порт ронял запуск, а не писал в лог
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	server := &http.Server{
+		Addr:    listener.Addr().String(),
+		Handler: pprofMux(),
+	}
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Printf("❌ pprof server error: %v", err)
+		}
+	}()
+
+	log.Printf("🩺 pprof listening on %s", server.Addr)
+	return server, nil
+}
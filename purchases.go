@@ -0,0 +1,72 @@
+package main
+
+import (
+	"contest_notcoin/db"
+	"context"
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"time"
+)
+
+// purchaseHistoryPageSize is the number of purchases per /purchases page / количество покупок на странице /purchases
+const purchaseHistoryPageSize = 50
+
+// purchasesResponse is the /purchases response body / тело ответа /purchases
+type purchasesResponse struct {
+	UserID    int64         `json:"user_id"`
+	Page      int           `json:"page"`
+	PageSize  int           `json:"page_size"`
+	Total     int64         `json:"total"`
+	Purchases []db.SaleItem `json:"purchases"` // newest first, [] past the last page / новые первыми, [] после последней страницы
+}
+
+// purchasesHandler pages through a user's purchases across all sales, newest first / постранично отдает покупки пользователя по всем распродажам, новые первыми
+func (s *ServerInstance) purchasesHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.isAcceptingRequests() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := parseUserID(r.URL.Query().Get("user_id"), s.config.MaxUserID)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Pages start at 1, the offset must fit in Postgres' OFFSET / Страницы начинаются с 1, смещение должно поместиться в OFFSET Postgres
+	page, ok := queryInt(r, "page", 1)
+	if !ok || page < 1 || page > math.MaxInt32/purchaseHistoryPageSize {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	items, total, err := s.purchases.GetPurchasedItemsPaged(ctx, userID, purchaseHistoryPageSize, (page-1)*purchaseHistoryPageSize)
+	if err != nil {
+		log.Printf("❌ Failed to load purchase history for user %d: %v", userID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if items == nil {
+		items = []db.SaleItem{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(purchasesResponse{
+		UserID:    userID,
+		Page:      page,
+		PageSize:  purchaseHistoryPageSize,
+		Total:     total,
+		Purchases: items,
+	})
+}
@@ -0,0 +1,162 @@
+// Package ratelimit limits request rates per key with token buckets / ограничивает частоту запросов по ключу корзинами токенов
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// shardCount spreads keys over independent locks / распределяет ключи по независимым блокировкам
+const shardCount = 64
+
+// minSweepInterval keeps the janitor from spinning at high rates / не дает уборщику крутиться вхолостую при высоких частотах
+const minSweepInterval = time.Second
+
+// bucket is the token bucket of one key / корзина токенов одного ключа
+type bucket struct {
+	tokens float64   // tokens left at last / сколько токенов было на момент last
+	last   time.Time // last refill / последнее пополнение
+}
+
+// shard holds the buckets of keys hashed to it / хранит корзины ключей, попавших в шард
+type shard struct {
+	mu      sync.Mutex
+	buckets map[int64]*bucket
+}
+
+// Limiter allows rate events per second per key with bursts up to burst, safe for concurrent use /
+// разрешает rate событий в секунду на ключ со всплесками до burst, безопасен для конкурентного использования
+type Limiter struct {
+	rate   float64
+	burst  float64
+	idle   time.Duration // time for an empty bucket to refill / время пополнения пустой корзины
+	now    func() time.Time
+	shards [shardCount]shard
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// Option configures a Limiter at construction / настраивает Limiter при создании
+type Option func(*Limiter)
+
+// WithClock replaces time.Now, nil keeps the real clock / заменяет time.Now, nil оставляет реальные часы
+func WithClock(now func() time.Time) Option {
+	return func(l *Limiter) {
+		if now != nil {
+			l.now = now
+		}
+	}
+}
+
+// New creates a limiter and starts evicting idle keys; rate and burst must be positive /
+// создает ограничитель и запускает вытеснение простаивающих ключей; rate и burst должны быть положительными
+func New(rate float64, burst int, opts ...Option) *Limiter {
+	l := &Limiter{
+		rate:  rate,
+		burst: float64(burst),
+		idle:  time.Duration(float64(burst) / rate * float64(time.Second)),
+		now:   time.Now,
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	for i := range l.shards {
+		l.shards[i].buckets = make(map[int64]*bucket)
+	}
+
+	go l.janitor(max(l.idle, minSweepInterval))
+	return l
+}
+
+// shard returns the shard of key / возвращает шард ключа
+func (l *Limiter) shard(key int64) *shard {
+	// Fibonacci hashing spreads sequential IDs / Хеширование Фибоначчи разносит последовательные ID
+	return &l.shards[(uint64(key)*0x9E3779B97F4A7C15)>>58]
+}
+
+// Allow takes a token for key, false when its bucket is empty / забирает токен для key, false если корзина пуста
+func (l *Limiter) Allow(key int64) bool {
+	now := l.now()
+	sh := l.shard(key)
+
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	b, ok := sh.buckets[key]
+	if !ok {
+		sh.buckets[key] = &bucket{tokens: l.burst - 1, last: now}
+		return true
+	}
+
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// RetryAfter is how long a rejected key waits for its next token at most / сколько отклоненный ключ ждет следующего токена в худшем случае
+func (l *Limiter) RetryAfter() time.Duration {
+	return time.Duration(float64(time.Second) / l.rate)
+}
+
+// Sweep evicts keys whose buckets have refilled, forgetting them changes nothing; returns how many were evicted /
+// вытесняет ключи с пополнившимися корзинами, их забывание ничего не меняет; возвращает, сколько вытеснено
+func (l *Limiter) Sweep() int {
+	now := l.now()
+	evicted := 0
+	for i := range l.shards {
+		sh := &l.shards[i]
+		sh.mu.Lock()
+		for key, b := range sh.buckets {
+			if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+				delete(sh.buckets, key)
+				evicted++
+			}
+		}
+		sh.mu.Unlock()
+	}
+	return evicted
+}
+
+// Len returns the number of tracked keys / возвращает количество отслеживаемых ключей
+func (l *Limiter) Len() int {
+	n := 0
+	for i := range l.shards {
+		sh := &l.shards[i]
+		sh.mu.Lock()
+		n += len(sh.buckets)
+		sh.mu.Unlock()
+	}
+	return n
+}
+
+// Close stops the janitor / останавливает уборщика
+func (l *Limiter) Close() {
+	l.stopOnce.Do(func() { close(l.stop) })
+	<-l.done
+}
+
+// janitor sweeps idle keys so memory tracks active users only / вытесняет простаивающие ключи, чтобы память росла только с активными пользователями
+func (l *Limiter) janitor(interval time.Duration) {
+	defer close(l.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			l.Sweep()
+		}
+	}
+}
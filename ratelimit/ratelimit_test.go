@@ -0,0 +1,100 @@
+package ratelimit
+
+import (
+	"contest_notcoin/leaktest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeClock is a manually advanced clock
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// TestAllowExceedsLimit tests that a key is cut off after its burst and refills at the rate
+func TestAllowExceedsLimit(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	l := New(2, 3, WithClock(clock.Now))
+	defer l.Close()
+
+	for i := 0; i < 3; i++ {
+		assert.True(t, l.Allow(1), "burst request %d", i)
+	}
+	assert.False(t, l.Allow(1), "burst exhausted")
+
+	// Other keys have their own buckets
+	assert.True(t, l.Allow(2))
+
+	// 2 tokens per second: one token every 500ms
+	clock.Advance(499 * time.Millisecond)
+	assert.False(t, l.Allow(1))
+	clock.Advance(time.Millisecond)
+	assert.True(t, l.Allow(1))
+	assert.False(t, l.Allow(1))
+
+	// Refill never exceeds the burst
+	clock.Advance(time.Hour)
+	for i := 0; i < 3; i++ {
+		assert.True(t, l.Allow(1))
+	}
+	assert.False(t, l.Allow(1))
+
+	assert.Equal(t, 500*time.Millisecond, l.RetryAfter())
+}
+
+// TestSweepEvictsIdleKeys tests that keys are forgotten once their buckets have refilled
+func TestSweepEvictsIdleKeys(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	l := New(1, 2, WithClock(clock.Now))
+	defer l.Close()
+
+	l.Allow(1)
+	l.Allow(1)
+	l.Allow(2)
+	assert.Equal(t, 2, l.Len())
+
+	// Key 2 refills after 1s, key 1 after 2s
+	clock.Advance(time.Second)
+	l.Allow(3)
+	assert.Equal(t, 1, l.Sweep())
+	assert.Equal(t, 2, l.Len())
+
+	// Key 3 was left with one token, so both refill now
+	clock.Advance(time.Second)
+	assert.Equal(t, 2, l.Sweep())
+	assert.Equal(t, 0, l.Len())
+
+	// An evicted key starts over with a full bucket, exactly what it would have had
+	assert.True(t, l.Allow(1))
+	assert.True(t, l.Allow(1))
+	assert.False(t, l.Allow(1))
+}
+
+// TestJanitorEvicts tests that idle keys are evicted in the background and Close stops the janitor
+func TestJanitorEvicts(t *testing.T) {
+	defer leaktest.Check(t)()
+
+	// 1000 tokens per second with a burst of 1 refills in 1ms, but sweeps run every minSweepInterval
+	l := New(1000, 1)
+	l.Allow(1)
+	assert.Eventually(t, func() bool { return l.Len() == 0 }, 3*minSweepInterval, 10*time.Millisecond)
+
+	l.Close()
+	l.Close() // closing twice is safe
+}
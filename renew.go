@@ -0,0 +1,84 @@
+package main
+
+import (
+	"contest_notcoin/megacache"
+	"contest_notcoin/token"
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// renewUpdateTimeout bounds the best-effort DB update of a renewed reservation / ограничивает обновление продленного резерва в БД
+const renewUpdateTimeout = 200 * time.Millisecond
+
+// reservationRenewer moves the expiry of a persisted reservation / переносит срок сохраненного резерва
+type reservationRenewer interface {
+	UpdateExpiresAt(ctx context.Context, code uuid.UUID, expiresAt time.Time) error
+}
+
+// renewHandler extends a reservation for long purchase flows such as 3DS confirmation / продлевает резерв для долгих покупок, например с подтверждением 3DS
+func (s *ServerInstance) renewHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.isAcceptingRequests() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	params, err := parseRequestParams(r, s.config.BodyContentTypePolicy)
+	if err != nil {
+		w.WriteHeader(paramsErrorStatus(err))
+		return
+	}
+
+	code, err := s.parseReservationCode(params.Get("code"))
+	if err != nil {
+		// An expired token means the reservation is gone anyway / Истекший токен значит, что резерва уже нет
+		if errors.Is(err, token.ErrExpired) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	expiresAt, err := s.cache.RenewCheckout(code)
+	switch {
+	case errors.Is(err, megacache.ErrReservationNotFound), errors.Is(err, megacache.ErrReservationExpired):
+		w.WriteHeader(http.StatusNotFound)
+		return
+	case errors.Is(err, megacache.ErrReservationCompleted), errors.Is(err, megacache.ErrRenewalLimitReached):
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+
+	s.updateReservationExpiry(r.Context(), code, expiresAt)
+
+	// Signed tokens carry the expiry, so the client gets a fresh one / Подписанные токены содержат срок, поэтому клиент получает новый
+	checkout, _ := s.cache.GetCheckoutInfo(code)
+	checkout.ExpiresAt = expiresAt
+	writeResponse(w, r, http.StatusOK, checkoutResponse{Code: s.reservationCode(checkout), ExpiresAt: expiresAt, ItemID: checkout.LotIndex})
+}
+
+// updateReservationExpiry moves the persisted expiry, failures are only logged since the cache is authoritative /
+// переносит сохраненный срок, ошибки только логируются, так как кеш актуален
+func (s *ServerInstance) updateReservationExpiry(ctx context.Context, code uuid.UUID, expiresAt time.Time) {
+	// Nothing is written in none mode / В режиме none ничего не записывается
+	if s.renewals == nil || s.config.CheckoutPersistMode == persistModeNone {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, renewUpdateTimeout)
+	defer cancel()
+
+	if err := s.renewals.UpdateExpiresAt(ctx, code, expiresAt); err != nil {
+		log.Printf("⚠️  Failed to update renewed reservation %s: %v", code, err)
+	}
+}
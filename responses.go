@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Response content types negotiated by Accept / Типы содержимого ответа, выбираемые по Accept
+const (
+	contentTypePlain    = "text/plain"
+	contentTypeJSON     = "application/json"
+	contentTypeProtobuf = "application/x-protobuf"
+)
+
+// responseBody is a handler result every encoder can serialize / результат обработчика, который умеет сериализовать любой кодировщик
+type responseBody interface {
+	plainText() string // legacy plain-text body / тело в старом текстовом формате
+	protobuf() []byte  // protobuf wire encoding / кодирование в формате protobuf
+}
+
+// responseEncoder serializes response bodies in one format / сериализует тела ответов в одном формате
+type responseEncoder interface {
+	contentType() string
+	encode(w io.Writer, body responseBody) error
+}
+
+type plainEncoder struct{}
+
+func (plainEncoder) contentType() string { return contentTypePlain }
+func (plainEncoder) encode(w io.Writer, body responseBody) error {
+	_, err := io.WriteString(w, body.plainText())
+	return err
+}
+
+type jsonEncoder struct{}
+
+func (jsonEncoder) contentType() string { return contentTypeJSON }
+func (jsonEncoder) encode(w io.Writer, body responseBody) error {
+	return json.NewEncoder(w).Encode(body)
+}
+
+type protobufEncoder struct{}
+
+func (protobufEncoder) contentType() string { return contentTypeProtobuf }
+func (protobufEncoder) encode(w io.Writer, body responseBody) error {
+	_, err := w.Write(body.protobuf())
+	return err
+}
+
+// responseEncoders lists supported formats by media type / поддерживаемые форматы по типу содержимого
+var responseEncoders = map[string]responseEncoder{
+	contentTypePlain:    plainEncoder{},
+	contentTypeJSON:     jsonEncoder{},
+	contentTypeProtobuf: protobufEncoder{},
+}
+
+// negotiateEncoder picks the supported format with the highest Accept quality, plain text by default so the load tester keeps working /
+// выбирает поддерживаемый формат с наибольшим качеством в Accept, по умолчанию простой текст, чтобы нагрузочный тест продолжал работать
+func negotiateEncoder(r *http.Request) responseEncoder {
+	var best responseEncoder = plainEncoder{}
+	bestQuality := 0.0
+
+	for _, value := range r.Header.Values("Accept") {
+		for _, part := range strings.Split(value, ",") {
+			mediaType, params, err := mime.ParseMediaType(part)
+			if err != nil {
+				continue
+			}
+			encoder, ok := responseEncoders[mediaType]
+			if !ok {
+				continue
+			}
+
+			quality := 1.0
+			if q, ok := params["q"]; ok {
+				if quality, err = strconv.ParseFloat(q, 64); err != nil {
+					continue
+				}
+			}
+			// Earlier entries win ties / При равенстве побеждает более ранняя запись
+			if quality > bestQuality {
+				best, bestQuality = encoder, quality
+			}
+		}
+	}
+	return best
+}
+
+// writeResponse writes body in the format negotiated for r / записывает тело в формате, выбранном для r
+func writeResponse(w http.ResponseWriter, r *http.Request, status int, body responseBody) {
+	encoder := negotiateEncoder(r)
+	w.Header().Set("Content-Type", encoder.contentType())
+	w.WriteHeader(status)
+	encoder.encode(w, body)
+}
+
+// checkoutResponse is the /checkout response body / тело ответа /checkout
+//
+//	message CheckoutResponse {
+//	  string code = 1;
+//	  int64 expires_at_unix_ms = 2;
+//	  int64 item_id = 3;
+//	}
+type checkoutResponse struct {
+	Code      string    `json:"code"`
+	ExpiresAt time.Time `json:"expires_at"`
+	ItemID    int64     `json:"item_id"`
+}
+
+func (c checkoutResponse) plainText() string { return c.Code }
+
+func (c checkoutResponse) protobuf() []byte {
+	b := appendProtoString(nil, 1, c.Code)
+	b = appendProtoInt64(b, 2, c.ExpiresAt.UnixMilli())
+	return appendProtoInt64(b, 3, c.ItemID)
+}
+
+// purchaseResponse is the /purchase response body, empty in plain text as before /
+// тело ответа /purchase, в простом тексте пустое, как и раньше
+//
+//	message PurchaseResponse {
+//	  string code = 1;
+//	  int64 item_id = 2;
+//	  int64 user_id = 3;
+//	  int64 purchased_at_unix_ms = 4;
+//	  int64 sale_id = 5;
+//	}
+type purchaseResponse struct {
+	Code        string    `json:"code"`
+	ItemID      int64     `json:"item_id"`
+	UserID      int64     `json:"user_id"`
+	PurchasedAt time.Time `json:"purchased_at"`
+	SaleID      int64     `json:"sale_id"`
+}
+
+func (p purchaseResponse) plainText() string { return "" }
+
+func (p purchaseResponse) protobuf() []byte {
+	b := appendProtoString(nil, 1, p.Code)
+	b = appendProtoInt64(b, 2, p.ItemID)
+	b = appendProtoInt64(b, 3, p.UserID)
+	if !p.PurchasedAt.IsZero() {
+		b = appendProtoInt64(b, 4, p.PurchasedAt.UnixMilli())
+	}
+	return appendProtoInt64(b, 5, p.SaleID)
+}
+
+// Protobuf wire types / Типы кодирования protobuf
+const (
+	protoWireVarint = 0
+	protoWireBytes  = 2
+)
+
+// appendProtoString appends a length-delimited field, empty strings are omitted as in proto3 /
+// добавляет поле с длиной, пустые строки пропускаются, как в proto3
+func appendProtoString(b []byte, field int, value string) []byte {
+	if value == "" {
+		return b
+	}
+	b = binary.AppendUvarint(b, uint64(field)<<3|protoWireBytes)
+	b = binary.AppendUvarint(b, uint64(len(value)))
+	return append(b, value...)
+}
+
+// appendProtoInt64 appends a varint field, zero is omitted as in proto3 / добавляет поле varint, ноль пропускается, как в proto3
+func appendProtoInt64(b []byte, field int, value int64) []byte {
+	if value == 0 {
+		return b
+	}
+	b = binary.AppendUvarint(b, uint64(field)<<3|protoWireVarint)
+	return binary.AppendUvarint(b, uint64(value))
+}
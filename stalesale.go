@@ -0,0 +1,51 @@
+package main
+
+import (
+	"contest_notcoin/db"
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// staleSaleLookupTimeout bounds the DB lookup on the purchase failure path / ограничивает поиск в БД на пути отказа покупки
+const staleSaleLookupTimeout = 200 * time.Millisecond
+
+// staleSaleMessage is the body returned for codes from an ended sale / тело ответа для кодов из завершенной распродажи
+const staleSaleMessage = "reservation from an ended sale"
+
+// errStaleSale marks a purchase of a code from an ended sale / отмечает покупку по коду из завершенной распродажи
+var errStaleSale = errors.New(staleSaleMessage)
+
+// reservationLookup finds a persisted reservation by code / находит сохраненный резерв по коду
+type reservationLookup interface {
+	GetReservationByCode(ctx context.Context, code uuid.UUID) (*db.CheckoutRecord, error)
+}
+
+// isStaleSaleCode reports whether a code missing from the cache was issued by a previous sale / сообщает, выдан ли отсутствующий в кеше код прошлой распродажей
+func (s *ServerInstance) isStaleSaleCode(ctx context.Context, code uuid.UUID) bool {
+	if s.reservations == nil {
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, staleSaleLookupTimeout)
+	defer cancel()
+
+	record, err := s.reservations.GetReservationByCode(ctx, code)
+	if err != nil {
+		// Lookup failure falls back to the plain conflict / При ошибке поиска отвечаем обычным конфликтом
+		log.Printf("⚠️  Stale sale lookup failed: %v", err)
+		return false
+	}
+	return record != nil && record.SaleID != s.saleID
+}
+
+// writeStaleSale answers a code from an ended sale with the configured status / отвечает на код из завершенной распродажи настроенным статусом
+func (s *ServerInstance) writeStaleSale(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(s.config.StaleSaleStatus)
+	w.Write([]byte(staleSaleMessage))
+}
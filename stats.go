@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// cacheSizeStats is the size of the cache maps in /stats / размер map кеша в /stats
+type cacheSizeStats struct {
+	Checkouts      int   `json:"checkouts"` // finished reservations included until cleanup / включая завершенные резервы до очистки
+	Users          int   `json:"users"`
+	Lots           int   `json:"lots"`
+	EstimatedBytes int64 `json:"estimated_bytes"`
+}
+
+// statsResponse is the /stats response body / тело ответа /stats
+type statsResponse struct {
+	SaleID             int64                  `json:"sale_id"`
+	UptimeSeconds      float64                `json:"uptime_seconds"`
+	Sold               int64                  `json:"sold"`
+	Available          int64                  `json:"available"`
+	Reserved           int64                  `json:"reserved"`
+	ActiveReservations int                    `json:"active_reservations"`
+	TotalUsers         int                    `json:"total_users"`
+	Cache              cacheSizeStats         `json:"cache"`
+	DBStats            map[string]interface{} `json:"db_stats"`
+}
+
+// statsHandler summarizes cache and DB state for live ops; lot counts are lock-free and other locks are held briefly, so polling every second is fine /
+// сводка состояния кеша и БД для оперативного наблюдения; лоты считаются без блокировок, остальные блокировки короткие, опрос раз в секунду допустим
+func (s *ServerInstance) statsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	available, reserved, sold := s.cache.GetLotsByStatus()
+	checkouts, users, lots := s.cache.SizeStats()
+	stats := statsResponse{
+		SaleID:             s.saleID,
+		UptimeSeconds:      time.Since(s.startedAt).Seconds(),
+		Sold:               sold,
+		Available:          available,
+		Reserved:           reserved,
+		ActiveReservations: s.cache.GetActiveReservationsCount(),
+		TotalUsers:         s.cache.UserCount(),
+		Cache: cacheSizeStats{
+			Checkouts:      checkouts,
+			Users:          users,
+			Lots:           lots,
+			EstimatedBytes: s.cache.EstimatedMemoryBytes(),
+		},
+	}
+	if s.server != nil {
+		stats.DBStats = s.server.GetConnectionInfo()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(stats)
+}
@@ -0,0 +1,64 @@
+//go:build testclock
+
+package main
+
+import (
+	"contest_notcoin/megacache"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// fakeClock is real time shifted by a manual offset / реальное время, сдвинутое на ручное смещение
+type fakeClock struct {
+	offset atomic.Int64 // nanoseconds / наносекунды
+}
+
+// Now returns shifted time / возвращает сдвинутое время
+func (c *fakeClock) Now() time.Time {
+	return time.Now().Add(time.Duration(c.offset.Load()))
+}
+
+// Advance moves the clock forward / сдвигает часы вперед
+func (c *fakeClock) Advance(d time.Duration) {
+	c.offset.Add(int64(d))
+}
+
+// testClock is shared by every cache of the process, so it survives hourly restarts / общий для всех кешей процесса, поэтому переживает ежечасные перезапуски
+var testClock = &fakeClock{}
+
+// testClockOptions injects the fake clock into the cache / подключает фейковые часы к кешу
+func testClockOptions() []megacache.Option {
+	return []megacache.Option{megacache.WithClock(testClock.Now)}
+}
+
+// registerTestRoutes exposes the clock control, only in testclock builds / открывает управление часами, только в сборках с testclock
+func (s *ServerInstance) registerTestRoutes(mux *http.ServeMux) {
+	log.Println("⚠️  testclock build: /test/advance is enabled, never run this binary in production")
+	mux.HandleFunc("/test/advance", s.advanceClockHandler)
+}
+
+// advanceClockHandler advances the fake clock by ms and sweeps expired reservations / сдвигает фейковые часы на ms и убирает истекшие резервы
+func (s *ServerInstance) advanceClockHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	ms, err := strconv.ParseInt(r.URL.Query().Get("ms"), 10, 64)
+	if err != nil || ms <= 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	testClock.Advance(time.Duration(ms) * time.Millisecond)
+	// Don't make the test wait for the next cleanup tick / Не заставляем тест ждать следующего тика очистки
+	s.cache.CleanupExpired()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]time.Time{"now": testClock.Now()})
+}
@@ -0,0 +1,16 @@
+//go:build !testclock
+
+package main
+
+import (
+	"contest_notcoin/megacache"
+	"net/http"
+)
+
+// testClockOptions keeps the real clock in regular builds / оставляет реальные часы в обычных сборках
+func testClockOptions() []megacache.Option {
+	return nil
+}
+
+// registerTestRoutes registers nothing in regular builds / ничего не регистрирует в обычных сборках
+func (s *ServerInstance) registerTestRoutes(mux *http.ServeMux) {}
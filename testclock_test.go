@@ -0,0 +1,44 @@
+//go:build testclock
+
+package main
+
+import (
+	"contest_notcoin/megacache"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestAdvanceClockFreesExpiredLot tests that advancing the clock past the TTL frees a reserved lot over HTTP
+func TestAdvanceClockFreesExpiredLot(t *testing.T) {
+	config := DefaultAppConfig()
+	instance := newTestInstance(100, config, noopSaver{})
+	instance.cache.Close()
+	instance.cache = megacache.NewMegacache(100, 10, testClockOptions()...)
+	defer instance.cache.Close()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/checkout", instance.checkoutHandler)
+	mux.HandleFunc("/item", instance.itemStatusHandler)
+	instance.registerTestRoutes(mux)
+
+	serve := func(method, target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+		return rec
+	}
+
+	require.Equal(t, http.StatusOK, serve(http.MethodPost, "/checkout?user_id=1&item_id=5").Code)
+	assert.Contains(t, serve(http.MethodGet, "/item?item_id=5").Body.String(), `"reserved"`)
+
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodPost, "/test/advance?ms=0").Code)
+	assert.Equal(t, http.StatusMethodNotAllowed, serve(http.MethodGet, "/test/advance?ms=1").Code)
+
+	ms := config.CheckoutTTL.Milliseconds() + 1
+	require.Equal(t, http.StatusOK, serve(http.MethodPost, fmt.Sprintf("/test/advance?ms=%d", ms)).Code)
+	assert.Contains(t, serve(http.MethodGet, "/item?item_id=5").Body.String(), `"available"`)
+}
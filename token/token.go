@@ -0,0 +1,83 @@
+// Package token signs reservation codes so clients cannot forge them / подписывает коды резервирования, чтобы клиенты не могли их подделать
+package token
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrMalformed    = errors.New("malformed token")         // ERROR: token can't be decoded / ОШИБКА: токен не декодируется
+	ErrBadSignature = errors.New("invalid token signature") // ERROR: token was tampered with / ОШИБКА: токен изменен
+	ErrExpired      = errors.New("token expired")           // ERROR: reservation expired / ОШИБКА: резерв истек
+)
+
+// payloadSize is UUID (16 bytes) + expiry unix millis (8 bytes) / UUID (16 байт) + время истечения в миллисекундах (8 байт)
+const payloadSize = 16 + 8
+
+var encoding = base64.RawURLEncoding
+
+// Signer creates and verifies HMAC-signed reservation tokens / создает и проверяет подписанные HMAC токены резервирования
+type Signer struct {
+	secret []byte
+}
+
+// NewSigner creates a signer with the given secret / создает подписчика с заданным секретом
+func NewSigner(secret []byte) *Signer {
+	return &Signer{secret: append([]byte(nil), secret...)}
+}
+
+// Sign encodes the code and expiry as "payload.signature" / кодирует код и время истечения в виде "payload.signature"
+func (s *Signer) Sign(code uuid.UUID, expiresAt time.Time) string {
+	payload := make([]byte, payloadSize)
+	copy(payload, code[:])
+	binary.BigEndian.PutUint64(payload[16:], uint64(expiresAt.UnixMilli()))
+
+	return encoding.EncodeToString(payload) + "." + encoding.EncodeToString(s.mac(payload))
+}
+
+// Verify checks the signature and expiry and returns the reservation code / проверяет подпись и срок действия и возвращает код резерва
+func (s *Signer) Verify(token string, now time.Time) (uuid.UUID, error) {
+	encodedPayload, encodedSig, ok := strings.Cut(token, ".")
+	if !ok {
+		return uuid.Nil, ErrMalformed
+	}
+
+	payload, err := encoding.DecodeString(encodedPayload)
+	if err != nil || len(payload) != payloadSize {
+		return uuid.Nil, ErrMalformed
+	}
+
+	sig, err := encoding.DecodeString(encodedSig)
+	if err != nil {
+		return uuid.Nil, ErrMalformed
+	}
+
+	// Constant-time comparison / Сравнение за постоянное время
+	if !hmac.Equal(sig, s.mac(payload)) {
+		return uuid.Nil, ErrBadSignature
+	}
+
+	expiresAt := time.UnixMilli(int64(binary.BigEndian.Uint64(payload[16:])))
+	if now.After(expiresAt) {
+		return uuid.Nil, ErrExpired
+	}
+
+	var code uuid.UUID
+	copy(code[:], payload[:16])
+	return code, nil
+}
+
+// mac computes HMAC-SHA256 of the payload / вычисляет HMAC-SHA256 от payload
+func (s *Signer) mac(payload []byte) []byte {
+	h := hmac.New(sha256.New, s.secret)
+	h.Write(payload)
+	return h.Sum(nil)
+}
@@ -0,0 +1,64 @@
+package token
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestSignVerify tests the sign/verify round trip
+func TestSignVerify(t *testing.T) {
+	signer := NewSigner([]byte("secret"))
+	code := uuid.New()
+	now := time.Now()
+
+	tok := signer.Sign(code, now.Add(time.Minute))
+	assert.NotContains(t, tok, code.String(), "token must not expose the raw code")
+
+	got, err := signer.Verify(tok, now)
+	require.NoError(t, err)
+	assert.Equal(t, code, got)
+}
+
+// TestVerifyRejects tests that forged, tampered and expired tokens are rejected
+func TestVerifyRejects(t *testing.T) {
+	signer := NewSigner([]byte("secret"))
+	now := time.Now()
+	tok := signer.Sign(uuid.New(), now.Add(time.Minute))
+	payload, sig, _ := strings.Cut(tok, ".")
+
+	// Flip one character of the payload
+	tampered := []byte(payload)
+	if tampered[0] == 'A' {
+		tampered[0] = 'B'
+	} else {
+		tampered[0] = 'A'
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		signer  *Signer
+		now     time.Time
+		wantErr error
+	}{
+		{"raw uuid", uuid.New().String(), signer, now, ErrMalformed},
+		{"empty", "", signer, now, ErrMalformed},
+		{"bad base64", "!!!." + sig, signer, now, ErrMalformed},
+		{"tampered payload", string(tampered) + "." + sig, signer, now, ErrBadSignature},
+		{"truncated signature", payload + "." + sig[:10], signer, now, ErrBadSignature},
+		{"other secret", tok, NewSigner([]byte("other")), now, ErrBadSignature},
+		{"expired", tok, signer, now.Add(2 * time.Minute), ErrExpired},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.signer.Verify(tt.token, tt.now)
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"contest_notcoin/db"
+	"contest_notcoin/megacache"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// purchaseHistory reads confirmed purchases from the database / читает подтвержденные покупки из БД
+type purchaseHistory interface {
+	GetPurchasedItems(ctx context.Context, userID int64) ([]db.SaleItem, error)
+	GetPurchasedItemsPaged(ctx context.Context, userID int64, limit, offset int) ([]db.SaleItem, int64, error)
+}
+
+// userReservation is a reservation as shown to the user / резерв в том виде, в котором он показывается пользователю
+type userReservation struct {
+	Code      uuid.UUID `json:"code"`
+	ItemID    int64     `json:"item_id"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// userState is the combined purchases and reservations of a user / объединенные покупки и резервы пользователя
+type userState struct {
+	UserID           int64             `json:"user_id"`
+	SaleID           int64             `json:"sale_id"`
+	Purchases        []db.SaleItem     `json:"purchases"`         // confirmed in DB / подтверждены в БД
+	PendingPurchases []userReservation `json:"pending_purchases"` // bought in cache, DB write in flight / куплены в кеше, запись в БД в процессе
+	Reservations     []userReservation `json:"reservations"`      // active reservations / активные резервы
+}
+
+// buildUserState merges DB purchases with cache reservations, DB wins on conflicts / объединяет покупки из БД с резервами из кеша, при конфликте приоритет у БД
+func buildUserState(userID, saleID int64, purchases []db.SaleItem, checkouts []megacache.Checkout) userState {
+	state := userState{
+		UserID:           userID,
+		SaleID:           saleID,
+		Purchases:        purchases,
+		PendingPurchases: []userReservation{},
+		Reservations:     []userReservation{},
+	}
+	if state.Purchases == nil {
+		state.Purchases = []db.SaleItem{}
+	}
+
+	// Items of the current sale already confirmed in DB / Лоты текущей распродажи, уже подтвержденные в БД
+	confirmed := make(map[int64]bool)
+	for _, item := range purchases {
+		if int64(item.SaleID) == saleID {
+			confirmed[int64(item.ItemID)] = true
+		}
+	}
+
+	for _, checkout := range checkouts {
+		// Cache lags behind DB: purchase already confirmed / Кеш отстает от БД: покупка уже подтверждена
+		if confirmed[checkout.LotIndex] {
+			continue
+		}
+
+		reservation := userReservation{
+			Code:      checkout.Code,
+			ItemID:    checkout.LotIndex,
+			CreatedAt: checkout.CreatedAt,
+			ExpiresAt: checkout.ExpiresAt,
+		}
+		if checkout.Status == megacache.CheckoutStatusPurchased {
+			state.PendingPurchases = append(state.PendingPurchases, reservation)
+		} else {
+			state.Reservations = append(state.Reservations, reservation)
+		}
+	}
+
+	// Stable order for the UI / Стабильный порядок для UI
+	sort.Slice(state.PendingPurchases, func(i, j int) bool {
+		return state.PendingPurchases[i].ItemID < state.PendingPurchases[j].ItemID
+	})
+	sort.Slice(state.Reservations, func(i, j int) bool {
+		return state.Reservations[i].ItemID < state.Reservations[j].ItemID
+	})
+
+	return state
+}
+
+// userStateHandler handles GET requests for a user's purchases and reservations / обрабатывает GET запросы покупок и резервов пользователя
+func (s *ServerInstance) userStateHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.isAcceptingRequests() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := parseUserID(r.URL.Query().Get("user_id"), s.config.MaxUserID)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Read cache first: a purchase confirmed meanwhile shows up in DB and is deduplicated / Сначала читаем кеш: покупка, подтвержденная за это время, появится в БД и будет отброшена
+	checkouts := s.cache.GetUserReservations(userID)
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	purchases, err := s.purchases.GetPurchasedItems(ctx, userID)
+	if err != nil {
+		log.Printf("❌ Failed to load purchases for user %d: %v", userID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(buildUserState(userID, s.saleID, purchases, checkouts))
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// Build info, injected with -ldflags "-X main.buildVersion=... -X main.buildCommit=..." / Информация о сборке, задается через -ldflags
+var (
+	buildVersion = "dev"
+	buildCommit  = "unknown"
+)
+
+// processStartedAt is when the binary started, instances restart hourly within it / время запуска бинарника, внутри него экземпляры перезапускаются каждый час
+var processStartedAt = time.Now()
+
+// versionInfo is the /version response body / тело ответа /version
+type versionInfo struct {
+	Version           string    `json:"version"`
+	Commit            string    `json:"commit"`
+	StartedAt         time.Time `json:"started_at"`          // process start / запуск процесса
+	InstanceStartedAt time.Time `json:"instance_started_at"` // last hourly restart / последний ежечасный перезапуск
+	SaleID            int64     `json:"sale_id"`
+}
+
+// versionHandler reports which build is live and when this instance started / сообщает, какая сборка запущена и когда стартовал этот экземпляр
+func (s *ServerInstance) versionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(versionInfo{
+		Version:           buildVersion,
+		Commit:            buildCommit,
+		StartedAt:         processStartedAt,
+		InstanceStartedAt: s.startedAt,
+		SaleID:            s.saleID,
+	})
+}
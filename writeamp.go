@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+)
+
+// writeAmplification counts checkout rows written against purchases they turned into / считает записанные строки checkout относительно покупок, в которые они превратились
+type writeAmplification struct {
+	checkoutRows atomic.Int64 // rows inserted by the sync write path / строки, вставленные синхронным путем записи
+	purchases    atomic.Int64 // purchases confirmed in DB / покупки, подтвержденные в БД
+}
+
+// writeAmplificationStats is a point-in-time view of write amplification / срез усиления записи на момент запроса
+type writeAmplificationStats struct {
+	CheckoutRows int64   `json:"checkout_rows"`
+	Purchases    int64   `json:"purchases"`
+	Ratio        float64 `json:"ratio"` // checkout rows per purchase, 0 until the first purchase / строк checkout на покупку, 0 до первой покупки
+}
+
+// recordCheckoutRows adds rows written to the checkouts table / добавляет строки, записанные в таблицу checkouts
+func (w *writeAmplification) recordCheckoutRows(n int64) {
+	w.checkoutRows.Add(n)
+}
+
+// recordPurchase counts a purchase confirmed in DB / учитывает покупку, подтвержденную в БД
+func (w *writeAmplification) recordPurchase() {
+	w.purchases.Add(1)
+}
+
+// stats computes the ratio, extraRows covers rows written outside the sync path / вычисляет коэффициент, extraRows - строки, записанные вне синхронного пути
+func (w *writeAmplification) stats(extraRows int64) writeAmplificationStats {
+	stats := writeAmplificationStats{
+		CheckoutRows: w.checkoutRows.Load() + extraRows,
+		Purchases:    w.purchases.Load(),
+	}
+	if stats.Purchases > 0 {
+		stats.Ratio = float64(stats.CheckoutRows) / float64(stats.Purchases)
+	}
+	return stats
+}
+
+// writeAmplificationStats returns checkout write amplification of this instance / возвращает усиление записи checkout этого экземпляра
+func (s *ServerInstance) writeAmplificationStats() writeAmplificationStats {
+	var persisted int64
+	if s.persister != nil {
+		persisted = s.persister.Written()
+	}
+	return s.writes.stats(persisted)
+}
+
+// writeAmplificationHandler returns checkout rows written per purchase / возвращает количество строк checkout на одну покупку
+func (s *ServerInstance) writeAmplificationHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte("Method not allowed"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(s.writeAmplificationStats())
+}